@@ -118,17 +118,93 @@ func readLinesFromFile(filename string) []string {
 }
 
 // MacroExpansionLocation points back to where the macro was invoked,
-// so errors in expanded code can be traced to the call site.
+// so errors in expanded code can be traced to the call site. Nested
+// expansions chain: when the call form was itself produced by another
+// macro, CallSite is that expansion's MacroExpansionLocation.
 type MacroExpansionLocation struct {
 	MacroName string
 	CallSite  CodeLocation
+	// DefSite is where the expanded form was written in the macro's
+	// template, or nil when the transformer built it at expansion time.
+	DefSite CodeLocation
 }
 
+// ExpansionFrame is one step of a macro expansion backtrace.
+type ExpansionFrame struct {
+	MacroName string
+	CallSite  CodeLocation // nil when the call form had no source position
+}
+
+// maxBacktraceFrames bounds how many frames Backtrace prints; deeper
+// chains (recursive macros) elide their middle.
+const maxBacktraceFrames = 10
+
 func (mel *MacroExpansionLocation) Line() int   { return mel.CallSite.Line() }
 func (mel *MacroExpansionLocation) Column() int { return mel.CallSite.Column() }
 func (mel *MacroExpansionLocation) String() string {
-	return fmt.Sprintf("%s in expansion of '%s'", mel.CallSite.String(), mel.MacroName)
+	frames := mel.Frames()
+	return fmt.Sprintf("%s in expansion of '%s'", mel.Root().String(), frames[0].MacroName)
 }
 func (mel *MacroExpansionLocation) SourceContext() string {
 	return mel.CallSite.SourceContext()
 }
+
+// Root returns the location in user source that started the expansion chain.
+func (mel *MacroExpansionLocation) Root() CodeLocation {
+	loc := mel.CallSite
+	for {
+		parent, ok := loc.(*MacroExpansionLocation)
+		if !ok {
+			return loc
+		}
+		loc = parent.CallSite
+	}
+}
+
+// Frames returns the expansion chain, outermost (user source) first.
+// Each nested frame's call site is where its call form was written in
+// the enclosing macro's template.
+func (mel *MacroExpansionLocation) Frames() []ExpansionFrame {
+	var frames []ExpansionFrame
+	for cur := mel; ; {
+		parent, ok := cur.CallSite.(*MacroExpansionLocation)
+		if !ok {
+			frames = append(frames, ExpansionFrame{MacroName: cur.MacroName, CallSite: cur.CallSite})
+			break
+		}
+		frames = append(frames, ExpansionFrame{MacroName: cur.MacroName, CallSite: parent.DefSite})
+		cur = parent
+	}
+	for i, j := 0, len(frames)-1; i < j; i, j = i+1, j-1 {
+		frames[i], frames[j] = frames[j], frames[i]
+	}
+	return frames
+}
+
+// Backtrace renders the expansion chain like a stack, e.g.
+// "in expansion of let* at foo.ghl:10:3, from let at prelude.ghl:17:4".
+func (mel *MacroExpansionLocation) Backtrace() string {
+	frames := mel.Frames()
+	parts := make([]string, 0, len(frames)+1)
+	for i, f := range frames {
+		if len(frames) > maxBacktraceFrames && i == maxBacktraceFrames/2 {
+			parts = append(parts, fmt.Sprintf("... %d more", len(frames)-maxBacktraceFrames))
+		}
+		if len(frames) > maxBacktraceFrames && i >= maxBacktraceFrames/2 && i < len(frames)-maxBacktraceFrames/2 {
+			continue
+		}
+		verb := "from"
+		if i == 0 {
+			verb = "in expansion of"
+		}
+		if f.CallSite != nil {
+			parts = append(parts, fmt.Sprintf("%s %s at %s", verb, f.MacroName, f.CallSite.String()))
+		} else {
+			parts = append(parts, fmt.Sprintf("%s %s", verb, f.MacroName))
+		}
+	}
+	if mel.DefSite != nil {
+		parts = append(parts, "template at "+mel.DefSite.String())
+	}
+	return strings.Join(parts, ", ")
+}
@@ -361,3 +361,48 @@ func TestMacroExpansionLocationImplementsCodeLocation(t *testing.T) {
 		t.Error("should implement CodeLocation")
 	}
 }
+
+func TestMacroExpansionLocationFramesOutermostFirst(t *testing.T) {
+	file := "foo.ghl"
+	prelude := "prelude.ghl"
+	outer := &MacroExpansionLocation{
+		MacroName: "let*",
+		CallSite:  &SourcePosition{Ln: 10, Col: 3, Filename: &file},
+		DefSite:   &SourcePosition{Ln: 17, Col: 4, Filename: &prelude},
+	}
+	inner := &MacroExpansionLocation{
+		MacroName: "let",
+		CallSite:  outer,
+		DefSite:   &SourcePosition{Ln: 9, Col: 4, Filename: &prelude},
+	}
+
+	frames := inner.Frames()
+	if len(frames) != 2 {
+		t.Fatalf("expected 2 frames, got %d", len(frames))
+	}
+	if frames[0].MacroName != "let*" || frames[0].CallSite.String() != "foo.ghl:10:3" {
+		t.Errorf("unexpected outer frame: %s at %v", frames[0].MacroName, frames[0].CallSite)
+	}
+	if frames[1].MacroName != "let" || frames[1].CallSite.String() != "prelude.ghl:17:4" {
+		t.Errorf("unexpected inner frame: %s at %v", frames[1].MacroName, frames[1].CallSite)
+	}
+
+	want := "in expansion of let* at foo.ghl:10:3, from let at prelude.ghl:17:4, template at prelude.ghl:9:4"
+	if got := inner.Backtrace(); got != want {
+		t.Errorf("expected backtrace %q, got %q", want, got)
+	}
+	if inner.Line() != 10 || inner.String() != "foo.ghl:10:3 in expansion of 'let*'" {
+		t.Errorf("expected position of user call site, got %s", inner.String())
+	}
+}
+
+func TestMacroExpansionLocationBacktraceElidesDeepChains(t *testing.T) {
+	var loc CodeLocation = &SourcePosition{Ln: 1, Col: 1}
+	for i := 0; i < 30; i++ {
+		loc = &MacroExpansionLocation{MacroName: "rec", CallSite: loc, DefSite: &SourcePosition{Ln: 2, Col: 1}}
+	}
+	bt := loc.(*MacroExpansionLocation).Backtrace()
+	if !strings.Contains(bt, "... 20 more") {
+		t.Errorf("expected elided frames, got %q", bt)
+	}
+}
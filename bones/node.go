@@ -50,6 +50,7 @@ type Node struct {
 
 	// Identifier
 	Name  string
+	Sym   Symbol          // interned Name; see Symbol()
 	Marks map[uint64]bool // non-nil = scoped identifier
 
 	// List structure
@@ -132,11 +133,16 @@ func BoolNode(v bool) *Node {
 }
 
 func IdentNode(name string) *Node {
-	return &Node{Kind: IdentifierNode, Name: name}
+	return &Node{Kind: IdentifierNode, Name: name, Sym: Intern(name)}
 }
 
 func ScopedIdentNode(name string, marks map[uint64]bool) *Node {
-	return &Node{Kind: IdentifierNode, Name: name, Marks: marks}
+	return &Node{Kind: IdentifierNode, Name: name, Sym: Intern(name), Marks: marks}
+}
+
+// SymbolNode returns the identifier for an already interned symbol.
+func SymbolNode(sym Symbol) *Node {
+	return &Node{Kind: IdentifierNode, Name: sym.Name(), Sym: sym}
 }
 
 func NewListNode(children []*Node) *Node {
@@ -172,6 +178,19 @@ func (n *Node) IdentName() string {
 	return ""
 }
 
+// Symbol returns the interned name of an identifier, or the zero Symbol
+// for other nodes. Identifiers built without a constructor are interned
+// on demand.
+func (n *Node) Symbol() Symbol {
+	if n.Kind != IdentifierNode {
+		return Symbol{}
+	}
+	if n.Sym != (Symbol{}) {
+		return n.Sym
+	}
+	return Intern(n.Name)
+}
+
 // First returns the first child of a list node, or Nil.
 func (n *Node) First() *Node {
 	if n.Kind == ListNode && len(n.Children) > 0 {
@@ -306,7 +325,7 @@ func (n *Node) equivNode(other *Node) bool {
 		if other.Kind != IdentifierNode {
 			return false
 		}
-		if n.Name != other.Name {
+		if n.Symbol() != other.Symbol() {
 			return false
 		}
 		// Both plain or both have matching marks
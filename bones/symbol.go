@@ -0,0 +1,39 @@
+package bones
+
+import "unique"
+
+// Symbol is the interned ID of an identifier name. Two identifiers with
+// the same name always carry the same Symbol, so they compare as cheaply
+// as pointers. The zero Symbol stands for no name.
+//
+// Interning is weak: once no Symbol for a name is left, the garbage
+// collector reclaims its entry, so names a script makes up as it runs,
+// with string->symbol or macro expansion, do not pile up.
+//
+// That is why a Symbol is a unique.Handle rather than a small integer
+// handed out by a counter. Both are one word and compare in one
+// instruction, but an integer ID stays reserved for its name as long as
+// any copy of the number might be around, so its table could only grow.
+// Nothing needs the number itself: .ghc files and images store names as
+// strings and intern them again when they are read, since IDs would not
+// mean the same in another process anyway.
+type Symbol struct {
+	h unique.Handle[string]
+}
+
+// Intern returns the Symbol for name.
+func Intern(name string) Symbol {
+	return Symbol{unique.Make(name)}
+}
+
+// Name returns the name s was interned from.
+func (s Symbol) Name() string {
+	if s == (Symbol{}) {
+		return ""
+	}
+	return s.h.Value()
+}
+
+func (s Symbol) String() string {
+	return s.Name()
+}
@@ -0,0 +1,78 @@
+package bones
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestInternReturnsTheSameSymbolForTheSameName(t *testing.T) {
+	a := Intern("wraith")
+	b := Intern("wraith")
+	if a != b {
+		t.Errorf("Intern gave %v and %v for the same name", a, b)
+	}
+	if a == (Symbol{}) {
+		t.Error("an interned name should not get the zero Symbol")
+	}
+	if Intern("spectre") == a {
+		t.Error("different names should get different symbols")
+	}
+}
+
+func TestSymbolNameRoundTrips(t *testing.T) {
+	for _, name := range []string{"x", "string->symbol", "two words", ""} {
+		if got := Intern(name).Name(); got != name {
+			t.Errorf("Intern(%q).Name() = %q", name, got)
+		}
+	}
+}
+
+func TestIdentNodesCarryTheirSymbol(t *testing.T) {
+	sym := Intern("lich")
+	if IdentNode("lich").Sym != sym {
+		t.Error("IdentNode should carry the interned symbol")
+	}
+	if ScopedIdentNode("lich", map[uint64]bool{1: true}).Sym != sym {
+		t.Error("ScopedIdentNode should carry the interned symbol")
+	}
+	if n := SymbolNode(sym); n.Name != "lich" || n.Symbol() != sym {
+		t.Errorf("SymbolNode gave %q with symbol %v", n.Name, n.Symbol())
+	}
+}
+
+func TestSymbolOfUninternedIdentifier(t *testing.T) {
+	bare := &Node{Kind: IdentifierNode, Name: "banshee"}
+	if bare.Symbol() != Intern("banshee") {
+		t.Error("Symbol should intern identifiers built without a constructor")
+	}
+	if !bare.Equiv(IdentNode("banshee")) {
+		t.Error("identifiers with the same name should be equivalent")
+	}
+	if IntNode(1).Symbol() != (Symbol{}) {
+		t.Error("non-identifiers have no symbol")
+	}
+}
+
+func TestInternIsSafeForConcurrentUse(t *testing.T) {
+	const workers = 8
+	results := make([][]Symbol, workers)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				results[w] = append(results[w], Intern(fmt.Sprintf("concurrent-%d", i)))
+			}
+		}(w)
+	}
+	wg.Wait()
+	for w := 1; w < workers; w++ {
+		for i := range results[0] {
+			if results[w][i] != results[0][i] {
+				t.Fatalf("worker %d interned concurrent-%d as %v, worker 0 as %v", w, i, results[w][i], results[0][i])
+			}
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package ghoul
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	e "github.com/archevel/ghoul/bones"
+)
+
+func (g ghoul) Lookup(name string) (*e.Node, error) {
+	return g.reanimator.EvalEnv().LookupByName(name)
+}
+
+func (g ghoul) Call(ctx context.Context, name string, args ...any) (*e.Node, error) {
+	fn, err := g.Lookup(name)
+	if err != nil {
+		return nil, err
+	}
+	nodes := make([]*e.Node, len(args))
+	for i, arg := range args {
+		if nodes[i], err = toNode(reflect.ValueOf(arg)); err != nil {
+			return nil, fmt.Errorf("failed to call %s: argument %d: %w", name, i, err)
+		}
+	}
+	result, err := g.evaluator.Apply(ctx, fn, nodes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call %s: %w", name, err)
+	}
+	return result, nil
+}
+
+// CallAs calls the procedure bound to name as Call does and converts its
+// result to T under the rules Define uses for arguments: a Ghoul list
+// fills a slice, a mummy gives back the Go value it holds, and a
+// procedure becomes a Go func. Errors from such a func reach the caller
+// only through a trailing error result.
+func CallAs[T any](ctx context.Context, g Ghoul, name string, args ...any) (T, error) {
+	var zero T
+	result, err := g.Call(ctx, name, args...)
+	if err != nil {
+		return zero, err
+	}
+	c := &converter{fn: name}
+	if impl, ok := g.(ghoul); ok {
+		c.ev = impl.evaluator
+	}
+	v, err := c.toGo(result, reflect.TypeFor[T](), "result")
+	if err != nil {
+		return zero, err
+	}
+	out, _ := v.Interface().(T)
+	return out, nil
+}
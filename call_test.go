@@ -0,0 +1,108 @@
+package ghoul
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	e "github.com/archevel/ghoul/bones"
+)
+
+func TestLookupFindsTopLevelBindings(t *testing.T) {
+	g := New()
+	processString(t, g, `(define answer 42)`)
+	got, err := g.Lookup("answer")
+	if err != nil || !got.Equiv(e.IntNode(42)) {
+		t.Errorf("expected 42, got %v, %v", got, err)
+	}
+	if _, err := g.Lookup("no-such-thing"); err == nil || !strings.Contains(err.Error(), "undefined identifier") {
+		t.Errorf("expected an undefined identifier error, got %v", err)
+	}
+}
+
+func TestCallConvertsArguments(t *testing.T) {
+	g := New()
+	processString(t, g, `(define on-event (lambda (kind n) (cond ((eq? kind "click") (* n 2)) (else 0))))`)
+	got, err := g.Call(context.Background(), "on-event", "click", 21)
+	if err != nil || !got.Equiv(e.IntNode(42)) {
+		t.Errorf("expected 42, got %v, %v", got, err)
+	}
+}
+
+func TestCallPassesGoFuncsAsProcedures(t *testing.T) {
+	g := New()
+	processString(t, g, `(define twice (lambda (f x) (f (f x))))`)
+	got, err := g.Call(context.Background(), "twice", func(n int) int { return n + 3 }, 1)
+	if err != nil || !got.Equiv(e.IntNode(7)) {
+		t.Errorf("expected 7, got %v, %v", got, err)
+	}
+}
+
+func TestCallReportsErrors(t *testing.T) {
+	g := New()
+	processString(t, g, `(define answer 42) (define broken (lambda () (undefined-thing)))`)
+	if _, err := g.Call(context.Background(), "answer"); err == nil || !strings.Contains(err.Error(), "not a procedure") {
+		t.Errorf("expected a not a procedure error, got %v", err)
+	}
+	if _, err := g.Call(context.Background(), "broken"); err == nil || !strings.Contains(err.Error(), "failed to call broken") {
+		t.Errorf("expected the call to fail, got %v", err)
+	}
+}
+
+func TestCallRespectsContextAndLimits(t *testing.T) {
+	g := New()
+	processString(t, g, `(define spin (lambda () (spin)))`)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := g.Call(ctx, "spin"); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected the cancelled context to stop the call, got %v", err)
+	}
+
+	g.SetLimits(Limits{Fuel: 1000})
+	if _, err := g.Call(context.Background(), "spin"); !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("expected the fuel limit to stop the call, got %v", err)
+	}
+}
+
+func TestCallAsDecodesResults(t *testing.T) {
+	g := New()
+	processString(t, g, `
+(define square (lambda (x) (* x x)))
+(define names (lambda () '("ann" "bob")))
+(define adder (lambda (n) (lambda (x) (+ x n))))`)
+	ctx := context.Background()
+
+	if n, err := CallAs[int](ctx, g, "square", 9); err != nil || n != 81 {
+		t.Errorf("expected 81, got %d, %v", n, err)
+	}
+	if names, err := CallAs[[]string](ctx, g, "names"); err != nil || strings.Join(names, ",") != "ann,bob" {
+		t.Errorf("expected ann and bob, got %v, %v", names, err)
+	}
+	if v, err := CallAs[any](ctx, g, "names"); err != nil || len(v.([]any)) != 2 {
+		t.Errorf("expected a []any of two names, got %v, %v", v, err)
+	}
+	add, err := CallAs[func(int) (int, error)](ctx, g, "adder", 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n, err := add(5); err != nil || n != 15 {
+		t.Errorf("expected 15, got %d, %v", n, err)
+	}
+}
+
+func TestCallAsReportsConversionErrors(t *testing.T) {
+	g := New()
+	processString(t, g, `(define greet (lambda () "hi")) (define big (lambda () 300))`)
+	ctx := context.Background()
+
+	_, err := CallAs[int](ctx, g, "greet")
+	if err == nil || err.Error() != "greet: expected integer for result, got string" {
+		t.Errorf("expected a conversion error, got %v", err)
+	}
+	_, err = CallAs[int8](ctx, g, "big")
+	if err == nil || err.Error() != "big: 300 for result does not fit in int8" {
+		t.Errorf("expected an overflow error, got %v", err)
+	}
+}
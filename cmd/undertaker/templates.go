@@ -16,6 +16,7 @@ import (
 	"flag"
 	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 
 	"github.com/archevel/ghoul"
@@ -27,21 +28,34 @@ var preludeSource string
 {{end}}
 func main() {
 	var verbose = flag.Bool("v", false, "enable verbose (trace) logging")
+	var disasm = flag.Bool("disasm", false, "print the bytecode of each expression before running it")
+	var compile = flag.Bool("compile", false, "compile the file to bytecode instead of running it")
+	var out = flag.String("o", "", "output file for -compile (default: the input with a .ghc extension)")
 	flag.Parse()
 
 	args := flag.Args()
-	if len(args) == 0 {
+	if *compile {
+		if len(args) != 1 {
+			usage()
+		}
+		compileFile(args[0], *out, *verbose)
+	} else if len(args) == 0 {
 		fmt.Println("Welcome to Ghoul")
-		repl(*verbose)
+		repl(*verbose, *disasm)
 	} else if len(args) == 1 {
-		runFile(args[0], *verbose)
+		runFile(args[0], *verbose, *disasm)
 	} else {
-		fmt.Println("Usage: ghoul [-v] [file]")
-		os.Exit(1)
+		usage()
 	}
 }
 
-func newGhoul(verbose bool) ghoul.Ghoul {
+func usage() {
+	fmt.Println("Usage: ghoul [-v] [-disasm] [file]")
+	fmt.Println("       ghoul [-v] -compile [-o out.ghc] file.ghl")
+	os.Exit(1)
+}
+
+func newGhoul(verbose, disasm bool) ghoul.Ghoul {
 	logger := engraving.StandardLogger
 	if verbose {
 		logger = engraving.VerboseLogger
@@ -50,11 +64,14 @@ func newGhoul(verbose bool) ghoul.Ghoul {
 {{- if .Prelude}}
 	g.Process(strings.NewReader(preludeSource))
 {{- end}}
+	if disasm {
+		g.SetDisassemblyOutput(os.Stdout)
+	}
 	return g
 }
 
-func runFile(path string, verbose bool) {
-	g := newGhoul(verbose)
+func runFile(path string, verbose, disasm bool) {
+	g := newGhoul(verbose, disasm)
 	_, processErr := g.ProcessFile(path)
 	if processErr != nil {
 		fmt.Println(processErr)
@@ -62,8 +79,21 @@ func runFile(path string, verbose bool) {
 	}
 }
 
-func repl(verbose bool) {
-	g := newGhoul(verbose)
+// compileFile writes the bytecode for a .ghl file next to it, or to the
+// path given with -o. The result runs like the source with runFile.
+func compileFile(src, dst string, verbose bool) {
+	if dst == "" {
+		dst = strings.TrimSuffix(src, filepath.Ext(src)) + ".ghc"
+	}
+
+	if err := newGhoul(verbose, false).CompileFile(src, dst); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+}
+
+func repl(verbose, disasm bool) {
+	g := newGhoul(verbose, disasm)
 	reader := bufio.NewReader(os.Stdin)
 	fmt.Print("> ")
 	for text, readErr := reader.ReadString('\n'); readErr == nil; text, readErr = reader.ReadString('\n') {
@@ -58,6 +58,47 @@ func TestRenderMainWithoutPrelude(t *testing.T) {
 	}
 }
 
+func TestRenderMainHasCompileCommand(t *testing.T) {
+	var buf bytes.Buffer
+	if err := renderMain(&buf, false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	out := buf.String()
+
+	if !strings.Contains(out, `flag.Bool("compile"`) {
+		t.Error("expected a -compile flag")
+	}
+	if strings.Contains(out, `args[0] == "compile"`) {
+		t.Error("a script file named compile should run, not start compiling")
+	}
+	if !strings.Contains(out, "CompileFile(src, dst)") {
+		t.Error("expected compile to call CompileFile")
+	}
+	if !strings.Contains(out, `".ghc"`) {
+		t.Error("expected .ghc as the default output extension")
+	}
+}
+
+func TestRenderMainHasDisasmFlag(t *testing.T) {
+	var buf bytes.Buffer
+	if err := renderMain(&buf, true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	out := buf.String()
+
+	if !strings.Contains(out, `flag.Bool("disasm"`) {
+		t.Error("expected a -disasm flag")
+	}
+	if !strings.Contains(out, "SetDisassemblyOutput(os.Stdout)") {
+		t.Error("expected -disasm to turn on the listing")
+	}
+	if strings.Index(out, "SetDisassemblyOutput") < strings.Index(out, "Process(strings.NewReader(preludeSource))") {
+		t.Error("expected the prelude to load before the listing is turned on")
+	}
+}
+
 func TestRenderSarcophagus(t *testing.T) {
 	mummyNames := []string{"math", "strings", "github.com_foo_bar"}
 
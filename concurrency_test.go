@@ -0,0 +1,137 @@
+package ghoul
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/archevel/ghoul/engraving"
+)
+
+// A handler registered from Ghoul and invoked by Go from many goroutines at
+// once, the way net/http calls the handlers of examples/hello_server.ghoul.
+func TestConcurrentHandlers(t *testing.T) {
+	g := New()
+	var handlers sync.Map
+	g.Define("handle-func", func(path string, h func(string) (string, error)) {
+		handlers.Store(path, h)
+	})
+	// serve plays ListenAndServe: it blocks while requests run.
+	g.Define("serve", func(n int) ([]string, error) {
+		h, _ := handlers.Load("/hello")
+		responses := make([]string, n)
+		errs := make([]error, n)
+		var wg sync.WaitGroup
+		for i := range responses {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				responses[i], errs[i] = h.(func(string) (string, error))(fmt.Sprintf("req-%d", i))
+			}()
+		}
+		wg.Wait()
+		for _, err := range errs {
+			if err != nil {
+				return nil, err
+			}
+		}
+		return responses, nil
+	})
+
+	result, err := g.Process(strings.NewReader(`
+(define hits 0)
+(define greet (lambda (who) (string-append "hello " who)))
+(handle-func "/hello" (lambda (who)
+  (define last-visitor who)
+  (set! hits (+ hits 1))
+  (greet who)))
+(serve 50)`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i, r := range result.Children {
+		if want := fmt.Sprintf("hello req-%d", i); r.StrVal != want {
+			t.Errorf("expected %q, got %s", want, r.Repr())
+		}
+	}
+
+	// Requests keep arriving after Process returned, alongside other work
+	// on the same instance.
+	h, _ := handlers.Load("/hello")
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			if got, err := h.(func(string) (string, error))("late"); err != nil || got != "hello late" {
+				t.Errorf("expected hello late, got %q, %v", got, err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			if got, err := CallAs[string](context.Background(), g, "greet", "caller"); err != nil || got != "hello caller" {
+				t.Errorf("expected hello caller, got %q, %v", got, err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			src := fmt.Sprintf("(define visitor-%d (greet %q)) visitor-%d", i, "p", i)
+			if _, err := g.Process(strings.NewReader(src)); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	if _, err := g.Lookup("visitor-19"); err != nil {
+		t.Errorf("expected the concurrent defines to be visible, got %v", err)
+	}
+}
+
+// Callbacks without an error result report failures to the call that
+// received them, from whichever goroutine they run on.
+func TestConcurrentCallbacksWithoutErrorResult(t *testing.T) {
+	g := New()
+	g.Define("fan-out", func(n int, f func(int)) {
+		var wg sync.WaitGroup
+		for i := 0; i < n; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				f(i)
+			}()
+		}
+		wg.Wait()
+	})
+	_, err := g.Process(strings.NewReader(`(fan-out 8 (lambda (x) (car x)))`))
+	if err == nil || !strings.Contains(err.Error(), "car") {
+		t.Errorf("expected a callback's error to fail the call, got %v", err)
+	}
+}
+
+// A callback kept and called after the call that received it returned
+// logs its error rather than dropping it.
+func TestLateCallbackErrorsAreLogged(t *testing.T) {
+	var logged bytes.Buffer
+	g := NewBareWithLogger(engraving.NewWithWriter(&logged, slog.LevelWarn))
+	var kept func(string)
+	g.Define("keep", func(f func(string)) { kept = f })
+	if _, err := g.Process(strings.NewReader(`(keep (lambda (s) (undefined-thing s)))`)); err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			kept("late")
+		}()
+	}
+	wg.Wait()
+	if !strings.Contains(logged.String(), "keep: callback failed after the call returned") {
+		t.Errorf("expected the late callback errors to be logged, got %q", logged.String())
+	}
+}
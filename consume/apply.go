@@ -0,0 +1,135 @@
+package consume
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/archevel/ghoul/bones"
+)
+
+// Apply calls fn with args and returns the result. Compiled closures run
+// directly on a VM, with no CallNode to compile: on top of the calling VM
+// when ev was handed to a native function that is still running and no
+// other procedure runs there, otherwise on an idle VM kept for reuse. Go
+// functions are called as they are.
+func (ev *Evaluator) Apply(ctx context.Context, fn *bones.Node, args []*bones.Node) (*bones.Node, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	for isApplyProcedure(fn) {
+		var err error
+		if fn, args, err = spreadApplyArgs(args); err != nil {
+			return nil, err
+		}
+	}
+
+	if fn.Kind == bones.FunctionNode {
+		if cd, ok := fn.ForeignVal.(*closureData); ok {
+			if vm := ev.active; vm != nil && vm.claim(ev) {
+				defer vm.unclaim()
+				return vm.applyClosure(ctx, cd, args)
+			}
+			vm := ev.idle.take(ev)
+			defer ev.idle.release(vm)
+			vm.budget = newBudget(ev.limits)
+			if ev.active == nil {
+				vm.stats = StatsFrom(ctx)
+			}
+			return vm.applyClosure(ctx, cd, args)
+		}
+		if fn.FuncVal != nil {
+			if ev.active == nil {
+				// Let the function see ctx through Context.
+				called := *ev
+				called.ctx = ctx
+				ev = &called
+			}
+			return (*fn.FuncVal)(args, ev)
+		}
+	}
+	return nil, fmt.Errorf("not a procedure: %s", fn.Repr())
+}
+
+// Context returns the context of the evaluation that called the native
+// function ev was handed to, or context.Background when there is none.
+// Native functions pass it on to blocking Go calls so cancelling the
+// evaluation also stops them.
+func (ev *Evaluator) Context() context.Context {
+	if vm := ev.active; vm != nil {
+		vm.gate.mu.Lock()
+		defer vm.gate.mu.Unlock()
+		return ev.ctx
+	}
+	if ev.ctx != nil {
+		return ev.ctx
+	}
+	return context.Background()
+}
+
+// applyClosure pushes a frame for cd above whatever is running and executes
+// until that frame returns. On error the stack is unwound to where it was.
+func (vm *VM) applyClosure(ctx context.Context, cd *closureData, args []*bones.Node) (*bones.Node, error) {
+	savedFP, savedSP, savedCtx := vm.fp, vm.sp, vm.ctx
+	vm.ctx = ctx
+	defer func() { vm.ctx = savedCtx }()
+
+	for _, arg := range args {
+		vm.push(nodeValue(arg))
+	}
+	if err := vm.callClosure(cd, len(args), false, nil); err != nil {
+		vm.fp, vm.sp = savedFP, savedSP
+		return nil, err
+	}
+	result, err := vm.execute(ctx, savedFP+1)
+	if err != nil {
+		clear(vm.stack[savedSP:vm.sp])
+		vm.fp, vm.sp = savedFP, savedSP
+		return nil, err
+	}
+	return result, nil
+}
+
+// applyTag marks the FuncNode of the apply procedure so the VM can spread
+// its arguments in place instead of calling it as a native function.
+type applyTag struct{}
+
+// ApplyProcedure returns the `apply` procedure:
+// (apply f a ... lst) calls f with the arguments a ... followed by the
+// elements of lst.
+func ApplyProcedure() *bones.Node {
+	fn := func(args []*bones.Node, evaluator bones.Evaluator) (*bones.Node, error) {
+		ev := evaluator.(*Evaluator)
+		f, spread, err := spreadApplyArgs(args)
+		if err != nil {
+			return nil, err
+		}
+		return ev.Apply(ev.Context(), f, spread)
+	}
+	return &bones.Node{Kind: bones.FunctionNode, FuncVal: &fn, ForeignVal: applyTag{}}
+}
+
+func isApplyProcedure(n *bones.Node) bool {
+	if n.Kind != bones.FunctionNode {
+		return false
+	}
+	_, ok := n.ForeignVal.(applyTag)
+	return ok
+}
+
+func spreadApplyArgs(args []*bones.Node) (*bones.Node, []*bones.Node, error) {
+	if len(args) == 0 {
+		return nil, nil, fmt.Errorf("apply: expected a procedure and an argument list")
+	}
+	fn := args[0]
+	if len(args) == 1 {
+		return fn, nil, nil
+	}
+	last := args[len(args)-1]
+	if !last.IsNil() && (last.Kind != bones.ListNode || last.DottedTail != nil) {
+		return nil, nil, fmt.Errorf("apply: last argument must be a proper list, got %s", bones.NodeTypeName(last))
+	}
+	spread := make([]*bones.Node, 0, len(args)-2+len(last.Children))
+	spread = append(spread, args[1:len(args)-1]...)
+	spread = append(spread, last.Children...)
+	return fn, spread, nil
+}
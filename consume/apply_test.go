@@ -0,0 +1,179 @@
+package consume
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	e "github.com/archevel/ghoul/bones"
+	"github.com/archevel/ghoul/engraving"
+	p "github.com/archevel/ghoul/exhumer"
+)
+
+func evalIn(t *testing.T, ev *Evaluator, in string) (*e.Node, error) {
+	t.Helper()
+	_, parsed := p.Parse(strings.NewReader(in))
+	return ev.EvaluateNode(context.Background(), parsed.Expressions)
+}
+
+func TestApplyGoFunction(t *testing.T) {
+	ev := New(engraving.StandardLogger, NewEnvironment())
+	fn := e.FuncNode(func(args []*e.Node, _ e.Evaluator) (*e.Node, error) {
+		return e.IntNode(args[0].IntVal * 2), nil
+	})
+	res, err := ev.Apply(context.Background(), fn, []*e.Node{e.IntNode(21)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Equiv(e.IntNode(42)) {
+		t.Errorf("expected 42, got %s", res.Repr())
+	}
+}
+
+func TestApplyClosureOutsideEvaluationReusesVM(t *testing.T) {
+	env := NewEnvironment()
+	ev := New(engraving.StandardLogger, env)
+	closure, err := evalIn(t, ev, "(lambda (a b) (cond ((eq? a b) 'same) (else (list a b))))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.Register("eq?", func(args []*e.Node, ev *Evaluator) (*e.Node, error) {
+		return e.BoolNode(args[0].Equiv(args[1])), nil
+	})
+	env.Register("list", func(args []*e.Node, ev *Evaluator) (*e.Node, error) {
+		return e.NewListNode(args), nil
+	})
+
+	for i := 0; i < 3; i++ {
+		res, err := ev.Apply(context.Background(), closure, []*e.Node{e.IntNode(1), e.IntNode(int64(i))})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if i == 1 && res.Repr() != "same" {
+			t.Errorf("expected same, got %s", res.Repr())
+		}
+	}
+	if len(ev.idle.vms) != 1 {
+		t.Errorf("expected one VM to be reused, have %d idle", len(ev.idle.vms))
+	}
+}
+
+func TestApplyFromNativeFunctionRunsOnActiveVM(t *testing.T) {
+	env := NewEnvironment()
+	ev := New(engraving.StandardLogger, env)
+	var depths []int
+	env.Register("call-twice", func(args []*e.Node, ev *Evaluator) (*e.Node, error) {
+		depths = append(depths, ev.active.fp)
+		first, err := ev.Apply(context.Background(), args[0], []*e.Node{args[1]})
+		if err != nil {
+			return nil, err
+		}
+		return ev.Apply(context.Background(), args[0], []*e.Node{first})
+	})
+	env.Register("+", func(args []*e.Node, ev *Evaluator) (*e.Node, error) {
+		return e.IntNode(args[0].IntVal + args[1].IntVal), nil
+	})
+
+	res, err := evalIn(t, ev, "(define add10 (lambda (x) (+ x 10))) (call-twice add10 (call-twice add10 1))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Equiv(e.IntNode(41)) {
+		t.Errorf("expected 41, got %s", res.Repr())
+	}
+	if len(ev.idle.vms) != 0 {
+		t.Errorf("expected nested applications to reuse the running VM, %d idle VMs created", len(ev.idle.vms))
+	}
+}
+
+func TestApplyErrorUnwindsToCaller(t *testing.T) {
+	env := NewEnvironment()
+	ev := New(engraving.StandardLogger, env)
+	env.Register("try", func(args []*e.Node, ev *Evaluator) (*e.Node, error) {
+		if _, err := ev.Apply(context.Background(), args[0], nil); err != nil {
+			return e.StrNode("caught"), nil
+		}
+		return e.StrNode("no error"), nil
+	})
+
+	res, err := evalIn(t, ev, `(define f (lambda () (undefined-thing 1 2))) (define g (lambda (x) x)) (g (try f))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !res.Equiv(e.StrNode("caught")) {
+		t.Errorf("expected caught, got %s", res.Repr())
+	}
+}
+
+func TestApplyProcedureSpreadsLastArgument(t *testing.T) {
+	env := NewEnvironment()
+	ev := New(engraving.StandardLogger, env)
+	env.BindByName("apply", ApplyProcedure())
+	env.Register("list", func(args []*e.Node, ev *Evaluator) (*e.Node, error) {
+		return e.NewListNode(args), nil
+	})
+
+	res, err := evalIn(t, ev, "(apply list 1 2 '(3 4))")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Repr() != "(1 2 3 4)" {
+		t.Errorf("expected (1 2 3 4), got %s", res.Repr())
+	}
+
+	_, err = evalIn(t, ev, "(apply list 1 2)")
+	if err == nil || !strings.Contains(err.Error(), "last argument must be a proper list") {
+		t.Errorf("expected proper list error, got %v", err)
+	}
+}
+
+func TestApplyProcedureInTailPositionDoesNotGrowFrames(t *testing.T) {
+	env := NewEnvironment()
+	ev := New(engraving.StandardLogger, env)
+	env.BindByName("apply", ApplyProcedure())
+	env.Register("-", func(args []*e.Node, ev *Evaluator) (*e.Node, error) {
+		return e.IntNode(args[0].IntVal - args[1].IntVal), nil
+	})
+	env.Register("zero?", func(args []*e.Node, ev *Evaluator) (*e.Node, error) {
+		return e.BoolNode(args[0].IntVal == 0), nil
+	})
+	env.Register("list", func(args []*e.Node, ev *Evaluator) (*e.Node, error) {
+		return e.NewListNode(args), nil
+	})
+	maxDepth := 0
+	env.Register("depth", func(args []*e.Node, ev *Evaluator) (*e.Node, error) {
+		if ev.active.fp > maxDepth {
+			maxDepth = ev.active.fp
+		}
+		return e.Nil, nil
+	})
+
+	res, err := evalIn(t, ev, `
+(define loop (lambda (n)
+  (depth)
+  (cond ((zero? n) 'done)
+        (else (apply loop (list (- n 1)))))))
+(loop 5000)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Repr() != "done" {
+		t.Errorf("expected done, got %s", res.Repr())
+	}
+	if maxDepth > 2 {
+		t.Errorf("expected apply in tail position to reuse the frame, reached depth %d", maxDepth)
+	}
+}
+
+func TestApplyNotAProcedure(t *testing.T) {
+	ev := New(engraving.StandardLogger, NewEnvironment())
+	_, err := ev.Apply(context.Background(), e.IntNode(1), nil)
+	if err == nil || !strings.Contains(err.Error(), "not a procedure") {
+		t.Errorf("expected not a procedure error, got %v", err)
+	}
+	var evalErr EvaluationError
+	if errors.As(err, &evalErr) {
+		t.Errorf("did not expect an evaluation error outside of evaluation, got %v", err)
+	}
+}
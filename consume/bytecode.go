@@ -2,6 +2,7 @@ package consume
 
 import (
 	"fmt"
+	"sync/atomic"
 
 	"github.com/archevel/ghoul/bones"
 )
@@ -24,7 +25,8 @@ const (
 	OP_MAKE_CLOSURE              // create closure from CodeObject at constants[operand]
 
 	// Specialized integer arithmetic — fast path for binary int ops.
-	// Pops two values; if both are IntegerNode, performs the op directly.
+	// Pops two values; if both are IntegerNode, performs the op directly,
+	// and if both are numbers and one is a float, does so in floats.
 	// Otherwise falls back to calling the named function from the environment.
 	OP_INT_ADD // int + int → int; fallback to "+"
 	OP_INT_SUB // int - int → int; fallback to "-"
@@ -35,20 +37,39 @@ const (
 	OP_INT_GE  // int >= int → bool; fallback to ">="
 
 	// Lexical addressing — O(1) variable access for locally-bound variables.
-	// Operand encodes (depth << 8 | slot) as a uint16.
-	OP_LOAD_LOCAL   // push env[depth][slot]
-	OP_SET_LOCAL    // pop value, assign to env[depth][slot], push value
-	OP_DEFINE_LOCAL // pop value, bind to env[top][slot], push value
+	// Locals live on the value stack above the frame's base pointer; the
+	// operand is the slot.
+	OP_LOAD_LOCAL   // push slot[operand]
+	OP_SET_LOCAL    // assign top of stack to slot[operand], leave it
+	OP_DEFINE_LOCAL // bind top of stack to slot[operand], leave it
+
+	// Boxed locals — slots captured by a closure that may change them
+	// afterwards are shared through a cell.
+	OP_LOAD_CELL   // push the cell of slot[operand]
+	OP_SET_CELL    // assign top of stack to the cell of slot[operand], leave it
+	OP_DEFINE_CELL // bind top of stack to the cell of slot[operand], leave it
+
+	// Free variables — values a closure captured when it was made, indexed
+	// by the operand into its CodeObject's Captures.
+	OP_LOAD_FREE      // push free[operand]
+	OP_LOAD_FREE_CELL // push the cell of free[operand]
+	OP_SET_FREE_CELL  // assign top of stack to the cell of free[operand], leave it
+
+	lastOpcode = OP_SET_FREE_CELL
 )
 
 // CodeObject represents a compiled function or top-level script.
 type CodeObject struct {
-	Code      []byte          // flat bytecode stream
-	Constants []*bones.Node   // constant pool
-	Locs      []LocEntry      // source map: bytecode offset → source location
+	Code      []byte           // flat bytecode stream
+	Constants []*bones.Node    // constant pool
+	Locs      []LocEntry       // source map: bytecode offset → source location
 	Params    *bones.ParamSpec // nil for top-level scripts
-	Name      string          // for debugging
-	NumLocals int             // number of indexed local slots needed
+	Name      string           // for debugging
+	NumLocals int              // number of indexed local slots needed
+	Captures  []Capture        // free variables, copied in by OP_MAKE_CLOSURE
+	Cells     []int            // slots boxed in a cell when the function is entered
+
+	caches atomic.Pointer[[]inlineCache] // OP_LOAD_VAR inline caches, by constant index
 }
 
 // LocEntry maps a bytecode offset to a source location for error reporting.
@@ -57,28 +78,43 @@ type LocEntry struct {
 	Loc     bones.CodeLocation
 }
 
-// localFrame holds indexed local variable slots for a single lexical scope.
-// Frames form a linked chain via the parent pointer, enabling O(1) access
-// to variables at known (depth, slot) coordinates.
-type localFrame struct {
-	slots  []*bones.Node
-	parent *localFrame
+// Capture says where OP_MAKE_CLOSURE finds one free variable: a local slot
+// of the function making the closure, or one of that function's own free
+// variables. Cell captures share the variable's cell instead of copying
+// its value.
+type Capture struct {
+	Local bool
+	Index int
+	Cell  bool
+}
+
+// cell boxes a local that is captured and changed after capture, so every
+// closure sharing it sees the change.
+type cell struct {
+	v value
+}
+
+// upvalue is one free variable of a closure: a copied value, or a cell.
+type upvalue struct {
+	val  value
+	cell *cell
 }
 
 // closureData holds a compiled function and its captured environment.
 type closureData struct {
-	code   *CodeObject
-	env    *environment
-	locals *localFrame // captured local slots from enclosing scopes
+	code *CodeObject
+	env  *environment
+	free []upvalue // captured variables, in the order of code.Captures
 }
 
 // callFrame tracks VM state per function call.
 type callFrame struct {
-	code   *CodeObject
-	ip     int
-	bp     int          // base pointer into value stack
-	env    *environment
-	locals *localFrame  // indexed local slots for this scope
+	code    *CodeObject
+	ip      int
+	bp      int // base pointer into value stack; locals start here
+	env     *environment
+	closure *closureData // nil for top-level code
+	cells   []*cell      // by slot, for the slots in code.Cells
 }
 
 // --- CodeObject helpers ---
@@ -181,6 +217,18 @@ func opcodeName(op byte) string {
 		return "OP_SET_LOCAL"
 	case OP_DEFINE_LOCAL:
 		return "OP_DEFINE_LOCAL"
+	case OP_LOAD_CELL:
+		return "OP_LOAD_CELL"
+	case OP_SET_CELL:
+		return "OP_SET_CELL"
+	case OP_DEFINE_CELL:
+		return "OP_DEFINE_CELL"
+	case OP_LOAD_FREE:
+		return "OP_LOAD_FREE"
+	case OP_LOAD_FREE_CELL:
+		return "OP_LOAD_FREE_CELL"
+	case OP_SET_FREE_CELL:
+		return "OP_SET_FREE_CELL"
 	default:
 		return fmt.Sprintf("OP_UNKNOWN(%d)", op)
 	}
@@ -0,0 +1,152 @@
+package consume
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/archevel/ghoul/engraving"
+)
+
+func TestClosuresCaptureOnlyTheirFreeVariables(t *testing.T) {
+	code := compileSource(t, `(lambda (a b c) (lambda () b))`)
+	inner := closureCode(t, closureCode(t, code))
+	if len(inner.Captures) != 1 || inner.Captures[0] != (Capture{Local: true, Index: 1}) {
+		t.Errorf("expected only b to be captured, by value:\n%s", DisassembleString(code))
+	}
+}
+
+func TestCapturesReachThroughIntermediateClosures(t *testing.T) {
+	ev := New(engraving.StandardLogger, pureTestEnvironment())
+	got := runSource(t, ev, `(define f (lambda (x) (lambda () (lambda () x)))) (((f 7)))`)
+	if got.IntVal != 7 {
+		t.Errorf("expected 7, got %s", got.Repr())
+	}
+	middle := closureCode(t, closureCode(t, compileSource(t, `(lambda (x) (lambda () (lambda () x)))`)))
+	if len(middle.Captures) != 1 || !middle.Captures[0].Local {
+		t.Errorf("expected the middle lambda to carry x for the inner one, got %+v", middle.Captures)
+	}
+}
+
+func TestMutatedCapturesShareACell(t *testing.T) {
+	ev := New(engraving.StandardLogger, pureTestEnvironment())
+	got := runSource(t, ev, `
+(define count-twice
+  (lambda ()
+    (define n 0)
+    (define inc (lambda () (set! n (+ n 1))))
+    (define get (lambda () n))
+    (inc)
+    (inc)
+    (get)))
+(count-twice)`)
+	if got.IntVal != 2 {
+		t.Errorf("expected get to see both increments, got %s", got.Repr())
+	}
+
+	got = runSource(t, ev, `
+(define make-counter (lambda () (define n 0) (lambda () (set! n (+ n 1)) n)))
+(define c (make-counter))
+(c)
+(c)`)
+	if got.IntVal != 2 {
+		t.Errorf("expected the counter to reach 2, got %s", got.Repr())
+	}
+
+	code := closureCode(t, compileSource(t, `(lambda () (define n 0) (lambda () (set! n 1)) (lambda () n))`))
+	if len(code.Cells) != 1 {
+		t.Errorf("expected n to be boxed:\n%s", DisassembleString(code))
+	}
+}
+
+func TestUnmutatedCapturesNeedNoCell(t *testing.T) {
+	code := closureCode(t, compileSource(t, `(lambda (x) (define y x) (lambda () (+ x y)))`))
+	if len(code.Cells) != 0 {
+		t.Errorf("expected no cells:\n%s", DisassembleString(code))
+	}
+}
+
+func TestRecursiveLocalClosureSeesItself(t *testing.T) {
+	ev := New(engraving.StandardLogger, pureTestEnvironment())
+	got := runSource(t, ev, `
+(define fact
+  (lambda (n)
+    (define go (lambda (k) (cond ((< k 2) 1) (else (* k (go (- k 1)))))))
+    (go n)))
+(fact 5)`)
+	if got.IntVal != 120 {
+		t.Errorf("expected 120, got %s", got.Repr())
+	}
+}
+
+func TestSelfTailCallsGiveEachIterationItsOwnVariables(t *testing.T) {
+	ev := New(engraving.StandardLogger, pureTestEnvironment())
+	got := runSource(t, ev, `
+(define loop
+  (lambda (i a b)
+    (cond ((< i 2) (loop (+ i 1) b (lambda () i)))
+          (else (+ (a) (* 10 (b)))))))
+(loop 0 #f #f)`)
+	if got.IntVal != 10 {
+		t.Errorf("expected the closures to see 0 and 1, got %s", got.Repr())
+	}
+
+	got = runSource(t, ev, `
+(define loop
+  (lambda (i a b)
+    (define j i)
+    (set! j (* j 3))
+    (cond ((< i 2) (loop (+ i 1) b (lambda () j)))
+          (else (+ (a) (* 10 (b)))))))
+(loop 0 #f #f)`)
+	if got.IntVal != 30 {
+		t.Errorf("expected the boxed closures to see 0 and 3, got %s", got.Repr())
+	}
+}
+
+func TestImmediatelyAppliedLambdasAreInlined(t *testing.T) {
+	code := compileSource(t, `(lambda (x) ((lambda (y z) (+ y z)) x (* x 2)))`)
+	body := closureCode(t, code)
+	if hasOpcode(body, OP_MAKE_CLOSURE) || hasOpcode(body, OP_CALL) || hasOpcode(body, OP_TAIL_CALL) {
+		t.Errorf("expected the inner lambda to be inlined:\n%s", DisassembleString(code))
+	}
+	if body.NumLocals != 3 {
+		t.Errorf("expected 3 locals, got %d", body.NumLocals)
+	}
+}
+
+func TestInlinedLambdasKeepTheirCaptures(t *testing.T) {
+	ev := New(engraving.StandardLogger, pureTestEnvironment())
+	code := compileSource(t, `(lambda (x) ((lambda (y) (lambda () y)) x))`)
+	if hasOpcode(closureCode(t, code), OP_TAIL_CALL) {
+		t.Errorf("expected the lambda capturing y to be inlined:\n%s", DisassembleString(code))
+	}
+	got := runSource(t, ev, `(define f (lambda (x) ((lambda (y) (lambda () y)) x))) ((f 4))`)
+	if got.IntVal != 4 {
+		t.Errorf("expected 4, got %s", got.Repr())
+	}
+}
+
+func TestInlinedLambdasAtTopLevelStayLocal(t *testing.T) {
+	ev := New(engraving.StandardLogger, pureTestEnvironment())
+	code := compileSource(t, `((lambda (y) (define z y) z) 1)`)
+	if hasOpcode(code, OP_MAKE_CLOSURE) || code.NumLocals != 2 {
+		t.Errorf("expected the lambda to be inlined into two top-level slots:\n%s", DisassembleString(code))
+	}
+	if got := runSource(t, ev, `((lambda (y) (define z y) z) 1)`); got.IntVal != 1 {
+		t.Errorf("expected 1, got %s", got.Repr())
+	}
+	for _, name := range []string{"y", "z"} {
+		if _, err := ev.env.LookupByName(name); err == nil {
+			t.Errorf("expected %s not to leak into the global environment", name)
+		}
+	}
+}
+
+func TestLocalUsedBeforeItsDefinitionIsAnError(t *testing.T) {
+	ev := New(engraving.StandardLogger, pureTestEnvironment())
+	_, err := ev.ConsumeNodesWithContext(context.Background(), translateSource(t, `((lambda () (define w ((lambda () w))) w))`))
+	if err == nil || !strings.Contains(err.Error(), "used before its definition") {
+		t.Errorf("expected a use before definition error, got %v", err)
+	}
+}
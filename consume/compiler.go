@@ -6,55 +6,175 @@ import (
 	"github.com/archevel/ghoul/bones"
 )
 
-// lexScope tracks variable-to-slot mappings at compile time for lexical addressing.
-// Each lambda body gets its own lexScope; inner lambdas link to the outer via parent.
+// variable is a binding the compiler keeps in a local slot: a parameter,
+// an internal define or a variable of an inlined lambda. Nested lambdas
+// capture it by value unless it needs a cell; sites remembers each place
+// that reads, writes or captures it so they can be switched over to the
+// cell once the function owning it is compiled.
+type variable struct {
+	fn       *funcScope
+	slot     int
+	captured bool // a nested lambda refers to it
+	assigned bool // a set! changes it after it is bound
+	defining bool // the value of its define is being compiled
+	cell     bool // captured and assigned, or captured before it was bound
+	sites    []site
+}
+
+// site is an instruction at pc in co, or with pc < 0, entry capture of
+// co.Captures.
+type site struct {
+	co      *CodeObject
+	pc      int
+	capture int
+}
+
+// funcScope is the compile-time state of one lambda, or of top-level code.
+type funcScope struct {
+	co     *CodeObject
+	outer  *funcScope        // the function the lambda is written in
+	free   map[*variable]int // captured variables, by index in co.Captures
+	locals []*variable       // variables owning a slot of co
+}
+
+func newFuncScope(co *CodeObject, outer *funcScope) *funcScope {
+	return &funcScope{co: co, outer: outer, free: map[*variable]int{}}
+}
+
+// capture returns the index of v among fn's free variables, capturing it
+// through every function between fn and the one owning v.
+func (fn *funcScope) capture(v *variable) int {
+	if idx, ok := fn.free[v]; ok {
+		return idx
+	}
+	c := Capture{Local: v.fn == fn.outer, Index: v.slot}
+	if !c.Local {
+		c.Index = fn.outer.capture(v)
+	}
+	idx := len(fn.co.Captures)
+	fn.co.Captures = append(fn.co.Captures, c)
+	fn.free[v] = idx
+	v.captured = true
+	if v.assigned || v.defining {
+		v.cell = true
+	}
+	v.sites = append(v.sites, site{co: fn.co, pc: -1, capture: idx})
+	return idx
+}
+
+// finish switches the variables of fn that need a cell over to one.
+func (fn *funcScope) finish() error {
+	if fn.co.NumLocals > maxLocals {
+		return fmt.Errorf("compile: %s needs %d local slots, more than %d", fn.co.Name, fn.co.NumLocals, maxLocals)
+	}
+	for _, v := range fn.locals {
+		if !v.cell {
+			continue
+		}
+		fn.co.Cells = append(fn.co.Cells, v.slot)
+		for _, s := range v.sites {
+			if s.pc < 0 {
+				s.co.Captures[s.capture].Cell = true
+				continue
+			}
+			s.co.Code[s.pc] = cellOpcode(s.co.Code[s.pc])
+		}
+	}
+	return nil
+}
+
+func cellOpcode(op byte) byte {
+	switch op {
+	case OP_LOAD_LOCAL:
+		return OP_LOAD_CELL
+	case OP_SET_LOCAL:
+		return OP_SET_CELL
+	case OP_DEFINE_LOCAL:
+		return OP_DEFINE_CELL
+	case OP_LOAD_FREE:
+		return OP_LOAD_FREE_CELL
+	}
+	return op
+}
+
+// lexScope maps names to variables for one lambda body or inlined lambda;
+// scopes link outward through enclosing lambdas. Names are keyed like
+// environment scopes (name plus hygiene marks) so a macro-introduced
+// binding never captures a user variable of the same name. Top-level code
+// has a root scope with no names, where define binds globals.
 type lexScope struct {
-	names  map[string]int // variable name → slot index in this scope
-	count  int            // next available slot
-	parent *lexScope      // enclosing scope (nil for top-level)
+	names  map[scopeKey]*variable
+	parent *lexScope
+	fn     *funcScope
+	global bool // defines in this scope bind globals
 }
 
-func newLexScope(parent *lexScope) *lexScope {
-	return &lexScope{names: map[string]int{}, parent: parent}
+func newLexScope(parent *lexScope, fn *funcScope) *lexScope {
+	return &lexScope{names: map[scopeKey]*variable{}, parent: parent, fn: fn}
 }
 
-// define allocates a new slot for the given name and returns its index.
-func (ls *lexScope) define(name string) int {
-	slot := ls.count
-	ls.names[name] = slot
-	ls.count++
-	return slot
+// define allocates a new slot in the scope's function for the given
+// identifier.
+func (ls *lexScope) define(key scopeKey) *variable {
+	fn := ls.fn
+	v := &variable{fn: fn, slot: fn.co.NumLocals}
+	fn.co.NumLocals++
+	fn.locals = append(fn.locals, v)
+	ls.names[key] = v
+	return v
 }
 
-// resolve looks up a variable name in the lexical scope chain.
-// Returns (depth, slot, found) where depth is 0 for the current scope,
-// 1 for the immediate parent, etc.
-func (ls *lexScope) resolve(name string) (int, int, bool) {
-	depth := 0
-	for s := ls; s != nil; s = s.parent {
-		if slot, ok := s.names[name]; ok {
-			return depth, slot, true
+// resolve looks up an identifier in the lexical scope chain. Like
+// findBinding, a marked identifier with no exact binding drops its newest
+// mark and retries, so macro templates still reach variables visible
+// where they were written.
+func (ls *lexScope) resolve(key scopeKey) (*variable, bool) {
+	for {
+		if v, ok := ls.resolveExact(key); ok {
+			return v, true
+		}
+		var more bool
+		if key, more = key.outerKey(); !more {
+			return nil, false
 		}
-		depth++
 	}
-	return 0, 0, false
 }
 
-// encodeLexAddr packs (depth, slot) into a uint16 operand: depth<<8 | slot.
-// Supports depth 0-255 and slot 0-255.
-func encodeLexAddr(depth, slot int) int {
-	return (depth << 8) | slot
+func (ls *lexScope) resolveExact(key scopeKey) (*variable, bool) {
+	for s := ls; s != nil; s = s.parent {
+		if v, ok := s.names[key]; ok {
+			return v, true
+		}
+	}
+	return nil, false
 }
 
-// decodeLexAddr unpacks a uint16 operand into (depth, slot).
-func decodeLexAddr(operand uint16) (int, int) {
-	return int(operand >> 8), int(operand & 0xFF)
+// emitLocal emits op for v as seen from ls: a slot of the running
+// function, or one of its free variables.
+func emitLocal(co *CodeObject, ls *lexScope, op byte, v *variable, loc bones.CodeLocation) {
+	operand := v.slot
+	if v.fn != ls.fn {
+		operand = ls.fn.capture(v)
+		switch op {
+		case OP_LOAD_LOCAL:
+			op = OP_LOAD_FREE
+		case OP_SET_LOCAL:
+			op = OP_SET_FREE_CELL
+		}
+	}
+	co.emitWithLoc(op, loc)
+	co.Code = co.Code[:len(co.Code)-1]
+	v.sites = append(v.sites, site{co: co, pc: len(co.Code)})
+	co.emitWithOperand(op, operand)
 }
 
 // compileTopLevel compiles a sequence of top-level AST nodes into a CodeObject.
-// Top-level code has no lexical scope — all variables are resolved via OP_LOAD_VAR.
+// Top-level variables are globals, resolved via OP_LOAD_VAR; only the
+// variables of lambdas inlined at top level get local slots.
 func compileTopLevel(nodes []*bones.Node) (*CodeObject, error) {
 	co := &CodeObject{Name: "top-level"}
+	fn := newFuncScope(co, nil)
+	ls := &lexScope{fn: fn, global: true}
 
 	if len(nodes) == 0 {
 		co.emit(OP_NIL)
@@ -63,7 +183,7 @@ func compileTopLevel(nodes []*bones.Node) (*CodeObject, error) {
 	}
 
 	for i, node := range nodes {
-		if err := compileExpr(co, node, i == len(nodes)-1, nil); err != nil {
+		if err := compileExpr(co, node, i == len(nodes)-1, ls); err != nil {
 			return nil, err
 		}
 		// Discard intermediate results (all but the last)
@@ -73,12 +193,15 @@ func compileTopLevel(nodes []*bones.Node) (*CodeObject, error) {
 	}
 
 	co.emit(OP_RETURN)
+	if err := fn.finish(); err != nil {
+		return nil, err
+	}
 	return co, nil
 }
 
 // compileExpr compiles a single AST node. tailPos indicates whether the
 // result of this expression goes directly to the caller (for TCO).
-// ls is the current lexical scope (nil at top-level).
+// ls is the current lexical scope.
 func compileExpr(co *CodeObject, node *bones.Node, tailPos bool, ls *lexScope) error {
 	switch node.Kind {
 	case bones.NilNode:
@@ -96,12 +219,9 @@ func compileExpr(co *CodeObject, node *bones.Node, tailPos bool, ls *lexScope) e
 		}
 
 	case bones.IdentifierNode:
-		name := node.IdentName()
-		if ls != nil && name != "" {
-			if depth, slot, ok := ls.resolve(name); ok {
-				co.emitWithLoc(OP_LOAD_LOCAL, node.Loc)
-				co.Code = co.Code[:len(co.Code)-1]
-				co.emitWithOperand(OP_LOAD_LOCAL, encodeLexAddr(depth, slot))
+		if key, ok := keyFromNode(node); ok {
+			if v, ok := ls.resolve(key); ok {
+				emitLocal(co, ls, OP_LOAD_LOCAL, v, node.Loc)
 				return nil
 			}
 		}
@@ -150,25 +270,23 @@ func compileExpr(co *CodeObject, node *bones.Node, tailPos bool, ls *lexScope) e
 }
 
 func compileDefine(co *CodeObject, node *bones.Node, tailPos bool, ls *lexScope) error {
-	name := node.Children[0].IdentName()
+	key, isIdent := keyFromNode(node.Children[0])
 
-	if ls != nil && name != "" {
+	if !ls.global && isIdent {
 		// Allocate the slot before compiling the value so that recursive
 		// references (e.g., (define walk (lambda ... (walk ...)))) can
 		// resolve the name during compilation of the lambda body.
-		slot := ls.define(name)
-		if slot+1 > co.NumLocals {
-			co.NumLocals = slot + 1
-		}
+		v := ls.define(key)
 
 		// Compile value (name is already in scope)
-		if err := compileExpr(co, node.Children[1], false, ls); err != nil {
+		v.defining = true
+		err := compileExpr(co, node.Children[1], false, ls)
+		v.defining = false
+		if err != nil {
 			return err
 		}
 
-		co.emitWithLoc(OP_DEFINE_LOCAL, node.Loc)
-		co.Code = co.Code[:len(co.Code)-1]
-		co.emitWithOperand(OP_DEFINE_LOCAL, slot)
+		emitLocal(co, ls, OP_DEFINE_LOCAL, v, node.Loc)
 		return nil
 	}
 
@@ -188,12 +306,13 @@ func compileSet(co *CodeObject, node *bones.Node, tailPos bool, ls *lexScope) er
 		return err
 	}
 
-	name := node.Children[0].IdentName()
-	if ls != nil && name != "" {
-		if depth, slot, ok := ls.resolve(name); ok {
-			co.emitWithLoc(OP_SET_LOCAL, node.Loc)
-			co.Code = co.Code[:len(co.Code)-1]
-			co.emitWithOperand(OP_SET_LOCAL, encodeLexAddr(depth, slot))
+	if key, ok := keyFromNode(node.Children[0]); ok {
+		if v, ok := ls.resolve(key); ok {
+			v.assigned = true
+			if v.captured {
+				v.cell = true
+			}
+			emitLocal(co, ls, OP_SET_LOCAL, v, node.Loc)
 			return nil
 		}
 	}
@@ -224,50 +343,35 @@ func compileBegin(co *CodeObject, node *bones.Node, tailPos bool, ls *lexScope)
 }
 
 func compileLambda(co *CodeObject, node *bones.Node, parentLs *lexScope) error {
-	// Create a new lexical scope for this lambda's body
-	ls := newLexScope(parentLs)
+	// Compile body into a child CodeObject
+	child := &CodeObject{
+		Name:   "lambda",
+		Params: node.Params,
+	}
+	fn := newFuncScope(child, parentLs.fn)
+	ls := newLexScope(parentLs, fn)
 
 	// Pre-allocate slots for parameters
 	if node.Params != nil {
 		for _, param := range node.Params.Fixed {
-			name := param.IdentName()
-			if name != "" {
-				ls.define(name)
+			if key, ok := keyFromNode(param); ok {
+				ls.define(key)
 			}
 		}
 		if node.Params.Variadic != nil {
-			name := node.Params.Variadic.IdentName()
-			if name != "" {
-				ls.define(name)
+			if key, ok := keyFromNode(node.Params.Variadic); ok {
+				ls.define(key)
 			}
 		}
 	}
 
-	// Compile body into a child CodeObject
-	child := &CodeObject{
-		Name:      "lambda",
-		Params:    node.Params,
-		NumLocals: ls.count, // at least params
-	}
-
-	if len(node.Children) == 0 {
-		child.emit(OP_NIL)
-	} else {
-		for i, bodyExpr := range node.Children {
-			isTail := i == len(node.Children)-1
-			if err := compileExpr(child, bodyExpr, isTail, ls); err != nil {
-				return err
-			}
-			if i < len(node.Children)-1 {
-				child.emit(OP_POP)
-			}
-		}
-	}
-	// NumLocals may have grown during body compilation (from define)
-	if ls.count > child.NumLocals {
-		child.NumLocals = ls.count
+	if err := compileBody(child, node.Children, true, ls); err != nil {
+		return err
 	}
 	child.emit(OP_RETURN)
+	if err := fn.finish(); err != nil {
+		return err
+	}
 
 	// Store child CodeObject in parent's constant pool (wrapped as ForeignNode)
 	codeNode := bones.ForeignNodeVal(child)
@@ -276,6 +380,68 @@ func compileLambda(co *CodeObject, node *bones.Node, parentLs *lexScope) error {
 	return nil
 }
 
+// compileBody compiles a sequence of expressions, leaving the value of
+// the last one, or Nil when there are none.
+func compileBody(co *CodeObject, body []*bones.Node, tailPos bool, ls *lexScope) error {
+	if len(body) == 0 {
+		co.emit(OP_NIL)
+		return nil
+	}
+	for i, expr := range body {
+		isTail := tailPos && i == len(body)-1
+		if err := compileExpr(co, expr, isTail, ls); err != nil {
+			return err
+		}
+		if i < len(body)-1 {
+			co.emit(OP_POP)
+		}
+	}
+	return nil
+}
+
+// inlinableLambda reports whether a call applies a lambda expression
+// directly to as many arguments as it has fixed parameters, as let does.
+// Such a lambda cannot escape, so its body is compiled in place.
+func inlinableLambda(node *bones.Node) bool {
+	lam := node.Children[0]
+	if lam.Kind != bones.LambdaNode || lam.Params == nil || lam.Params.Variadic != nil {
+		return false
+	}
+	if len(lam.Params.Fixed) != len(node.Children)-1 {
+		return false
+	}
+	for _, p := range lam.Params.Fixed {
+		if p.Kind != bones.IdentifierNode {
+			return false
+		}
+	}
+	return true
+}
+
+// compileInlineCall binds the arguments to fresh slots of the running
+// function and compiles the lambda's body in a scope of its own.
+func compileInlineCall(co *CodeObject, node *bones.Node, tailPos bool, ls *lexScope) error {
+	lam := node.Children[0]
+	for _, arg := range node.Children[1:] {
+		if err := compileExpr(co, arg, false, ls); err != nil {
+			return err
+		}
+	}
+
+	block := newLexScope(ls, ls.fn)
+	vars := make([]*variable, len(lam.Params.Fixed))
+	for i, p := range lam.Params.Fixed {
+		key, _ := keyFromNode(p)
+		vars[i] = block.define(key)
+	}
+	// The last argument is on top of the stack.
+	for i := len(vars) - 1; i >= 0; i-- {
+		emitLocal(co, block, OP_DEFINE_LOCAL, vars[i], nil)
+		co.emit(OP_POP)
+	}
+	return compileBody(co, lam.Children, tailPos, block)
+}
+
 func compileCond(co *CodeObject, node *bones.Node, tailPos bool, ls *lexScope) error {
 	if len(node.Clauses) == 0 {
 		co.emit(OP_NIL)
@@ -332,20 +498,7 @@ func compileCond(co *CodeObject, node *bones.Node, tailPos bool, ls *lexScope) e
 }
 
 func compileCondBody(co *CodeObject, body []*bones.Node, tailPos bool, ls *lexScope) error {
-	if len(body) == 0 {
-		co.emit(OP_NIL)
-		return nil
-	}
-	for i, expr := range body {
-		isTail := tailPos && i == len(body)-1
-		if err := compileExpr(co, expr, isTail, ls); err != nil {
-			return err
-		}
-		if i < len(body)-1 {
-			co.emit(OP_POP)
-		}
-	}
-	return nil
+	return compileBody(co, body, tailPos, ls)
 }
 
 // intArithOp maps binary arithmetic operator names to specialized opcodes.
@@ -366,6 +519,10 @@ func compileCall(co *CodeObject, node *bones.Node, tailPos bool, ls *lexScope) e
 
 	argc := len(node.Children) - 1
 
+	if inlinableLambda(node) {
+		return compileInlineCall(co, node, tailPos, ls)
+	}
+
 	// Try to emit a specialized integer opcode for binary calls to known operators.
 	if argc == 2 {
 		callee := node.Children[0]
@@ -1,6 +1,7 @@
 package consume
 
 import (
+	"reflect"
 	"testing"
 
 	e "github.com/archevel/ghoul/bones"
@@ -37,7 +38,7 @@ func TestBoundIdentifierNamesExcludesMarkedBindings(t *testing.T) {
 	// Bind a scoped identifier (with marks) — should NOT appear in BoundIdentifierNames
 	scope := currentScope(env)
 	marks := map[uint64]bool{1: true}
-	(*scope)[keyFromNameAndMarks("scoped-var", marks)] = e.IntNode(42)
+	scope.set(keyFromNameAndMarks("scoped-var", marks), e.IntNode(42))
 
 	names := env.BoundIdentifierNames()
 	if names["scoped-var"] {
@@ -45,28 +46,33 @@ func TestBoundIdentifierNamesExcludesMarkedBindings(t *testing.T) {
 	}
 }
 
-// --- canonicalMarks ---
+// --- internMarks ---
 
-func TestCanonicalMarksOrder(t *testing.T) {
-	marks := map[uint64]bool{3: true, 1: true, 2: true}
-	result := canonicalMarks(marks)
-	expected := "1,2,3"
-	if result != expected {
-		t.Errorf("canonicalMarks(%v) = %q, want %q", marks, result, expected)
+func TestInternMarksIgnoresOrder(t *testing.T) {
+	a := internMarks(map[uint64]bool{3: true, 1: true, 2: true})
+	b := internMarks(map[uint64]bool{1: true, 2: true, 3: true})
+	if a != b {
+		t.Errorf("equal mark sets interned as %v and %v", marksOf(a), marksOf(b))
+	}
+	if got := marksOf(a); !reflect.DeepEqual(got, []uint64{1, 2, 3}) {
+		t.Errorf("marksOf(a) = %v, want [1 2 3]", got)
 	}
 }
 
-func TestCanonicalMarksEmpty(t *testing.T) {
-	result := canonicalMarks(map[uint64]bool{})
-	if result != "" {
-		t.Errorf("canonicalMarks(empty) = %q, want empty string", result)
+func TestInternMarksEmpty(t *testing.T) {
+	if id := internMarks(map[uint64]bool{}); !id.empty() {
+		t.Errorf("internMarks(empty) = %v, want the empty set", marksOf(id))
 	}
 }
 
-func TestCanonicalMarksSingle(t *testing.T) {
-	result := canonicalMarks(map[uint64]bool{42: true})
-	if result != "42" {
-		t.Errorf("canonicalMarks({42}) = %q, want \"42\"", result)
+func TestInternMarksOuterDropsNewestMark(t *testing.T) {
+	id := internMarks(map[uint64]bool{42: true, 7: true})
+	outer := outerMarks(id)
+	if got := marksOf(outer); !reflect.DeepEqual(got, []uint64{7}) {
+		t.Errorf("outer marks = %v, want [7]", got)
+	}
+	if !outerMarks(outer).empty() {
+		t.Error("expected a single mark to drop to the empty set")
 	}
 }
 
@@ -161,10 +167,10 @@ func TestKeyFromNodeScopedIdentifier(t *testing.T) {
 	if !ok {
 		t.Fatal("keyFromNode on ScopedIdentNode should return true")
 	}
-	if key.Name != "x" {
-		t.Errorf("expected name 'x', got %q", key.Name)
+	if key.name() != "x" {
+		t.Errorf("expected name 'x', got %q", key.name())
 	}
-	if key.MarksKey != "5,10" {
-		t.Errorf("expected MarksKey '5,10', got %q", key.MarksKey)
+	if got := marksOf(key.Marks); !reflect.DeepEqual(got, []uint64{5, 10}) {
+		t.Errorf("expected marks [5 10], got %v", got)
 	}
 }
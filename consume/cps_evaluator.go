@@ -12,20 +12,16 @@ func (ev *Evaluator) ConsumeNodes(nodes []*bones.Node) (*bones.Node, error) {
 }
 
 func (ev *Evaluator) ConsumeNodesWithContext(ctx context.Context, nodes []*bones.Node) (*bones.Node, error) {
-	code, err := compileTopLevel(nodes)
+	code, err := ev.Compile(nodes)
 	if err != nil {
 		return nil, err
 	}
-	vm := newVM(ev)
-	return vm.run(ctx, code)
+	return ev.RunCode(ctx, code)
 }
 
-// EvalSubExpression evaluates a single Node expression using a fresh VM.
+// EvalSubExpression evaluates a single Node expression using a fresh VM,
+// under the context of the running evaluation.
 func (ev *Evaluator) EvalSubExpression(node *bones.Node) (*bones.Node, error) {
-	subEval := &Evaluator{
-		log:         ev.log,
-		env:         ev.env,
-		markCounter: ev.markCounter,
-	}
-	return subEval.ConsumeNodes([]*bones.Node{node})
+	subEval := ev.ForEnvironment(ev.env)
+	return subEval.ConsumeNodesWithContext(ev.Context(), []*bones.Node{node})
 }
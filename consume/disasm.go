@@ -0,0 +1,189 @@
+package consume
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/archevel/ghoul/bones"
+)
+
+// Disassemble writes a listing of co to w: one line per instruction with
+// its offset, decoded operand and, where the source map starts an entry,
+// the source location. Closures in the constant pool follow their parent,
+// numbered in the order they are first met.
+func Disassemble(w io.Writer, co *CodeObject) error {
+	bw := bufio.NewWriter(w)
+	d := &disassembler{w: bw, numbers: map[*CodeObject]int{}}
+	d.code(co)
+	return bw.Flush()
+}
+
+// DisassembleString returns the listing Disassemble would write.
+func DisassembleString(co *CodeObject) string {
+	var sb strings.Builder
+	Disassemble(&sb, co)
+	return sb.String()
+}
+
+// ProcedureCode returns the compiled code behind a closure, or false when
+// fn is a Go function or not a procedure at all.
+func ProcedureCode(fn *bones.Node) (*CodeObject, bool) {
+	if fn == nil || fn.Kind != bones.FunctionNode {
+		return nil, false
+	}
+	cd, ok := fn.ForeignVal.(*closureData)
+	if !ok {
+		return nil, false
+	}
+	return cd.code, true
+}
+
+type disassembler struct {
+	w       *bufio.Writer
+	numbers map[*CodeObject]int
+	pending []*CodeObject // closures waiting to be listed
+}
+
+func (d *disassembler) label(co *CodeObject) string {
+	if co.Params == nil {
+		return co.Name
+	}
+	n, ok := d.numbers[co]
+	if !ok {
+		n = len(d.numbers) + 1
+		d.numbers[co] = n
+	}
+	return fmt.Sprintf("%s #%d", co.Name, n)
+}
+
+func (d *disassembler) code(co *CodeObject) {
+	if co.Params != nil {
+		fmt.Fprintf(d.w, "== %s %s, %d locals%s ==\n", d.label(co), paramsRepr(co.Params), co.NumLocals, captureRepr(co))
+	} else if co.NumLocals > 0 {
+		fmt.Fprintf(d.w, "== %s, %d locals ==\n", d.label(co), co.NumLocals)
+	} else {
+		fmt.Fprintf(d.w, "== %s ==\n", d.label(co))
+	}
+
+	// A location is shown where it first applies; the compiler repeats
+	// it for every instruction of an expression.
+	shown := ""
+	for pc := 0; pc < len(co.Code); {
+		op := co.Code[pc]
+		line := fmt.Sprintf("%04d  %s", pc, opcodeName(op))
+		next := pc + 1
+		if op <= lastOpcode && hasOperand(op) {
+			if pc+2 < len(co.Code) {
+				line = fmt.Sprintf("%04d  %-17s %s", pc, opcodeName(op), d.operand(co, op, int(readUint16(co.Code, pc+1))))
+				next = pc + 3
+			} else {
+				line += "  <truncated>"
+				next = len(co.Code)
+			}
+		}
+		if loc := co.locForPC(pc); loc != nil && loc.String() != shown {
+			shown = loc.String()
+			line = fmt.Sprintf("%-48s  @ %s", line, shown)
+		}
+		fmt.Fprintln(d.w, strings.TrimRight(line, " "))
+		pc = next
+	}
+
+	pending := d.pending
+	d.pending = nil
+	for _, p := range pending {
+		fmt.Fprintln(d.w)
+		d.code(p)
+	}
+}
+
+func (d *disassembler) operand(co *CodeObject, op byte, operand int) string {
+	constant := func() *bones.Node {
+		if operand < len(co.Constants) {
+			return co.Constants[operand]
+		}
+		return nil
+	}
+
+	switch op {
+	case OP_CONST, OP_LOAD_VAR, OP_DEFINE, OP_SET:
+		if c := constant(); c != nil {
+			return fmt.Sprintf("%-5d ; %s", operand, c.Repr())
+		}
+	case OP_INT_ADD, OP_INT_SUB, OP_INT_MUL, OP_INT_LT, OP_INT_LE, OP_INT_GT, OP_INT_GE:
+		if c := constant(); c != nil {
+			return fmt.Sprintf("%-5d ; else call %s", operand, c.Repr())
+		}
+	case OP_MAKE_CLOSURE:
+		if c := constant(); c != nil {
+			if child, ok := c.ForeignVal.(*CodeObject); ok {
+				d.pending = append(d.pending, child)
+				return fmt.Sprintf("%-5d ; %s", operand, d.label(child))
+			}
+		}
+	case OP_CALL, OP_TAIL_CALL:
+		return fmt.Sprintf("%d args", operand)
+	case OP_JUMP, OP_JUMP_IF_FALSE:
+		return fmt.Sprintf("-> %04d", operand)
+	case OP_LOAD_LOCAL, OP_SET_LOCAL, OP_DEFINE_LOCAL, OP_LOAD_CELL, OP_SET_CELL, OP_DEFINE_CELL:
+		if name := localName(co, operand); name != "" {
+			return fmt.Sprintf("slot %-5d ; %s", operand, name)
+		}
+		return fmt.Sprintf("slot %d", operand)
+	case OP_LOAD_FREE, OP_LOAD_FREE_CELL, OP_SET_FREE_CELL:
+		return fmt.Sprintf("free %d", operand)
+	}
+	return fmt.Sprintf("%-5d ; <invalid>", operand)
+}
+
+// localName names a slot when it holds a parameter of co; slots for
+// internal defines are not named in the CodeObject.
+func localName(co *CodeObject, slot int) string {
+	params := co.Params
+	if params == nil {
+		return ""
+	}
+	if slot < len(params.Fixed) {
+		return params.Fixed[slot].Repr()
+	}
+	if slot == len(params.Fixed) && params.Variadic != nil {
+		return params.Variadic.Repr()
+	}
+	return ""
+}
+
+// captureRepr lists where co's free variables come from in the code that
+// makes it, marking the ones shared through a cell.
+func captureRepr(co *CodeObject) string {
+	if len(co.Captures) == 0 {
+		return ""
+	}
+	froms := make([]string, len(co.Captures))
+	for i, c := range co.Captures {
+		from := fmt.Sprintf("free %d", c.Index)
+		if c.Local {
+			from = fmt.Sprintf("slot %d", c.Index)
+		}
+		if c.Cell {
+			from += " (cell)"
+		}
+		froms[i] = from
+	}
+	return ", captures " + strings.Join(froms, ", ")
+}
+
+func paramsRepr(params *bones.ParamSpec) string {
+	names := make([]string, 0, len(params.Fixed)+2)
+	for _, p := range params.Fixed {
+		names = append(names, p.Repr())
+	}
+	if params.Variadic != nil {
+		if len(params.Fixed) == 0 {
+			return params.Variadic.Repr()
+		}
+		names = append(names, ".", params.Variadic.Repr())
+	}
+	return "(" + strings.Join(names, " ") + ")"
+}
@@ -0,0 +1,111 @@
+package consume
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/archevel/ghoul/bones"
+	"github.com/archevel/ghoul/engraving"
+)
+
+func TestDisassembleListsNestedClosures(t *testing.T) {
+	code := compileSource(t, `(define make-adder
+  (lambda (n . rest)
+    (lambda (x) (+ x n))))`)
+
+	want := `== top-level ==
+0000  OP_MAKE_CLOSURE   0     ; lambda #1
+0003  OP_DEFINE         1     ; make-adder        @ crypt.ghl:1:2
+0006  OP_RETURN
+
+== lambda #1 (n . rest), 2 locals ==
+0000  OP_MAKE_CLOSURE   0     ; lambda #2
+0003  OP_RETURN
+
+== lambda #2 (x), 1 locals, captures slot 0 ==
+0000  OP_LOAD_LOCAL     slot 0     ; x            @ crypt.ghl:3:18
+0003  OP_LOAD_FREE      free 0
+0006  OP_INT_ADD        0     ; else call +
+0009  OP_RETURN
+`
+	if got := DisassembleString(code); got != want {
+		t.Errorf("unexpected listing:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestDisassembleShowsJumpTargets(t *testing.T) {
+	code := &CodeObject{
+		Name:      "top-level",
+		Code:      []byte{OP_TRUE, OP_JUMP_IF_FALSE, 0, 8, OP_CONST, 0, 0, OP_RETURN, OP_NIL, OP_RETURN},
+		Constants: []*bones.Node{bones.StrNode("yes")},
+	}
+	got := DisassembleString(code)
+	for _, want := range []string{"0001  OP_JUMP_IF_FALSE  -> 0008", `0004  OP_CONST          0     ; "yes"`, "0008  OP_NIL"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in listing:\n%s", want, got)
+		}
+	}
+}
+
+func TestDisassembleToleratesMalformedCode(t *testing.T) {
+	code := &CodeObject{Name: "top-level", Code: []byte{0xee, OP_CONST, 0, 9, OP_CALL, 0}}
+	got := DisassembleString(code)
+	for _, want := range []string{"OP_UNKNOWN(238)", "9     ; <invalid>", "OP_CALL  <truncated>"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in listing:\n%s", want, got)
+		}
+	}
+}
+
+func TestProcedureCodeOnlyAcceptsClosures(t *testing.T) {
+	env := setupTestEnvironment()
+	ev := New(engraving.StandardLogger, env)
+	closure, err := ev.RunCode(context.Background(), compileSource(t, "(lambda (x) x)"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code, ok := ProcedureCode(closure); !ok || code.Params == nil {
+		t.Error("expected the closure's code")
+	}
+	plus, _ := env.LookupByName("+")
+	if _, ok := ProcedureCode(plus); ok {
+		t.Error("expected no code for a Go function")
+	}
+	if _, ok := ProcedureCode(bones.IntNode(1)); ok {
+		t.Error("expected no code for a non-procedure")
+	}
+}
+
+func TestSetDisassemblyOutputListsBeforeRunning(t *testing.T) {
+	var buf bytes.Buffer
+	ev := New(engraving.StandardLogger, setupTestEnvironment())
+	ev.SetDisassemblyOutput(&buf)
+	if _, err := ev.RunCode(context.Background(), compileSource(t, "(+ 1 2)")); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "OP_INT_ADD") {
+		t.Errorf("expected the listing to be written, got %q", buf.String())
+	}
+
+	buf.Reset()
+	ev.SetDisassemblyOutput(nil)
+	ev.RunCode(context.Background(), compileSource(t, "(+ 1 2)"))
+	if buf.Len() != 0 {
+		t.Errorf("expected no listing once turned off, got %q", buf.String())
+	}
+}
+
+func TestForEnvironmentKeepsDisassembling(t *testing.T) {
+	var buf bytes.Buffer
+	ev := New(engraving.StandardLogger, setupTestEnvironment())
+	ev.SetDisassemblyOutput(&buf)
+	module := ev.ForEnvironment(NewModuleEnvironment(ev.env))
+	if _, err := module.RunCode(context.Background(), compileSource(t, "(+ 1 2)")); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "OP_INT_ADD") {
+		t.Errorf("expected the derived evaluator to write a listing, got %q", buf.String())
+	}
+}
@@ -2,56 +2,126 @@ package consume
 
 import (
 	"fmt"
-	"sort"
-	"strconv"
-	"strings"
+	"sync"
+	"sync/atomic"
 
 	e "github.com/archevel/ghoul/bones"
 )
 
-// scopeKey must be comparable for use as a map key, so marks are
-// encoded as a canonical sorted string rather than a map.
+// scopeKey identifies a binding by its interned name and the interned
+// set of hygiene marks on it, so scope maps hash two pointers.
 type scopeKey struct {
-	Name     string
-	MarksKey string
+	Sym   e.Symbol
+	Marks marksID
 }
 
 func keyFromName(name string) scopeKey {
-	return scopeKey{Name: name, MarksKey: ""}
+	return scopeKey{Sym: e.Intern(name)}
 }
 
 func keyFromNameAndMarks(name string, marks map[uint64]bool) scopeKey {
-	return scopeKey{Name: name, MarksKey: canonicalMarks(marks)}
+	return scopeKey{Sym: e.Intern(name), Marks: internMarks(marks)}
 }
 
 func keyFromNode(node *e.Node) (scopeKey, bool) {
 	if node.Kind == e.IdentifierNode {
-		if len(node.Marks) > 0 {
-			return keyFromNameAndMarks(node.Name, node.Marks), true
-		}
-		return keyFromName(node.Name), true
+		return scopeKey{Sym: node.Symbol(), Marks: internMarks(node.Marks)}, true
 	}
 	return scopeKey{}, false
 }
 
+// name returns the identifier name the key was made from.
+func (key scopeKey) name() string {
+	return key.Sym.Name()
+}
 
-func canonicalMarks(marks map[uint64]bool) string {
-	if len(marks) == 0 {
-		return ""
+// outerKey drops the newest mark. Marks are handed out in increasing order,
+// so the result names the same identifier as seen by the expansion that
+// produced the template the newest mark was applied to.
+func (key scopeKey) outerKey() (scopeKey, bool) {
+	if key.Marks.empty() {
+		return key, false
 	}
-	ids := make([]uint64, 0, len(marks))
-	for k := range marks {
-		ids = append(ids, k)
+	return scopeKey{Sym: key.Sym, Marks: outerMarks(key.Marks)}, true
+}
+
+// binding holds the value of one name in a scope. Defining the name again
+// in the same scope or assigning it stores into the same binding, so an
+// inline cache that holds the binding sees the new value.
+type binding struct {
+	val atomic.Pointer[e.Node]
+}
+
+// shapeVersion is bumped whenever a name is added to any scope or the
+// bindings of a scope are replaced, the only changes that can make a
+// lookup find a different binding. Inline caches compare it with the
+// version they were filled at.
+var shapeVersion atomic.Uint64
+
+// scope holds the bindings of one level of an environment. Goroutines
+// sharing the environment may define and look up names at once, so each
+// scope guards its map with a lock of its own.
+type scope struct {
+	mu   sync.RWMutex
+	vars map[scopeKey]*binding
+	// assigned holds the names that code run in an environment built on
+	// this scope may assign with set!. Only bottom scopes use it; see
+	// noteAssignments.
+	assigned sync.Map
+}
+
+func newScope() *scope {
+	return &scope{vars: map[scopeKey]*binding{}}
+}
+
+func (s *scope) get(key scopeKey) (*e.Node, bool) {
+	if b := s.binding(key); b != nil {
+		return b.val.Load(), true
 	}
-	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
-	parts := make([]string, len(ids))
-	for i, id := range ids {
-		parts[i] = strconv.FormatUint(id, 10)
+	return nil, false
+}
+
+func (s *scope) binding(key scopeKey) *binding {
+	s.mu.RLock()
+	b := s.vars[key]
+	s.mu.RUnlock()
+	return b
+}
+
+func (s *scope) set(key scopeKey, val *e.Node) {
+	s.mu.Lock()
+	b, ok := s.vars[key]
+	if !ok {
+		b = &binding{}
+		s.vars[key] = b
+		shapeVersion.Add(1)
 	}
-	return strings.Join(parts, ",")
+	b.val.Store(val)
+	s.mu.Unlock()
 }
 
-type scope map[scopeKey]*e.Node
+// replace swaps all of the scope's bindings for vars.
+func (s *scope) replace(vars map[scopeKey]*e.Node) {
+	bindings := make(map[scopeKey]*binding, len(vars))
+	for key, val := range vars {
+		b := &binding{}
+		b.val.Store(val)
+		bindings[key] = b
+	}
+	s.mu.Lock()
+	s.vars = bindings
+	shapeVersion.Add(1)
+	s.mu.Unlock()
+}
+
+// each calls f with every binding in the scope, holding the read lock.
+func (s *scope) each(f func(key scopeKey, val *e.Node)) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for key, b := range s.vars {
+		f(key, b.val.Load())
+	}
+}
 
 type Environment = environment
 type environment []*scope
@@ -69,11 +139,11 @@ func (env environment) BoundIdentifierNames() map[string]bool {
 		"syntax-rules": true, "quote": true,
 	}
 	for i := range env {
-		for key := range *env[i] {
-			if key.MarksKey == "" {
-				result[key.Name] = true
+		env[i].each(func(key scopeKey, _ *e.Node) {
+			if key.Marks.empty() {
+				result[key.name()] = true
 			}
-		}
+		})
 	}
 	return result
 }
@@ -83,7 +153,35 @@ func (env environment) Register(name string, f func([]*e.Node, *Evaluator) (*e.N
 	wrapped := func(args []*e.Node, ev e.Evaluator) (*e.Node, error) {
 		return f(args, ev.(*Evaluator))
 	}
-	(*scope)[keyFromName(name)] = e.FuncNode(wrapped)
+	scope.set(keyFromName(name), e.FuncNode(wrapped))
+}
+
+// RegisterValue binds name to val alongside the builtins, where every
+// module sees it.
+func (env environment) RegisterValue(name string, val *e.Node) {
+	bottomScope(&env).set(keyFromName(name), val)
+}
+
+// pureBuiltin tags the FuncNode of a function registered with
+// RegisterPure.
+type pureBuiltin struct{}
+
+// RegisterPure registers a function whose result depends only on its
+// arguments and which has no side effects. The optimizer may call it at
+// compile time on constant arguments, as long as the name still refers to
+// it where the call is compiled.
+func (env environment) RegisterPure(name string, f func([]*e.Node, *Evaluator) (*e.Node, error)) {
+	env.Register(name, f)
+	fn, _ := bottomScope(&env).get(keyFromName(name))
+	fn.ForeignVal = pureBuiltin{}
+}
+
+func isPureBuiltin(n *e.Node) bool {
+	if n == nil || n.Kind != e.FunctionNode {
+		return false
+	}
+	_, ok := n.ForeignVal.(pureBuiltin)
+	return ok
 }
 
 func RegisterFuncAs(name string, f func([]*e.Node, *Evaluator) (*e.Node, error), env *environment) {
@@ -91,7 +189,7 @@ func RegisterFuncAs(name string, f func([]*e.Node, *Evaluator) (*e.Node, error),
 	wrapped := func(args []*e.Node, ev e.Evaluator) (*e.Node, error) {
 		return f(args, ev.(*Evaluator))
 	}
-	(*scope)[keyFromName(name)] = e.FuncNode(wrapped)
+	scope.set(keyFromName(name), e.FuncNode(wrapped))
 }
 
 func bindNode(variable *e.Node, value *e.Node, env *environment) (*e.Node, error) {
@@ -99,8 +197,7 @@ func bindNode(variable *e.Node, value *e.Node, env *environment) (*e.Node, error
 	if !ok {
 		return nil, fmt.Errorf("define: bad syntax, no valid identifier given in %s", variable.Repr())
 	}
-	scope := currentScope(env)
-	(*scope)[key] = value
+	currentScope(env).set(key, value)
 	return value, nil
 }
 
@@ -109,42 +206,53 @@ func assignByName(variable *e.Node, value *e.Node, env *environment) (*e.Node, e
 	if !ok {
 		return nil, fmt.Errorf("set!: expected an identifier, got %s", e.NodeTypeName(variable))
 	}
-	for i := len(*env) - 1; i >= 0; i-- {
-		scope := (*env)[i]
-		if _, ok := (*scope)[key]; ok {
-			(*scope)[key] = value
-			return value, nil
+	if scope, bound, ok := findBinding(key, env); ok {
+		scope.set(bound, value)
+		return value, nil
+	}
+	return nil, fmt.Errorf("set!: assignment disallowed for identifier %s", key.name())
+}
+
+// findBinding resolves key to the innermost scope binding it. A marked
+// identifier with no exact binding was introduced by a macro and falls
+// back, newest mark first, to the binding visible where its template was
+// written; the unmarked name is the last resort.
+func findBinding(key scopeKey, env *environment) (*scope, scopeKey, bool) {
+	for {
+		for i := len(*env) - 1; i >= 0; i-- {
+			if _, ok := (*env)[i].get(key); ok {
+				return (*env)[i], key, true
+			}
+		}
+		var more bool
+		if key, more = key.outerKey(); !more {
+			return nil, key, false
 		}
 	}
-	return nil, fmt.Errorf("set!: assignment disallowed for identifier %s", key.Name)
 }
 
 func lookupNode(ident *e.Node, env *environment) (*e.Node, error) {
+	b, err := lookupBinding(ident, env)
+	if err != nil {
+		return nil, err
+	}
+	return b.val.Load(), nil
+}
+
+func lookupBinding(ident *e.Node, env *environment) (*binding, error) {
 	key, ok := keyFromNode(ident)
 	if !ok {
 		return nil, fmt.Errorf("undefined identifier: %s", ident.Repr())
 	}
 
-	for i := len(*env) - 1; i >= 0; i-- {
-		scope := (*env)[i]
-		if res, ok := (*scope)[key]; ok {
-			return res, nil
+	if scope, bound, ok := findBinding(key, env); ok {
+		if b := scope.binding(bound); b != nil {
+			return b, nil
 		}
 	}
 
-	// Fall back to name-only lookup for macro-introduced references
-	if key.MarksKey != "" {
-		plainKey := scopeKey{Name: key.Name, MarksKey: ""}
-		for i := len(*env) - 1; i >= 0; i-- {
-			scope := (*env)[i]
-			if res, ok := (*scope)[plainKey]; ok {
-				return res, nil
-			}
-		}
-	}
-
-	suggestion := formatSuggestion(suggestIdentifiers(key.Name, env))
-	return nil, fmt.Errorf("undefined identifier: %s%s", key.Name, suggestion)
+	suggestion := formatSuggestion(suggestIdentifiers(key.name(), env))
+	return nil, fmt.Errorf("undefined identifier: %s%s", key.name(), suggestion)
 }
 
 func (env environment) LookupByName(name string) (*e.Node, error) {
@@ -153,36 +261,32 @@ func (env environment) LookupByName(name string) (*e.Node, error) {
 
 // BindByName binds a value to a name in the current (top) scope.
 func (env *environment) BindByName(name string, val *e.Node) {
-	scope := currentScope(env)
-	(*scope)[keyFromName(name)] = val
+	currentScope(env).set(keyFromName(name), val)
 }
 
 // NewModuleEnvironment creates a fresh environment that shares the builtins
 // (bottom scope) from the parent but has its own top-level scope.
 func NewModuleEnvironment(parent *environment) *environment {
 	builtins := bottomScope(parent)
-	newEnv := environment{builtins, &scope{}}
+	newEnv := environment{builtins, newScope()}
 	return &newEnv
 }
 
 // ExtractExports returns all bindings from the top scope (not builtins).
 func ExtractExports(env *environment) *ModuleExports {
 	topScope := currentScope(env)
-	exports := &ModuleExports{
-		Names:    make([]string, 0, len(*topScope)),
-		Bindings: make(map[string]*e.Node, len(*topScope)),
-	}
-	for key, val := range *topScope {
-		exports.Names = append(exports.Names, key.Name)
-		exports.Bindings[key.Name] = val
-	}
+	exports := &ModuleExports{Bindings: map[string]*e.Node{}}
+	topScope.each(func(key scopeKey, val *e.Node) {
+		exports.Names = append(exports.Names, key.name())
+		exports.Bindings[key.name()] = val
+	})
 	return exports
 }
 
 func newEnvWithEmptyScope(env *environment) *environment {
 	copied := make(environment, len(*env), len(*env)+1)
 	copy(copied, *env)
-	newEnv := append(copied, &scope{})
+	newEnv := append(copied, newScope())
 	return &newEnv
 }
 
@@ -118,3 +118,33 @@ func TestBoundFunctionsResideInBottomScope(t *testing.T) {
 		t.Errorf("expected FunctionNode, got %d", val.Kind)
 	}
 }
+
+func TestScopedIdentifierFallsBackNewestMarkFirst(t *testing.T) {
+	env := NewEnvironment()
+
+	bindNode(e.IdentNode("x"), e.IntNode(1), env)
+	bindNode(e.ScopedIdentNode("x", map[uint64]bool{3: true}), e.IntNode(2), env)
+
+	nested := e.ScopedIdentNode("x", map[uint64]bool{3: true, 7: true})
+	result, err := lookupNode(nested, env)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Equiv(e.IntNode(2)) {
+		t.Errorf("x{3,7} should resolve to x{3}, got %s", result.Repr())
+	}
+
+	other := e.ScopedIdentNode("x", map[uint64]bool{5: true, 7: true})
+	result, _ = lookupNode(other, env)
+	if !result.Equiv(e.IntNode(1)) {
+		t.Errorf("x{5,7} should resolve to plain x, got %s", result.Repr())
+	}
+
+	if _, err := assignByName(nested, e.IntNode(9), env); err != nil {
+		t.Fatalf("set! through marked reference failed: %v", err)
+	}
+	result, _ = lookupNode(e.ScopedIdentNode("x", map[uint64]bool{3: true}), env)
+	if !result.Equiv(e.IntNode(9)) {
+		t.Errorf("expected x{3} to be assigned, got %s", result.Repr())
+	}
+}
@@ -6,8 +6,10 @@
 package consume
 
 import (
+	"bufio"
 	"context"
 	"fmt"
+	"io"
 
 	e "github.com/archevel/ghoul/bones"
 	"github.com/archevel/ghoul/engraving"
@@ -31,13 +33,44 @@ func New(logger engraving.Logger, env *environment) *Evaluator {
 
 // NewWithMarkCounter creates an evaluator sharing an external mark counter.
 func NewWithMarkCounter(logger engraving.Logger, env *environment, markCounter *uint64) *Evaluator {
-	return &Evaluator{log: logger, env: env, markCounter: markCounter}
+	return &Evaluator{log: logger, env: env, markCounter: markCounter, idle: &vmPool{}}
 }
 
 type Evaluator struct {
 	log         engraving.Logger
 	env         *environment
 	markCounter *uint64
+
+	// active is set on the evaluator a VM hands to a native function: the
+	// VM itself, parked in its level-th native call during the evaluation
+	// numbered gen, and ctx the context the call runs under. See gate.
+	active *VM
+	level  int
+	gen    uint64
+	ctx    context.Context
+	// idle holds VMs kept for reuse by Apply, shared with the evaluators
+	// handed to native functions.
+	idle *vmPool
+
+	// disasm receives a listing of each top-level CodeObject before it
+	// runs, when set.
+	disasm io.Writer
+	// noOptimize turns off the optimizer in Compile.
+	noOptimize bool
+	// limits bounds each evaluation; see SetLimits.
+	limits Limits
+
+	// stdout, stderr and stdin are the streams builtins use; nil means
+	// the process's own.
+	stdout io.Writer
+	stderr io.Writer
+	stdin  *bufio.Reader
+}
+
+// SetDisassemblyOutput makes the evaluator write a listing of each
+// top-level CodeObject to w before running it. A nil w turns it off.
+func (ev *Evaluator) SetDisassemblyOutput(w io.Writer) {
+	ev.disasm = w
 }
 
 // EvaluateNode translates a top-level Node tree and evaluates it.
@@ -65,6 +98,14 @@ func (ev *Evaluator) EvaluateNode(ctx context.Context, exprs *e.Node) (*e.Node,
 	return ev.ConsumeNodesWithContext(ctx, translated)
 }
 
+// root returns the evaluator ev was handed out for, or ev itself.
+func (ev *Evaluator) root() *Evaluator {
+	if ev.active != nil {
+		return ev.active.ev
+	}
+	return ev
+}
+
 func (ev *Evaluator) Log() engraving.Logger {
 	return ev.log
 }
@@ -223,6 +264,9 @@ func NewEvaluationError(msg string, loc e.CodeLocation) EvaluationError {
 func (err EvaluationError) Error() string {
 	if err.Loc != nil {
 		msg := fmt.Sprintf("%s: %s", err.Loc.String(), err.msg)
+		if mel, ok := err.Loc.(*e.MacroExpansionLocation); ok {
+			msg += "\n  " + mel.Backtrace()
+		}
 		if ctx := err.Loc.SourceContext(); ctx != "" {
 			msg += "\n\n" + ctx
 		}
@@ -2,12 +2,14 @@ package consume
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"testing"
 	"time"
 
 	e "github.com/archevel/ghoul/bones"
+	"github.com/archevel/ghoul/engraving"
 	"github.com/archevel/ghoul/exhumer"
 )
 
@@ -274,3 +276,99 @@ func TestBackwardCompatibilityEvaluate(t *testing.T) {
 		t.Errorf("Expected %s, but got %s", expected.Repr(), result.Repr())
 	}
 }
+
+type ctxKey struct{}
+
+func TestNativeFunctionSeesEvaluationContext(t *testing.T) {
+	env := NewEnvironment()
+	env.Register("ctx-value", func(args []*e.Node, ev *Evaluator) (*e.Node, error) {
+		v, _ := ev.Context().Value(ctxKey{}).(string)
+		return e.StrNode(v), nil
+	})
+	ev := New(engraving.StandardLogger, env)
+	ctx := context.WithValue(context.Background(), ctxKey{}, "haunted")
+
+	_, parsed := exhumer.Parse(strings.NewReader("(define f (lambda () (ctx-value))) (f)"))
+	result, err := ev.EvaluateNode(ctx, parsed.Expressions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Equiv(e.StrNode("haunted")) {
+		t.Errorf("expected haunted, got %s", result.Repr())
+	}
+	if ev.Context() != context.Background() {
+		t.Error("expected the context to be released after evaluation")
+	}
+}
+
+func TestApplyPassesContextToGoFunction(t *testing.T) {
+	ev := New(engraving.StandardLogger, NewEnvironment())
+	fn := e.FuncNode(func(args []*e.Node, evaluator e.Evaluator) (*e.Node, error) {
+		v, _ := evaluator.(*Evaluator).Context().Value(ctxKey{}).(string)
+		return e.StrNode(v), nil
+	})
+	ctx := context.WithValue(context.Background(), ctxKey{}, "lantern")
+
+	result, err := ev.Apply(ctx, fn, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Equiv(e.StrNode("lantern")) {
+		t.Errorf("expected lantern, got %s", result.Repr())
+	}
+}
+
+func TestApplyStopsOnCancelledContext(t *testing.T) {
+	env := setupTestEnvironment()
+	ev := New(engraving.StandardLogger, env)
+	calls := 0
+	env.Register("for-each-until-cancelled", func(args []*e.Node, ev *Evaluator) (*e.Node, error) {
+		for _, item := range args[1].Children {
+			if _, err := ev.Apply(ev.Context(), args[0], []*e.Node{item}); err != nil {
+				return nil, err
+			}
+			calls++
+		}
+		return e.Nil, nil
+	})
+	env.Register("eq?", func(args []*e.Node, ev *Evaluator) (*e.Node, error) {
+		return e.BoolNode(args[0].Equiv(args[1])), nil
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	env.Register("cancel!", func(args []*e.Node, ev *Evaluator) (*e.Node, error) {
+		cancel()
+		return e.Nil, nil
+	})
+
+	_, parsed := exhumer.Parse(strings.NewReader(
+		"(for-each-until-cancelled (lambda (x) (cond ((eq? x 2) (cancel!)) (else x))) '(1 2 3 4 5))"))
+	_, err := ev.EvaluateNode(ctx, parsed.Expressions)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("expected the loop to stop right after cancelling, got %d calls", calls)
+	}
+}
+
+func TestEvalSubExpressionInheritsContext(t *testing.T) {
+	env := NewEnvironment()
+	env.Register("ctx-value", func(args []*e.Node, ev *Evaluator) (*e.Node, error) {
+		v, _ := ev.Context().Value(ctxKey{}).(string)
+		return e.StrNode(v), nil
+	})
+	env.Register("eval-call", func(args []*e.Node, ev *Evaluator) (*e.Node, error) {
+		return ev.EvalSubExpression(&e.Node{Kind: e.CallNode, Children: []*e.Node{e.IdentNode(args[0].IdentName())}})
+	})
+	ev := New(engraving.StandardLogger, env)
+	ctx := context.WithValue(context.Background(), ctxKey{}, "crypt")
+
+	_, parsed := exhumer.Parse(strings.NewReader("(eval-call 'ctx-value)"))
+	result, err := ev.EvaluateNode(ctx, parsed.Expressions)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Equiv(e.StrNode("crypt")) {
+		t.Errorf("expected crypt, got %s", result.Repr())
+	}
+}
@@ -97,7 +97,7 @@ func TestVariableDefinitionsAreStoredInEnvironment(t *testing.T) {
 		Evaluate(parsed.Expressions, env)
 
 		frame := currentScope(env)
-		val, ok := (*frame)[keyFromName(c.identifier)]
+		val, ok := frame.get(keyFromName(c.identifier))
 		if !ok {
 			t.Errorf("environment had no value for '%s'", c.identifier)
 		}
@@ -0,0 +1,178 @@
+package consume
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/archevel/ghoul/bones"
+)
+
+// errPaused ends a step of an Execution when its share of fuel is spent,
+// and errYield when its code calls yield. Neither leaves the package.
+var (
+	errPaused = errors.New("execution paused")
+	errYield  = errors.New("yield")
+)
+
+// Execution is an evaluation of top-level code that runs a step at a
+// time, so a host can interleave scripts with its own work without
+// goroutines. A step ends when its instruction budget is spent, when the
+// code calls yield or when the code finishes; the state of the evaluation
+// stays on the Execution's VM in between. An Execution is not safe for
+// use by several goroutines at once.
+type Execution struct {
+	vm   *VM
+	ctx  context.Context
+	code *CodeObject
+
+	started bool
+	yielded bool
+	resume  *bones.Node
+	done    bool
+	result  *bones.Node
+	err     error
+}
+
+// Start returns an Execution of code in the evaluator's environment. No
+// code runs until the first Step.
+func (ev *Evaluator) Start(code *CodeObject) *Execution {
+	return ev.StartWithContext(context.Background(), code)
+}
+
+// StartWithContext is Start for an evaluation that stops with an error
+// once ctx is done.
+func (ev *Evaluator) StartWithContext(ctx context.Context, code *CodeObject) *Execution {
+	vm := newVM(ev)
+	vm.stepping = true
+	vm.stats = StatsFrom(ctx)
+	return &Execution{vm: vm, ctx: ctx, code: code}
+}
+
+// Step runs the execution for at most budget instructions; a budget of
+// zero or less runs it until it yields or finishes. It returns the value
+// passed to yield if the code yields, the result if it finishes and nil
+// if the budget runs out first. Procedures called from Go, such as the
+// function passed to map, cannot stop part way and may take the step past
+// its budget. The evaluator's limits apply to all steps together. An
+// error finishes the execution; once done, Step returns the result or
+// error again.
+func (x *Execution) Step(budget int) (*bones.Node, error) {
+	if x.done {
+		return x.result, x.err
+	}
+	vm := x.vm
+	if !x.started {
+		x.started = true
+		if vm.ev.disasm != nil {
+			if err := Disassemble(vm.ev.disasm, x.code); err != nil {
+				return x.finish(nil, err)
+			}
+		}
+		if err := vm.begin(x.ctx, x.code); err != nil {
+			return x.finish(nil, err)
+		}
+	}
+	if x.yielded {
+		// The value of the yield call.
+		vm.push(nodeValue(x.resume))
+		x.yielded, x.resume = false, nil
+	}
+
+	if b := int64(budget); budget > 0 && vm.budget.fuel > b {
+		vm.budget.reserve = vm.budget.fuel - b
+		vm.budget.fuel = b
+	}
+	result, err := vm.execute(x.ctx, 0)
+	vm.budget.fuel += vm.budget.reserve
+	vm.budget.reserve, vm.budget.rearm = 0, false
+
+	switch err {
+	case errPaused:
+		return nil, nil
+	case errYield:
+		value := vm.yielded
+		vm.yielded = nil
+		x.yielded, x.resume = true, bones.Nil
+		return value, nil
+	}
+	return x.finish(result, err)
+}
+
+func (x *Execution) finish(result *bones.Node, err error) (*bones.Node, error) {
+	x.done, x.result, x.err = true, result, err
+	x.vm, x.code = nil, nil
+	return result, err
+}
+
+// Resume sets the value the pending yield returns when the next Step
+// continues the execution. Without it, yield returns the empty list.
+func (x *Execution) Resume(value *bones.Node) error {
+	if !x.yielded {
+		return errors.New("resume: execution is not suspended in yield")
+	}
+	if value == nil {
+		value = bones.Nil
+	}
+	x.resume = value
+	return nil
+}
+
+// Done reports whether the execution has finished, with a result or an
+// error.
+func (x *Execution) Done() bool {
+	return x.done
+}
+
+// Yielded reports whether the execution is suspended in a call to yield.
+func (x *Execution) Yielded() bool {
+	return x.yielded
+}
+
+// yieldTag marks the FuncNode of the yield procedure, which the VM of an
+// Execution calls in place; its Go function only reports errors.
+type yieldTag struct{}
+
+// YieldProcedure returns the `yield` procedure: (yield) or (yield v)
+// suspends the Execution running it, ending its step with v, and returns
+// the value passed to Resume once a later step continues it. It cannot
+// suspend from inside a procedure called from Go, nor outside an
+// Execution.
+func YieldProcedure() *bones.Node {
+	fn := func(args []*bones.Node, evaluator bones.Evaluator) (*bones.Node, error) {
+		if ev, ok := evaluator.(*Evaluator); ok && ev.active != nil && ev.active.stepping {
+			return nil, errYieldFromGo
+		}
+		return nil, errors.New("yield: not in a resumable execution")
+	}
+	return &bones.Node{Kind: bones.FunctionNode, FuncVal: &fn, ForeignVal: yieldTag{}}
+}
+
+var errYieldFromGo = errors.New("yield: cannot suspend inside a procedure called from Go")
+
+func isYieldProcedure(n *bones.Node) bool {
+	if n.Kind != bones.FunctionNode {
+		return false
+	}
+	_, ok := n.ForeignVal.(yieldTag)
+	return ok
+}
+
+// yield suspends the VM's top-level code with the value in args, unless a
+// native function it called is still running.
+func (vm *VM) yield(args []*bones.Node) error {
+	if len(args) > 1 {
+		return fmt.Errorf("yield: expected at most 1 argument, got %d", len(args))
+	}
+	vm.gate.mu.Lock()
+	parked := vm.gate.parked
+	vm.gate.mu.Unlock()
+	if parked > 0 {
+		return errYieldFromGo
+	}
+	vm.yielded = bones.Nil
+	if len(args) == 1 {
+		vm.yielded = args[0]
+	}
+	return nil
+}
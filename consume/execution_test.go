@@ -0,0 +1,155 @@
+package consume
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/archevel/ghoul/bones"
+	"github.com/archevel/ghoul/engraving"
+)
+
+func startExecution(t *testing.T, ev *Evaluator, src string) *Execution {
+	t.Helper()
+	code, err := ev.Compile(translateSource(t, src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ev.Start(code)
+}
+
+func stepEvaluator() *Evaluator {
+	env := setupTestEnvironment()
+	env.BindByName("yield", YieldProcedure())
+	env.BindByName("apply", ApplyProcedure())
+	env.Register("call", func(args []*bones.Node, ev *Evaluator) (*bones.Node, error) {
+		return ev.Apply(ev.Context(), args[0], args[1:])
+	})
+	return New(engraving.StandardLogger, env)
+}
+
+func TestExecutionStepsWithinItsBudget(t *testing.T) {
+	ev := stepEvaluator()
+	x := startExecution(t, ev, `
+(define count (lambda (n acc) (cond ((< n 1) acc) (else (count (- n 1) (+ acc 1))))))
+(count 1000 0)`)
+	steps := 0
+	for !x.Done() {
+		res, err := x.Step(100)
+		if err != nil {
+			t.Fatal(err)
+		}
+		steps++
+		if x.Done() && res.Repr() != "1000" {
+			t.Errorf("expected 1000, got %s", res.Repr())
+		} else if !x.Done() && res != nil {
+			t.Errorf("expected no value from an unfinished step, got %s", res.Repr())
+		}
+	}
+	if steps < 10 {
+		t.Errorf("expected the loop to take many steps of 100 instructions, took %d", steps)
+	}
+	if res, err := x.Step(100); err != nil || res.Repr() != "1000" {
+		t.Errorf("expected a finished execution to return its result again, got %v, %v", res, err)
+	}
+}
+
+func TestExecutionYieldsAndResumes(t *testing.T) {
+	ev := stepEvaluator()
+	x := startExecution(t, ev, `
+(define total 0)
+(define loop (lambda (i)
+  (cond ((< i 3) (set! total (+ total (yield i))) (loop (+ i 1)))
+        (else total))))
+(loop 0)`)
+	for i := int64(0); i < 3; i++ {
+		res, err := x.Step(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !x.Yielded() || res.Repr() != bones.IntNode(i).Repr() {
+			t.Fatalf("expected a yield of %d, got %v (yielded %v)", i, res, x.Yielded())
+		}
+		if err := x.Resume(bones.IntNode(10 * (i + 1))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	res, err := x.Step(0)
+	if err != nil || !x.Done() || res.Repr() != "60" {
+		t.Errorf("expected 60 once done, got %v, %v", res, err)
+	}
+	if err := x.Resume(bones.Nil); err == nil {
+		t.Error("expected Resume to fail when no yield is pending")
+	}
+}
+
+func TestExecutionYieldWithoutResumeReturnsNil(t *testing.T) {
+	x := startExecution(t, stepEvaluator(), `(yield 1) (yield)`)
+	if res, _ := x.Step(0); res.Repr() != "1" {
+		t.Fatalf("expected a yield of 1, got %v", res)
+	}
+	if res, _ := x.Step(0); !x.Yielded() || !res.IsNil() {
+		t.Fatalf("expected a yield of nothing, got %v", res)
+	}
+	if res, err := x.Step(0); err != nil || !res.IsNil() {
+		t.Errorf("expected the pending yield to return nil, got %v, %v", res, err)
+	}
+}
+
+func TestYieldCannotSuspendGoCalls(t *testing.T) {
+	for _, src := range []string{
+		`(call (lambda () (yield 1)))`,
+		`(call yield 1)`,
+	} {
+		x := startExecution(t, stepEvaluator(), src)
+		_, err := x.Step(0)
+		if err == nil || !errors.Is(err, errYieldFromGo) || !x.Done() {
+			t.Errorf("%s: expected yield to fail inside a Go call, got %v", src, err)
+		}
+	}
+	if _, err := evalIn(t, stepEvaluator(), `(yield 1)`); err == nil {
+		t.Error("expected yield to fail outside an execution")
+	}
+	x := startExecution(t, stepEvaluator(), `(apply yield '(7))`)
+	if res, err := x.Step(0); err != nil || res.Repr() != "7" {
+		t.Errorf("expected yield through apply to suspend, got %v, %v", res, err)
+	}
+}
+
+func TestExecutionStepsThroughGoCalls(t *testing.T) {
+	ev := stepEvaluator()
+	x := startExecution(t, ev, `
+(define spin (lambda (n) (cond ((< n 1) 'done) (else (spin (- n 1))))))
+(call spin 500)`)
+	if _, err := x.Step(50); err != nil || x.Done() {
+		t.Fatalf("expected the step to end once the call returned, got %v", err)
+	}
+	// Only the return from the top-level code is left.
+	res, err := x.Step(1)
+	if err != nil || !x.Done() || res.Repr() != "done" {
+		t.Errorf("expected the call from Go to have finished, got %v, %v", res, err)
+	}
+}
+
+func TestExecutionKeepsTheFuelLimitAcrossSteps(t *testing.T) {
+	ev := stepEvaluator()
+	ev.SetLimits(Limits{Fuel: 500})
+	x := startExecution(t, ev, `
+(define spin (lambda (n) (cond ((< n 1) 'done) (else (spin (- n 1))))))
+(spin 1000)`)
+	var err error
+	for !x.Done() {
+		_, err = x.Step(30)
+	}
+	var limit LimitError
+	if !errors.As(err, &limit) || limit.Limit != LimitFuel {
+		t.Errorf("expected the fuel limit to end the execution, got %v", err)
+	}
+
+	x = startExecution(t, ev, `(call (lambda () (spin 1000)))`)
+	for !x.Done() {
+		_, err = x.Step(30)
+	}
+	if !errors.As(err, &limit) || limit.Limit != LimitFuel {
+		t.Errorf("expected the fuel limit to hold inside Go calls, got %v", err)
+	}
+}
@@ -0,0 +1,168 @@
+package consume
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/archevel/ghoul/bones"
+)
+
+// An environment and the evaluators made for it may be shared between
+// goroutines: scopes lock their bindings and every evaluation runs on a
+// VM of its own. A VM hands each native function it calls an evaluator
+// tied to that call, and Apply on it runs procedures on top of the VM
+// while the VM waits for the call to return. Only one such procedure runs
+// on the VM at a time, and the native call returns only once it is done.
+// Any other Apply, say from a goroutine the native function started, runs
+// on an idle VM with a budget of its own.
+
+// ErrPanic is matched by the error of a call to a Go function that
+// panicked.
+var ErrPanic = errors.New("go function panicked")
+
+// gate tracks the native calls a VM is parked in and the procedures run
+// on top of them.
+type gate struct {
+	mu   sync.Mutex
+	done sync.Cond
+
+	// parked counts the native calls in progress and nested the
+	// procedures running on top of them. A procedure may start on top of
+	// the call at level parked once nested is parked-1.
+	parked int
+	nested int
+
+	// gen changes whenever the VM is taken for another evaluation, so
+	// evaluators handed out during an earlier one no longer claim it.
+	gen uint64
+	// views[i] is the evaluator handed to native calls at level i+1.
+	views []*Evaluator
+}
+
+// callNative calls the Go function fn, which env binds, with args and an
+// evaluator tied to this call.
+func (vm *VM) callNative(fn *bones.Node, args []*bones.Node, env *environment) (*bones.Node, error) {
+	var start time.Time
+	if vm.stats != nil {
+		start = time.Now()
+	}
+	view := vm.park()
+	result, err := vm.invoke(*fn.FuncVal, args, view)
+	vm.unpark(view.level)
+	if vm.stats != nil {
+		vm.stats.native(fn, env, time.Since(start))
+	}
+	if vm.budget.rearm {
+		// Procedures the call applied ran past the end of the step;
+		// end it at the next instruction.
+		vm.budget.rearm = false
+		vm.budget.reserve, vm.budget.fuel = vm.budget.fuel, 0
+	}
+	return result, err
+}
+
+// invoke calls proc, turning a panic into an error so a faulty Go
+// function or bad arguments to one do not take the host down. Procedures
+// it applied on the VM may have been cut short, so their frames and
+// values are dropped.
+func (vm *VM) invoke(proc func([]*bones.Node, bones.Evaluator) (*bones.Node, error), args []*bones.Node, view *Evaluator) (result *bones.Node, err error) {
+	fp, sp := vm.fp, vm.sp
+	defer func() {
+		if r := recover(); r != nil {
+			vm.fp = fp
+			if vm.sp > sp {
+				vm.dropFrame(sp)
+			}
+			result, err = nil, fmt.Errorf("%w: %v", ErrPanic, r)
+		}
+	}()
+	return proc(args, view)
+}
+
+func (vm *VM) park() *Evaluator {
+	g := &vm.gate
+	g.mu.Lock()
+	g.parked++
+	if g.parked > len(g.views) {
+		view := *vm.ev
+		view.active, view.level, view.gen = vm, g.parked, g.gen
+		g.views = append(g.views, &view)
+	}
+	view := g.views[g.parked-1]
+	view.ctx = vm.ctx
+	g.mu.Unlock()
+	return view
+}
+
+// unpark waits for any procedure running on top of the native call at
+// level before returning from it.
+func (vm *VM) unpark(level int) {
+	g := &vm.gate
+	g.mu.Lock()
+	for g.nested >= level {
+		g.done.Wait()
+	}
+	g.parked = level - 1
+	g.mu.Unlock()
+}
+
+// claim reserves the VM for a procedure applied through ev, which must be
+// the evaluator of the native call the VM is parked in.
+func (vm *VM) claim(ev *Evaluator) bool {
+	g := &vm.gate
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if ev.gen != g.gen || ev.level != g.parked || g.nested != g.parked-1 {
+		return false
+	}
+	g.nested++
+	return true
+}
+
+func (vm *VM) unclaim() {
+	g := &vm.gate
+	g.mu.Lock()
+	g.nested--
+	g.done.Broadcast()
+	g.mu.Unlock()
+}
+
+// renew readies the gate for another evaluation on the VM.
+func (g *gate) renew() {
+	g.mu.Lock()
+	g.gen++
+	g.parked, g.nested = 0, 0
+	clear(g.views)
+	g.views = g.views[:0]
+	g.mu.Unlock()
+}
+
+// vmPool holds idle VMs for Apply to reuse.
+type vmPool struct {
+	mu  sync.Mutex
+	vms []*VM
+}
+
+func (p *vmPool) take(ev *Evaluator) *VM {
+	p.mu.Lock()
+	if n := len(p.vms); n > 0 {
+		vm := p.vms[n-1]
+		p.vms = p.vms[:n-1]
+		p.mu.Unlock()
+		vm.gate.renew()
+		return vm
+	}
+	p.mu.Unlock()
+	return newVM(ev)
+}
+
+func (p *vmPool) release(vm *VM) {
+	clear(vm.stack[:vm.sp])
+	vm.sp, vm.fp = 0, 0
+	vm.stats = nil
+	p.mu.Lock()
+	p.vms = append(p.vms, vm)
+	p.mu.Unlock()
+}
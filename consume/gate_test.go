@@ -0,0 +1,142 @@
+package consume
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/archevel/ghoul/bones"
+	"github.com/archevel/ghoul/engraving"
+)
+
+func TestEvaluationsShareAnEnvironmentAcrossGoroutines(t *testing.T) {
+	env := pureTestEnvironment()
+	ev := New(engraving.StandardLogger, env)
+	if _, err := evalIn(t, ev, "(define total 0) (define bump (lambda (n) (set! total (add total n)) n))"); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 16)
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			src := fmt.Sprintf("(define v%d %d) (bump v%d) total", i, i, i)
+			if _, err := evalIn(t, ev, src); err != nil {
+				errs <- err
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	for i := 0; i < 16; i++ {
+		if v, err := env.LookupByName(fmt.Sprintf("v%d", i)); err != nil || !v.Equiv(bones.IntNode(int64(i))) {
+			t.Errorf("expected v%d to be %d, got %v, %v", i, i, v, err)
+		}
+	}
+}
+
+func TestCallbacksFromOtherGoroutinesRunInParallel(t *testing.T) {
+	env := pureTestEnvironment()
+	var handler *bones.Node
+	var handlerEv *Evaluator
+	env.Register("handle", func(args []*bones.Node, ev *Evaluator) (*bones.Node, error) {
+		handler, handlerEv = args[0], ev
+		return bones.Nil, nil
+	})
+	// serve calls the handler from many goroutines at once and waits for
+	// them, as net/http does while ListenAndServe blocks.
+	env.Register("serve", func(args []*bones.Node, ev *Evaluator) (*bones.Node, error) {
+		results := make([]*bones.Node, args[0].IntVal)
+		errs := make([]error, len(results))
+		var wg sync.WaitGroup
+		for i := range results {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				results[i], errs[i] = handlerEv.Apply(ev.Context(), handler, []*bones.Node{bones.IntNode(int64(i))})
+			}()
+		}
+		wg.Wait()
+		if err := firstError(errs); err != nil {
+			return nil, err
+		}
+		return bones.NewListNode(results), nil
+	})
+
+	ev := New(engraving.StandardLogger, env)
+	res, err := evalIn(t, ev, `
+(define served 0)
+(handle (lambda (n)
+  (define last n)
+  (set! served (add served 1))
+  (list n (add n n))))
+(serve 32)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range res.Children {
+		if want := fmt.Sprintf("(%d %d)", i, 2*i); r.Repr() != want {
+			t.Errorf("expected %s, got %s", want, r.Repr())
+		}
+	}
+
+	// The handler outlives the evaluation that registered it.
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if r, err := handlerEv.Apply(context.Background(), handler, []*bones.Node{bones.IntNode(1)}); err != nil || r.Repr() != "(1 2)" {
+				t.Errorf("expected (1 2), got %v, %v", r, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestNestedApplicationWaitsForCallbacksOnTheParkedVM(t *testing.T) {
+	env := pureTestEnvironment()
+	started, release := make(chan struct{}), make(chan struct{})
+	env.Register("block", func(args []*bones.Node, ev *Evaluator) (*bones.Node, error) {
+		close(started)
+		<-release
+		return bones.Nil, nil
+	})
+	var async *bones.Node
+	var asyncErr error
+	done := make(chan struct{})
+	env.Register("call-later", func(args []*bones.Node, ev *Evaluator) (*bones.Node, error) {
+		go func() {
+			defer close(done)
+			async, asyncErr = ev.Apply(ev.Context(), args[0], nil)
+		}()
+		<-started
+		close(release)
+		return bones.IntNode(1), nil
+	})
+
+	ev := New(engraving.StandardLogger, env)
+	res, err := evalIn(t, ev, "(add (call-later (lambda () (block) 41)) 1)")
+	if err != nil {
+		t.Fatal(err)
+	}
+	<-done
+	if !res.Equiv(bones.IntNode(2)) || asyncErr != nil || !async.Equiv(bones.IntNode(41)) {
+		t.Errorf("expected 2 and 41, got %v and %v, %v", res, async, asyncErr)
+	}
+}
+
+func firstError(errs []error) error {
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,769 @@
+package consume
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+
+	"github.com/archevel/ghoul/bones"
+)
+
+// GhcFormatVersion is bumped whenever the .ghc encoding or the meaning of
+// an opcode changes, so files written by an incompatible build are
+// rejected instead of misread.
+const GhcFormatVersion = 2
+
+// ghcMagic starts every .ghc file, and nodesMagic what WriteNodes writes.
+var (
+	ghcMagic   = []byte("GHC\x00")
+	nodesMagic = []byte("GHN\x00")
+)
+
+// ErrMalformedBytecode is wrapped by every error ReadCompiled returns for
+// input that is truncated, corrupt or fails verification.
+var ErrMalformedBytecode = errors.New("malformed bytecode")
+
+// ErrUnserializable is returned by WriteCompiled when the code holds values
+// that only exist at runtime, such as procedures spliced in by a macro.
+var ErrUnserializable = errors.New("code contains runtime values")
+
+// maxNesting bounds how deeply nodes and code objects may nest in a .ghc
+// file, so hostile input cannot exhaust the Go stack while decoding.
+const maxNesting = 1 << 16
+
+// CompiledModule is the content of a .ghc file: the compiled top-level
+// code of a module, plus the expansion-time effects (macro definitions
+// and requires) that must be replayed to rebuild its macro scope.
+type CompiledModule struct {
+	Effects []*bones.Node
+	Code    *CodeObject
+}
+
+// Compile compiles translated top-level nodes into a CodeObject as
+// written, without the optimizations Evaluator.Compile applies.
+func Compile(nodes []*bones.Node) (*CodeObject, error) {
+	return compileTopLevel(nodes)
+}
+
+// RunCode runs a compiled top-level CodeObject in the evaluator's
+// environment. Code read from outside the process should come from
+// ReadCompiled, which verifies it first.
+func (ev *Evaluator) RunCode(ctx context.Context, code *CodeObject) (*bones.Node, error) {
+	if ev.disasm != nil {
+		if err := Disassemble(ev.disasm, code); err != nil {
+			return nil, err
+		}
+	}
+	vm := newVM(ev)
+	if parent := ev.active; parent != nil && parent.claim(ev) {
+		// Code run from a native function draws on the budget of the
+		// evaluation that called it, and adds to its stats.
+		defer parent.unclaim()
+		defer vm.inheritBudget(parent)()
+		vm.stats = parent.stats
+	} else if parent == nil {
+		vm.stats = StatsFrom(ctx)
+	}
+	return vm.run(ctx, code)
+}
+
+// Node tags in the encoding.
+const (
+	tagNil byte = iota
+	tagInt
+	tagFloat
+	tagString
+	tagBool
+	tagIdent
+	tagList
+	tagQuote
+	tagCode
+	// tagExtern refers to a runtime value stored elsewhere in an image;
+	// see WriteImage.
+	tagExtern
+)
+
+// Location tags in the encoding.
+const (
+	locPosition byte = iota + 1
+	locExpansion
+)
+
+// WriteCompiled encodes m in the .ghc format. Source locations and
+// filenames are stored once and shared by index; hygiene marks are stored
+// as a sorted table so ReadCompiled can remap them in order.
+func WriteCompiled(w io.Writer, m *CompiledModule) error {
+	enc := &ghcEncoder{
+		fileIndex: map[string]int{},
+		locIndex:  map[bones.CodeLocation]int{},
+		marks:     map[uint64]bool{},
+	}
+	var body ghcBuffer
+	body.uvarint(uint64(len(m.Effects)))
+	for _, n := range m.Effects {
+		if err := enc.node(&body, n, false); err != nil {
+			return err
+		}
+	}
+	if err := enc.code(&body, m.Code); err != nil {
+		return err
+	}
+
+	return enc.finish(w, ghcMagic, &body)
+}
+
+// WriteNodes encodes groups of syntax nodes the way WriteCompiled encodes
+// expansion effects, with one table of locations and marks for all of
+// them. It is for callers that keep expanded code rather than bytecode,
+// such as the expansion cache. Runtime values fail with ErrUnserializable.
+func WriteNodes(w io.Writer, groups ...[]*bones.Node) error {
+	enc := &ghcEncoder{
+		fileIndex: map[string]int{},
+		locIndex:  map[bones.CodeLocation]int{},
+		marks:     map[uint64]bool{},
+	}
+	var body ghcBuffer
+	body.uvarint(uint64(len(groups)))
+	for _, nodes := range groups {
+		body.uvarint(uint64(len(nodes)))
+		for _, n := range nodes {
+			if err := enc.node(&body, n, false); err != nil {
+				return err
+			}
+		}
+	}
+	return enc.finish(w, nodesMagic, &body)
+}
+
+// finish writes magic, the format version, the tables and then body to w.
+func (enc *ghcEncoder) finish(w io.Writer, magic []byte, body *ghcBuffer) error {
+	var out ghcBuffer
+	out.Write(magic)
+	out.uvarint(GhcFormatVersion)
+	enc.tables(&out)
+	out.Write(body.Bytes())
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+type ghcBuffer struct {
+	bytes.Buffer
+}
+
+func (b *ghcBuffer) uvarint(v uint64) {
+	b.Write(binary.AppendUvarint(nil, v))
+}
+
+func (b *ghcBuffer) varint(v int64) {
+	b.Write(binary.AppendVarint(nil, v))
+}
+
+func (b *ghcBuffer) str(s string) {
+	b.uvarint(uint64(len(s)))
+	b.WriteString(s)
+}
+
+func (b *ghcBuffer) flag(v bool) {
+	if v {
+		b.WriteByte(1)
+	} else {
+		b.WriteByte(0)
+	}
+}
+
+type ghcEncoder struct {
+	files     []string
+	fileIndex map[string]int
+	locs      ghcBuffer
+	numLocs   int
+	locIndex  map[bones.CodeLocation]int
+	marks     map[uint64]bool
+
+	// extern, when set, is offered every node first. It returns the index
+	// to write for a runtime value the image stores out of line, or false
+	// for a node to be encoded in place.
+	extern func(n *bones.Node) (int, bool, error)
+}
+
+// tables writes the filename, location and mark tables collected while
+// encoding the body.
+func (enc *ghcEncoder) tables(out *ghcBuffer) {
+	out.uvarint(uint64(len(enc.files)))
+	for _, f := range enc.files {
+		out.str(f)
+	}
+	out.uvarint(uint64(enc.numLocs))
+	out.Write(enc.locs.Bytes())
+
+	// Every mark used by an identifier, in ascending order, so the reader
+	// can hand out replacements that keep their relative age.
+	sorted := make([]uint64, 0, len(enc.marks))
+	for m := range enc.marks {
+		sorted = append(sorted, m)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	out.uvarint(uint64(len(sorted)))
+	for _, m := range sorted {
+		out.uvarint(m)
+	}
+}
+
+func (enc *ghcEncoder) file(name string) int {
+	if idx, ok := enc.fileIndex[name]; ok {
+		return idx
+	}
+	enc.files = append(enc.files, name)
+	enc.fileIndex[name] = len(enc.files)
+	return len(enc.files)
+}
+
+// loc returns the table index of l, offset by one so zero means none.
+// Expansion locations are added after the locations they refer to.
+func (enc *ghcEncoder) loc(l bones.CodeLocation) int {
+	if l == nil {
+		return 0
+	}
+	if idx, ok := enc.locIndex[l]; ok {
+		return idx
+	}
+	switch l := l.(type) {
+	case *bones.MacroExpansionLocation:
+		call, def := enc.loc(l.CallSite), enc.loc(l.DefSite)
+		enc.locs.WriteByte(locExpansion)
+		enc.locs.str(l.MacroName)
+		enc.locs.uvarint(uint64(call))
+		enc.locs.uvarint(uint64(def))
+	default:
+		file := 0
+		if sp, ok := l.(*bones.SourcePosition); ok && sp.Filename != nil {
+			file = enc.file(*sp.Filename)
+		}
+		// Unknown location kinds degrade to a plain position.
+		enc.locs.WriteByte(locPosition)
+		enc.locs.uvarint(uint64(max(l.Line(), 0)))
+		enc.locs.uvarint(uint64(max(l.Column(), 0)))
+		enc.locs.uvarint(uint64(file))
+	}
+	enc.numLocs++
+	enc.locIndex[l] = enc.numLocs
+	return enc.numLocs
+}
+
+func (enc *ghcEncoder) node(b *ghcBuffer, n *bones.Node, allowCode bool) error {
+	if n == nil || n.Kind == bones.NilNode {
+		b.WriteByte(tagNil)
+		return nil
+	}
+	if enc.extern != nil {
+		idx, ok, err := enc.extern(n)
+		if err != nil {
+			return err
+		}
+		if ok {
+			b.WriteByte(tagExtern)
+			b.uvarint(uint64(idx))
+			return nil
+		}
+	}
+	if n.Kind == bones.ForeignNode && allowCode {
+		if co, ok := n.ForeignVal.(*CodeObject); ok {
+			b.WriteByte(tagCode)
+			return enc.code(b, co)
+		}
+	}
+
+	switch n.Kind {
+	case bones.IntegerNode:
+		b.WriteByte(tagInt)
+		b.uvarint(uint64(enc.loc(n.Loc)))
+		b.varint(n.IntVal)
+	case bones.FloatNodeKind:
+		b.WriteByte(tagFloat)
+		b.uvarint(uint64(enc.loc(n.Loc)))
+		b.Write(binary.LittleEndian.AppendUint64(nil, math.Float64bits(n.FloatVal)))
+	case bones.StringNode:
+		b.WriteByte(tagString)
+		b.uvarint(uint64(enc.loc(n.Loc)))
+		b.str(n.StrVal)
+	case bones.BooleanNode:
+		b.WriteByte(tagBool)
+		b.uvarint(uint64(enc.loc(n.Loc)))
+		b.flag(n.BoolVal)
+	case bones.IdentifierNode:
+		b.WriteByte(tagIdent)
+		b.uvarint(uint64(enc.loc(n.Loc)))
+		b.str(n.Name)
+		b.flag(n.Marks != nil)
+		if n.Marks != nil {
+			marks := make([]uint64, 0, len(n.Marks))
+			for m := range n.Marks {
+				enc.marks[m] = true
+				marks = append(marks, m)
+			}
+			sort.Slice(marks, func(i, j int) bool { return marks[i] < marks[j] })
+			b.uvarint(uint64(len(marks)))
+			for _, m := range marks {
+				b.uvarint(m)
+			}
+		}
+	case bones.QuoteNode:
+		b.WriteByte(tagQuote)
+		b.uvarint(uint64(enc.loc(n.Loc)))
+		return enc.node(b, n.Quoted, false)
+	case bones.ListNode:
+		b.WriteByte(tagList)
+		b.uvarint(uint64(enc.loc(n.Loc)))
+		b.uvarint(uint64(len(n.Children)))
+		for _, c := range n.Children {
+			if err := enc.node(b, c, false); err != nil {
+				return err
+			}
+		}
+		b.flag(n.DottedTail != nil)
+		if n.DottedTail != nil {
+			return enc.node(b, n.DottedTail, false)
+		}
+	default:
+		return fmt.Errorf("%w: %s", ErrUnserializable, bones.NodeTypeName(n))
+	}
+	return nil
+}
+
+func (enc *ghcEncoder) code(b *ghcBuffer, co *CodeObject) error {
+	b.str(co.Name)
+	b.uvarint(uint64(co.NumLocals))
+	b.flag(co.Params != nil)
+	if co.Params != nil {
+		b.uvarint(uint64(len(co.Params.Fixed)))
+		for _, p := range co.Params.Fixed {
+			if err := enc.node(b, p, false); err != nil {
+				return err
+			}
+		}
+		b.flag(co.Params.Variadic != nil)
+		if co.Params.Variadic != nil {
+			if err := enc.node(b, co.Params.Variadic, false); err != nil {
+				return err
+			}
+		}
+	}
+	b.uvarint(uint64(len(co.Code)))
+	b.Write(co.Code)
+	b.uvarint(uint64(len(co.Constants)))
+	for _, c := range co.Constants {
+		if err := enc.node(b, c, true); err != nil {
+			return err
+		}
+	}
+	b.uvarint(uint64(len(co.Locs)))
+	for _, l := range co.Locs {
+		b.uvarint(uint64(l.StartPC))
+		b.uvarint(uint64(enc.loc(l.Loc)))
+	}
+	b.uvarint(uint64(len(co.Captures)))
+	for _, c := range co.Captures {
+		b.flag(c.Local)
+		b.uvarint(uint64(c.Index))
+		b.flag(c.Cell)
+	}
+	b.uvarint(uint64(len(co.Cells)))
+	for _, slot := range co.Cells {
+		b.uvarint(uint64(slot))
+	}
+	return nil
+}
+
+// ReadCompiled decodes and verifies a .ghc file. Hygiene marks are
+// replaced with marks drawn from fresh, in their original order, so they
+// cannot collide with marks handed out by the running interpreter. Every
+// failure wraps ErrMalformedBytecode; ReadCompiled never panics on bad
+// input.
+func ReadCompiled(data []byte, fresh func() uint64) (*CompiledModule, error) {
+	dec := &ghcDecoder{data: data}
+	m := dec.module(fresh)
+	if dec.err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrMalformedBytecode, dec.err)
+	}
+	if err := Verify(m.Code); err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrMalformedBytecode, err)
+	}
+	return m, nil
+}
+
+// ReadNodes decodes the groups of nodes WriteNodes wrote, replacing
+// hygiene marks with marks drawn from fresh as ReadCompiled does. Every
+// failure wraps ErrMalformedBytecode.
+func ReadNodes(data []byte, fresh func() uint64) ([][]*bones.Node, error) {
+	dec := &ghcDecoder{data: data}
+	var groups [][]*bones.Node
+	if dec.header(nodesMagic, "a node file") {
+		dec.tables(fresh)
+		n := dec.count()
+		for i := 0; i < n && dec.err == nil; i++ {
+			nodes := make([]*bones.Node, dec.count())
+			for j := range nodes {
+				nodes[j] = dec.node(false)
+			}
+			groups = append(groups, nodes)
+		}
+		if dec.err == nil && dec.pos != len(dec.data) {
+			dec.fail("%d trailing bytes", len(dec.data)-dec.pos)
+		}
+	}
+	if dec.err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrMalformedBytecode, dec.err)
+	}
+	return groups, nil
+}
+
+type ghcDecoder struct {
+	data  []byte
+	pos   int
+	err   error
+	depth int
+
+	files []*string
+	locs  []bones.CodeLocation
+	marks map[uint64]uint64
+
+	// extern returns the runtime value a tagExtern refers to; see
+	// ghcEncoder.extern. Outside an image the tag is malformed.
+	extern func(idx int) *bones.Node
+}
+
+func (dec *ghcDecoder) fail(format string, args ...any) {
+	if dec.err == nil {
+		dec.err = fmt.Errorf(format, args...)
+	}
+}
+
+// header reads magic and the format version, reporting whether the data
+// is what was described.
+func (dec *ghcDecoder) header(magic []byte, what string) bool {
+	if len(dec.data) < len(magic) || !bytes.Equal(dec.data[:len(magic)], magic) {
+		dec.fail("not %s", what)
+		return false
+	}
+	dec.pos = len(magic)
+	if v := dec.uvarint(); dec.err == nil && v != GhcFormatVersion {
+		dec.fail("unsupported format version %d (this build reads version %d)", v, GhcFormatVersion)
+		return false
+	}
+	return dec.err == nil
+}
+
+func (dec *ghcDecoder) module(fresh func() uint64) *CompiledModule {
+	if !dec.header(ghcMagic, "a .ghc file") {
+		return nil
+	}
+	dec.tables(fresh)
+
+	m := &CompiledModule{}
+	n := dec.count()
+	for i := 0; i < n && dec.err == nil; i++ {
+		m.Effects = append(m.Effects, dec.node(false))
+	}
+	m.Code = dec.code()
+	if dec.err == nil && dec.pos != len(dec.data) {
+		dec.fail("%d trailing bytes", len(dec.data)-dec.pos)
+	}
+	if dec.err != nil {
+		return nil
+	}
+	return m
+}
+
+// tables reads the tables written by ghcEncoder.tables, replacing each
+// mark with one from fresh. A nil fresh keeps the marks as they are.
+func (dec *ghcDecoder) tables(fresh func() uint64) {
+	n := dec.count()
+	for i := 0; i < n && dec.err == nil; i++ {
+		name := dec.str()
+		dec.files = append(dec.files, &name)
+	}
+
+	n = dec.count()
+	dec.locs = make([]bones.CodeLocation, 1, n+1)
+	for i := 0; i < n && dec.err == nil; i++ {
+		switch tag := dec.byte(); tag {
+		case locPosition:
+			pos := &bones.SourcePosition{Ln: dec.int(), Col: dec.int()}
+			if f := dec.index(len(dec.files)); f > 0 {
+				pos.Filename = dec.files[f-1]
+			}
+			dec.locs = append(dec.locs, pos)
+		case locExpansion:
+			mel := &bones.MacroExpansionLocation{MacroName: dec.str()}
+			// Only earlier entries may be referenced, so chains are acyclic.
+			mel.CallSite = dec.locs[dec.index(len(dec.locs)-1)]
+			mel.DefSite = dec.locs[dec.index(len(dec.locs)-1)]
+			if mel.CallSite == nil {
+				dec.fail("expansion location without a call site")
+			}
+			dec.locs = append(dec.locs, mel)
+		default:
+			dec.fail("unknown location tag %d", tag)
+		}
+	}
+
+	n = dec.count()
+	dec.marks = make(map[uint64]uint64, n)
+	var prev uint64
+	for i := 0; i < n && dec.err == nil; i++ {
+		m := dec.uvarint()
+		if i > 0 && m <= prev {
+			dec.fail("mark table out of order")
+			return
+		}
+		prev = m
+		if fresh == nil {
+			dec.marks[m] = m
+		} else {
+			dec.marks[m] = fresh()
+		}
+	}
+}
+
+func (dec *ghcDecoder) node(allowCode bool) *bones.Node {
+	if dec.err != nil {
+		return nil
+	}
+	dec.depth++
+	defer func() { dec.depth-- }()
+	if dec.depth > maxNesting {
+		dec.fail("nesting deeper than %d", maxNesting)
+		return nil
+	}
+
+	tag := dec.byte()
+	switch tag {
+	case tagNil:
+		return bones.Nil
+	case tagCode:
+		if !allowCode {
+			dec.fail("code object outside a constant pool")
+			return nil
+		}
+		co := dec.code()
+		if co == nil {
+			return nil
+		}
+		return bones.ForeignNodeVal(co)
+	case tagExtern:
+		if dec.extern == nil {
+			dec.fail("runtime value outside an image")
+			return nil
+		}
+		return dec.extern(dec.int())
+	}
+
+	n := &bones.Node{Loc: dec.locs[dec.index(len(dec.locs)-1)]}
+	switch tag {
+	case tagInt:
+		n.Kind = bones.IntegerNode
+		n.IntVal = dec.varint()
+	case tagFloat:
+		n.Kind = bones.FloatNodeKind
+		if b := dec.bytes(8); b != nil {
+			n.FloatVal = math.Float64frombits(binary.LittleEndian.Uint64(b))
+		}
+	case tagString:
+		n.Kind = bones.StringNode
+		n.StrVal = dec.str()
+	case tagBool:
+		n.Kind = bones.BooleanNode
+		n.BoolVal = dec.flag()
+	case tagIdent:
+		n.Kind = bones.IdentifierNode
+		n.Name = dec.str()
+		n.Sym = bones.Intern(n.Name)
+		if dec.flag() {
+			count := dec.count()
+			n.Marks = make(map[uint64]bool, count)
+			for i := 0; i < count && dec.err == nil; i++ {
+				m, ok := dec.marks[dec.uvarint()]
+				if !ok {
+					dec.fail("identifier %s has a mark missing from the mark table", n.Name)
+				}
+				n.Marks[m] = true
+			}
+		}
+	case tagQuote:
+		n.Kind = bones.QuoteNode
+		n.Quoted = dec.node(false)
+	case tagList:
+		n.Kind = bones.ListNode
+		count := dec.count()
+		n.Children = make([]*bones.Node, 0, count)
+		for i := 0; i < count && dec.err == nil; i++ {
+			n.Children = append(n.Children, dec.node(false))
+		}
+		if dec.flag() {
+			n.DottedTail = dec.node(false)
+		}
+	default:
+		dec.fail("unknown node tag %d", tag)
+	}
+	if dec.err != nil {
+		return nil
+	}
+	return n
+}
+
+func (dec *ghcDecoder) code() *CodeObject {
+	if dec.err != nil {
+		return nil
+	}
+	dec.depth++
+	defer func() { dec.depth-- }()
+	if dec.depth > maxNesting {
+		dec.fail("nesting deeper than %d", maxNesting)
+		return nil
+	}
+
+	co := &CodeObject{Name: dec.str(), NumLocals: dec.int()}
+	if dec.flag() {
+		co.Params = &bones.ParamSpec{}
+		n := dec.count()
+		for i := 0; i < n && dec.err == nil; i++ {
+			co.Params.Fixed = append(co.Params.Fixed, dec.node(false))
+		}
+		if dec.flag() {
+			co.Params.Variadic = dec.node(false)
+		}
+	}
+	co.Code = append([]byte(nil), dec.bytes(dec.count())...)
+	n := dec.count()
+	for i := 0; i < n && dec.err == nil; i++ {
+		co.Constants = append(co.Constants, dec.node(true))
+	}
+	n = dec.count()
+	for i := 0; i < n && dec.err == nil; i++ {
+		pc := dec.int()
+		loc := dec.locs[dec.index(len(dec.locs)-1)]
+		if loc == nil {
+			dec.fail("source map entry without a location")
+		}
+		co.Locs = append(co.Locs, LocEntry{StartPC: pc, Loc: loc})
+	}
+	n = dec.count()
+	for i := 0; i < n && dec.err == nil; i++ {
+		co.Captures = append(co.Captures, Capture{Local: dec.flag(), Index: dec.int(), Cell: dec.flag()})
+	}
+	n = dec.count()
+	for i := 0; i < n && dec.err == nil; i++ {
+		co.Cells = append(co.Cells, dec.int())
+	}
+	if dec.err != nil {
+		return nil
+	}
+	return co
+}
+
+// --- primitive readers; each records the first error and returns a zero
+// value once one has occurred ---
+
+func (dec *ghcDecoder) byte() byte {
+	if dec.err != nil {
+		return 0
+	}
+	if dec.pos >= len(dec.data) {
+		dec.fail("unexpected end of input")
+		return 0
+	}
+	b := dec.data[dec.pos]
+	dec.pos++
+	return b
+}
+
+func (dec *ghcDecoder) bytes(n int) []byte {
+	if dec.err != nil {
+		return nil
+	}
+	if n > len(dec.data)-dec.pos {
+		dec.fail("unexpected end of input")
+		return nil
+	}
+	b := dec.data[dec.pos : dec.pos+n]
+	dec.pos += n
+	return b
+}
+
+func (dec *ghcDecoder) uvarint() uint64 {
+	if dec.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(dec.data[dec.pos:])
+	if n <= 0 {
+		dec.fail("bad varint at offset %d", dec.pos)
+		return 0
+	}
+	dec.pos += n
+	return v
+}
+
+func (dec *ghcDecoder) varint() int64 {
+	if dec.err != nil {
+		return 0
+	}
+	v, n := binary.Varint(dec.data[dec.pos:])
+	if n <= 0 {
+		dec.fail("bad varint at offset %d", dec.pos)
+		return 0
+	}
+	dec.pos += n
+	return v
+}
+
+// int reads a non-negative int that fits comfortably in memory.
+func (dec *ghcDecoder) int() int {
+	v := dec.uvarint()
+	if v > math.MaxInt32 {
+		dec.fail("value %d out of range", v)
+		return 0
+	}
+	return int(v)
+}
+
+// count reads an element count. Every element takes at least one byte,
+// so a count larger than the remaining input is rejected before anything
+// is allocated for it.
+func (dec *ghcDecoder) count() int {
+	v := dec.uvarint()
+	if v > uint64(len(dec.data)-dec.pos) {
+		dec.fail("count %d exceeds remaining input", v)
+		return 0
+	}
+	return int(v)
+}
+
+// index reads a one-based table index no greater than limit.
+func (dec *ghcDecoder) index(limit int) int {
+	v := dec.uvarint()
+	if v > uint64(limit) {
+		dec.fail("table index %d out of range", v)
+		return 0
+	}
+	return int(v)
+}
+
+func (dec *ghcDecoder) flag() bool {
+	switch b := dec.byte(); b {
+	case 0:
+		return false
+	case 1:
+		return true
+	default:
+		dec.fail("bad flag byte %d", b)
+		return false
+	}
+}
+
+func (dec *ghcDecoder) str() string {
+	return string(dec.bytes(dec.count()))
+}
@@ -0,0 +1,229 @@
+package consume
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/archevel/ghoul/bones"
+	"github.com/archevel/ghoul/engraving"
+	p "github.com/archevel/ghoul/exhumer"
+)
+
+func translateSource(t testing.TB, src string) []*bones.Node {
+	t.Helper()
+	filename := "crypt.ghl"
+	_, parsed := p.ParseWithFilename(strings.NewReader(src), &filename)
+	var nodes []*bones.Node
+	for _, n := range parsed.Expressions.Children {
+		tn, err := translateForEval(n)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nodes = append(nodes, tn)
+	}
+	return nodes
+}
+
+func compileSource(t *testing.T, src string) *CodeObject {
+	t.Helper()
+	code, err := Compile(translateSource(t, src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func encodeModule(t *testing.T, m *CompiledModule) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := WriteCompiled(&buf, m); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func counter(start uint64) func() uint64 {
+	next := start
+	return func() uint64 {
+		next++
+		return next
+	}
+}
+
+const ghcTestSource = `
+(define make-counter
+  (lambda (start)
+    (define n start)
+    (lambda (step . ignored)
+      (set! n (+ n step))
+      n)))
+(define c (make-counter 10))
+(c 1)
+(c 2 'skipped "also skipped" 1.5)
+(define result (cond ((< (c 0) 13) 'low) ((eq? (c 0) 13) (list 'exactly (c 0) '(a . b))) (else 'high)))
+result`
+
+func TestCompiledModuleRoundTripRunsTheSame(t *testing.T) {
+	code := compileSource(t, ghcTestSource)
+	data := encodeModule(t, &CompiledModule{Code: code})
+
+	m, err := ReadCompiled(data, counter(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	env := setupTestEnvironment()
+	env.Register("eq?", func(args []*bones.Node, ev *Evaluator) (*bones.Node, error) {
+		return bones.BoolNode(args[0].Equiv(args[1])), nil
+	})
+	env.Register("list", func(args []*bones.Node, ev *Evaluator) (*bones.Node, error) {
+		return bones.NewListNode(args), nil
+	})
+	result, err := New(engraving.StandardLogger, env).RunCode(context.Background(), m.Code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Repr() != "(exactly 13 (a . b))" {
+		t.Errorf("expected (exactly 13 (a . b)), got %s", result.Repr())
+	}
+}
+
+func TestCompiledModuleKeepsSourceLocations(t *testing.T) {
+	code := compileSource(t, "(define f (lambda (x) (undefined-thing x)))\n(f 1)")
+	m, err := ReadCompiled(encodeModule(t, &CompiledModule{Code: code}), counter(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = New(engraving.StandardLogger, NewEnvironment()).RunCode(context.Background(), m.Code)
+	if err == nil || !strings.HasPrefix(err.Error(), "crypt.ghl:1:24: undefined identifier: undefined-thing") {
+		t.Errorf("expected the original location in the error, got %v", err)
+	}
+}
+
+func TestCompiledModuleSharesExpansionLocations(t *testing.T) {
+	file := "crypt.ghl"
+	call := &bones.SourcePosition{Ln: 4, Col: 2, Filename: &file}
+	mel := &bones.MacroExpansionLocation{MacroName: "haunt", CallSite: call, DefSite: &bones.SourcePosition{Ln: 1, Col: 9, Filename: &file}}
+	code := &CodeObject{
+		Name:      "top-level",
+		Code:      []byte{OP_CONST, 0, 0, OP_POP, OP_CONST, 0, 1, OP_RETURN},
+		Constants: []*bones.Node{bones.IntNode(1), bones.IntNode(2)},
+		Locs:      []LocEntry{{StartPC: 0, Loc: mel}, {StartPC: 4, Loc: mel}},
+	}
+	m, err := ReadCompiled(encodeModule(t, &CompiledModule{Code: code}), counter(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := m.Code.Locs
+	if got[0].Loc != got[1].Loc {
+		t.Error("expected both entries to share one expansion location")
+	}
+	if got[0].Loc.(*bones.MacroExpansionLocation).Backtrace() != mel.Backtrace() {
+		t.Errorf("expected %q, got %q", mel.Backtrace(), got[0].Loc.(*bones.MacroExpansionLocation).Backtrace())
+	}
+}
+
+func TestCompiledModuleRemapsMarksInOrder(t *testing.T) {
+	older := bones.ScopedIdentNode("tmp", map[uint64]bool{7: true})
+	newer := bones.ScopedIdentNode("tmp", map[uint64]bool{7: true, 40: true})
+	code := &CodeObject{
+		Name:      "top-level",
+		Code:      []byte{OP_NIL, OP_DEFINE, 0, 0, OP_DEFINE, 0, 1, OP_RETURN},
+		Constants: []*bones.Node{older, newer},
+	}
+	effect := bones.NewListNode([]*bones.Node{bones.IdentNode("define-syntax"), bones.ScopedIdentNode("m", map[uint64]bool{40: true})})
+
+	m, err := ReadCompiled(encodeModule(t, &CompiledModule{Effects: []*bones.Node{effect}, Code: code}), counter(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if marks := m.Code.Constants[0].Marks; len(marks) != 1 || !marks[101] {
+		t.Errorf("expected mark 7 remapped to 101, got %v", marks)
+	}
+	if marks := m.Code.Constants[1].Marks; len(marks) != 2 || !marks[101] || !marks[102] {
+		t.Errorf("expected marks 7,40 remapped to 101,102, got %v", marks)
+	}
+	if marks := m.Effects[0].Children[1].Marks; len(marks) != 1 || !marks[102] {
+		t.Errorf("expected effect mark 40 remapped to 102, got %v", marks)
+	}
+}
+
+func TestWriteCompiledRejectsRuntimeValues(t *testing.T) {
+	fn := bones.FuncNode(func(args []*bones.Node, ev bones.Evaluator) (*bones.Node, error) { return bones.Nil, nil })
+	code := &CodeObject{Name: "top-level", Code: []byte{OP_CONST, 0, 0, OP_RETURN}, Constants: []*bones.Node{fn}}
+	err := WriteCompiled(&bytes.Buffer{}, &CompiledModule{Code: code})
+	if !errors.Is(err, ErrUnserializable) {
+		t.Errorf("expected ErrUnserializable, got %v", err)
+	}
+}
+
+func TestNodesRoundTrip(t *testing.T) {
+	filename := "crypt.ghl"
+	_, parsed := p.ParseWithFilename(strings.NewReader(ghcTestSource), &filename)
+	forms := parsed.Expressions.Children
+	effects := []*bones.Node{bones.NewListNode([]*bones.Node{bones.IdentNode("define-syntax"), bones.StrNode("x")})}
+	var buf bytes.Buffer
+	if err := WriteNodes(&buf, effects, forms); err != nil {
+		t.Fatal(err)
+	}
+	groups, err := ReadNodes(buf.Bytes(), counter(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(groups) != 2 || len(groups[0]) != len(effects) || len(groups[1]) != len(forms) {
+		t.Fatalf("expected the groups back, got %v", groups)
+	}
+	for i, n := range forms {
+		if !groups[1][i].Equiv(n) {
+			t.Errorf("form %d: expected %s, got %s", i, n.Repr(), groups[1][i].Repr())
+		}
+	}
+	if _, err := ReadNodes(encodeModule(t, &CompiledModule{Code: compileSource(t, "1")}), counter(0)); !errors.Is(err, ErrMalformedBytecode) {
+		t.Errorf("expected a .ghc file to be rejected, got %v", err)
+	}
+}
+
+func TestReadCompiledRejectsOtherVersions(t *testing.T) {
+	data := encodeModule(t, &CompiledModule{Code: compileSource(t, "1")})
+	data[len(ghcMagic)] = GhcFormatVersion + 1
+	_, err := ReadCompiled(data, counter(0))
+	if !errors.Is(err, ErrMalformedBytecode) || !strings.Contains(err.Error(), "unsupported format version") {
+		t.Errorf("expected a version error, got %v", err)
+	}
+}
+
+func TestReadCompiledRejectsEveryTruncation(t *testing.T) {
+	data := encodeModule(t, &CompiledModule{Code: compileSource(t, ghcTestSource)})
+	for n := 0; n < len(data); n++ {
+		if _, err := ReadCompiled(data[:n], counter(0)); !errors.Is(err, ErrMalformedBytecode) {
+			t.Fatalf("expected truncation to %d of %d bytes to be rejected, got %v", n, len(data), err)
+		}
+	}
+}
+
+func TestReadCompiledSurvivesCorruption(t *testing.T) {
+	data := encodeModule(t, &CompiledModule{Code: compileSource(t, ghcTestSource)})
+	for i := len(ghcMagic) + 1; i < len(data); i++ {
+		for _, b := range []byte{0x00, 0x01, 0x7f, 0xff} {
+			corrupt := append([]byte(nil), data...)
+			corrupt[i] = b
+			m, err := ReadCompiled(corrupt, counter(0))
+			if err != nil {
+				if !errors.Is(err, ErrMalformedBytecode) {
+					t.Fatalf("byte %d set to %#x: unexpected error %v", i, b, err)
+				}
+				continue
+			}
+			// Whatever still verifies must run without panicking. A
+			// corrupted jump may loop forever, so bound the run.
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			New(engraving.StandardLogger, setupTestEnvironment()).RunCode(ctx, m.Code)
+			cancel()
+		}
+	}
+}
@@ -0,0 +1,477 @@
+package consume
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/archevel/ghoul/bones"
+)
+
+// An image is the state of an environment written out, so that a new
+// interpreter can start from it instead of running the code that built
+// it: every binding, the closures they hold with their compiled code, and
+// the environments and cells those closures share. Values that live in Go,
+// such as builtins and mummies, cannot be written; they are stored by the
+// names they are bound to and looked up again when the image is loaded.
+// Images share the .ghc encoding and its format version.
+
+// imageMagic starts every image.
+var imageMagic = []byte("GHI\x00")
+
+// ErrMalformedImage is wrapped by the errors OpenImage and Load return for
+// input that is truncated, corrupt or fails verification.
+var ErrMalformedImage = errors.New("malformed image")
+
+// Image is what WriteImage stores.
+type Image struct {
+	// Env is the global environment. Every environment, scope and cell
+	// reachable from it through closures is stored with it.
+	Env *Environment
+	// Requires is stored ahead of everything else, for the reader to act
+	// on before values are looked up; see OpenImage.
+	Requires []string
+	// Roots are further values to store, such as macro transformers.
+	Roots []*bones.Node
+	// Marks is the hygiene mark counter.
+	Marks uint64
+}
+
+// Object kinds in an image.
+const (
+	objClosure byte = iota + 1
+	objHost
+)
+
+type imageEncoder struct {
+	ghcEncoder
+	objs       []*bones.Node
+	objIndex   map[*bones.Node]int
+	cells      []*cell
+	cellIndex  map[*cell]int
+	codes      []*CodeObject
+	codeIndex  map[*CodeObject]int
+	scopes     []*scope
+	scopeIndex map[*scope]int
+	envs       []*environment
+	envIndex   map[*environment]int
+}
+
+// WriteImage encodes img. Closures are stored with their code; Go values
+// are stored by the unmarked names bound to them in any stored scope, and
+// ErrUnserializable is returned for one that has none.
+func WriteImage(w io.Writer, img *Image) error {
+	enc := &imageEncoder{
+		ghcEncoder: ghcEncoder{
+			fileIndex: map[string]int{},
+			locIndex:  map[bones.CodeLocation]int{},
+			marks:     map[uint64]bool{},
+		},
+		objIndex:   map[*bones.Node]int{},
+		cellIndex:  map[*cell]int{},
+		codeIndex:  map[*CodeObject]int{},
+		scopeIndex: map[*scope]int{},
+		envIndex:   map[*environment]int{},
+	}
+	enc.extern = enc.object
+	indexOf(enc.envIndex, &enc.envs, img.Env)
+
+	var roots ghcBuffer
+	roots.uvarint(uint64(len(img.Roots)))
+	for _, n := range img.Roots {
+		if err := enc.node(&roots, n, false); err != nil {
+			return err
+		}
+	}
+
+	// Encoding one table finds entries for the others, so keep going
+	// round until none grows.
+	var codes, cells, scopes, envs, closures ghcBuffer
+	var codeN, cellN, scopeN, envN, objN int
+	for codeN < len(enc.codes) || cellN < len(enc.cells) || scopeN < len(enc.scopes) ||
+		envN < len(enc.envs) || objN < len(enc.objs) {
+		for ; envN < len(enc.envs); envN++ {
+			env := *enc.envs[envN]
+			envs.uvarint(uint64(len(env)))
+			for _, s := range env {
+				envs.uvarint(uint64(indexOf(enc.scopeIndex, &enc.scopes, s)))
+			}
+		}
+		for ; scopeN < len(enc.scopes); scopeN++ {
+			if err := enc.scope(&scopes, enc.scopes[scopeN]); err != nil {
+				return err
+			}
+		}
+		for ; cellN < len(enc.cells); cellN++ {
+			if err := enc.value(&cells, enc.cells[cellN].v); err != nil {
+				return err
+			}
+		}
+		for ; objN < len(enc.objs); objN++ {
+			if cd, ok := enc.objs[objN].ForeignVal.(*closureData); ok {
+				if err := enc.closure(&closures, cd); err != nil {
+					return err
+				}
+			}
+		}
+		for ; codeN < len(enc.codes); codeN++ {
+			if err := enc.code(&codes, enc.codes[codeN]); err != nil {
+				return err
+			}
+		}
+	}
+	objects, err := enc.objects()
+	if err != nil {
+		return err
+	}
+
+	var out ghcBuffer
+	out.Write(imageMagic)
+	out.uvarint(GhcFormatVersion)
+	out.uvarint(uint64(len(img.Requires)))
+	for _, r := range img.Requires {
+		out.str(r)
+	}
+	out.uvarint(img.Marks)
+	enc.tables(&out)
+	out.Write(objects.Bytes())
+	for _, n := range []int{len(enc.codes), len(enc.cells), len(enc.scopes), len(enc.envs)} {
+		out.uvarint(uint64(n))
+	}
+	for _, section := range []*ghcBuffer{&codes, &cells, &scopes, &envs, &closures, &roots} {
+		out.Write(section.Bytes())
+	}
+	_, err = w.Write(out.Bytes())
+	return err
+}
+
+// indexOf returns the index of v in list, appending it if it is new.
+func indexOf[T comparable](index map[T]int, list *[]T, v T) int {
+	if idx, ok := index[v]; ok {
+		return idx
+	}
+	index[v] = len(*list)
+	*list = append(*list, v)
+	return len(*list) - 1
+}
+
+// object stores closures and Go values out of line, so values shared in
+// the running interpreter are shared again when the image is loaded.
+func (enc *imageEncoder) object(n *bones.Node) (int, bool, error) {
+	switch n.Kind {
+	case bones.FunctionNode, bones.MummyNode:
+	case bones.ForeignNode:
+		if _, ok := n.ForeignVal.(*CodeObject); ok {
+			return 0, false, nil
+		}
+	default:
+		return 0, false, nil
+	}
+	return indexOf(enc.objIndex, &enc.objs, n), true, nil
+}
+
+// objects writes the kind of every object, and the names of those that
+// live in Go.
+func (enc *imageEncoder) objects() (*ghcBuffer, error) {
+	names := map[*bones.Node][]string{}
+	for _, s := range enc.scopes {
+		s.each(func(key scopeKey, val *bones.Node) {
+			if _, ok := enc.objIndex[val]; ok && key.Marks.empty() {
+				names[val] = append(names[val], key.name())
+			}
+		})
+	}
+
+	var b ghcBuffer
+	b.uvarint(uint64(len(enc.objs)))
+	for _, n := range enc.objs {
+		if _, ok := n.ForeignVal.(*closureData); ok {
+			b.WriteByte(objClosure)
+			continue
+		}
+		if len(names[n]) == 0 {
+			return nil, fmt.Errorf("%w: %s with no global name", ErrUnserializable, bones.NodeTypeName(n))
+		}
+		slices.Sort(names[n])
+		b.WriteByte(objHost)
+		b.uvarint(uint64(n.Kind))
+		b.uvarint(uint64(len(names[n])))
+		for _, name := range names[n] {
+			b.str(name)
+		}
+	}
+	return &b, nil
+}
+
+func (enc *imageEncoder) scope(b *ghcBuffer, s *scope) error {
+	type named struct {
+		key scopeKey
+		val *bones.Node
+	}
+	var bindings []named
+	s.each(func(key scopeKey, val *bones.Node) {
+		bindings = append(bindings, named{key, val})
+	})
+	// Sorted, so an unchanged environment always writes the same image.
+	slices.SortFunc(bindings, func(x, y named) int {
+		if c := strings.Compare(x.key.name(), y.key.name()); c != 0 {
+			return c
+		}
+		return slices.Compare(marksOf(x.key.Marks), marksOf(y.key.Marks))
+	})
+
+	b.uvarint(uint64(len(bindings)))
+	for _, bd := range bindings {
+		ident := bones.IdentNode(bd.key.name())
+		if !bd.key.Marks.empty() {
+			ident.Marks = map[uint64]bool{}
+			for _, m := range marksOf(bd.key.Marks) {
+				ident.Marks[m] = true
+			}
+		}
+		if err := enc.node(b, ident, false); err != nil {
+			return err
+		}
+		if err := enc.node(b, bd.val, false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (enc *imageEncoder) value(b *ghcBuffer, v value) error {
+	b.flag(v.isUnset())
+	if v.isUnset() {
+		return nil
+	}
+	return enc.node(b, v.Node(), false)
+}
+
+func (enc *imageEncoder) closure(b *ghcBuffer, cd *closureData) error {
+	b.uvarint(uint64(indexOf(enc.codeIndex, &enc.codes, cd.code)))
+	b.uvarint(uint64(indexOf(enc.envIndex, &enc.envs, cd.env)))
+	b.uvarint(uint64(len(cd.free)))
+	for _, uv := range cd.free {
+		b.flag(uv.cell != nil)
+		if uv.cell != nil {
+			b.uvarint(uint64(indexOf(enc.cellIndex, &enc.cells, uv.cell)))
+		} else if err := enc.value(b, uv.val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ImageReader loads an image read by OpenImage.
+type ImageReader struct {
+	// Requires and Marks are as written in the Image.
+	Requires []string
+	Marks    uint64
+
+	dec *ghcDecoder
+}
+
+// OpenImage reads the start of an image, up to the first value that may
+// need looking up, so the caller can prepare for Load.
+func OpenImage(data []byte) (*ImageReader, error) {
+	if len(data) < len(imageMagic) || !bytes.Equal(data[:len(imageMagic)], imageMagic) {
+		return nil, fmt.Errorf("%w: not an image", ErrMalformedImage)
+	}
+	dec := &ghcDecoder{data: data, pos: len(imageMagic)}
+	if v := dec.uvarint(); dec.err == nil && v != GhcFormatVersion {
+		return nil, fmt.Errorf("%w: unsupported format version %d (this build reads version %d)", ErrMalformedImage, v, GhcFormatVersion)
+	}
+	r := &ImageReader{dec: dec}
+	n := dec.count()
+	for i := 0; i < n && dec.err == nil; i++ {
+		r.Requires = append(r.Requires, dec.str())
+	}
+	r.Marks = dec.uvarint()
+	if dec.err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrMalformedImage, dec.err)
+	}
+	return r, nil
+}
+
+// Load reads the bindings the image holds for env, which must have as many
+// scopes as the stored environment, and returns the image's roots and a
+// commit func that replaces env's bindings with them. Go values are looked
+// up with resolve, trying each name they were bound to. env is left alone
+// until commit is called, so a caller can first finish restoring what the
+// roots describe and drop the image if that fails.
+func (r *ImageReader) Load(env *Environment, resolve func(name string) (*bones.Node, bool)) ([]*bones.Node, func(), error) {
+	dec := r.dec
+	if dec == nil {
+		return nil, nil, fmt.Errorf("load image: already loaded")
+	}
+	r.dec = nil
+	dec.tables(nil)
+
+	n := dec.count()
+	objs := make([]*bones.Node, n)
+	closures := make([]*closureData, n)
+	for i := 0; i < n && dec.err == nil; i++ {
+		switch kind := dec.byte(); kind {
+		case objClosure:
+			closures[i] = &closureData{}
+			objs[i] = makeClosureNode(closures[i])
+		case objHost:
+			want := bones.NodeKind(dec.int())
+			names := make([]string, dec.count())
+			for j := range names {
+				names[j] = dec.str()
+			}
+			for _, name := range names {
+				if v, ok := resolve(name); ok && v.Kind == want {
+					objs[i] = v
+					break
+				}
+			}
+			if objs[i] == nil && dec.err == nil {
+				return nil, nil, fmt.Errorf("load image: no %s named %s", bones.NodeTypeName(&bones.Node{Kind: want}), strings.Join(names, " or "))
+			}
+		default:
+			dec.fail("unknown object kind %d", kind)
+		}
+	}
+	dec.extern = func(idx int) *bones.Node {
+		if idx >= len(objs) {
+			dec.fail("object %d out of range", idx)
+			return nil
+		}
+		return objs[idx]
+	}
+
+	codes := make([]*CodeObject, dec.count())
+	cells := make([]*cell, dec.count())
+	bindings := make([]map[scopeKey]*bones.Node, dec.count())
+	envs := make([]*environment, dec.count())
+	if len(envs) == 0 && dec.err == nil {
+		dec.fail("no environment")
+	}
+	for i := range codes {
+		codes[i] = dec.code()
+	}
+	for i := range cells {
+		cells[i] = &cell{v: dec.value()}
+	}
+	for i := range bindings {
+		bindings[i] = map[scopeKey]*bones.Node{}
+		count := dec.count()
+		for j := 0; j < count && dec.err == nil; j++ {
+			ident, val := dec.node(false), dec.node(false)
+			if dec.err != nil {
+				break
+			}
+			if key, ok := keyFromNode(ident); ok {
+				bindings[i][key] = val
+			} else {
+				dec.fail("binding without a name")
+			}
+		}
+	}
+
+	// The scopes of the stored global environment are env's own; the
+	// others are new.
+	envScopes := make([][]int, len(envs))
+	for i := range envs {
+		envScopes[i] = make([]int, dec.count())
+		for j := range envScopes[i] {
+			envScopes[i][j] = dec.ref(len(bindings))
+		}
+	}
+	scopes := make([]*scope, len(bindings))
+	if dec.err == nil {
+		if len(envScopes[0]) != len(*env) {
+			dec.fail("stored environment has %d scopes, not %d", len(envScopes[0]), len(*env))
+		}
+		for j, idx := range envScopes[0] {
+			if j < len(*env) {
+				scopes[idx] = (*env)[j]
+			}
+		}
+	}
+	for i := range scopes {
+		if scopes[i] == nil {
+			scopes[i] = newScope()
+		}
+	}
+	if dec.err == nil {
+		envs[0] = env
+	}
+	for i := 1; i < len(envs) && dec.err == nil; i++ {
+		e := make(environment, len(envScopes[i]))
+		for j, idx := range envScopes[i] {
+			e[j] = scopes[idx]
+		}
+		envs[i] = &e
+	}
+
+	for i, cd := range closures {
+		if cd == nil || dec.err != nil {
+			continue
+		}
+		if idx := dec.ref(len(codes)); dec.err == nil {
+			cd.code = codes[idx]
+		}
+		if idx := dec.ref(len(envs)); dec.err == nil {
+			cd.env = envs[idx]
+		}
+		cd.free = make([]upvalue, dec.count())
+		for j := range cd.free {
+			if !dec.flag() {
+				cd.free[j].val = dec.value()
+			} else if idx := dec.ref(len(cells)); dec.err == nil {
+				cd.free[j].cell = cells[idx]
+			}
+		}
+		if dec.err == nil {
+			if err := verifyClosure(cd); err != nil {
+				dec.fail("closure %d: %s", i, err)
+			}
+		}
+	}
+
+	roots := make([]*bones.Node, dec.count())
+	for i := range roots {
+		roots[i] = dec.node(false)
+	}
+	if dec.err == nil && dec.pos != len(dec.data) {
+		dec.fail("%d trailing bytes", len(dec.data)-dec.pos)
+	}
+	if dec.err != nil {
+		return nil, nil, fmt.Errorf("%w: %s", ErrMalformedImage, dec.err)
+	}
+
+	commit := func() {
+		for i, s := range scopes {
+			s.replace(bindings[i])
+		}
+		for _, code := range codes {
+			noteAssignments(code, env)
+		}
+	}
+	return roots, commit, nil
+}
+
+func (dec *ghcDecoder) value() value {
+	if dec.flag() {
+		return value{}
+	}
+	return nodeValue(dec.node(false))
+}
+
+// ref reads an index into a table of n entries.
+func (dec *ghcDecoder) ref(n int) int {
+	v := dec.uvarint()
+	if dec.err == nil && v >= uint64(n) {
+		dec.fail("index %d out of range", v)
+	}
+	if dec.err != nil {
+		return 0
+	}
+	return int(v)
+}
@@ -0,0 +1,172 @@
+package consume
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/archevel/ghoul/bones"
+	"github.com/archevel/ghoul/engraving"
+	p "github.com/archevel/ghoul/exhumer"
+)
+
+const imageTestSource = `
+(define make-counter
+  (lambda (start)
+    (lambda (step)
+      (set! start (+ start step))
+      start)))
+(define c (make-counter 10))
+(c 3)
+(define deposit #f)
+(define peek #f)
+(define open (lambda (b)
+  (set! deposit (lambda (x) (set! b (+ b x)) b))
+  (set! peek (lambda () b))))
+(open 5)
+(define plus +)
+(define data '(1 "two" (x . 3.5)))`
+
+func imageOf(t *testing.T, env *environment, roots ...*bones.Node) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := WriteImage(&buf, &Image{Env: env, Requires: []string{"req"}, Roots: roots, Marks: 7}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func loadImage(t *testing.T, data []byte, env *environment) ([]*bones.Node, error) {
+	t.Helper()
+	r, err := OpenImage(data)
+	if err != nil {
+		return nil, err
+	}
+	roots, commit, err := r.Load(env, func(name string) (*bones.Node, bool) {
+		v, err := env.LookupByName(name)
+		return v, err == nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	commit()
+	return roots, nil
+}
+
+func TestImageRoundTripKeepsClosuresAndSharedCells(t *testing.T) {
+	env := setupTestEnvironment()
+	if _, err := evalIn(t, New(engraving.StandardLogger, env), imageTestSource); err != nil {
+		t.Fatal(err)
+	}
+	data := imageOf(t, env)
+
+	r, err := OpenImage(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(r.Requires) != 1 || r.Requires[0] != "req" || r.Marks != 7 {
+		t.Errorf("expected the requires and mark counter back, got %v and %d", r.Requires, r.Marks)
+	}
+
+	fresh := setupTestEnvironment()
+	if _, err := loadImage(t, data, fresh); err != nil {
+		t.Fatal(err)
+	}
+	ev := New(engraving.StandardLogger, fresh)
+	for src, want := range map[string]string{
+		"(c 1)":                             "14",
+		"(deposit 2) (peek)":                "7",
+		"(plus 1 2)":                        "3",
+		"data":                              `(1 "two" (x . 3.5))`,
+		"(define d (make-counter 0)) (d 5)": "5",
+	} {
+		res, err := evalIn(t, ev, src)
+		if err != nil || res.Repr() != want {
+			t.Errorf("%s: expected %s, got %v, %v", src, want, res, err)
+		}
+	}
+	plus, _ := fresh.LookupByName("plus")
+	builtin, _ := fresh.LookupByName("+")
+	if plus != builtin {
+		t.Error("expected plus to be re-bound to the loading environment's +")
+	}
+	if _, err := env.LookupByName("d"); err == nil {
+		t.Error("expected the stored environment to be separate from the loaded one")
+	}
+
+	if !bytes.Equal(imageOf(t, fresh), imageOf(t, fresh)) {
+		t.Error("expected an unchanged environment to write the same image")
+	}
+}
+
+func TestWriteImageRejectsGoValuesWithoutNames(t *testing.T) {
+	env := setupTestEnvironment()
+	anonymous := bones.FuncNode(func(args []*bones.Node, ev bones.Evaluator) (*bones.Node, error) { return bones.Nil, nil })
+	env.BindByName("procs", bones.NewListNode([]*bones.Node{anonymous}))
+	err := WriteImage(&bytes.Buffer{}, &Image{Env: env})
+	if !errors.Is(err, ErrUnserializable) {
+		t.Errorf("expected ErrUnserializable, got %v", err)
+	}
+}
+
+func TestLoadImageLeavesTheEnvironmentAloneWithoutItsGoValues(t *testing.T) {
+	env := setupTestEnvironment()
+	env.Register("host", func(args []*bones.Node, ev *Evaluator) (*bones.Node, error) { return bones.Nil, nil })
+	env.BindByName("x", bones.IntNode(1))
+	data := imageOf(t, env)
+
+	fresh := setupTestEnvironment()
+	_, err := loadImage(t, data, fresh)
+	if err == nil || err.Error() != "load image: no procedure named host" {
+		t.Errorf("expected a missing procedure error, got %v", err)
+	}
+	if _, err := fresh.LookupByName("x"); err == nil {
+		t.Error("expected a failed load to bind nothing")
+	}
+}
+
+func TestLoadImageRejectsEveryTruncation(t *testing.T) {
+	env := setupTestEnvironment()
+	if _, err := evalIn(t, New(engraving.StandardLogger, env), imageTestSource); err != nil {
+		t.Fatal(err)
+	}
+	data := imageOf(t, env, bones.IdentNode("root"))
+	for n := 0; n < len(data); n++ {
+		_, err := loadImage(t, data[:n], setupTestEnvironment())
+		if !errors.Is(err, ErrMalformedImage) {
+			t.Fatalf("expected truncation to %d of %d bytes to be rejected, got %v", n, len(data), err)
+		}
+	}
+	if !strings.HasPrefix(string(data), "GHI") {
+		t.Error("expected images to start with their magic")
+	}
+}
+
+func TestLoadImageSurvivesCorruption(t *testing.T) {
+	env := setupTestEnvironment()
+	if _, err := evalIn(t, New(engraving.StandardLogger, env), imageTestSource); err != nil {
+		t.Fatal(err)
+	}
+	data := imageOf(t, env)
+	_, call := p.Parse(strings.NewReader("(c 1) (deposit 1) (peek)"))
+	for i := len(imageMagic) + 1; i < len(data); i++ {
+		for _, b := range []byte{0x00, 0x01, 0x7f, 0xff} {
+			corrupt := append([]byte(nil), data...)
+			corrupt[i] = b
+			fresh := setupTestEnvironment()
+			if _, err := loadImage(t, corrupt, fresh); err != nil {
+				if !errors.Is(err, ErrMalformedImage) && !strings.HasPrefix(err.Error(), "load image: no ") {
+					t.Fatalf("byte %d set to %#x: unexpected error %v", i, b, err)
+				}
+				continue
+			}
+			// Whatever still loads must run without panicking.
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+			New(engraving.StandardLogger, fresh).EvaluateNode(ctx, call.Expressions)
+			cancel()
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package consume
+
+import (
+	"sync/atomic"
+
+	"github.com/archevel/ghoul/bones"
+)
+
+// inlineCache remembers the binding an OP_LOAD_VAR found and the
+// shapeVersion it was found at. The compiler gives every identifier it
+// emits its own constant, so caches indexed by constant are per
+// instruction.
+//
+// Assigning or redefining a name stores into its binding, which leaves
+// every cache holding the binding valid. Only a name added to a scope,
+// which may shadow the binding found, sends the next lookup through the
+// environment again. A hit costs one comparison however deep env is.
+type inlineCache struct {
+	entry atomic.Pointer[inlineCacheEntry]
+}
+
+type inlineCacheEntry struct {
+	env     *environment
+	version uint64
+	binding *binding
+}
+
+// lookupGlobal resolves the identifier at constants[idx] in env, through
+// the inline cache while no scope has gained a name since it was filled.
+func (co *CodeObject) lookupGlobal(idx uint16, env *environment) (*bones.Node, error) {
+	caches := co.caches.Load()
+	if caches == nil {
+		fresh := make([]inlineCache, len(co.Constants))
+		if !co.caches.CompareAndSwap(nil, &fresh) {
+			caches = co.caches.Load()
+		} else {
+			caches = &fresh
+		}
+	}
+	cache := &(*caches)[idx]
+	version := shapeVersion.Load()
+	if entry := cache.entry.Load(); entry != nil && entry.env == env && entry.version == version {
+		return entry.binding.val.Load(), nil
+	}
+
+	// version was read before looking up, so a name added during the
+	// lookup leaves the entry already stale.
+	b, err := lookupBinding(co.Constants[idx], env)
+	if err != nil {
+		return nil, err
+	}
+	cache.entry.Store(&inlineCacheEntry{env: env, version: version, binding: b})
+	return b.val.Load(), nil
+}
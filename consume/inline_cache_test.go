@@ -0,0 +1,176 @@
+package consume
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/archevel/ghoul/bones"
+	"github.com/archevel/ghoul/engraving"
+)
+
+func runSource(t *testing.T, ev *Evaluator, src string) *bones.Node {
+	t.Helper()
+	result, err := ev.ConsumeNodesWithContext(context.Background(), translateSource(t, src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestInlineCacheSeesRedefinition(t *testing.T) {
+	ev := New(engraving.StandardLogger, setupTestEnvironment())
+	runSource(t, ev, `(define f (lambda () 1)) (define g (lambda () (f)))`)
+	if got := runSource(t, ev, `(g) (g)`); got.IntVal != 1 {
+		t.Fatalf("expected 1, got %s", got.Repr())
+	}
+	if got := runSource(t, ev, `(define f (lambda () 2)) (g)`); got.IntVal != 2 {
+		t.Errorf("expected the redefined f, got %s", got.Repr())
+	}
+}
+
+func TestInlineCacheSeesAssignment(t *testing.T) {
+	ev := New(engraving.StandardLogger, pureTestEnvironment())
+	got := runSource(t, ev, `
+(define x 1)
+(define get (lambda () x))
+(define seen (get))
+(set! x 2)
+(list seen (get))`)
+	if got.Repr() != "(1 2)" {
+		t.Errorf("expected (1 2), got %s", got.Repr())
+	}
+}
+
+// cachedEntry returns the inline cache entry of the first OP_LOAD_VAR of
+// name in co, or nil.
+func cachedEntry(co *CodeObject, name string) *inlineCacheEntry {
+	caches := co.caches.Load()
+	if caches == nil {
+		return nil
+	}
+	for i, c := range co.Constants {
+		if c.Kind == bones.IdentifierNode && c.Name == name {
+			return (*caches)[i].entry.Load()
+		}
+	}
+	return nil
+}
+
+func TestInlineCacheSkipsLookupUntilAScopeChanges(t *testing.T) {
+	env := setupTestEnvironment()
+	ev := New(engraving.StandardLogger, env)
+	runSource(t, ev, `(define x 1) (define get (lambda () x)) (get)`)
+
+	// Bypass set so the shape version stays and the cache is trusted.
+	hidden := &binding{}
+	hidden.val.Store(bones.IntNode(99))
+	currentScope(env).mu.Lock()
+	old := currentScope(env).vars[keyFromName("x")]
+	currentScope(env).vars[keyFromName("x")] = hidden
+	currentScope(env).mu.Unlock()
+	if got := runSource(t, ev, `(get)`); got.IntVal != 1 {
+		t.Fatalf("expected the cached binding's 1, got %s", got.Repr())
+	}
+	old.val.Store(bones.IntNode(2))
+	if got := runSource(t, ev, `(get)`); got.IntVal != 2 {
+		t.Fatalf("expected the cached binding's new value 2, got %s", got.Repr())
+	}
+	runSource(t, ev, `(define y 0)`)
+	if got := runSource(t, ev, `(get)`); got.IntVal != 99 {
+		t.Errorf("expected 99 once a name was added to the scope, got %s", got.Repr())
+	}
+}
+
+func TestInlineCacheSurvivesAssignment(t *testing.T) {
+	ev := New(engraving.StandardLogger, setupTestEnvironment())
+	runSource(t, ev, `(define x 1) (define get (lambda () x)) (get)`)
+	get, err := ev.env.LookupByName("get")
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := get.ForeignVal.(*closureData).code
+	cached := cachedEntry(code, "x")
+	if cached == nil {
+		t.Fatal("expected the lookup of x to be cached")
+	}
+	if got := runSource(t, ev, `(set! x 2) (define x 3) (get)`); got.IntVal != 3 {
+		t.Fatalf("expected 3, got %s", got.Repr())
+	}
+	if cachedEntry(code, "x") != cached {
+		t.Error("expected assigning and redefining x to keep the cache entry")
+	}
+}
+
+func TestInlineCacheSeesShadowingDefinition(t *testing.T) {
+	ev := New(engraving.StandardLogger, pureTestEnvironment())
+	runSource(t, ev, `(define get (lambda () (list 1))) (get)`)
+	if got := runSource(t, ev, `(define list (lambda xs 'mine)) (get)`); got.Repr() != "mine" {
+		t.Errorf("expected the new top-level list to shadow the builtin, got %s", got.Repr())
+	}
+}
+
+func TestCallsKeepInlineCachesValid(t *testing.T) {
+	ev := New(engraving.StandardLogger, setupTestEnvironment())
+	runSource(t, ev, `(define id (lambda (a . rest) a))`)
+	code, err := Compile(translateSource(t, `(id 1 2) (id 3)`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ev.RunCode(context.Background(), code); err != nil {
+		t.Fatal(err)
+	}
+	entry := cachedEntry(code, "id")
+	version := shapeVersion.Load()
+	if _, err := ev.RunCode(context.Background(), code); err != nil {
+		t.Fatal(err)
+	}
+	if shapeVersion.Load() != version {
+		t.Error("calls changed the shape version")
+	}
+	if entry == nil || cachedEntry(code, "id") != entry {
+		t.Error("expected the cache entry of id to be kept")
+	}
+}
+
+func TestInlineCacheDoesNotCacheMisses(t *testing.T) {
+	ev := New(engraving.StandardLogger, setupTestEnvironment())
+	runSource(t, ev, `(define get (lambda () later))`)
+	if _, err := ev.ConsumeNodes(translateSource(t, `(get)`)); err == nil {
+		t.Fatal("expected an undefined identifier error")
+	}
+	if got := runSource(t, ev, `(define later 7) (get)`); got.IntVal != 7 {
+		t.Errorf("expected 7, got %s", got.Repr())
+	}
+}
+
+// BenchmarkInlineCacheHit reads a global bound in the outermost of depth
+// scopes, as code that also assigns it would. The stamp case is the cache
+// as it is, which assignments leave valid; the epoch case is the full
+// lookup the cache fell back to on every read while it checked a single
+// epoch that each set! bumped.
+func BenchmarkInlineCacheHit(b *testing.B) {
+	for _, depth := range []int{1, 8, 64} {
+		env := NewEnvironment()
+		bottomScope(env).set(keyFromName("x"), bones.IntNode(0))
+		for i := 1; i < depth; i++ {
+			env = newEnvWithEmptyScope(env)
+		}
+		code := &CodeObject{Constants: []*bones.Node{bones.IdentNode("x")}}
+
+		b.Run(fmt.Sprintf("stamp/depth=%d", depth), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := code.lookupGlobal(0, env); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("epoch/depth=%d", depth), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, err := lookupBinding(code.Constants[0], env); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
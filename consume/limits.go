@@ -0,0 +1,192 @@
+package consume
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"unsafe"
+
+	"github.com/archevel/ghoul/bones"
+)
+
+// Limits bounds the resources a single evaluation may use. Evaluations
+// started from inside a running one, such as Apply from a native function
+// or a nested RunCode, draw on the same budget. A zero field means no
+// limit.
+type Limits struct {
+	// Fuel is the number of bytecode instructions that may execute.
+	Fuel int64
+	// MaxDepth is the number of nested non-tail calls; tail calls reuse
+	// their frame and do not count.
+	MaxDepth int
+	// MaxStack is the number of values the VM stack may hold. It is
+	// checked as frames are entered.
+	MaxStack int
+	// MaxAlloc approximates, in bytes, the memory the evaluation may
+	// allocate for closures, cells, rest argument lists and the values
+	// native functions return.
+	MaxAlloc int64
+}
+
+// Limit names one of the fields of Limits, or the output cap a host puts
+// on the streams builtins write to.
+type Limit int
+
+const (
+	LimitFuel Limit = iota + 1
+	LimitDepth
+	LimitStack
+	LimitAlloc
+	LimitOutput
+)
+
+func (l Limit) String() string {
+	switch l {
+	case LimitFuel:
+		return "fuel"
+	case LimitDepth:
+		return "depth"
+	case LimitStack:
+		return "stack"
+	case LimitAlloc:
+		return "alloc"
+	case LimitOutput:
+		return "output"
+	}
+	return fmt.Sprintf("Limit(%d)", int(l))
+}
+
+// ErrLimitExceeded is matched by every LimitError.
+var ErrLimitExceeded = errors.New("limit exceeded")
+
+// LimitError is returned when an evaluation runs past one of its Limits.
+// It arrives wrapped in an EvaluationError; use errors.As to get it.
+type LimitError struct {
+	Limit Limit
+	Max   int64
+}
+
+func (err LimitError) Error() string {
+	switch err.Limit {
+	case LimitFuel:
+		return fmt.Sprintf("fuel limit exceeded: more than %d instructions", err.Max)
+	case LimitDepth:
+		return fmt.Sprintf("depth limit exceeded: more than %d nested calls", err.Max)
+	case LimitStack:
+		return fmt.Sprintf("stack limit exceeded: more than %d values", err.Max)
+	case LimitAlloc:
+		return fmt.Sprintf("alloc limit exceeded: more than %d bytes", err.Max)
+	case LimitOutput:
+		return fmt.Sprintf("output limit exceeded: more than %d bytes", err.Max)
+	}
+	return fmt.Sprintf("%s limit exceeded", err.Limit)
+}
+
+func (err LimitError) Is(target error) bool {
+	return target == ErrLimitExceeded
+}
+
+// SetLimits bounds every later evaluation. The zero Limits removes all
+// bounds.
+func (ev *Evaluator) SetLimits(limits Limits) {
+	ev.limits = limits
+}
+
+// Limits returns the bounds set with SetLimits.
+func (ev *Evaluator) Limits() Limits {
+	return ev.limits
+}
+
+// budget is what is left of an evaluation's Limits. Unlimited amounts are
+// math.MaxInt64, so the VM checks them without asking whether a limit is
+// set.
+type budget struct {
+	fuel  int64
+	alloc int64
+	depth int // frames of enclosing evaluations, counted against MaxDepth
+
+	// reserve is fuel held back from an Execution's step, which pauses
+	// when fuel runs out while any is left. rearm is set when a procedure
+	// called from Go ran on into it; see callNative.
+	reserve int64
+	rearm   bool
+}
+
+func newBudget(limits Limits) budget {
+	b := budget{fuel: math.MaxInt64, alloc: math.MaxInt64}
+	if limits.Fuel > 0 {
+		b.fuel = limits.Fuel
+	}
+	if limits.MaxAlloc > 0 {
+		b.alloc = limits.MaxAlloc
+	}
+	return b
+}
+
+// inheritBudget gives vm the budget of parent, the VM whose native call
+// started it. The returned func hands what is left back to parent.
+func (vm *VM) inheritBudget(parent *VM) func() {
+	vm.budget = parent.budget
+	vm.budget.depth += parent.fp + 1
+	return func() {
+		depth := parent.budget.depth
+		parent.budget = vm.budget
+		parent.budget.depth = depth
+	}
+}
+
+func (vm *VM) limitError(limit Limit) error {
+	l := vm.ev.limits
+	var max int64
+	switch limit {
+	case LimitFuel:
+		max = l.Fuel
+	case LimitDepth:
+		max = int64(l.MaxDepth)
+	case LimitStack:
+		max = int64(l.MaxStack)
+	case LimitAlloc:
+		max = l.MaxAlloc
+	}
+	return LimitError{Limit: limit, Max: max}
+}
+
+// charge takes n bytes from the allocation budget.
+func (vm *VM) charge(n int64) error {
+	vm.budget.alloc -= n
+	if vm.budget.alloc < 0 {
+		return vm.limitError(LimitAlloc)
+	}
+	return nil
+}
+
+const (
+	nodeBytes    = int64(unsafe.Sizeof(bones.Node{}))
+	closureBytes = nodeBytes + int64(unsafe.Sizeof(closureData{}))
+	upvalueBytes = int64(unsafe.Sizeof(upvalue{}))
+	cellBytes    = int64(unsafe.Sizeof(cell{}))
+	pointerBytes = int64(unsafe.Sizeof(uintptr(0)))
+	// symbolBytes is the interning table's entry for a name, besides the
+	// name itself.
+	symbolBytes = 4 * pointerBytes
+)
+
+// resultBytes approximates what a native function allocated to build
+// result: the node itself with its string and children, but not nodes it
+// shares with its arguments. A symbol, as string->symbol makes, is charged
+// for interning its name.
+func resultBytes(result *bones.Node, args []*bones.Node) int64 {
+	if result == nil {
+		return 0
+	}
+	for _, arg := range args {
+		if arg == result {
+			return 0
+		}
+	}
+	n := nodeBytes + int64(len(result.StrVal)) + int64(len(result.Children))*pointerBytes
+	if result.Kind == bones.IdentifierNode {
+		n += symbolBytes + int64(len(result.Name))
+	}
+	return n
+}
@@ -0,0 +1,50 @@
+package consume
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/archevel/ghoul/bones"
+	"github.com/archevel/ghoul/engraving"
+)
+
+func TestFuelIsSharedWithNativeCallbacks(t *testing.T) {
+	env := pureTestEnvironment()
+	env.Register("call-often", func(args []*bones.Node, ev *Evaluator) (*bones.Node, error) {
+		for i := 0; i < 1000; i++ {
+			if _, err := ev.Apply(ev.Context(), args[0], nil); err != nil {
+				return nil, err
+			}
+		}
+		return bones.Nil, nil
+	})
+	ev := New(engraving.StandardLogger, env)
+	ev.SetLimits(Limits{Fuel: 2000})
+
+	_, err := ev.ConsumeNodesWithContext(context.Background(), translateSource(t, `(call-often (lambda () 1))`))
+	var limitErr LimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != LimitFuel || limitErr.Max != 2000 {
+		t.Fatalf("expected the callbacks to run out of fuel, got %v", err)
+	}
+	if ev.active != nil {
+		t.Error("expected no VM to be left active")
+	}
+}
+
+func TestDepthCountsFramesOfEnclosingEvaluations(t *testing.T) {
+	env := pureTestEnvironment()
+	env.Register("call", func(args []*bones.Node, ev *Evaluator) (*bones.Node, error) {
+		return ev.Apply(ev.Context(), args[0], args[1:])
+	})
+	ev := New(engraving.StandardLogger, env)
+	ev.SetLimits(Limits{MaxDepth: 50})
+
+	_, err := ev.ConsumeNodesWithContext(context.Background(), translateSource(t, `(define down (lambda (n) (add 1 (call down n)))) (down 0)`))
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("expected the depth limit to hold across native calls, got %v", err)
+	}
+	if got := runSource(t, ev, `(add 1 2)`); got.IntVal != 3 {
+		t.Errorf("expected the evaluator to be usable after the limit error, got %s", got.Repr())
+	}
+}
@@ -0,0 +1,73 @@
+package consume
+
+import (
+	"encoding/binary"
+	"slices"
+	"unique"
+)
+
+// marksID is the interned ID of a set of hygiene marks, so scope keys
+// compare sets as cheaply as pointers. The empty set is the zero marksID,
+// so unmarked identifiers need no interning. Like symbols, mark sets are
+// interned weakly: the garbage collector reclaims a set once no key holds
+// it, however many expansions made sets before. Every expansion makes a
+// new mark, so a counter-assigned integer per set, which could never be
+// given back, would grow without bound in a long-running process.
+type marksID struct {
+	h unique.Handle[string] // the marks, ascending, as 8 bytes each
+}
+
+// empty reports whether id is the empty set.
+func (id marksID) empty() bool {
+	return id == marksID{}
+}
+
+func internMarks(marks map[uint64]bool) marksID {
+	if len(marks) == 0 {
+		return marksID{}
+	}
+	var buf [8]uint64
+	sorted := buf[:0]
+	for m := range marks {
+		sorted = append(sorted, m)
+	}
+	slices.Sort(sorted)
+	return internSortedMarks(sorted)
+}
+
+func internSortedMarks(sorted []uint64) marksID {
+	if len(sorted) == 0 {
+		return marksID{}
+	}
+	var buf [64]byte
+	b := buf[:0]
+	for _, m := range sorted {
+		b = binary.BigEndian.AppendUint64(b, m)
+	}
+	return marksID{unique.Make(string(b))}
+}
+
+// outerMarks returns id without its newest mark.
+func outerMarks(id marksID) marksID {
+	if id.empty() {
+		return id
+	}
+	s := id.h.Value()
+	if len(s) <= 8 {
+		return marksID{}
+	}
+	return marksID{unique.Make(s[:len(s)-8])}
+}
+
+// marksOf returns the marks in id, ascending.
+func marksOf(id marksID) []uint64 {
+	if id.empty() {
+		return nil
+	}
+	s := id.h.Value()
+	marks := make([]uint64, len(s)/8)
+	for i := range marks {
+		marks[i] = binary.BigEndian.Uint64([]byte(s[8*i : 8*i+8]))
+	}
+	return marks
+}
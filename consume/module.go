@@ -1,8 +1,11 @@
 package consume
 
 import (
+	"errors"
 	"fmt"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
 
@@ -17,6 +20,8 @@ type ModuleExports struct {
 
 type ModuleState struct {
 	currentFile string
+	searchPaths []string // searched after the requiring file's directory
+	fsys        fs.FS    // where modules are read from; nil means the OS
 	loading     []string
 	loadingSet  map[string]bool
 	loaded      map[string]*ModuleExports
@@ -59,19 +64,89 @@ func (ms *ModuleState) GetCached(path string) *ModuleExports {
 	return ms.loaded[path]
 }
 
+// SetSearchPaths sets the directories ResolveFile searches, in order,
+// when the requiring file's own directory has no such module. They also
+// let code without a file context, such as the REPL, require modules.
+func (ms *ModuleState) SetSearchPaths(dirs []string) {
+	ms.searchPaths = dirs
+}
+
+// SetFS makes ResolveFile and ReadFile look in fsys instead of the OS
+// file system. Paths are then slash-separated and relative to its root,
+// which is searched last; module names that would leave it are not found.
+func (ms *ModuleState) SetFS(fsys fs.FS) {
+	ms.fsys = fsys
+}
+
 func (ms *ModuleState) ResolveFile(name string) (string, error) {
-	if ms.currentFile == "" {
+	var dirs []string
+	if ms.currentFile != "" {
+		if dir := ms.dir(ms.currentFile); ms.fsys == nil || fs.ValidPath(dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	dirs = append(dirs, ms.searchPaths...)
+	if ms.fsys != nil {
+		dirs = append(dirs, ".")
+	}
+	if len(dirs) == 0 {
 		return "", fmt.Errorf("cannot require Ghoul modules from REPL (no file context)")
 	}
 
-	dir := filepath.Dir(ms.currentFile)
-	path := filepath.Join(dir, name+".ghl")
+	var searched []string
+	for _, dir := range dirs {
+		path, found := ms.resolveIn(dir, name)
+		if found {
+			return path, nil
+		}
+		searched = append(searched, path)
+	}
+	return "", fmt.Errorf("module not found: %s (searched for %s)", name, strings.Join(searched, ", "))
+}
+
+// ReadFile reads a module ResolveFile found.
+func (ms *ModuleState) ReadFile(name string) ([]byte, error) {
+	if ms.fsys != nil {
+		return fs.ReadFile(ms.fsys, name)
+	}
+	return os.ReadFile(name)
+}
+
+func (ms *ModuleState) dir(file string) string {
+	if ms.fsys != nil {
+		return path.Dir(filepath.ToSlash(file))
+	}
+	return filepath.Dir(file)
+}
 
-	if _, err := os.Stat(path); os.IsNotExist(err) {
-		return "", fmt.Errorf("module not found: %s (searched for %s)", name, path)
+func (ms *ModuleState) stat(name string) (fs.FileInfo, error) {
+	if ms.fsys != nil {
+		if !fs.ValidPath(name) {
+			return nil, fs.ErrNotExist
+		}
+		return fs.Stat(ms.fsys, name)
 	}
+	return os.Stat(name)
+}
+
+// resolveIn looks for the module name in dir, returning the path of its
+// source when neither it nor a compiled version exists.
+func (ms *ModuleState) resolveIn(dir, name string) (string, bool) {
+	join := filepath.Join
+	if ms.fsys != nil {
+		join = path.Join
+	}
+	src := join(dir, name+".ghl")
+	compiled := join(dir, name+".ghc")
 
-	return path, nil
+	srcInfo, srcErr := ms.stat(src)
+	if ghc, err := ms.stat(compiled); err == nil {
+		// A compiled module older than its source is stale.
+		if srcErr != nil || !ghc.ModTime().Before(srcInfo.ModTime()) {
+			return compiled, true
+		}
+	}
+	return src, !errors.Is(srcErr, fs.ErrNotExist)
 }
 
 // ForChild creates a new ModuleState for evaluating a child module,
@@ -79,6 +154,8 @@ func (ms *ModuleState) ResolveFile(name string) (string, error) {
 func (ms *ModuleState) ForChild(childFile string) *ModuleState {
 	return &ModuleState{
 		currentFile: childFile,
+		searchPaths: ms.searchPaths,
+		fsys:        ms.fsys,
 		loading:     ms.loading,
 		loadingSet:  ms.loadingSet,
 		loaded:      ms.loaded,
@@ -5,6 +5,8 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
 
 	e "github.com/archevel/ghoul/bones"
 )
@@ -74,6 +76,38 @@ func TestModuleStateResolveFile(t *testing.T) {
 	}
 }
 
+func TestModuleStateResolveFilePrefersCompiled(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "utils.ghl"), []byte("(define x 1)"), 0644)
+	os.WriteFile(filepath.Join(dir, "utils.ghc"), []byte("GHC"), 0644)
+
+	ms := NewModuleState(filepath.Join(dir, "main.ghl"))
+	path, err := ms.ResolveFile("utils")
+	if err != nil {
+		t.Fatalf("expected to resolve utils, got: %v", err)
+	}
+	if !strings.HasSuffix(path, "utils.ghc") {
+		t.Errorf("expected path ending in utils.ghc, got: %s", path)
+	}
+}
+
+func TestModuleStateResolveFileSkipsStaleCompiled(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "utils.ghc"), []byte("GHC"), 0644)
+	os.WriteFile(filepath.Join(dir, "utils.ghl"), []byte("(define x 1)"), 0644)
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes(filepath.Join(dir, "utils.ghc"), old, old)
+
+	ms := NewModuleState(filepath.Join(dir, "main.ghl"))
+	path, err := ms.ResolveFile("utils")
+	if err != nil {
+		t.Fatalf("expected to resolve utils, got: %v", err)
+	}
+	if !strings.HasSuffix(path, "utils.ghl") {
+		t.Errorf("expected the newer source to win, got: %s", path)
+	}
+}
+
 func TestModuleStateResolveFileMissing(t *testing.T) {
 	dir := t.TempDir()
 	ms := NewModuleState(filepath.Join(dir, "main.ghl"))
@@ -90,3 +124,71 @@ func TestModuleStateResolveFileNoCurrentFile(t *testing.T) {
 		t.Fatal("expected error when no current file (REPL mode)")
 	}
 }
+
+func TestModuleStateResolveFileSearchesPathsInOrder(t *testing.T) {
+	own, first, second := t.TempDir(), t.TempDir(), t.TempDir()
+	os.WriteFile(filepath.Join(first, "utils.ghl"), []byte("(define x 1)"), 0644)
+	os.WriteFile(filepath.Join(second, "utils.ghl"), []byte("(define x 2)"), 0644)
+	os.WriteFile(filepath.Join(own, "local.ghl"), []byte("(define x 3)"), 0644)
+	os.WriteFile(filepath.Join(second, "local.ghl"), []byte("(define x 4)"), 0644)
+
+	ms := NewModuleState(filepath.Join(own, "main.ghl"))
+	ms.SetSearchPaths([]string{first, second})
+	if path, err := ms.ResolveFile("utils"); err != nil || path != filepath.Join(first, "utils.ghl") {
+		t.Errorf("expected the first search path to win, got %s, %v", path, err)
+	}
+	if path, err := ms.ResolveFile("local"); err != nil || path != filepath.Join(own, "local.ghl") {
+		t.Errorf("expected the requiring file's directory to win, got %s, %v", path, err)
+	}
+	if child, err := ms.ForChild(filepath.Join(own, "local.ghl")).ResolveFile("utils"); err != nil || child != filepath.Join(first, "utils.ghl") {
+		t.Errorf("expected child modules to keep the search paths, got %s, %v", child, err)
+	}
+	if _, err := ms.ResolveFile("missing"); err == nil || !strings.Contains(err.Error(), filepath.Join(second, "missing.ghl")) {
+		t.Errorf("expected the error to list every place searched, got %v", err)
+	}
+}
+
+func TestModuleStateResolveFileWithoutCurrentFileUsesSearchPaths(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "utils.ghl"), []byte("(define x 1)"), 0644)
+	ms := NewModuleState("")
+	ms.SetSearchPaths([]string{dir})
+	if _, err := ms.ResolveFile("utils"); err != nil {
+		t.Errorf("expected the search path to be used from the REPL, got %v", err)
+	}
+}
+
+func TestModuleStateResolvesAndReadsFromAnFS(t *testing.T) {
+	fsys := fstest.MapFS{
+		"lib/utils.ghl":  {Data: []byte("(define x 1)")},
+		"top.ghl":        {Data: []byte("(define x 2)")},
+		"lib/cached.ghl": {Data: []byte("(define x 3)"), ModTime: time.Unix(1, 0)},
+		"lib/cached.ghc": {Data: []byte("compiled"), ModTime: time.Unix(2, 0)},
+	}
+	ms := NewModuleState("lib/main.ghl")
+	ms.SetFS(fsys)
+
+	if path, err := ms.ResolveFile("utils"); err != nil || path != "lib/utils.ghl" {
+		t.Errorf("expected lib/utils.ghl, got %s, %v", path, err)
+	}
+	if path, err := ms.ResolveFile("top"); err != nil || path != "top.ghl" {
+		t.Errorf("expected the root of the FS to be searched last, got %s, %v", path, err)
+	}
+	if path, err := ms.ResolveFile("cached"); err != nil || path != "lib/cached.ghc" {
+		t.Errorf("expected the compiled module, got %s, %v", path, err)
+	}
+	if src, err := ms.ReadFile("top.ghl"); err != nil || string(src) != "(define x 2)" {
+		t.Errorf("expected to read top.ghl from the FS, got %q, %v", src, err)
+	}
+
+	for _, name := range []string{"../secret", "/etc/passwd", "missing"} {
+		if _, err := ms.ResolveFile(name); err == nil {
+			t.Errorf("expected %s not to be found", name)
+		}
+	}
+	outside := NewModuleState("/abs/host/main.ghl")
+	outside.SetFS(fsys)
+	if path, err := outside.ResolveFile("top"); err != nil || path != "top.ghl" {
+		t.Errorf("expected a file outside the FS to search its root, got %s, %v", path, err)
+	}
+}
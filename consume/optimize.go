@@ -0,0 +1,308 @@
+package consume
+
+import "github.com/archevel/ghoul/bones"
+
+// Compile compiles top-level nodes for this evaluator. Unless turned off
+// with SetOptimize, the nodes are optimized first and the emitted code
+// goes through a peephole pass:
+//
+//   - calls to pure builtins on constant arguments are folded, provided the
+//     name still refers to the builtin and no set! can change it: neither
+//     one in the unit nor one in code already run in the environment,
+//     which may sit in a procedure the unit calls. Only code outside
+//     lambda bodies is folded, as a procedure may be called after a later
+//     unit defines the name again;
+//   - cond clauses whose test is a constant are dropped or become the
+//     else clause;
+//   - push/pop pairs, jumps to jumps or to the next instruction, and
+//     unreachable code are removed from the bytecode.
+//
+// Lambdas applied on the spot, such as let, are compiled in place either
+// way: that is how the compiler gives local variables their slots, not
+// an optimization SetOptimize turns off.
+func (ev *Evaluator) Compile(nodes []*bones.Node) (*CodeObject, error) {
+	if ev.noOptimize {
+		return compileTopLevel(nodes)
+	}
+	code, err := compileTopLevel(optimizeNodes(nodes, ev))
+	if err != nil {
+		return nil, err
+	}
+	peephole(code)
+	return code, nil
+}
+
+// SetOptimize turns the optimizer on or off. It is on by default.
+func (ev *Evaluator) SetOptimize(enabled bool) {
+	ev.noOptimize = !enabled
+}
+
+// Optimizing reports whether Compile optimizes.
+func (ev *Evaluator) Optimizing() bool {
+	return !ev.noOptimize
+}
+
+type optimizer struct {
+	ev *Evaluator
+	// bound holds every name the unit defines, assigns or takes as a
+	// parameter. Calls through those names are never folded.
+	bound map[string]bool
+	// lambdas counts the lambda bodies the optimizer is inside of.
+	lambdas int
+}
+
+func optimizeNodes(nodes []*bones.Node, ev *Evaluator) []*bones.Node {
+	o := &optimizer{ev: ev, bound: map[string]bool{}}
+	for _, n := range nodes {
+		collectBound(n, o.bound)
+	}
+	out := make([]*bones.Node, len(nodes))
+	for i, n := range nodes {
+		out[i] = o.expr(n)
+	}
+	return out
+}
+
+func collectBound(n *bones.Node, bound map[string]bool) {
+	walkCode(n, func(n *bones.Node) {
+		switch n.Kind {
+		case bones.DefineNode, bones.SetNode:
+			bound[n.Children[0].IdentName()] = true
+		case bones.LambdaNode:
+			if n.Params != nil {
+				for _, p := range n.Params.Fixed {
+					bound[p.IdentName()] = true
+				}
+				if n.Params.Variadic != nil {
+					bound[n.Params.Variadic.IdentName()] = true
+				}
+			}
+		}
+	})
+}
+
+// noteAssignments records on the bottom scope of env every global that
+// code, or a closure made by it, assigns with set!. Code goes through here
+// before it first runs in env, so a unit compiled later does not fold a
+// call through a name that an earlier unit's procedure may still change.
+func noteAssignments(code *CodeObject, env *environment) {
+	assigned := &bottomScope(env).assigned
+	for _, in := range decodeCode(code) {
+		if in.op == OP_SET && in.operand < len(code.Constants) {
+			assigned.Store(code.Constants[in.operand].IdentName(), true)
+		}
+	}
+	for _, c := range code.Constants {
+		if child, ok := c.ForeignVal.(*CodeObject); ok && c.Kind == bones.ForeignNode {
+			noteAssignments(child, env)
+		}
+	}
+}
+
+// mayAssign reports whether code run in env may assign name with set!.
+func mayAssign(env *environment, name string) bool {
+	_, ok := bottomScope(env).assigned.Load(name)
+	return ok
+}
+
+// walkCode calls visit on n and every node of code below it. Quoted data
+// and runtime values are not code and are not entered.
+func walkCode(n *bones.Node, visit func(*bones.Node)) {
+	visit(n)
+	switch n.Kind {
+	case bones.CallNode, bones.BeginNode, bones.LambdaNode, bones.DefineNode, bones.SetNode:
+		for _, c := range n.Children {
+			walkCode(c, visit)
+		}
+	case bones.CondNode:
+		for _, cl := range n.Clauses {
+			if !cl.IsElse {
+				walkCode(cl.Test, visit)
+			}
+			for _, c := range cl.Consequent {
+				walkCode(c, visit)
+			}
+		}
+	}
+}
+
+// expr returns an optimized copy of n; n itself is left untouched.
+func (o *optimizer) expr(n *bones.Node) *bones.Node {
+	switch n.Kind {
+	case bones.CallNode:
+		return o.call(n)
+	case bones.LambdaNode:
+		return o.lambda(n)
+	case bones.DefineNode, bones.SetNode:
+		out := *n
+		out.Children = []*bones.Node{n.Children[0], o.expr(n.Children[1])}
+		return &out
+	case bones.BeginNode:
+		out := *n
+		out.Children = o.exprs(n.Children)
+		return &out
+	case bones.CondNode:
+		return o.cond(n)
+	}
+	return n
+}
+
+func (o *optimizer) exprs(nodes []*bones.Node) []*bones.Node {
+	out := make([]*bones.Node, len(nodes))
+	for i, n := range nodes {
+		out[i] = o.expr(n)
+	}
+	return out
+}
+
+func (o *optimizer) lambda(n *bones.Node) *bones.Node {
+	out := *n
+	o.lambdas++
+	out.Children = o.exprs(n.Children)
+	o.lambdas--
+	return &out
+}
+
+// countDefines counts the defines that get a slot in the lambda whose
+// body n belongs to.
+func countDefines(n *bones.Node) int {
+	count := 0
+	var visit func(*bones.Node)
+	visit = func(n *bones.Node) {
+		if n.Kind == bones.LambdaNode {
+			return
+		}
+		if n.Kind == bones.DefineNode {
+			count++
+		}
+		switch n.Kind {
+		case bones.CallNode, bones.BeginNode, bones.DefineNode, bones.SetNode:
+			for _, c := range n.Children {
+				visit(c)
+			}
+		case bones.CondNode:
+			for _, cl := range n.Clauses {
+				if !cl.IsElse {
+					visit(cl.Test)
+				}
+				for _, c := range cl.Consequent {
+					visit(c)
+				}
+			}
+		}
+	}
+	visit(n)
+	return count
+}
+
+func (o *optimizer) call(n *bones.Node) *bones.Node {
+	out := *n
+	out.Children = o.exprs(n.Children)
+	if folded := o.fold(&out); folded != nil {
+		return folded
+	}
+	return &out
+}
+
+// fold evaluates a call to a pure builtin on constant arguments. It gives
+// up, leaving the call for run time, when the builtin fails.
+func (o *optimizer) fold(n *bones.Node) (result *bones.Node) {
+	callee := n.Children[0]
+	if o.lambdas > 0 || callee.Kind != bones.IdentifierNode || o.bound[callee.Name] || mayAssign(o.ev.env, callee.Name) {
+		return nil
+	}
+	for _, arg := range n.Children[1:] {
+		if !isLiteral(arg) {
+			return nil
+		}
+	}
+	f, err := lookupNode(callee, o.ev.env)
+	if err != nil || !isPureBuiltin(f) {
+		return nil
+	}
+	defer func() {
+		if recover() != nil {
+			result = nil
+		}
+	}()
+	v, err := (*f.FuncVal)(n.Children[1:], o.ev)
+	if err != nil || !isLiteral(v) {
+		return nil
+	}
+	return v
+}
+
+func isLiteral(n *bones.Node) bool {
+	switch n.Kind {
+	case bones.IntegerNode, bones.FloatNodeKind, bones.StringNode, bones.BooleanNode:
+		return true
+	}
+	return false
+}
+
+// constantTruth reports whether n is a constant and, if so, whether it
+// counts as true in a cond test.
+func constantTruth(n *bones.Node) (truthy, constant bool) {
+	switch n.Kind {
+	case bones.NilNode:
+		return false, true
+	case bones.BooleanNode:
+		return n.BoolVal, true
+	case bones.IntegerNode, bones.FloatNodeKind, bones.StringNode:
+		return true, true
+	case bones.QuoteNode:
+		return n.Quoted != nil && !n.Quoted.IsNil(), true
+	}
+	return false, false
+}
+
+func (o *optimizer) cond(n *bones.Node) *bones.Node {
+	if countDefines(n) > 0 {
+		// Dropping a clause would drop the slot of a define in it and
+		// change what later uses of the name resolve to.
+		out := *n
+		out.Clauses = make([]*bones.CondClause, len(n.Clauses))
+		for i, cl := range n.Clauses {
+			c := *cl
+			if !cl.IsElse {
+				c.Test = o.expr(cl.Test)
+			}
+			c.Consequent = o.exprs(cl.Consequent)
+			out.Clauses[i] = &c
+		}
+		return &out
+	}
+
+	var clauses []*bones.CondClause
+	for _, cl := range n.Clauses {
+		if cl.IsElse {
+			clauses = append(clauses, &bones.CondClause{IsElse: true, Consequent: o.exprs(cl.Consequent)})
+			break
+		}
+		test := o.expr(cl.Test)
+		truthy, constant := constantTruth(test)
+		if constant && !truthy {
+			continue
+		}
+		body := o.exprs(cl.Consequent)
+		if constant {
+			// Nothing after a clause that always matches can run.
+			clauses = append(clauses, &bones.CondClause{IsElse: true, Consequent: body})
+			break
+		}
+		clauses = append(clauses, &bones.CondClause{Test: test, Consequent: body})
+	}
+
+	if len(clauses) == 0 {
+		return bones.Nil
+	}
+	if clauses[0].IsElse {
+		if len(clauses[0].Consequent) == 0 {
+			return bones.Nil
+		}
+		return &bones.Node{Kind: bones.BeginNode, Children: clauses[0].Consequent, Loc: n.Loc}
+	}
+	out := *n
+	out.Clauses = clauses
+	return &out
+}
@@ -0,0 +1,256 @@
+package consume
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/archevel/ghoul/bones"
+	"github.com/archevel/ghoul/engraving"
+)
+
+// pureTestEnvironment extends the test builtins with pure functions the
+// optimizer may fold.
+func pureTestEnvironment() *environment {
+	env := setupTestEnvironment()
+	env.RegisterPure("add", func(args []*bones.Node, ev *Evaluator) (*bones.Node, error) {
+		sum := int64(0)
+		for _, a := range args {
+			if a.Kind != bones.IntegerNode {
+				return nil, fmt.Errorf("add: expected integer, got %s", bones.NodeTypeName(a))
+			}
+			sum += a.IntVal
+		}
+		return bones.IntNode(sum), nil
+	})
+	env.RegisterPure("not", func(args []*bones.Node, ev *Evaluator) (*bones.Node, error) {
+		return bones.BoolNode(!vmTruthy(args[0])), nil
+	})
+	env.RegisterPure("first", func(args []*bones.Node, ev *Evaluator) (*bones.Node, error) {
+		return args[0], nil
+	})
+	env.Register("list", func(args []*bones.Node, ev *Evaluator) (*bones.Node, error) {
+		return bones.NewListNode(args), nil
+	})
+	return env
+}
+
+func optimizeSource(t *testing.T, ev *Evaluator, src string) *CodeObject {
+	t.Helper()
+	code, err := ev.Compile(translateSource(t, src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Verify(code); err != nil {
+		t.Fatalf("optimized code does not verify: %v\n%s", err, DisassembleString(code))
+	}
+	return code
+}
+
+func hasOpcode(co *CodeObject, op byte) bool {
+	for _, in := range decodeCode(co) {
+		if in.op == op {
+			return true
+		}
+	}
+	return false
+}
+
+func closureCode(t *testing.T, co *CodeObject) *CodeObject {
+	t.Helper()
+	for _, c := range co.Constants {
+		if child, ok := c.ForeignVal.(*CodeObject); ok {
+			return child
+		}
+	}
+	t.Fatalf("no closure in:\n%s", DisassembleString(co))
+	return nil
+}
+
+// runOptimizedAndPlain runs src with and without the optimizer and
+// returns what each printed: the result or the error.
+func runOptimizedAndPlain(t *testing.T, src string) (string, string) {
+	t.Helper()
+	run := func(optimize bool) string {
+		ev := New(engraving.StandardLogger, pureTestEnvironment())
+		ev.SetOptimize(optimize)
+		result, err := ev.ConsumeNodesWithContext(context.Background(), translateSource(t, src))
+		if err != nil {
+			return "error: " + err.Error()
+		}
+		return result.Repr()
+	}
+	return run(true), run(false)
+}
+
+func TestOptimizerPreservesSemantics(t *testing.T) {
+	programs := []string{
+		`(add 1 2 (add 3 4))`,
+		`(not (add 1 2))`,
+		`(add 1 "two")`,
+		`(cond (#f 1) ((not #t) 2) ((add 0 1) 3) (else 4))`,
+		`(cond ((< 1 2) 'yes))`,
+		`(cond (#f 'never))`,
+		`(cond ('() 1) (else 2))`,
+		`(define not (lambda (x) 'mine)) (not #t)`,
+		`((lambda (add) (add 1 2)) list)`,
+		`(define f (lambda (x) ((lambda (y z) (list x y z)) (+ x 1) (* x 2)))) (f 5)`,
+		`(define f (lambda (x) ((lambda (x) ((lambda (x) (+ x 1)) (* x 2))) (+ x 1)))) (f 5)`,
+		`(define f (lambda (a b) ((lambda (a b) (list a b)) b a))) (f 1 2)`,
+		`(define f (lambda (n) ((lambda (k) (set! k (+ k n)) k) 10))) (f 5)`,
+		`(define f (lambda (n) ((lambda (g) (g n)) (lambda (m) (* m m))))) (f 7)`,
+		`(define f (lambda (n) ((lambda (k) (lambda () k)) n))) ((f 3))`,
+		`(define f (lambda (n) ((lambda (k) (define j (+ k 1)) j) n))) (f 3)`,
+		`(define f (lambda (n) ((lambda (k . rest) (list k rest)) n 1 2))) (f 3)`,
+		`(define f (lambda (n) ((lambda (k) k)))) (f 3)`,
+		`(define f (lambda (n) ((lambda () n)))) (f 3)`,
+		`(define f (lambda (n) ((lambda (+) (+ n 1)) -))) (f 3)`,
+		`(define f (lambda (n) (cond (#f (define q 1)) (else n)))) (f 3)`,
+		`(define loop (lambda (i acc) (cond ((< i 1) acc) (else ((lambda (j) (loop (- i 1) (+ acc j))) i))))) (loop 100 0)`,
+		`(define f (lambda (x) 1 'two "three" x)) (f 4)`,
+	}
+	for _, src := range programs {
+		optimized, plain := runOptimizedAndPlain(t, src)
+		if optimized != plain {
+			t.Errorf("%s\noptimized: %s\nplain:     %s", src, optimized, plain)
+		}
+	}
+}
+
+func TestOptimizerFoldsPureBuiltins(t *testing.T) {
+	ev := New(engraving.StandardLogger, pureTestEnvironment())
+	code := optimizeSource(t, ev, `(add 1 2 (add 3 4))`)
+	if len(code.Constants) != 1 || !code.Constants[0].Equiv(bones.IntNode(10)) || hasOpcode(code, OP_CALL) {
+		t.Errorf("expected a single constant 10:\n%s", DisassembleString(code))
+	}
+}
+
+func TestOptimizerLeavesFailingCallsForRunTime(t *testing.T) {
+	ev := New(engraving.StandardLogger, pureTestEnvironment())
+	code := optimizeSource(t, ev, `(add 1 "two")`)
+	if !hasOpcode(code, OP_TAIL_CALL) {
+		t.Errorf("expected the call to stay:\n%s", DisassembleString(code))
+	}
+	_, err := ev.RunCode(context.Background(), code)
+	if err == nil || !strings.HasPrefix(err.Error(), "crypt.ghl:1:2: add: expected integer") {
+		t.Errorf("expected the run-time error at the call, got %v", err)
+	}
+}
+
+func TestOptimizerSurvivesPanickingBuiltins(t *testing.T) {
+	ev := New(engraving.StandardLogger, pureTestEnvironment())
+	code := optimizeSource(t, ev, `(first)`)
+	if !hasOpcode(code, OP_TAIL_CALL) {
+		t.Errorf("expected the call to stay:\n%s", DisassembleString(code))
+	}
+}
+
+func TestOptimizerDoesNotFoldReboundNames(t *testing.T) {
+	sources := map[string]string{
+		"defined in the unit":   `(define not (lambda (x) x)) (not #t)`,
+		"assigned in the unit":  `(set! not (lambda (x) x)) (not #t)`,
+		"shadowed by parameter": `(lambda (not) (not #t))`,
+	}
+	for name, src := range sources {
+		t.Run(name, func(t *testing.T) {
+			ev := New(engraving.StandardLogger, pureTestEnvironment())
+			code := optimizeSource(t, ev, src)
+			if hasOpcode(code, OP_FALSE) || (hasOpcode(code, OP_MAKE_CLOSURE) && hasOpcode(closureCode(t, code), OP_FALSE)) {
+				t.Errorf("expected (not #t) to stay a call:\n%s", DisassembleString(code))
+			}
+		})
+	}
+
+	t.Run("rebound earlier", func(t *testing.T) {
+		ev := New(engraving.StandardLogger, pureTestEnvironment())
+		if _, err := ev.ConsumeNodes(translateSource(t, `(define not (lambda (x) 'mine))`)); err != nil {
+			t.Fatal(err)
+		}
+		result, err := ev.ConsumeNodes(translateSource(t, `(not #t)`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Repr() != "mine" {
+			t.Errorf("expected the rebound not to run, got %s", result.Repr())
+		}
+	})
+}
+
+func TestOptimizerDoesNotFoldInsideProcedures(t *testing.T) {
+	ev := New(engraving.StandardLogger, pureTestEnvironment())
+	runSource(t, ev, `(define check (lambda () (not #t)))`)
+	runSource(t, ev, `(define not (lambda (x) 'mine))`)
+	if got := runSource(t, ev, `(check)`); got.Repr() != "mine" {
+		t.Errorf("expected a procedure compiled earlier to call the rebound not, got %s", got.Repr())
+	}
+}
+
+func TestOptimizerDoesNotFoldNamesAnEarlierProcedureAssigns(t *testing.T) {
+	ev := New(engraving.StandardLogger, pureTestEnvironment())
+	runSource(t, ev, `(define evil (lambda () (set! not (lambda (x) 'mine))))`)
+	if got := runSource(t, ev, `(evil) (not #t)`); got.Repr() != "mine" {
+		t.Errorf("expected the not assigned by evil to run, got %s", got.Repr())
+	}
+}
+
+func TestOptimizerPrunesConstantCondClauses(t *testing.T) {
+	ev := New(engraving.StandardLogger, pureTestEnvironment())
+	code := optimizeSource(t, ev, `(cond (#f 1) ((not #t) 2) (#t 3) (else 4))`)
+	if hasOpcode(code, OP_JUMP) || hasOpcode(code, OP_JUMP_IF_FALSE) || len(code.Constants) != 1 || !code.Constants[0].Equiv(bones.IntNode(3)) {
+		t.Errorf("expected only the constant 3:\n%s", DisassembleString(code))
+	}
+}
+
+func TestOptimizerCanBeTurnedOff(t *testing.T) {
+	src := `(define f (lambda (x) ((lambda (y) (add y 1 2)) x))) (cond (#t (f 1)))`
+	ev := New(engraving.StandardLogger, pureTestEnvironment())
+	ev.SetOptimize(false)
+	if ev.Optimizing() {
+		t.Error("expected Optimizing to report false")
+	}
+	code := optimizeSource(t, ev, src)
+	if got, want := DisassembleString(code), DisassembleString(compileSource(t, src)); got != want {
+		t.Errorf("expected unoptimized code:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestPeepholeRemovesConstantsPushedForNothing(t *testing.T) {
+	ev := New(engraving.StandardLogger, pureTestEnvironment())
+	code := optimizeSource(t, ev, `(begin 1 "two" (lambda () 3) 4)`)
+	if hasOpcode(code, OP_POP) || hasOpcode(code, OP_MAKE_CLOSURE) || len(decodeCode(code)) != 2 {
+		t.Errorf("expected just the final constant and a return:\n%s", DisassembleString(code))
+	}
+}
+
+func TestPeepholeThreadsJumpsAndDropsDeadCode(t *testing.T) {
+	loc := &bones.SourcePosition{Ln: 2, Col: 1}
+	code := &CodeObject{
+		Name: "top-level",
+		Code: []byte{
+			OP_LOAD_VAR, 0, 0, // 0
+			OP_JUMP_IF_FALSE, 0, 10, // 3
+			OP_TRUE,        // 6
+			OP_JUMP, 0, 14, // 7
+			OP_FALSE,       // 10
+			OP_JUMP, 0, 14, // 11: to the next instruction
+			OP_JUMP, 0, 18, // 14
+			OP_NIL,    // 17: unreachable
+			OP_RETURN, // 18
+		},
+		Constants: []*bones.Node{bones.IdentNode("x")},
+		Locs:      []LocEntry{{StartPC: 0, Loc: &bones.SourcePosition{Ln: 1, Col: 1}}, {StartPC: 10, Loc: loc}},
+	}
+	peephole(code)
+
+	want := []byte{OP_LOAD_VAR, 0, 0, OP_JUMP_IF_FALSE, 0, 8, OP_TRUE, OP_RETURN, OP_FALSE, OP_RETURN}
+	if string(code.Code) != string(want) {
+		t.Errorf("expected %v, got %v:\n%s", want, code.Code, DisassembleString(code))
+	}
+	if err := Verify(code); err != nil {
+		t.Error(err)
+	}
+	if got := code.locForPC(8); got != loc {
+		t.Errorf("expected the false branch to keep its location, got %v", got)
+	}
+}
@@ -0,0 +1,200 @@
+package consume
+
+import "github.com/archevel/ghoul/bones"
+
+// maxPeepholePasses bounds how often peephole rewrites one CodeObject;
+// each pass can expose more work for the next.
+const maxPeepholePasses = 8
+
+// peephole simplifies compiled code in place, and that of every closure
+// in its constant pool. It threads jumps to jumps, turns jumps to a return
+// into a return, and removes unreachable instructions, jumps to the next
+// instruction and constants pushed only to be popped. Jump targets and the
+// source map follow the instructions they pointed at.
+func peephole(co *CodeObject) {
+	for _, c := range co.Constants {
+		if child, ok := c.ForeignVal.(*CodeObject); ok && c.Kind == bones.ForeignNode {
+			peephole(child)
+		}
+	}
+	for i := 0; i < maxPeepholePasses && peepholePass(co); i++ {
+	}
+}
+
+type instruction struct {
+	pc      int
+	op      byte
+	operand int
+}
+
+func decodeCode(co *CodeObject) []instruction {
+	var ins []instruction
+	for pc := 0; pc < len(co.Code); {
+		in := instruction{pc: pc, op: co.Code[pc]}
+		pc++
+		if hasOperand(in.op) {
+			in.operand = int(readUint16(co.Code, pc))
+			pc += 2
+		}
+		ins = append(ins, in)
+	}
+	return ins
+}
+
+func isJump(op byte) bool {
+	return op == OP_JUMP || op == OP_JUMP_IF_FALSE
+}
+
+// pushesConstant reports whether op only pushes a value, with no other
+// effect and no way to fail.
+func pushesConstant(op byte) bool {
+	switch op {
+	case OP_CONST, OP_NIL, OP_TRUE, OP_FALSE, OP_MAKE_CLOSURE:
+		return true
+	}
+	return false
+}
+
+func peepholePass(co *CodeObject) bool {
+	ins := decodeCode(co)
+	if len(ins) == 0 {
+		return false
+	}
+	index := make(map[int]int, len(ins))
+	for i, in := range ins {
+		index[in.pc] = i
+	}
+	changed := false
+
+	for i := range ins {
+		if !isJump(ins[i].op) {
+			continue
+		}
+		target := ins[i].operand
+		for hops := 0; hops < len(ins); hops++ {
+			j, ok := index[target]
+			if !ok || ins[j].op != OP_JUMP || ins[j].operand == target {
+				break
+			}
+			target = ins[j].operand
+		}
+		if target != ins[i].operand {
+			ins[i].operand = target
+			changed = true
+		}
+		if j, ok := index[target]; ok && ins[i].op == OP_JUMP && ins[j].op == OP_RETURN {
+			ins[i] = instruction{pc: ins[i].pc, op: OP_RETURN}
+			changed = true
+		}
+	}
+
+	deleted := make([]bool, len(ins))
+	reached := make([]bool, len(ins))
+	work := []int{0}
+	follow := func(pc int) {
+		if j, ok := index[pc]; ok {
+			work = append(work, j)
+		}
+	}
+	for len(work) > 0 {
+		i := work[len(work)-1]
+		work = work[:len(work)-1]
+		if reached[i] {
+			continue
+		}
+		reached[i] = true
+		switch ins[i].op {
+		case OP_RETURN:
+		case OP_JUMP:
+			follow(ins[i].operand)
+		case OP_JUMP_IF_FALSE:
+			follow(ins[i].operand)
+			work = append(work, i+1)
+		default:
+			if i+1 < len(ins) {
+				work = append(work, i+1)
+			}
+		}
+	}
+	targets := map[int]bool{}
+	for i, in := range ins {
+		if !reached[i] {
+			deleted[i] = true
+			changed = true
+		} else if isJump(in.op) {
+			targets[in.operand] = true
+		}
+	}
+
+	for i, in := range ins {
+		if deleted[i] {
+			continue
+		}
+		next := len(co.Code)
+		if i+1 < len(ins) {
+			next = ins[i+1].pc
+		}
+		switch {
+		case in.op == OP_JUMP && in.operand == next:
+			deleted[i] = true
+			changed = true
+		case pushesConstant(in.op) && i+1 < len(ins) && !deleted[i+1] && ins[i+1].op == OP_POP && !targets[ins[i+1].pc]:
+			deleted[i], deleted[i+1] = true, true
+			changed = true
+		}
+	}
+
+	if changed {
+		rewrite(co, ins, deleted)
+	}
+	return changed
+}
+
+// rewrite re-emits the instructions not deleted. A jump or source map
+// entry at a deleted instruction moves to the next one kept.
+func rewrite(co *CodeObject, ins []instruction, deleted []bool) {
+	// A deleted instruction maps to where the next kept one lands.
+	newPC := make(map[int]int, len(ins)+1)
+	size := 0
+	for i, in := range ins {
+		newPC[in.pc] = size
+		if deleted[i] {
+			continue
+		}
+		size++
+		if hasOperand(in.op) {
+			size += 2
+		}
+	}
+	newPC[len(co.Code)] = size
+
+	code := make([]byte, 0, size)
+	for i, in := range ins {
+		if deleted[i] {
+			continue
+		}
+		code = append(code, in.op)
+		if hasOperand(in.op) {
+			operand := in.operand
+			if isJump(in.op) {
+				operand = newPC[operand]
+			}
+			code = append(code, byte(operand>>8), byte(operand))
+		}
+	}
+
+	var locs []LocEntry
+	for _, l := range co.Locs {
+		pc, ok := newPC[l.StartPC]
+		if !ok {
+			continue
+		}
+		if n := len(locs); n > 0 && locs[n-1].StartPC == pc {
+			locs[n-1].Loc = l.Loc
+			continue
+		}
+		locs = append(locs, LocEntry{StartPC: pc, Loc: l.Loc})
+	}
+	co.Code = code
+	co.Locs = locs
+}
@@ -0,0 +1,103 @@
+package consume
+
+import (
+	"context"
+	"time"
+
+	"github.com/archevel/ghoul/bones"
+)
+
+// Stats counts what evaluations did. Pass a context from CollectStats to
+// RunCode, StartWithContext or the like to have an evaluation add to it;
+// without one the VM keeps no counts. An evaluation started from inside a
+// running one on the same goroutine, such as Apply from a native function
+// or a nested RunCode, adds to the same Stats. A Stats must not be used by
+// evaluations running at once.
+type Stats struct {
+	// Instructions is the number of bytecode instructions executed.
+	Instructions int64
+	// ClosureCalls and NativeCalls count calls of compiled procedures and
+	// of Go functions. TailCalls counts the closure calls that reused the
+	// caller's frame.
+	ClosureCalls int64
+	NativeCalls  int64
+	TailCalls    int64
+	// MaxStack is the most values a VM stack held, and MaxDepth the
+	// deepest nesting of call frames, those of enclosing evaluations
+	// included.
+	MaxStack int
+	MaxDepth int
+	// Nodes counts the closures, rest argument lists and native function
+	// results allocated.
+	Nodes int64
+	// NativeTime is the time spent in Go functions by the name they are
+	// bound to, Ghoul procedures they call back into included.
+	NativeTime map[string]time.Duration
+	// Expansion is the time macro expansion took, modules it required
+	// included. The reanimator records it; evaluation alone leaves it 0.
+	Expansion time.Duration
+
+	// names caches the names of the Go functions called.
+	names map[*bones.Node]string
+}
+
+type statsKey struct{}
+
+// CollectStats returns a copy of ctx that makes evaluations run under it
+// add their counts to stats.
+func CollectStats(ctx context.Context, stats *Stats) context.Context {
+	return context.WithValue(ctx, statsKey{}, stats)
+}
+
+// StatsFrom returns the Stats ctx collects into, or nil.
+func StatsFrom(ctx context.Context) *Stats {
+	stats, _ := ctx.Value(statsKey{}).(*Stats)
+	return stats
+}
+
+// step counts an instruction about to execute on vm.
+func (s *Stats) step(vm *VM) {
+	s.Instructions++
+	if vm.sp > s.MaxStack {
+		s.MaxStack = vm.sp
+	}
+	if depth := vm.budget.depth + vm.fp + 1; depth > s.MaxDepth {
+		s.MaxDepth = depth
+	}
+}
+
+// native records a call of the Go function fn, which env binds, taking d.
+func (s *Stats) native(fn *bones.Node, env *environment, d time.Duration) {
+	s.NativeCalls++
+	name, ok := s.names[fn]
+	if !ok {
+		name = nativeName(fn, env)
+		if s.names == nil {
+			s.names = map[*bones.Node]string{}
+		}
+		s.names[fn] = name
+	}
+	if s.NativeTime == nil {
+		s.NativeTime = map[string]time.Duration{}
+	}
+	s.NativeTime[name] += d
+}
+
+// nativeName returns the first of the unmarked names env binds fn to, or
+// its printed form when there is none.
+func nativeName(fn *bones.Node, env *environment) string {
+	name := ""
+	if env != nil {
+		for _, s := range *env {
+			s.each(func(key scopeKey, val *bones.Node) {
+				if val == fn && key.Marks.empty() && (name == "" || key.name() < name) {
+					name = key.name()
+				}
+			})
+		}
+	}
+	if name == "" {
+		return fn.Repr()
+	}
+	return name
+}
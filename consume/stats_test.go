@@ -0,0 +1,77 @@
+package consume
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/archevel/ghoul/bones"
+	"github.com/archevel/ghoul/engraving"
+)
+
+func TestStatsCountCallsAndAllocations(t *testing.T) {
+	ev := stepEvaluator()
+	code := optimizeSource(t, ev, `
+(define count (lambda (n acc) (cond ((< n 1) acc) (else (count (- n 1) (+ acc 1))))))
+(define wrap (lambda xs xs))
+(wrap (count 10 0) (call (lambda () 'back)))`)
+	var stats Stats
+	if _, err := ev.RunCode(CollectStats(context.Background(), &stats), code); err != nil {
+		t.Fatal(err)
+	}
+
+	// count runs 11 times, 10 of them as tail calls; wrap, in tail
+	// position at top level, and the lambda passed to call once each.
+	if stats.ClosureCalls != 13 || stats.TailCalls != 11 {
+		t.Errorf("expected 13 closure calls, 11 of them tail calls, got %d and %d", stats.ClosureCalls, stats.TailCalls)
+	}
+	if stats.NativeCalls != 1 || len(stats.NativeTime) != 1 {
+		t.Errorf("expected one timed call of call, got %d calls and %v", stats.NativeCalls, stats.NativeTime)
+	}
+	if _, ok := stats.NativeTime["call"]; !ok {
+		t.Errorf("expected native time by name, got %v", stats.NativeTime)
+	}
+	// Three closures, the rest list of wrap and what call returned.
+	if stats.Nodes != 5 {
+		t.Errorf("expected 5 nodes allocated, got %d", stats.Nodes)
+	}
+	if stats.Instructions == 0 || stats.MaxStack == 0 || stats.MaxDepth != 2 {
+		t.Errorf("expected instructions, stack and a depth of 2 (top level and lambda), got %+v", stats)
+	}
+}
+
+func TestStatsCountInstructionsLikeFuel(t *testing.T) {
+	ev := New(engraving.StandardLogger, setupTestEnvironment())
+	code := optimizeSource(t, ev, `(+ 1 2)`)
+	var stats Stats
+	ctx := CollectStats(context.Background(), &stats)
+	if _, err := ev.RunCode(ctx, code); err != nil {
+		t.Fatal(err)
+	}
+	ev.SetLimits(Limits{Fuel: stats.Instructions})
+	if _, err := ev.RunCode(context.Background(), code); err != nil {
+		t.Errorf("expected the counted instructions to fit the same fuel, got %v", err)
+	}
+	ev.SetLimits(Limits{Fuel: stats.Instructions - 1})
+	if _, err := ev.RunCode(context.Background(), code); err == nil {
+		t.Error("expected one instruction less fuel to run out")
+	}
+}
+
+func TestStatsTimeNativeFunctionsByName(t *testing.T) {
+	env := setupTestEnvironment()
+	env.Register("nap", func(args []*bones.Node, ev *Evaluator) (*bones.Node, error) {
+		time.Sleep(5 * time.Millisecond)
+		return bones.Nil, nil
+	})
+	ev := New(engraving.StandardLogger, env)
+	var stats Stats
+	ctx := CollectStats(context.Background(), &stats)
+	x := ev.StartWithContext(ctx, optimizeSource(t, ev, `(define snooze nap) (snooze) (nap)`))
+	if _, err := x.Step(0); err != nil {
+		t.Fatal(err)
+	}
+	if stats.NativeCalls != 2 || stats.NativeTime["nap"] < 10*time.Millisecond || len(stats.NativeTime) != 1 {
+		t.Errorf("expected 2 naps of 5ms under the first name, got %d calls and %v", stats.NativeCalls, stats.NativeTime)
+	}
+}
@@ -0,0 +1,73 @@
+package consume
+
+import (
+	"bufio"
+	"io"
+	"os"
+)
+
+// processStdin buffers os.Stdin once for every evaluator reading it, so
+// input one of them buffered is not lost to the others.
+var processStdin = bufio.NewReader(os.Stdin)
+
+// SetStdout sets where builtins such as print and println write. A nil w
+// restores os.Stdout.
+func (ev *Evaluator) SetStdout(w io.Writer) {
+	ev.stdout = w
+}
+
+// Stdout returns where builtins write their output.
+func (ev *Evaluator) Stdout() io.Writer {
+	if ev.stdout == nil {
+		return os.Stdout
+	}
+	return ev.stdout
+}
+
+// SetStderr sets where builtins such as eprintln write. A nil w restores
+// os.Stderr.
+func (ev *Evaluator) SetStderr(w io.Writer) {
+	ev.stderr = w
+}
+
+// Stderr returns where builtins write error output.
+func (ev *Evaluator) Stderr() io.Writer {
+	if ev.stderr == nil {
+		return os.Stderr
+	}
+	return ev.stderr
+}
+
+// SetStdin sets what builtins such as read-line read. Evaluators meant
+// to share one input should be given the same *bufio.Reader. A nil r
+// restores os.Stdin.
+func (ev *Evaluator) SetStdin(r io.Reader) {
+	switch r := r.(type) {
+	case nil:
+		ev.stdin = nil
+	case *bufio.Reader:
+		ev.stdin = r
+	default:
+		ev.stdin = bufio.NewReader(r)
+	}
+}
+
+// Stdin returns what builtins read their input from.
+func (ev *Evaluator) Stdin() *bufio.Reader {
+	if ev.stdin == nil {
+		return processStdin
+	}
+	return ev.stdin
+}
+
+// ForEnvironment returns an evaluator for env that shares this one's
+// logger, mark counter, streams and settings, such as the evaluator a
+// required module runs in. Only the state of running evaluations is not
+// carried over.
+func (ev *Evaluator) ForEnvironment(env *environment) *Evaluator {
+	derived := *ev
+	derived.env = env
+	derived.active, derived.level, derived.gen, derived.ctx = nil, 0, 0, nil
+	derived.idle = &vmPool{}
+	return &derived
+}
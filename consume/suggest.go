@@ -3,6 +3,8 @@ package consume
 import (
 	"fmt"
 	"strings"
+
+	e "github.com/archevel/ghoul/bones"
 )
 
 func levenshteinDistance(a, b string) int {
@@ -47,19 +49,18 @@ func suggestIdentifiers(name string, env *environment) []string {
 	var candidates []string
 
 	for i := len(*env) - 1; i >= 0; i-- {
-		scope := (*env)[i]
-		for key := range *scope {
-			dist := levenshteinDistance(name, key.Name)
+		(*env)[i].each(func(key scopeKey, _ *e.Node) {
+			dist := levenshteinDistance(name, key.name())
 			if dist > maxSuggestionDistance {
-				continue
+				return
 			}
 			if dist < minDist {
 				minDist = dist
-				candidates = []string{key.Name}
+				candidates = []string{key.name()}
 			} else if dist == minDist {
-				candidates = append(candidates, key.Name)
+				candidates = append(candidates, key.name())
 			}
-		}
+		})
 	}
 
 	if len(candidates) > maxSuggestions {
@@ -0,0 +1,111 @@
+package consume
+
+import (
+	"math"
+
+	"github.com/archevel/ghoul/bones"
+)
+
+// value is how the VM holds a runtime value on its stack and in local
+// slots: either a *bones.Node, or an immediate, a number the VM's own
+// arithmetic computed, held inline until something outside the VM needs
+// it as a Node. Nil, booleans and small integers are shared Nodes
+// already, so the immediates spare the allocation of arithmetic results
+// only. Every other value, numbers returned by Go functions included, is
+// still a full Node, and Nodes made outside the VM cost what they did.
+type value struct {
+	ref *bones.Node // the Node, or intTag or floatTag for an immediate
+	imm uint64      // the bits of an immediate
+}
+
+// intTag and floatTag carry the right Kind, so ref.Kind is the kind of
+// any value.
+var (
+	intTag   = &bones.Node{Kind: bones.IntegerNode}
+	floatTag = &bones.Node{Kind: bones.FloatNodeKind}
+)
+
+func nodeValue(n *bones.Node) value {
+	return value{ref: n}
+}
+
+func intValue(i int64) value {
+	return value{ref: intTag, imm: uint64(i)}
+}
+
+func floatValue(f float64) value {
+	return value{ref: floatTag, imm: math.Float64bits(f)}
+}
+
+func boolValue(b bool) value {
+	return value{ref: bones.BoolNode(b)}
+}
+
+// Node returns v as a Node, allocating one for an immediate outside the
+// small integer cache.
+func (v value) Node() *bones.Node {
+	switch v.ref {
+	case intTag:
+		return bones.IntNode(int64(v.imm))
+	case floatTag:
+		return bones.FloatNode(math.Float64frombits(v.imm))
+	}
+	return v.ref
+}
+
+func (v value) kind() bones.NodeKind {
+	return v.ref.Kind
+}
+
+// isUnset reports whether v is the zero value, as in a local slot whose
+// define has not run yet.
+func (v value) isUnset() bool {
+	return v.ref == nil
+}
+
+func (v value) intVal() int64 {
+	if v.ref == intTag {
+		return int64(v.imm)
+	}
+	return v.ref.IntVal
+}
+
+func (v value) floatVal() float64 {
+	if v.ref == floatTag {
+		return math.Float64frombits(v.imm)
+	}
+	return v.ref.FloatVal
+}
+
+// floats returns a and b as floats when both are numbers and at least one
+// is a float, the case where arithmetic builtins work in floats.
+func floats(a, b value) (float64, float64, bool) {
+	ak, bk := a.kind(), b.kind()
+	if (ak != bones.FloatNodeKind && bk != bones.FloatNodeKind) ||
+		(ak != bones.FloatNodeKind && ak != bones.IntegerNode) ||
+		(bk != bones.FloatNodeKind && bk != bones.IntegerNode) {
+		return 0, 0, false
+	}
+	return a.number(), b.number(), true
+}
+
+// number returns an integer or float value as a float.
+func (v value) number() float64 {
+	if v.kind() == bones.IntegerNode {
+		return float64(v.intVal())
+	}
+	return v.floatVal()
+}
+
+func (v value) truthy() bool {
+	return v.ref == intTag || v.ref == floatTag || vmTruthy(v.ref)
+}
+
+// nodesOf converts values for code that takes Nodes, such as Go functions.
+func nodesOf(vals []value) []*bones.Node {
+	nodes := make([]*bones.Node, len(vals))
+	for i, v := range vals {
+		nodes[i] = v.Node()
+	}
+	return nodes
+}
@@ -0,0 +1,169 @@
+package consume
+
+import (
+	"context"
+	"testing"
+
+	"github.com/archevel/ghoul/bones"
+	"github.com/archevel/ghoul/engraving"
+)
+
+func TestIntValueConvertsToNode(t *testing.T) {
+	for _, i := range []int64{0, -128, 127, 1000, -1 << 62} {
+		n := intValue(i).Node()
+		if n.Kind != bones.IntegerNode || n.IntVal != i {
+			t.Errorf("intValue(%d).Node() = %s", i, n.Repr())
+		}
+	}
+	if intValue(5).Node() != bones.IntNode(5) {
+		t.Error("small immediates should convert to the cached Node")
+	}
+}
+
+func TestFloatValueConvertsToNode(t *testing.T) {
+	for _, f := range []float64{0, -1.5, 3.25, 1e300} {
+		n := floatValue(f).Node()
+		if n.Kind != bones.FloatNodeKind || n.FloatVal != f {
+			t.Errorf("floatValue(%g).Node() = %s", f, n.Repr())
+		}
+	}
+	if floatValue(2.5).kind() != bones.FloatNodeKind || floatValue(2.5).floatVal() != 2.5 {
+		t.Error("an immediate float should read back as a float")
+	}
+}
+
+func TestNodeValueKeepsTheNode(t *testing.T) {
+	n := bones.StrNode("bone")
+	v := nodeValue(n)
+	if v.Node() != n {
+		t.Error("a Node value should convert back to the same Node")
+	}
+	if v.kind() != bones.StringNode {
+		t.Errorf("expected a string kind, got %d", v.kind())
+	}
+	if nodeValue(bones.IntNode(1000)).intVal() != 1000 || intValue(1000).intVal() != 1000 {
+		t.Error("intVal should read boxed and immediate integers alike")
+	}
+}
+
+func TestValueTruthiness(t *testing.T) {
+	cases := []struct {
+		v    value
+		want bool
+	}{
+		{intValue(0), true},
+		{floatValue(0), true},
+		{boolValue(false), false},
+		{boolValue(true), true},
+		{nodeValue(bones.Nil), false},
+		{nodeValue(bones.StrNode("")), true},
+	}
+	for _, c := range cases {
+		if got := c.v.truthy(); got != c.want {
+			t.Errorf("%s: truthy() = %v", c.v.Node().Repr(), got)
+		}
+	}
+}
+
+func TestImmediatesReachBuiltinsAndGlobalsAsNodes(t *testing.T) {
+	ev := New(engraving.StandardLogger, pureTestEnvironment())
+	result := runSource(t, ev, `
+(define big (lambda (n) (* n 1000)))
+(define stored (big 7))
+(list stored (big 9) (add (big 2) 1))`)
+	if result.Repr() != "(7000 9000 2001)" {
+		t.Errorf("expected (7000 9000 2001), got %s", result.Repr())
+	}
+}
+
+const largeIntLoop = `
+(define loop (lambda (i acc)
+  (cond
+    ((< i 1) acc)
+    (else (loop (- i 1) (+ acc 1000))))))
+(loop 1000 0)`
+
+func TestLargeIntegerLoopDoesNotAllocatePerIteration(t *testing.T) {
+	ev := New(engraving.StandardLogger, setupTestEnvironment())
+	code, err := Compile(translateSource(t, largeIntLoop))
+	if err != nil {
+		t.Fatal(err)
+	}
+	allocs := testing.AllocsPerRun(10, func() {
+		if _, err := ev.RunCode(context.Background(), code); err != nil {
+			t.Fatal(err)
+		}
+	})
+	// 1000 iterations; a Node per sum would be well over a thousand.
+	if allocs > 100 {
+		t.Errorf("expected the loop to run on immediates, got %.0f allocations", allocs)
+	}
+}
+
+const floatLoop = `
+(define loop (lambda (i acc)
+  (cond
+    ((< i 1) acc)
+    (else (loop (- i 1) (+ acc 0.5))))))
+(loop 1000 0.25)`
+
+func TestFloatLoopDoesNotAllocatePerIteration(t *testing.T) {
+	ev := New(engraving.StandardLogger, setupTestEnvironment())
+	code, err := Compile(translateSource(t, floatLoop))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var result *bones.Node
+	allocs := testing.AllocsPerRun(10, func() {
+		if result, err = ev.RunCode(context.Background(), code); err != nil {
+			t.Fatal(err)
+		}
+	})
+	if result.Kind != bones.FloatNodeKind || result.FloatVal != 500.25 {
+		t.Errorf("expected 500.25, got %s", result.Repr())
+	}
+	if allocs > 100 {
+		t.Errorf("expected the loop to run on immediates, got %.0f allocations", allocs)
+	}
+}
+
+func TestMixedArithmeticWorksInFloats(t *testing.T) {
+	ev := New(engraving.StandardLogger, pureTestEnvironment())
+	result := runSource(t, ev, `
+(define f (lambda (a b) (list (+ a b) (- a b) (* a b) (< a b) (>= a b))))
+(f 3 0.5)`)
+	if result.Repr() != "(3.5 2.5 1.5 #f #t)" {
+		t.Errorf("expected (3.5 2.5 1.5 #f #t), got %s", result.Repr())
+	}
+}
+
+func BenchmarkLargeIntegerLoop(b *testing.B) {
+	ev := New(engraving.StandardLogger, setupTestEnvironment())
+	nodes := translateSource(b, largeIntLoop)
+	code, err := Compile(nodes)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ev.RunCode(context.Background(), code); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFloatLoop(b *testing.B) {
+	ev := New(engraving.StandardLogger, setupTestEnvironment())
+	code, err := Compile(translateSource(b, floatLoop))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := ev.RunCode(context.Background(), code); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,311 @@
+package consume
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/archevel/ghoul/bones"
+)
+
+// maxLocals bounds the local slots a verified CodeObject may ask for;
+// slot operands are 16 bits wide.
+const maxLocals = 1 << 16
+
+// Verify checks that a top-level CodeObject and every closure in its
+// constant pool can run without the VM indexing out of range: opcodes are
+// known, operands are complete, constant, local slot, cell and free
+// variable references are in bounds and of the right kind, jumps land on
+// instruction boundaries, and the value stack never underflows and has one
+// depth at each instruction whichever path reaches it. The compiler only produces code that passes;
+// Verify exists for code read back from .ghc files.
+func Verify(co *CodeObject) error {
+	if co == nil {
+		return fmt.Errorf("verify: missing top-level code")
+	}
+	if co.Params != nil {
+		return fmt.Errorf("verify: top-level code must not take parameters")
+	}
+	if len(co.Captures) != 0 {
+		return fmt.Errorf("verify: top-level code must not capture variables")
+	}
+	return verifyCode(co, nil)
+}
+
+// verifyCode checks co, whose closures are made by running parent (nil
+// for top-level code).
+func verifyCode(co *CodeObject, parent *CodeObject) error {
+	if err := verifyCaptures(co, parent); err != nil {
+		return err
+	}
+	return verifyBody(co)
+}
+
+// verifyClosure checks the code of a closure read from an image, whose
+// free variables are already captured rather than made by a parent.
+func verifyClosure(cd *closureData) error {
+	co := cd.code
+	if len(cd.free) != len(co.Captures) {
+		return fmt.Errorf("verify: %s: %d free variables, code captures %d", co.Name, len(cd.free), len(co.Captures))
+	}
+	for i, c := range co.Captures {
+		if (cd.free[i].cell != nil) != c.Cell {
+			return fmt.Errorf("verify: %s: free variable %d is not in scope", co.Name, i)
+		}
+	}
+	return verifyBody(co)
+}
+
+// verifyBody checks everything about co but where its free variables
+// come from.
+func verifyBody(co *CodeObject) error {
+	if co.NumLocals < 0 || co.NumLocals > maxLocals {
+		return fmt.Errorf("verify: %s: %d local slots is out of range", co.Name, co.NumLocals)
+	}
+	if co.Params != nil {
+		if err := verifyParams(co); err != nil {
+			return err
+		}
+	}
+	cells, err := verifyCells(co)
+	if err != nil {
+		return err
+	}
+
+	starts, err := decodeInstructions(co)
+	if err != nil {
+		return err
+	}
+	for i, l := range co.Locs {
+		if l.Loc == nil || l.StartPC < 0 || l.StartPC > len(co.Code) || (i > 0 && l.StartPC < co.Locs[i-1].StartPC) {
+			return fmt.Errorf("verify: %s: bad source map entry %d", co.Name, i)
+		}
+	}
+
+	for pc := range starts {
+		op := co.Code[pc]
+		if !hasOperand(op) {
+			continue
+		}
+		operand := int(readUint16(co.Code, pc+1))
+		if err := verifyOperand(co, pc, op, operand, cells, starts); err != nil {
+			return err
+		}
+	}
+	return verifyStack(co)
+}
+
+func verifyParams(co *CodeObject) error {
+	slots := len(co.Params.Fixed)
+	for _, p := range co.Params.Fixed {
+		if p == nil || p.Kind != bones.IdentifierNode {
+			return fmt.Errorf("verify: %s: parameter is not an identifier", co.Name)
+		}
+	}
+	if v := co.Params.Variadic; v != nil {
+		if v.Kind != bones.IdentifierNode {
+			return fmt.Errorf("verify: %s: rest parameter is not an identifier", co.Name)
+		}
+		slots++
+	}
+	if slots > co.NumLocals {
+		return fmt.Errorf("verify: %s: %d parameters but only %d local slots", co.Name, slots, co.NumLocals)
+	}
+	return nil
+}
+
+// verifyCells checks the slots co boxes and returns them as a set.
+func verifyCells(co *CodeObject) (map[int]bool, error) {
+	cells := map[int]bool{}
+	for _, slot := range co.Cells {
+		if slot < 0 || slot >= co.NumLocals || cells[slot] {
+			return nil, fmt.Errorf("verify: %s: bad cell slot %d", co.Name, slot)
+		}
+		cells[slot] = true
+	}
+	return cells, nil
+}
+
+// verifyCaptures checks that every free variable of co names a slot or
+// free variable of parent, boxed exactly when the capture says it is.
+func verifyCaptures(co *CodeObject, parent *CodeObject) error {
+	for i, c := range co.Captures {
+		ok := false
+		switch {
+		case parent == nil:
+		case c.Local:
+			ok = c.Index >= 0 && c.Index < parent.NumLocals && slices.Contains(parent.Cells, c.Index) == c.Cell
+		default:
+			ok = c.Index >= 0 && c.Index < len(parent.Captures) && parent.Captures[c.Index].Cell == c.Cell
+		}
+		if !ok {
+			return fmt.Errorf("verify: %s: free variable %d is not in scope", co.Name, i)
+		}
+	}
+	return nil
+}
+
+// decodeInstructions walks co.Code once and returns the set of offsets
+// where an instruction starts.
+func decodeInstructions(co *CodeObject) (map[int]bool, error) {
+	starts := map[int]bool{}
+	for pc := 0; pc < len(co.Code); {
+		op := co.Code[pc]
+		if op > lastOpcode {
+			return nil, fmt.Errorf("verify: %s: unknown opcode %d at %d", co.Name, op, pc)
+		}
+		starts[pc] = true
+		size := 1
+		if hasOperand(op) {
+			size = 3
+		}
+		if pc+size > len(co.Code) {
+			return nil, fmt.Errorf("verify: %s: truncated %s at %d", co.Name, opcodeName(op), pc)
+		}
+		pc += size
+	}
+	return starts, nil
+}
+
+func hasOperand(op byte) bool {
+	switch op {
+	case OP_NIL, OP_TRUE, OP_FALSE, OP_POP, OP_RETURN:
+		return false
+	}
+	return true
+}
+
+func verifyOperand(co *CodeObject, pc int, op byte, operand int, cells map[int]bool, starts map[int]bool) error {
+	fail := func(format string, args ...any) error {
+		return fmt.Errorf("verify: %s: %s at %d: %s", co.Name, opcodeName(op), pc, fmt.Sprintf(format, args...))
+	}
+	constant := func() (*bones.Node, error) {
+		if operand >= len(co.Constants) {
+			return nil, fail("constant %d out of range", operand)
+		}
+		if co.Constants[operand] == nil {
+			return nil, fail("constant %d is missing", operand)
+		}
+		return co.Constants[operand], nil
+	}
+
+	switch op {
+	case OP_CONST:
+		_, err := constant()
+		return err
+	case OP_LOAD_VAR, OP_DEFINE, OP_SET,
+		OP_INT_ADD, OP_INT_SUB, OP_INT_MUL, OP_INT_LT, OP_INT_LE, OP_INT_GT, OP_INT_GE:
+		c, err := constant()
+		if err != nil {
+			return err
+		}
+		if c.Kind != bones.IdentifierNode {
+			return fail("constant %d is a %s, not an identifier", operand, bones.NodeTypeName(c))
+		}
+	case OP_MAKE_CLOSURE:
+		c, err := constant()
+		if err != nil {
+			return err
+		}
+		child, ok := c.ForeignVal.(*CodeObject)
+		if !ok || c.Kind != bones.ForeignNode {
+			return fail("constant %d is not compiled code", operand)
+		}
+		return verifyCode(child, co)
+	case OP_LOAD_LOCAL, OP_SET_LOCAL, OP_DEFINE_LOCAL:
+		if operand >= co.NumLocals {
+			return fail("local slot %d is not in scope", operand)
+		}
+	case OP_LOAD_CELL, OP_SET_CELL, OP_DEFINE_CELL:
+		if !cells[operand] {
+			return fail("local slot %d has no cell", operand)
+		}
+	case OP_LOAD_FREE, OP_LOAD_FREE_CELL, OP_SET_FREE_CELL:
+		if operand >= len(co.Captures) {
+			return fail("free variable %d is not in scope", operand)
+		}
+		if co.Captures[operand].Cell != (op != OP_LOAD_FREE) {
+			return fail("free variable %d is not a cell", operand)
+		}
+	case OP_JUMP, OP_JUMP_IF_FALSE:
+		if operand != len(co.Code) && !starts[operand] {
+			return fail("jump to %d is not an instruction boundary", operand)
+		}
+	}
+	return nil
+}
+
+// stackEffect returns how many values op needs on the stack and the net
+// change it makes.
+func stackEffect(op byte, operand int) (needs, delta int) {
+	switch op {
+	case OP_CONST, OP_NIL, OP_TRUE, OP_FALSE, OP_LOAD_VAR, OP_LOAD_LOCAL, OP_MAKE_CLOSURE,
+		OP_LOAD_CELL, OP_LOAD_FREE, OP_LOAD_FREE_CELL:
+		return 0, 1
+	case OP_POP, OP_JUMP_IF_FALSE:
+		return 1, -1
+	case OP_DEFINE, OP_SET, OP_SET_LOCAL, OP_DEFINE_LOCAL, OP_SET_CELL, OP_DEFINE_CELL, OP_SET_FREE_CELL:
+		return 1, 0
+	case OP_CALL, OP_TAIL_CALL:
+		return operand + 1, -operand
+	case OP_RETURN:
+		return 1, -1
+	case OP_INT_ADD, OP_INT_SUB, OP_INT_MUL, OP_INT_LT, OP_INT_LE, OP_INT_GT, OP_INT_GE:
+		return 2, -1
+	}
+	return 0, 0
+}
+
+// verifyStack follows every path through co, tracking the stack depth.
+func verifyStack(co *CodeObject) error {
+	depthAt := map[int]int{0: 0}
+	work := []int{0}
+	reach := func(pc, depth int) error {
+		if seen, ok := depthAt[pc]; ok {
+			if seen != depth {
+				return fmt.Errorf("verify: %s: stack depth %d at %d, %d on another path", co.Name, depth, pc, seen)
+			}
+			return nil
+		}
+		depthAt[pc] = depth
+		work = append(work, pc)
+		return nil
+	}
+
+	for len(work) > 0 {
+		pc := work[len(work)-1]
+		work = work[:len(work)-1]
+		if pc == len(co.Code) {
+			continue // running off the end returns the top of stack or Nil
+		}
+		op := co.Code[pc]
+		operand := 0
+		next := pc + 1
+		if hasOperand(op) {
+			operand = int(readUint16(co.Code, pc+1))
+			next = pc + 3
+		}
+		depth := depthAt[pc]
+		needs, delta := stackEffect(op, operand)
+		if depth < needs {
+			return fmt.Errorf("verify: %s: %s at %d needs %d stack values, has %d", co.Name, opcodeName(op), pc, needs, depth)
+		}
+		depth += delta
+
+		var err error
+		switch op {
+		case OP_RETURN:
+		case OP_JUMP:
+			err = reach(operand, depth)
+		case OP_JUMP_IF_FALSE:
+			if err = reach(operand, depth); err == nil {
+				err = reach(next, depth)
+			}
+		default:
+			err = reach(next, depth)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,97 @@
+package consume
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/archevel/ghoul/bones"
+)
+
+func lambdaCode(numLocals int, code []byte, constants ...*bones.Node) *bones.Node {
+	return bones.ForeignNodeVal(&CodeObject{
+		Name:      "lambda",
+		Code:      code,
+		Constants: constants,
+		Params:    &bones.ParamSpec{Fixed: []*bones.Node{bones.IdentNode("x")}},
+		NumLocals: numLocals,
+	})
+}
+
+func capturing(code *bones.Node, captures ...Capture) *bones.Node {
+	code.ForeignVal.(*CodeObject).Captures = captures
+	return code
+}
+
+func TestVerifyAcceptsCompiledCode(t *testing.T) {
+	if err := Verify(compileSource(t, ghcTestSource)); err != nil {
+		t.Errorf("expected compiled code to verify, got %v", err)
+	}
+}
+
+func TestVerifyRejectsMalformedCode(t *testing.T) {
+	x := bones.IdentNode("x")
+	cases := []struct {
+		name string
+		code *CodeObject
+		want string
+	}{
+		{"unknown opcode", &CodeObject{Code: []byte{0xee}}, "unknown opcode"},
+		{"truncated operand", &CodeObject{Code: []byte{OP_CONST, 0}}, "truncated OP_CONST"},
+		{"constant out of range", &CodeObject{Code: []byte{OP_CONST, 0, 3, OP_RETURN}}, "constant 3 out of range"},
+		{"name is not an identifier", &CodeObject{Code: []byte{OP_LOAD_VAR, 0, 0, OP_RETURN}, Constants: []*bones.Node{bones.IntNode(1)}}, "not an identifier"},
+		{"closure is not code", &CodeObject{Code: []byte{OP_MAKE_CLOSURE, 0, 0, OP_RETURN}, Constants: []*bones.Node{x}}, "not compiled code"},
+		{"jump into an operand", &CodeObject{Code: []byte{OP_JUMP, 0, 4, OP_CONST, 0, 0, OP_RETURN}, Constants: []*bones.Node{x}}, "not an instruction boundary"},
+		{"stack underflow", &CodeObject{Code: []byte{OP_POP, OP_RETURN}}, "needs 1 stack values, has 0"},
+		{"call without arguments", &CodeObject{Code: []byte{OP_NIL, OP_CALL, 0, 2, OP_RETURN}}, "needs 3 stack values"},
+		{"inconsistent depth", &CodeObject{Code: []byte{OP_TRUE, OP_JUMP_IF_FALSE, 0, 6, OP_NIL, OP_NIL, OP_RETURN}}, "on another path"},
+		{"local at top level", &CodeObject{Code: []byte{OP_LOAD_LOCAL, 0, 0, OP_RETURN}}, "not in scope"},
+		{"top level captures", &CodeObject{Code: []byte{OP_NIL, OP_RETURN}, Captures: []Capture{{Local: true}}}, "must not capture"},
+		{"local slot out of range", &CodeObject{
+			Code:      []byte{OP_MAKE_CLOSURE, 0, 0, OP_RETURN},
+			Constants: []*bones.Node{lambdaCode(1, []byte{OP_LOAD_LOCAL, 0, 1, OP_RETURN})},
+		}, "local slot 1 is not in scope"},
+		{"cell op on a plain slot", &CodeObject{
+			Code:      []byte{OP_MAKE_CLOSURE, 0, 0, OP_RETURN},
+			Constants: []*bones.Node{lambdaCode(1, []byte{OP_LOAD_CELL, 0, 0, OP_RETURN})},
+		}, "local slot 0 has no cell"},
+		{"free variable out of range", &CodeObject{
+			Code:      []byte{OP_MAKE_CLOSURE, 0, 0, OP_RETURN},
+			Constants: []*bones.Node{lambdaCode(1, []byte{OP_LOAD_FREE, 0, 0, OP_RETURN})},
+		}, "free variable 0 is not in scope"},
+		{"capture of a missing slot", &CodeObject{
+			Code:      []byte{OP_MAKE_CLOSURE, 0, 0, OP_RETURN},
+			Constants: []*bones.Node{capturing(lambdaCode(1, []byte{OP_LOAD_FREE, 0, 0, OP_RETURN}), Capture{Local: true})},
+		}, "free variable 0 is not in scope"},
+		{"cell capture of a plain slot", &CodeObject{
+			Code:      []byte{OP_MAKE_CLOSURE, 0, 0, OP_RETURN},
+			NumLocals: 1,
+			Constants: []*bones.Node{capturing(lambdaCode(1, []byte{OP_LOAD_FREE_CELL, 0, 0, OP_RETURN}), Capture{Local: true, Cell: true})},
+		}, "free variable 0 is not in scope"},
+		{"parameters without slots", &CodeObject{
+			Code:      []byte{OP_MAKE_CLOSURE, 0, 0, OP_RETURN},
+			Constants: []*bones.Node{lambdaCode(0, []byte{OP_NIL, OP_RETURN})},
+		}, "1 parameters but only 0 local slots"},
+		{"source map out of order", &CodeObject{
+			Code: []byte{OP_NIL, OP_RETURN},
+			Locs: []LocEntry{{StartPC: 1, Loc: &bones.SourcePosition{}}, {StartPC: 0, Loc: &bones.SourcePosition{}}},
+		}, "bad source map entry 1"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			c.code.Name = "top-level"
+			err := Verify(c.code)
+			if err == nil || !strings.Contains(err.Error(), c.want) {
+				t.Errorf("expected error containing %q, got %v", c.want, err)
+			}
+		})
+	}
+}
+
+func TestVerifyFollowsNestedClosureScopes(t *testing.T) {
+	inner := capturing(lambdaCode(1, []byte{OP_LOAD_FREE, 0, 0, OP_RETURN}), Capture{Local: true})
+	outer := lambdaCode(1, []byte{OP_MAKE_CLOSURE, 0, 0, OP_RETURN}, inner)
+	code := &CodeObject{Name: "top-level", Code: []byte{OP_MAKE_CLOSURE, 0, 0, OP_RETURN}, Constants: []*bones.Node{outer}}
+	if err := Verify(code); err != nil {
+		t.Errorf("expected a reference to the enclosing lambda's slot to verify, got %v", err)
+	}
+}
@@ -10,11 +10,28 @@ import (
 
 // VM executes compiled bytecode.
 type VM struct {
-	stack  []*bones.Node
+	stack  []value
 	sp     int
 	frames []callFrame
 	fp     int
 
+	// budget is what the running evaluation has left of its Limits, and
+	// ctx the context it runs under.
+	budget budget
+	ctx    context.Context
+
+	// gate lets the native functions the VM calls apply procedures on it.
+	gate gate
+
+	// stepping is set on the VM of an Execution, which may pause between
+	// instructions of its top-level code, and yielded holds the value of
+	// the yield that paused it.
+	stepping bool
+	yielded  *bones.Node
+
+	// stats, when set, receives the counts of the evaluation.
+	stats *Stats
+
 	// Shared evaluator state
 	ev *Evaluator
 }
@@ -25,40 +42,54 @@ const (
 )
 
 func newVM(ev *Evaluator) *VM {
-	return &VM{
-		stack:  make([]*bones.Node, defaultStackSize),
+	ev = ev.root()
+	vm := &VM{
+		stack:  make([]value, defaultStackSize),
 		frames: make([]callFrame, defaultFrameSize),
+		budget: newBudget(ev.limits),
 		ev:     ev,
 	}
+	vm.gate.done.L = &vm.gate.mu
+	return vm
 }
 
-func (vm *VM) push(val *bones.Node) {
+func (vm *VM) push(val value) {
 	if vm.sp >= len(vm.stack) {
-		vm.stack = append(vm.stack, make([]*bones.Node, len(vm.stack))...)
+		vm.stack = append(vm.stack, make([]value, len(vm.stack))...)
 	}
 	vm.stack[vm.sp] = val
 	vm.sp++
 }
 
-func (vm *VM) pop() *bones.Node {
+func (vm *VM) pop() value {
 	vm.sp--
 	val := vm.stack[vm.sp]
-	vm.stack[vm.sp] = nil // help GC
+	vm.stack[vm.sp] = value{} // help GC
 	return val
 }
 
-func (vm *VM) peek() *bones.Node {
+func (vm *VM) peek() value {
 	return vm.stack[vm.sp-1]
 }
 
 func (vm *VM) run(ctx context.Context, code *CodeObject) (*bones.Node, error) {
+	if err := vm.begin(ctx, code); err != nil {
+		return nil, err
+	}
+	return vm.execute(ctx, 0)
+}
+
+// begin sets up the frame for top-level code.
+func (vm *VM) begin(ctx context.Context, code *CodeObject) error {
 	// Check for pre-cancelled context
 	select {
 	case <-ctx.Done():
-		return nil, ctx.Err()
+		return ctx.Err()
 	default:
 	}
 
+	noteAssignments(code, vm.ev.env)
+
 	// Set up initial frame
 	vm.frames[0] = callFrame{
 		code: code,
@@ -68,11 +99,41 @@ func (vm *VM) run(ctx context.Context, code *CodeObject) (*bones.Node, error) {
 	}
 	vm.fp = 0
 	vm.sp = 0
+	if err := vm.enterLocals(&vm.frames[0], 0); err != nil {
+		return err
+	}
+
+	vm.ctx = ctx
+	return nil
+}
 
+// execute runs the dispatch loop until the frame at baseFP returns. Nested
+// executions started by Evaluator.Apply share the stack and frames with
+// the evaluation that called into Go, using a higher baseFP.
+func (vm *VM) execute(ctx context.Context, baseFP int) (*bones.Node, error) {
 	var counter int
 
 	for {
 		frame := &vm.frames[vm.fp]
+		if vm.stats != nil {
+			vm.stats.step(vm)
+		}
+
+		vm.budget.fuel--
+		if vm.budget.fuel < 0 {
+			if vm.budget.reserve == 0 {
+				return nil, vm.wrapError(vm.limitError(LimitFuel), frame)
+			}
+			// Only the share of an Execution's step is spent.
+			if vm.stepping && baseFP == 0 {
+				vm.budget.fuel++
+				return nil, errPaused
+			}
+			// A procedure called from Go cannot stop part way, so it runs
+			// on the reserve and the step ends once the call returns.
+			vm.budget.fuel, vm.budget.reserve = vm.budget.reserve-1, 0
+			vm.budget.rearm = true
+		}
 
 		// Context cancellation check (every 1024 iterations)
 		counter++
@@ -87,7 +148,7 @@ func (vm *VM) run(ctx context.Context, code *CodeObject) (*bones.Node, error) {
 		if frame.ip >= len(frame.code.Code) {
 			// End of code — return top of stack or Nil
 			if vm.sp > 0 {
-				return vm.pop(), nil
+				return vm.pop().Node(), nil
 			}
 			return bones.Nil, nil
 		}
@@ -99,16 +160,16 @@ func (vm *VM) run(ctx context.Context, code *CodeObject) (*bones.Node, error) {
 		case OP_CONST:
 			idx := readUint16(frame.code.Code, frame.ip)
 			frame.ip += 2
-			vm.push(frame.code.Constants[idx])
+			vm.push(nodeValue(frame.code.Constants[idx]))
 
 		case OP_NIL:
-			vm.push(bones.Nil)
+			vm.push(nodeValue(bones.Nil))
 
 		case OP_TRUE:
-			vm.push(bones.BoolNode(true))
+			vm.push(boolValue(true))
 
 		case OP_FALSE:
-			vm.push(bones.BoolNode(false))
+			vm.push(boolValue(false))
 
 		case OP_POP:
 			vm.pop()
@@ -116,17 +177,18 @@ func (vm *VM) run(ctx context.Context, code *CodeObject) (*bones.Node, error) {
 		case OP_LOAD_VAR:
 			idx := readUint16(frame.code.Code, frame.ip)
 			frame.ip += 2
-			identNode := frame.code.Constants[idx]
-			val, err := lookupNode(identNode, frame.env)
+			val, err := frame.code.lookupGlobal(idx, frame.env)
 			if err != nil {
 				return nil, vm.wrapError(err, frame)
 			}
-			vm.push(val)
+			vm.push(nodeValue(val))
 
 		case OP_DEFINE:
 			idx := readUint16(frame.code.Code, frame.ip)
 			frame.ip += 2
-			val := vm.peek() // leave value on stack
+			// Leave the value on the stack, as the Node it is bound to.
+			val := vm.peek().Node()
+			vm.stack[vm.sp-1] = nodeValue(val)
 			nameNode := frame.code.Constants[idx]
 			if _, err := bindNode(nameNode, val, frame.env); err != nil {
 				return nil, vm.wrapError(err, frame)
@@ -135,43 +197,65 @@ func (vm *VM) run(ctx context.Context, code *CodeObject) (*bones.Node, error) {
 		case OP_SET:
 			idx := readUint16(frame.code.Code, frame.ip)
 			frame.ip += 2
-			val := vm.peek()
+			val := vm.peek().Node()
+			vm.stack[vm.sp-1] = nodeValue(val)
 			nameNode := frame.code.Constants[idx]
 			if _, err := assignByName(nameNode, val, frame.env); err != nil {
 				return nil, vm.wrapError(err, frame)
 			}
 
 		case OP_LOAD_LOCAL:
-			operand := readUint16(frame.code.Code, frame.ip)
+			slot := int(readUint16(frame.code.Code, frame.ip))
 			frame.ip += 2
-			depth, slot := decodeLexAddr(operand)
-			lf := frame.locals
-			for i := 0; i < depth; i++ {
-				lf = lf.parent
+			val := vm.stack[frame.bp+slot]
+			if val.isUnset() {
+				return nil, vm.wrapError(errUsedBeforeDefinition, frame)
 			}
-			vm.push(lf.slots[slot])
+			vm.push(val)
+
+		case OP_SET_LOCAL, OP_DEFINE_LOCAL:
+			slot := int(readUint16(frame.code.Code, frame.ip))
+			frame.ip += 2
+			vm.stack[frame.bp+slot] = vm.peek() // leave value on stack
+
+		case OP_LOAD_CELL:
+			slot := readUint16(frame.code.Code, frame.ip)
+			frame.ip += 2
+			val := frame.cells[slot].v
+			if val.isUnset() {
+				// Only reachable when a closure runs before the define
+				// that binds its slot, e.g. (define w ((lambda () w))).
+				return nil, vm.wrapError(errUsedBeforeDefinition, frame)
+			}
+			vm.push(val)
 
-		case OP_SET_LOCAL:
-			operand := readUint16(frame.code.Code, frame.ip)
+		case OP_SET_CELL, OP_DEFINE_CELL:
+			slot := readUint16(frame.code.Code, frame.ip)
 			frame.ip += 2
-			depth, slot := decodeLexAddr(operand)
-			val := vm.peek() // leave value on stack
-			lf := frame.locals
-			for i := 0; i < depth; i++ {
-				lf = lf.parent
+			frame.cells[slot].v = vm.peek()
+
+		case OP_LOAD_FREE:
+			idx := readUint16(frame.code.Code, frame.ip)
+			frame.ip += 2
+			val := frame.closure.free[idx].val
+			if val.isUnset() {
+				return nil, vm.wrapError(errUsedBeforeDefinition, frame)
 			}
-			lf.slots[slot] = val
+			vm.push(val)
 
-		case OP_DEFINE_LOCAL:
-			slotIdx := int(readUint16(frame.code.Code, frame.ip))
+		case OP_LOAD_FREE_CELL:
+			idx := readUint16(frame.code.Code, frame.ip)
 			frame.ip += 2
-			val := vm.peek() // leave value on stack
-			lf := frame.locals
-			// Grow slots if needed (define may come after params)
-			for slotIdx >= len(lf.slots) {
-				lf.slots = append(lf.slots, nil)
+			val := frame.closure.free[idx].cell.v
+			if val.isUnset() {
+				return nil, vm.wrapError(errUsedBeforeDefinition, frame)
 			}
-			lf.slots[slotIdx] = val
+			vm.push(val)
+
+		case OP_SET_FREE_CELL:
+			idx := readUint16(frame.code.Code, frame.ip)
+			frame.ip += 2
+			frame.closure.free[idx].cell.v = vm.peek()
 
 		case OP_CALL:
 			argc := int(readUint16(frame.code.Code, frame.ip))
@@ -189,11 +273,15 @@ func (vm *VM) run(ctx context.Context, code *CodeObject) (*bones.Node, error) {
 
 		case OP_RETURN:
 			result := vm.pop()
-			if vm.fp == 0 {
-				return result, nil
+			if vm.fp == baseFP {
+				if baseFP > 0 {
+					vm.dropFrame(vm.frames[vm.fp].bp)
+					vm.fp--
+				}
+				return result.Node(), nil
 			}
 			vm.fp--
-			vm.sp = vm.frames[vm.fp+1].bp
+			vm.dropFrame(vm.frames[vm.fp+1].bp)
 			vm.push(result)
 
 		case OP_JUMP:
@@ -204,7 +292,7 @@ func (vm *VM) run(ctx context.Context, code *CodeObject) (*bones.Node, error) {
 			offset := readUint16(frame.code.Code, frame.ip)
 			frame.ip += 2
 			val := vm.pop()
-			if !vmTruthy(val) {
+			if !val.truthy() {
 				frame.ip = int(offset)
 			}
 
@@ -216,22 +304,27 @@ func (vm *VM) run(ctx context.Context, code *CodeObject) (*bones.Node, error) {
 			if !ok {
 				return nil, fmt.Errorf("VM: expected CodeObject in constant pool")
 			}
-			closure := makeClosureWithLocals(codeObj, frame.env, frame.locals)
-			vm.push(closure)
+			closure, err := vm.makeClosure(codeObj, frame)
+			if err != nil {
+				return nil, vm.wrapError(err, frame)
+			}
+			vm.push(nodeValue(closure))
 
 		case OP_INT_ADD:
 			idx := readUint16(frame.code.Code, frame.ip)
 			frame.ip += 2
 			b := vm.pop()
 			a := vm.pop()
-			if a.Kind == bones.IntegerNode && b.Kind == bones.IntegerNode {
-				vm.push(bones.IntNode(a.IntVal + b.IntVal))
+			if a.kind() == bones.IntegerNode && b.kind() == bones.IntegerNode {
+				vm.push(intValue(a.intVal() + b.intVal()))
+			} else if x, y, ok := floats(a, b); ok {
+				vm.push(floatValue(x + y))
 			} else {
 				result, err := vm.callArithFallback(frame, idx, a, b)
 				if err != nil {
 					return nil, err
 				}
-				vm.push(result)
+				vm.push(nodeValue(result))
 			}
 
 		case OP_INT_SUB:
@@ -239,14 +332,16 @@ func (vm *VM) run(ctx context.Context, code *CodeObject) (*bones.Node, error) {
 			frame.ip += 2
 			b := vm.pop()
 			a := vm.pop()
-			if a.Kind == bones.IntegerNode && b.Kind == bones.IntegerNode {
-				vm.push(bones.IntNode(a.IntVal - b.IntVal))
+			if a.kind() == bones.IntegerNode && b.kind() == bones.IntegerNode {
+				vm.push(intValue(a.intVal() - b.intVal()))
+			} else if x, y, ok := floats(a, b); ok {
+				vm.push(floatValue(x - y))
 			} else {
 				result, err := vm.callArithFallback(frame, idx, a, b)
 				if err != nil {
 					return nil, err
 				}
-				vm.push(result)
+				vm.push(nodeValue(result))
 			}
 
 		case OP_INT_MUL:
@@ -254,14 +349,16 @@ func (vm *VM) run(ctx context.Context, code *CodeObject) (*bones.Node, error) {
 			frame.ip += 2
 			b := vm.pop()
 			a := vm.pop()
-			if a.Kind == bones.IntegerNode && b.Kind == bones.IntegerNode {
-				vm.push(bones.IntNode(a.IntVal * b.IntVal))
+			if a.kind() == bones.IntegerNode && b.kind() == bones.IntegerNode {
+				vm.push(intValue(a.intVal() * b.intVal()))
+			} else if x, y, ok := floats(a, b); ok {
+				vm.push(floatValue(x * y))
 			} else {
 				result, err := vm.callArithFallback(frame, idx, a, b)
 				if err != nil {
 					return nil, err
 				}
-				vm.push(result)
+				vm.push(nodeValue(result))
 			}
 
 		case OP_INT_LT:
@@ -269,14 +366,16 @@ func (vm *VM) run(ctx context.Context, code *CodeObject) (*bones.Node, error) {
 			frame.ip += 2
 			b := vm.pop()
 			a := vm.pop()
-			if a.Kind == bones.IntegerNode && b.Kind == bones.IntegerNode {
-				vm.push(bones.BoolNode(a.IntVal < b.IntVal))
+			if a.kind() == bones.IntegerNode && b.kind() == bones.IntegerNode {
+				vm.push(boolValue(a.intVal() < b.intVal()))
+			} else if x, y, ok := floats(a, b); ok {
+				vm.push(boolValue(x < y))
 			} else {
 				result, err := vm.callArithFallback(frame, idx, a, b)
 				if err != nil {
 					return nil, err
 				}
-				vm.push(result)
+				vm.push(nodeValue(result))
 			}
 
 		case OP_INT_LE:
@@ -284,14 +383,16 @@ func (vm *VM) run(ctx context.Context, code *CodeObject) (*bones.Node, error) {
 			frame.ip += 2
 			b := vm.pop()
 			a := vm.pop()
-			if a.Kind == bones.IntegerNode && b.Kind == bones.IntegerNode {
-				vm.push(bones.BoolNode(a.IntVal <= b.IntVal))
+			if a.kind() == bones.IntegerNode && b.kind() == bones.IntegerNode {
+				vm.push(boolValue(a.intVal() <= b.intVal()))
+			} else if x, y, ok := floats(a, b); ok {
+				vm.push(boolValue(x <= y))
 			} else {
 				result, err := vm.callArithFallback(frame, idx, a, b)
 				if err != nil {
 					return nil, err
 				}
-				vm.push(result)
+				vm.push(nodeValue(result))
 			}
 
 		case OP_INT_GT:
@@ -299,14 +400,16 @@ func (vm *VM) run(ctx context.Context, code *CodeObject) (*bones.Node, error) {
 			frame.ip += 2
 			b := vm.pop()
 			a := vm.pop()
-			if a.Kind == bones.IntegerNode && b.Kind == bones.IntegerNode {
-				vm.push(bones.BoolNode(a.IntVal > b.IntVal))
+			if a.kind() == bones.IntegerNode && b.kind() == bones.IntegerNode {
+				vm.push(boolValue(a.intVal() > b.intVal()))
+			} else if x, y, ok := floats(a, b); ok {
+				vm.push(boolValue(x > y))
 			} else {
 				result, err := vm.callArithFallback(frame, idx, a, b)
 				if err != nil {
 					return nil, err
 				}
-				vm.push(result)
+				vm.push(nodeValue(result))
 			}
 
 		case OP_INT_GE:
@@ -314,14 +417,16 @@ func (vm *VM) run(ctx context.Context, code *CodeObject) (*bones.Node, error) {
 			frame.ip += 2
 			b := vm.pop()
 			a := vm.pop()
-			if a.Kind == bones.IntegerNode && b.Kind == bones.IntegerNode {
-				vm.push(bones.BoolNode(a.IntVal >= b.IntVal))
+			if a.kind() == bones.IntegerNode && b.kind() == bones.IntegerNode {
+				vm.push(boolValue(a.intVal() >= b.intVal()))
+			} else if x, y, ok := floats(a, b); ok {
+				vm.push(boolValue(x >= y))
 			} else {
 				result, err := vm.callArithFallback(frame, idx, a, b)
 				if err != nil {
 					return nil, err
 				}
-				vm.push(result)
+				vm.push(nodeValue(result))
 			}
 
 		default:
@@ -332,32 +437,60 @@ func (vm *VM) run(ctx context.Context, code *CodeObject) (*bones.Node, error) {
 
 func (vm *VM) doCall(argc int, isTail bool, frame *callFrame) error {
 	// Stack: [... arg0, arg1, ..., argN, func]
-	funNode := vm.pop() // pop function
+	funNode := vm.pop().ref // a procedure is never an immediate
+
+	// Compiled closures take their arguments where they are on the stack,
+	// as the first local slots of the new frame.
+	if cd, ok := funNode.ForeignVal.(*closureData); ok && funNode.Kind == bones.FunctionNode {
+		return vm.callClosure(cd, argc, isTail, frame)
+	}
 
 	// Collect args from stack
-	args := make([]*bones.Node, argc)
-	for i := argc - 1; i >= 0; i-- {
-		args[i] = vm.pop()
+	args := nodesOf(vm.stack[vm.sp-argc : vm.sp])
+	vm.dropFrame(vm.sp - argc)
+
+	// (apply f a ... lst) calls f in apply's own position, so a tail call
+	// through apply is still a proper tail call.
+	if isApplyProcedure(funNode) {
+		for isApplyProcedure(funNode) {
+			var err error
+			if funNode, args, err = spreadApplyArgs(args); err != nil {
+				return vm.wrapError(err, frame)
+			}
+		}
+		if cd, ok := funNode.ForeignVal.(*closureData); ok && funNode.Kind == bones.FunctionNode {
+			for _, arg := range args {
+				vm.push(nodeValue(arg))
+			}
+			return vm.callClosure(cd, len(args), isTail, frame)
+		}
 	}
 
-	// Check if it's a compiled Ghoul closure
-	if funNode.Kind == bones.FunctionNode && funNode.ForeignVal != nil {
-		if cd, ok := funNode.ForeignVal.(*closureData); ok {
-			return vm.callClosure(cd, args, isTail, frame)
+	if isYieldProcedure(funNode) && vm.stepping {
+		if err := vm.yield(args); err != nil {
+			return vm.wrapError(err, frame)
 		}
+		return errYield
 	}
 
 	// Go native function call
 	if funNode.Kind == bones.FunctionNode && funNode.FuncVal != nil {
-		proc := *funNode.FuncVal
-		result, err := proc(args, vm.ev)
+		result, err := vm.callNative(funNode, args, frame.env)
 		if err != nil {
 			if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
 				return err
 			}
 			return vm.wrapError(err, frame)
 		}
-		vm.push(result)
+		if n := resultBytes(result, args); n > 0 {
+			if err := vm.charge(n); err != nil {
+				return vm.wrapError(err, frame)
+			}
+			if vm.stats != nil {
+				vm.stats.Nodes++
+			}
+		}
+		vm.push(nodeValue(result))
 		return nil
 	}
 
@@ -372,150 +505,173 @@ func (vm *VM) doCall(argc int, isTail bool, frame *callFrame) error {
 	return vm.wrapError(fmt.Errorf("not a procedure: %s", funNode.Repr()), frame)
 }
 
-// bindLocalParams binds arguments into the localFrame's slots by index,
-// matching the slot order established by the compiler.
-func bindLocalParams(lf *localFrame, params *bones.ParamSpec, args []*bones.Node) error {
-	slot := 0
-	if params != nil {
-		for _, _ = range params.Fixed {
-			if slot >= len(args) {
-				return fmt.Errorf("arity mismatch: too few arguments")
-			}
-			lf.slots[slot] = args[slot]
-			slot++
-		}
-		if params.Variadic != nil {
-			lf.slots[slot] = bones.NewListNode(args[slot:])
-			slot++
-		} else if slot < len(args) {
-			return fmt.Errorf("arity mismatch: too many arguments")
-		}
+// errUsedBeforeDefinition is only reachable when a closure runs before the
+// define that binds its variable, e.g. (define w ((lambda () w))), or
+// when a define in a branch not taken left the variable unbound.
+var errUsedBeforeDefinition = errors.New("variable used before its definition")
+
+// bindArgs checks the argc arguments on top of the stack against params,
+// collecting the variadic rest into a list in its slot. It returns how
+// many slots the arguments fill.
+func (vm *VM) bindArgs(params *bones.ParamSpec, argc int) (int, error) {
+	if params == nil {
+		return argc, nil
 	}
-	return nil
-}
-
-func (vm *VM) callClosure(cd *closureData, args []*bones.Node, isTail bool, frame *callFrame) error {
-	// Self-tail-call optimization: when a closure tail-calls itself,
-	// reuse the current frame's local slots instead of allocating new ones.
-	if isTail && cd.code == frame.code {
-		if frame.locals != nil {
-			// Reuse the local frame: clear slots and rebind params
-			for i := range frame.locals.slots {
-				frame.locals.slots[i] = nil
-			}
-			if err := bindLocalParams(frame.locals, cd.code.Params, args); err != nil {
-				return err
-			}
-		}
-		// Also clear the map-based scope (for OP_DEFINE/OP_SET compatibility)
-		topScope := currentScope(frame.env)
-		clear(*topScope)
-		if cd.code.Params != nil {
-			argIdx := 0
-			for _, param := range cd.code.Params.Fixed {
-				if argIdx >= len(args) {
-					return fmt.Errorf("arity mismatch: too few arguments")
-				}
-				bindNode(param, args[argIdx], frame.env)
-				argIdx++
-			}
-			if cd.code.Params.Variadic != nil {
-				remaining := bones.NewListNode(args[argIdx:])
-				bindNode(cd.code.Params.Variadic, remaining, frame.env)
-			} else if argIdx < len(args) {
-				return fmt.Errorf("arity mismatch: too many arguments")
-			}
-		}
-		frame.ip = 0
-		vm.sp = frame.bp
-		return nil
+	fixed := len(params.Fixed)
+	if argc < fixed {
+		return 0, fmt.Errorf("arity mismatch: too few arguments")
 	}
-
-	// Create new local frame for lexically-addressed variables.
-	// A frame is needed when the function has local slots (params or defines)
-	// OR when it captures locals from an enclosing scope (cd.locals != nil)
-	// so that depth-walking in OP_LOAD_LOCAL can reach the parent chain.
-	var newLocals *localFrame
-	if cd.code.NumLocals > 0 || cd.locals != nil {
-		newLocals = &localFrame{
-			slots:  make([]*bones.Node, cd.code.NumLocals),
-			parent: cd.locals,
-		}
-		if err := bindLocalParams(newLocals, cd.code.Params, args); err != nil {
-			return err
+	if params.Variadic == nil {
+		if argc > fixed {
+			return 0, fmt.Errorf("arity mismatch: too many arguments")
 		}
+		return argc, nil
 	}
+	rest := bones.NewListNode(nodesOf(vm.stack[vm.sp-argc+fixed : vm.sp]))
+	if err := vm.charge(nodeBytes + int64(argc-fixed)*pointerBytes); err != nil {
+		return 0, err
+	}
+	if vm.stats != nil {
+		vm.stats.Nodes++
+	}
+	vm.dropFrame(vm.sp - argc + fixed)
+	vm.push(nodeValue(rest))
+	return fixed + 1, nil
+}
 
-	// Create new environment (still needed for OP_LOAD_VAR fallback)
-	newEnv := newEnvWithEmptyScope(cd.env)
-
-	// Bind parameters into the map-based env too, for OP_LOAD_VAR compatibility
-	// (needed when the same closure is called via the FuncVal wrapper path)
-	if cd.code.Params != nil {
-		argIdx := 0
-		for _, param := range cd.code.Params.Fixed {
-			if argIdx >= len(args) {
-				return fmt.Errorf("arity mismatch: too few arguments")
-			}
-			bindNode(param, args[argIdx], newEnv)
-			argIdx++
-		}
-		if cd.code.Params.Variadic != nil {
-			remaining := bones.NewListNode(args[argIdx:])
-			bindNode(cd.code.Params.Variadic, remaining, newEnv)
-		} else if argIdx < len(args) {
-			return fmt.Errorf("arity mismatch: too many arguments")
+// callClosure enters cd with the argc arguments on top of the stack.
+// Parameters live only in local slots: the compiler resolves every
+// reference to them lexically, so the frame shares the closure's
+// environment instead of binding them in a scope of its own.
+func (vm *VM) callClosure(cd *closureData, argc int, isTail bool, frame *callFrame) error {
+	n, err := vm.bindArgs(cd.code.Params, argc)
+	if err != nil {
+		vm.dropFrame(vm.sp - argc)
+		return err
+	}
+	if vm.stats != nil {
+		vm.stats.ClosureCalls++
+		if isTail {
+			vm.stats.TailCalls++
 		}
 	}
+	base := vm.sp - n
 
 	if isTail {
-		// Reuse current frame
+		// Reuse the current frame: slide the arguments down over its
+		// locals. A self-tail call loops without allocating anything but
+		// the cells its body needs.
+		copy(vm.stack[frame.bp:], vm.stack[base:vm.sp])
+		vm.dropFrame(frame.bp + n)
 		frame.code = cd.code
 		frame.ip = 0
-		frame.env = newEnv
-		frame.locals = newLocals
-		vm.sp = frame.bp // trim stack
+		frame.env = cd.env
+		frame.closure = cd
 	} else {
+		if max := vm.ev.limits.MaxDepth; max > 0 && vm.budget.depth+vm.fp >= max {
+			vm.dropFrame(base)
+			return vm.limitError(LimitDepth)
+		}
 		// Push new frame
 		vm.fp++
 		if vm.fp >= len(vm.frames) {
 			vm.frames = append(vm.frames, callFrame{})
 		}
 		vm.frames[vm.fp] = callFrame{
-			code:   cd.code,
-			ip:     0,
-			bp:     vm.sp,
-			env:    newEnv,
-			locals: newLocals,
+			code:    cd.code,
+			ip:      0,
+			bp:      base,
+			env:     cd.env,
+			closure: cd,
 		}
+		frame = &vm.frames[vm.fp]
 	}
+	if err := vm.enterLocals(frame, n); err != nil {
+		vm.dropFrame(frame.bp)
+		if !isTail {
+			vm.fp--
+		}
+		return err
+	}
+	return nil
+}
 
+// enterLocals reserves the frame's local slots above the n arguments
+// already at its base and boxes the slots that need a cell.
+func (vm *VM) enterLocals(frame *callFrame, n int) error {
+	top := frame.bp + frame.code.NumLocals
+	if max := vm.ev.limits.MaxStack; max > 0 && top >= max {
+		return vm.limitError(LimitStack)
+	}
+	for top > len(vm.stack) {
+		vm.stack = append(vm.stack, make([]value, len(vm.stack))...)
+	}
+	clear(vm.stack[frame.bp+n : top])
+	vm.sp = top
+
+	frame.cells = nil
+	if len(frame.code.Cells) > 0 {
+		if err := vm.charge(int64(len(frame.code.Cells))*cellBytes + int64(frame.code.NumLocals)*pointerBytes); err != nil {
+			return err
+		}
+		frame.cells = make([]*cell, frame.code.NumLocals)
+		for _, slot := range frame.code.Cells {
+			frame.cells[slot] = &cell{v: vm.stack[frame.bp+slot]}
+		}
+	}
 	return nil
 }
 
+// dropFrame pops everything above sp, clearing it for the GC.
+func (vm *VM) dropFrame(sp int) {
+	clear(vm.stack[sp:vm.sp])
+	vm.sp = sp
+}
+
+// makeClosure creates a closure over code, copying the variables it
+// captures out of the running frame.
+func (vm *VM) makeClosure(code *CodeObject, frame *callFrame) (*bones.Node, error) {
+	if err := vm.charge(closureBytes + int64(len(code.Captures))*upvalueBytes); err != nil {
+		return nil, err
+	}
+	if vm.stats != nil {
+		vm.stats.Nodes++
+	}
+	var free []upvalue
+	if len(code.Captures) > 0 {
+		free = make([]upvalue, len(code.Captures))
+		for i, c := range code.Captures {
+			switch {
+			case !c.Local:
+				free[i] = frame.closure.free[c.Index]
+			case c.Cell:
+				free[i].cell = frame.cells[c.Index]
+			default:
+				free[i].val = vm.stack[frame.bp+c.Index]
+			}
+		}
+	}
+	return makeClosureNode(&closureData{code: code, env: frame.env, free: free}), nil
+}
+
 func (vm *VM) wrapError(err error, frame *callFrame) error {
 	if _, ok := err.(EvaluationError); ok {
 		return err
 	}
 	loc := frame.code.locForPC(frame.ip - 1)
-	if loc != nil {
-		return EvaluationError{msg: err.Error(), Loc: loc}
-	}
-	return EvaluationError{msg: err.Error()}
+	return EvaluationError{msg: err.Error(), Loc: loc, cause: err}
 }
 
 // callArithFallback looks up a function by name from the constant pool and
 // calls it with two arguments. Used when a specialized integer opcode
 // encounters non-integer operands.
-func (vm *VM) callArithFallback(frame *callFrame, nameIdx uint16, a, b *bones.Node) (*bones.Node, error) {
-	nameNode := frame.code.Constants[nameIdx]
-	funNode, err := lookupNode(nameNode, frame.env)
+func (vm *VM) callArithFallback(frame *callFrame, nameIdx uint16, a, b value) (*bones.Node, error) {
+	funNode, err := frame.code.lookupGlobal(nameIdx, frame.env)
 	if err != nil {
 		return nil, vm.wrapError(err, frame)
 	}
 	if funNode.Kind == bones.FunctionNode && funNode.FuncVal != nil {
-		proc := *funNode.FuncVal
-		result, err := proc([]*bones.Node{a, b}, vm.ev)
+		result, err := vm.callNative(funNode, []*bones.Node{a.Node(), b.Node()}, frame.env)
 		if err != nil {
 			return nil, vm.wrapError(err, frame)
 		}
@@ -534,11 +690,8 @@ func vmTruthy(n *bones.Node) bool {
 	return true
 }
 
-// makeClosureWithLocals creates a FuncNode that wraps a compiled closure,
-// capturing both the map-based environment and the indexed local frame.
-func makeClosureWithLocals(code *CodeObject, env *environment, locals *localFrame) *bones.Node {
-	cd := &closureData{code: code, env: env, locals: locals}
-
+// makeClosureNode creates a FuncNode that wraps a compiled closure.
+func makeClosureNode(cd *closureData) *bones.Node {
 	// Build the node first so the wrapper can reference it
 	node := &bones.Node{Kind: bones.FunctionNode, ForeignVal: cd}
 
@@ -547,11 +700,7 @@ func makeClosureWithLocals(code *CodeObject, env *environment, locals *localFram
 	closureNode := node // capture for wrapper closure
 	wrapper := func(args []*bones.Node, evaluator bones.Evaluator) (*bones.Node, error) {
 		ev := evaluator.(*Evaluator)
-		children := make([]*bones.Node, 0, len(args)+1)
-		children = append(children, closureNode)
-		children = append(children, args...)
-		callNode := &bones.Node{Kind: bones.CallNode, Children: children}
-		return ev.EvalSubExpression(callNode)
+		return ev.Apply(ev.Context(), closureNode, args)
 	}
 	node.FuncVal = &wrapper
 	return node
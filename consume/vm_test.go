@@ -2,6 +2,7 @@ package consume
 
 import (
 	"context"
+	"strings"
 	"testing"
 
 	"github.com/archevel/ghoul/bones"
@@ -108,6 +109,27 @@ func TestVMIdentifierUndefined(t *testing.T) {
 	}
 }
 
+func TestVMLocalUsedBeforeItsDefinition(t *testing.T) {
+	// (define f (lambda () (define w ((lambda () w))) w)) (f)
+	inner := &bones.Node{Kind: bones.LambdaNode, Params: &bones.ParamSpec{}, Children: []*bones.Node{bones.IdentNode("w")}}
+	body := &bones.Node{Kind: bones.DefineNode, Children: []*bones.Node{
+		bones.IdentNode("w"),
+		&bones.Node{Kind: bones.CallNode, Children: []*bones.Node{inner}},
+	}}
+	f := &bones.Node{Kind: bones.LambdaNode, Params: &bones.ParamSpec{}, Children: []*bones.Node{body, bones.IdentNode("w")}}
+	code, err := compileTopLevel([]*bones.Node{
+		{Kind: bones.DefineNode, Children: []*bones.Node{bones.IdentNode("f"), f}},
+		{Kind: bones.CallNode, Children: []*bones.Node{bones.IdentNode("f")}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = newTestVM().run(context.Background(), code)
+	if err == nil || !strings.Contains(err.Error(), "used before its definition") {
+		t.Errorf("expected a use-before-definition error, got %v", err)
+	}
+}
+
 func TestVMQuote(t *testing.T) {
 	q := &bones.Node{Kind: bones.QuoteNode, Quoted: bones.IntNode(42)}
 	result := compileAndRun(t, []*bones.Node{q}, NewEnvironment())
@@ -0,0 +1,297 @@
+package ghoul
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"reflect"
+	"sync"
+
+	e "github.com/archevel/ghoul/bones"
+	ev "github.com/archevel/ghoul/consume"
+)
+
+// The conversions here follow the rules the embalmer generates code for:
+// booleans, strings, integers and floats (named types included) become
+// the matching Ghoul value, anything else travels as a mummy holding the
+// Go value, and Go funcs accept Ghoul procedures. Slices, which the
+// embalmer only passes as mummies, also accept Ghoul lists.
+
+var (
+	nodeType     = reflect.TypeOf((*e.Node)(nil))
+	errorType    = reflect.TypeOf((*error)(nil)).Elem()
+	contextType  = reflect.TypeOf((*context.Context)(nil)).Elem()
+	anySliceType = reflect.TypeOf([]any(nil))
+)
+
+// toNode converts a Go value to the Ghoul value it stands for. It fails
+// only for unsigned integers too large for a Ghoul integer.
+func toNode(v reflect.Value) (*e.Node, error) {
+	if !v.IsValid() {
+		return e.Nil, nil
+	}
+	if v.Type() == nodeType {
+		if v.IsNil() {
+			return e.Nil, nil
+		}
+		return v.Interface().(*e.Node), nil
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		return e.BoolNode(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return e.IntNode(v.Int()), nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if v.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("%s %d does not fit in an integer", v.Type(), v.Uint())
+		}
+		return e.IntNode(int64(v.Uint())), nil
+	case reflect.Float32, reflect.Float64:
+		return e.FloatNode(v.Float()), nil
+	case reflect.String:
+		return e.StrNode(v.String()), nil
+	case reflect.Interface:
+		if v.IsNil() {
+			return e.Nil, nil
+		}
+		return toNode(v.Elem())
+	case reflect.Func:
+		// Go funcs handed to Ghoul become procedures it can call.
+		if native, err := nativeFunc(v.Type().String(), v.Interface()); err == nil {
+			return e.FuncNode(func(args []*e.Node, evaluator e.Evaluator) (*e.Node, error) {
+				return native(args, evaluator.(*ev.Evaluator))
+			}), nil
+		}
+	}
+	return e.MummyNodeVal(v.Interface(), v.Type().String()), nil
+}
+
+// converter turns Ghoul values into Go values for one call of a defined
+// function. Procedures passed as func arguments report their errors to
+// it while the call runs, so the call can fail with them once the Go
+// function returns. Go code may keep such a func and call it later, from
+// any goroutine; errors it cannot return then are logged.
+type converter struct {
+	fn string // the Ghoul name, for error messages
+	ev *ev.Evaluator
+
+	mu       sync.Mutex
+	running  bool // the call is in progress
+	callback error
+}
+
+// call calls f with in, keeping the errors of callbacks made meanwhile,
+// and returns its results with the first of those errors.
+func (c *converter) call(f reflect.Value, in []reflect.Value) (out []reflect.Value, callbackErr error) {
+	c.mu.Lock()
+	c.running = true
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.running = false
+		callbackErr = c.callback
+		c.mu.Unlock()
+	}()
+	return f.Call(in), nil
+}
+
+// callbackFailed keeps err for the running call, or logs it once the call
+// has returned.
+func (c *converter) callbackFailed(err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.running {
+		if c.ev != nil {
+			c.ev.Log().Warn(fmt.Sprintf("%s: callback failed after the call returned", c.fn), err)
+		}
+		return
+	}
+	if c.callback == nil {
+		c.callback = err
+	}
+}
+
+// toGo converts n for the Go parameter what, which has type t.
+func (c *converter) toGo(n *e.Node, t reflect.Type, what string) (reflect.Value, error) {
+	fail := func(expected string) (reflect.Value, error) {
+		return reflect.Value{}, fmt.Errorf("%s: expected %s for %s, got %s", c.fn, expected, what, e.NodeTypeName(n))
+	}
+	if t == nodeType {
+		return reflect.ValueOf(n), nil
+	}
+
+	v := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Bool:
+		if n.Kind != e.BooleanNode {
+			return fail("boolean")
+		}
+		v.SetBool(n.BoolVal)
+		return v, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n.Kind != e.IntegerNode {
+			return fail("integer")
+		}
+		if v.OverflowInt(n.IntVal) {
+			return c.overflow(n, t, what)
+		}
+		v.SetInt(n.IntVal)
+		return v, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if n.Kind != e.IntegerNode {
+			return fail("integer")
+		}
+		if n.IntVal < 0 || v.OverflowUint(uint64(n.IntVal)) {
+			return c.overflow(n, t, what)
+		}
+		v.SetUint(uint64(n.IntVal))
+		return v, nil
+	case reflect.Float32, reflect.Float64:
+		if n.Kind != e.FloatNodeKind {
+			return fail("float")
+		}
+		if v.OverflowFloat(n.FloatVal) {
+			return c.overflow(n, t, what)
+		}
+		v.SetFloat(n.FloatVal)
+		return v, nil
+	case reflect.String:
+		if n.Kind != e.StringNode {
+			return fail("string")
+		}
+		v.SetString(n.StrVal)
+		return v, nil
+	case reflect.Func:
+		if n.Kind != e.FunctionNode {
+			return fail("function")
+		}
+		if c.ev == nil {
+			return reflect.Value{}, fmt.Errorf("%s: cannot call a procedure for %s without an evaluator", c.fn, what)
+		}
+		return c.procedure(n, t), nil
+	case reflect.Slice:
+		if n.Kind == e.ListNode || n.Kind == e.NilNode {
+			return c.slice(n, t, what)
+		}
+	}
+
+	if n.Kind != e.MummyNode {
+		if t.Kind() == reflect.Interface {
+			// An int or string passed where any is expected.
+			inner := toGoValue(n)
+			if !inner.IsValid() && (n.Kind == e.ListNode || n.Kind == e.NilNode) && anySliceType.AssignableTo(t) {
+				var err error
+				if inner, err = c.slice(n, anySliceType, what); err != nil {
+					return reflect.Value{}, err
+				}
+			}
+			if inner.IsValid() && inner.Type().AssignableTo(t) {
+				v.Set(inner)
+				return v, nil
+			}
+		}
+		return fail("mummy")
+	}
+	if n.ForeignVal == nil {
+		return v, nil
+	}
+	held := reflect.ValueOf(n.ForeignVal)
+	switch {
+	case held.Type().AssignableTo(t):
+		v.Set(held)
+	case held.Kind() == reflect.Pointer && held.Type().Elem().AssignableTo(t) && !held.IsNil():
+		v.Set(held.Elem())
+	default:
+		return reflect.Value{}, fmt.Errorf("%s: %s: mummy contains %T, expected %s", c.fn, what, n.ForeignVal, t)
+	}
+	return v, nil
+}
+
+func (c *converter) overflow(n *e.Node, t reflect.Type, what string) (reflect.Value, error) {
+	return reflect.Value{}, fmt.Errorf("%s: %s for %s does not fit in %s", c.fn, n.Repr(), what, t)
+}
+
+// toGoValue is the natural Go value of a primitive Ghoul value, or the
+// invalid Value for anything else.
+func toGoValue(n *e.Node) reflect.Value {
+	switch n.Kind {
+	case e.BooleanNode:
+		return reflect.ValueOf(n.BoolVal)
+	case e.IntegerNode:
+		return reflect.ValueOf(int(n.IntVal))
+	case e.FloatNodeKind:
+		return reflect.ValueOf(n.FloatVal)
+	case e.StringNode:
+		return reflect.ValueOf(n.StrVal)
+	}
+	return reflect.Value{}
+}
+
+func (c *converter) slice(list *e.Node, t reflect.Type, what string) (reflect.Value, error) {
+	if list.DottedTail != nil {
+		return reflect.Value{}, fmt.Errorf("%s: expected a proper list for %s", c.fn, what)
+	}
+	s := reflect.MakeSlice(t, len(list.Children), len(list.Children))
+	for i, child := range list.Children {
+		elem, err := c.toGo(child, t.Elem(), fmt.Sprintf("element %d of %s", i, what))
+		if err != nil {
+			return reflect.Value{}, err
+		}
+		s.Index(i).Set(elem)
+	}
+	return s, nil
+}
+
+// procedure wraps the Ghoul procedure proc as a Go func of type t. A
+// trailing error result receives the procedure's error; otherwise it is
+// kept for the enclosing call to return, or logged once it has.
+func (c *converter) procedure(proc *e.Node, t reflect.Type) reflect.Value {
+	returnsError := t.NumOut() > 0 && t.Out(t.NumOut()-1) == errorType
+	return reflect.MakeFunc(t, func(in []reflect.Value) []reflect.Value {
+		out := make([]reflect.Value, t.NumOut())
+		for i := range out {
+			out[i] = reflect.Zero(t.Out(i))
+		}
+		fail := func(err error) []reflect.Value {
+			if returnsError {
+				out[len(out)-1] = reflect.ValueOf(&err).Elem()
+			} else {
+				c.callbackFailed(err)
+			}
+			return out
+		}
+
+		args := make([]*e.Node, len(in))
+		for i, arg := range in {
+			node, err := toNode(arg)
+			if err != nil {
+				return fail(fmt.Errorf("%s: argument %d of the callback: %w", c.fn, i, err))
+			}
+			args[i] = node
+		}
+		result, err := c.ev.Apply(c.ev.Context(), proc, args)
+		if err != nil {
+			return fail(err)
+		}
+
+		results := t.NumOut()
+		if returnsError {
+			results--
+		}
+		if results == 1 {
+			result = e.NewListNode([]*e.Node{result})
+		}
+		for i := 0; i < results; i++ {
+			var r *e.Node = e.Nil
+			if result.Kind == e.ListNode && i < len(result.Children) {
+				r = result.Children[i]
+			}
+			v, err := c.toGo(r, t.Out(i), fmt.Sprintf("result %d of the callback", i))
+			if err != nil {
+				return fail(err)
+			}
+			out[i] = v
+		}
+		return out
+	})
+}
@@ -0,0 +1,116 @@
+package ghoul
+
+import (
+	"fmt"
+	"reflect"
+
+	e "github.com/archevel/ghoul/bones"
+	ev "github.com/archevel/ghoul/consume"
+)
+
+func (g ghoul) Define(name string, fn any) error {
+	native, err := nativeFunc(name, fn)
+	if err != nil {
+		return err
+	}
+	g.reanimator.EvalEnv().Register(name, native)
+	return nil
+}
+
+func (g ghoul) DefineValue(name string, v any) error {
+	if name == "" {
+		return fmt.Errorf("define: name must not be empty")
+	}
+	node, err := toNode(reflect.ValueOf(v))
+	if err != nil {
+		return fmt.Errorf("define %s: %w", name, err)
+	}
+	g.reanimator.EvalEnv().RegisterValue(name, node)
+	return nil
+}
+
+// nativeFunc adapts the Go function fn to the form builtins take,
+// converting arguments and results by reflection. A leading
+// context.Context parameter receives the evaluation's context and a
+// trailing error result fails the call.
+func nativeFunc(name string, fn any) (func([]*e.Node, *ev.Evaluator) (*e.Node, error), error) {
+	if name == "" {
+		return nil, fmt.Errorf("define: name must not be empty")
+	}
+	if native, ok := fn.(func([]*e.Node, *ev.Evaluator) (*e.Node, error)); ok {
+		return native, nil
+	}
+	f := reflect.ValueOf(fn)
+	if f.Kind() != reflect.Func || f.IsNil() {
+		return nil, fmt.Errorf("define %s: %T is not a function", name, fn)
+	}
+	t := f.Type()
+	for i := 0; i < t.NumIn(); i++ {
+		if p := t.In(i); p.Kind() == reflect.Func && p.IsVariadic() {
+			return nil, fmt.Errorf("define %s: parameter %d uses unsupported variadic function type %s", name, i, p)
+		}
+	}
+
+	takesContext := t.NumIn() > 0 && t.In(0) == contextType
+	returnsError := t.NumOut() > 0 && t.Out(t.NumOut()-1) == errorType
+	first, fixed := 0, t.NumIn()
+	if takesContext {
+		first = 1
+	}
+	if t.IsVariadic() {
+		fixed--
+	}
+	want := fixed - first
+
+	return func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
+		switch {
+		case t.IsVariadic() && len(args) < want:
+			return nil, fmt.Errorf("%s: expected at least %d arguments, got %d", name, want, len(args))
+		case !t.IsVariadic() && len(args) != want:
+			return nil, fmt.Errorf("%s: expected %d arguments, got %d", name, want, len(args))
+		}
+
+		c := &converter{fn: name, ev: evaluator}
+		in := make([]reflect.Value, 0, first+len(args))
+		if takesContext {
+			in = append(in, reflect.ValueOf(evaluator.Context()))
+		}
+		for i, arg := range args {
+			pt := t.In(min(first+i, t.NumIn()-1))
+			if i >= want {
+				pt = pt.Elem()
+			}
+			v, err := c.toGo(arg, pt, fmt.Sprintf("parameter 'arg%d'", i))
+			if err != nil {
+				return nil, err
+			}
+			in = append(in, v)
+		}
+
+		out, callbackErr := c.call(f, in)
+		if returnsError {
+			if err, _ := out[len(out)-1].Interface().(error); err != nil {
+				return nil, fmt.Errorf("%s: %w", name, err)
+			}
+			out = out[:len(out)-1]
+		}
+		if callbackErr != nil {
+			return nil, callbackErr
+		}
+		results := make([]*e.Node, len(out))
+		for i, v := range out {
+			node, err := toNode(v)
+			if err != nil {
+				return nil, fmt.Errorf("%s: result %d: %w", name, i, err)
+			}
+			results[i] = node
+		}
+		switch len(results) {
+		case 0:
+			return e.Nil, nil
+		case 1:
+			return results[0], nil
+		}
+		return e.NewListNode(results), nil
+	}, nil
+}
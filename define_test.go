@@ -0,0 +1,229 @@
+package ghoul
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	e "github.com/archevel/ghoul/bones"
+)
+
+type celsius float64
+
+type account struct {
+	owner   string
+	balance int
+}
+
+func processString(t *testing.T, g Ghoul, code string) (*e.Node, error) {
+	t.Helper()
+	return g.Process(strings.NewReader(code))
+}
+
+func TestDefineConvertsPrimitives(t *testing.T) {
+	g := NewBare()
+	g.Define("repeat", strings.Repeat)
+	g.Define("warm?", func(c celsius, threshold int64) bool { return float64(c) > float64(threshold) })
+
+	result, err := processString(t, g, `(repeat "ab" 3)`)
+	if err != nil || !result.Equiv(e.StrNode("ababab")) {
+		t.Errorf("expected ababab, got %v, %v", result, err)
+	}
+	result, err = processString(t, g, `(warm? 21.5 20)`)
+	if err != nil || !result.Equiv(e.BoolNode(true)) {
+		t.Errorf("expected #t, got %v, %v", result, err)
+	}
+}
+
+func TestDefineReportsBadArguments(t *testing.T) {
+	g := NewBare()
+	g.Define("repeat", strings.Repeat)
+
+	_, err := processString(t, g, `(repeat 1 3)`)
+	if err == nil || !strings.Contains(err.Error(), "repeat: expected string for parameter 'arg0', got integer") {
+		t.Errorf("expected a type error, got %v", err)
+	}
+	_, err = processString(t, g, `(repeat "a")`)
+	if err == nil || !strings.Contains(err.Error(), "repeat: expected 2 arguments, got 1") {
+		t.Errorf("expected an arity error, got %v", err)
+	}
+}
+
+func TestDefineTrailingErrorFailsTheCall(t *testing.T) {
+	g := NewBare()
+	broke := errors.New("insufficient funds")
+	g.Define("withdraw", func(amount int) (int, error) {
+		if amount > 10 {
+			return 0, broke
+		}
+		return 10 - amount, nil
+	})
+
+	result, err := processString(t, g, `(withdraw 3)`)
+	if err != nil || !result.Equiv(e.IntNode(7)) {
+		t.Errorf("expected 7, got %v, %v", result, err)
+	}
+	_, err = processString(t, g, `(withdraw 30)`)
+	if !errors.Is(err, broke) {
+		t.Errorf("expected the Go error to be wrapped, got %v", err)
+	}
+}
+
+func TestDefineRejectsUnsignedOverflow(t *testing.T) {
+	g := NewBare()
+	g.Define("huge", func() uint64 { return ^uint64(0) })
+	_, err := processString(t, g, `(huge)`)
+	if err == nil || !strings.Contains(err.Error(), "does not fit in an integer") {
+		t.Errorf("expected an overflow error, got %v", err)
+	}
+	if err := g.DefineValue("limit", uint64(1)<<63); err == nil {
+		t.Error("expected DefineValue to reject a uint64 above MaxInt64")
+	}
+	if _, err := Marshal(struct{ N uint64 }{^uint64(0)}); err == nil {
+		t.Error("expected Marshal to reject a uint64 above MaxInt64")
+	}
+}
+
+func TestDefineReturnsSeveralResultsAsAList(t *testing.T) {
+	g := NewBare()
+	g.Define("divmod", func(a, b int) (int, int) { return a / b, a % b })
+	result, err := processString(t, g, `(divmod 17 5)`)
+	if err != nil || result.Repr() != "(3 2)" {
+		t.Errorf("expected (3 2), got %v, %v", result, err)
+	}
+}
+
+func TestDefineVariadicAndSlices(t *testing.T) {
+	g := NewBare()
+	sum := func(xs ...int) int {
+		total := 0
+		for _, x := range xs {
+			total += x
+		}
+		return total
+	}
+	g.Define("sum", sum)
+	g.Define("sum-list", func(xs []int) int { return sum(xs...) })
+
+	if result, err := processString(t, g, `(sum 1 2 3)`); err != nil || !result.Equiv(e.IntNode(6)) {
+		t.Errorf("expected 6, got %v, %v", result, err)
+	}
+	if result, err := processString(t, g, `(sum)`); err != nil || !result.Equiv(e.IntNode(0)) {
+		t.Errorf("expected 0, got %v, %v", result, err)
+	}
+	if result, err := processString(t, g, `(sum-list '(4 5))`); err != nil || !result.Equiv(e.IntNode(9)) {
+		t.Errorf("expected 9, got %v, %v", result, err)
+	}
+	_, err := processString(t, g, `(sum-list '(4 "5"))`)
+	if err == nil || !strings.Contains(err.Error(), "element 1 of parameter 'arg0'") {
+		t.Errorf("expected the bad element to be named, got %v", err)
+	}
+}
+
+func TestDefinePassesOtherValuesAsMummies(t *testing.T) {
+	g := NewBare()
+	g.Define("open-account", func(owner string) *account { return &account{owner: owner} })
+	g.Define("deposit", func(a *account, amount int) { a.balance += amount })
+	g.Define("balance", func(a account) int { return a.balance })
+
+	result, err := processString(t, g, `
+(define acct (open-account "ann"))
+(deposit acct 5)
+(deposit acct 7)
+(balance acct)`)
+	if err != nil || !result.Equiv(e.IntNode(12)) {
+		t.Errorf("expected 12, got %v, %v", result, err)
+	}
+	_, err = processString(t, g, `(deposit 1 2)`)
+	if err == nil || !strings.Contains(err.Error(), "expected mummy for parameter 'arg0'") {
+		t.Errorf("expected a mummy error, got %v", err)
+	}
+}
+
+func TestDefineAcceptsProceduresForFuncParameters(t *testing.T) {
+	g := New()
+	g.Define("map-ints", func(f func(int) int, xs []int) []int {
+		out := make([]int, len(xs))
+		for i, x := range xs {
+			out[i] = f(x)
+		}
+		return out
+	})
+	g.Define("sum-ints", func(xs []int) int {
+		total := 0
+		for _, x := range xs {
+			total += x
+		}
+		return total
+	})
+	result, err := processString(t, g, `(sum-ints (map-ints (lambda (x) (* x x)) '(1 2 3)))`)
+	if err != nil || !result.Equiv(e.IntNode(14)) {
+		t.Errorf("expected 14, got %v, %v", result, err)
+	}
+
+	_, err = processString(t, g, `(map-ints (lambda (x) "no") '(1))`)
+	if err == nil || !strings.Contains(err.Error(), "expected integer for result 0 of the callback") {
+		t.Errorf("expected the callback's bad result to fail the call, got %v", err)
+	}
+}
+
+func TestDefineCallbackErrorsReachTheGoFunction(t *testing.T) {
+	g := NewBare()
+	var seen error
+	g.Define("try", func(f func() (int, error)) int {
+		n, err := f()
+		seen = err
+		return n
+	})
+	if _, err := processString(t, g, `(try (lambda () (undefined-thing)))`); err != nil {
+		t.Fatalf("expected the Go function to handle the error, got %v", err)
+	}
+	if seen == nil {
+		t.Error("expected the callback to return the procedure's error")
+	}
+}
+
+func TestDefinePassesTheEvaluationContext(t *testing.T) {
+	g := NewBare()
+	type key struct{}
+	g.Define("lookup", func(ctx context.Context) string {
+		v, _ := ctx.Value(key{}).(string)
+		return v
+	})
+	ctx := context.WithValue(context.Background(), key{}, "from ctx")
+	result, err := g.ProcessWithContext(ctx, strings.NewReader(`(lookup)`), nil)
+	if err != nil || !result.Equiv(e.StrNode("from ctx")) {
+		t.Errorf("expected the context value, got %v, %v", result, err)
+	}
+}
+
+func TestDefineRejectsNonFunctions(t *testing.T) {
+	if err := NewBare().Define("x", 42); err == nil {
+		t.Error("expected an error for a non-function")
+	}
+	if err := NewBare().Define("bad", func(f func(...int)) {}); err == nil {
+		t.Error("expected an error for a variadic func parameter")
+	}
+}
+
+func TestDefineValue(t *testing.T) {
+	g := NewBare()
+	g.DefineValue("greeting", "hello")
+	g.DefineValue("limit", uint8(7))
+	g.DefineValue("ratio", 0.5)
+	g.DefineValue("home", &account{owner: "bob"})
+	g.Define("owner", func(a *account) string { return a.owner })
+
+	for code, want := range map[string]*e.Node{
+		"greeting":     e.StrNode("hello"),
+		"limit":        e.IntNode(7),
+		"ratio":        e.FloatNode(0.5),
+		"(owner home)": e.StrNode("bob"),
+	} {
+		result, err := processString(t, g, code)
+		if err != nil || !result.Equiv(want) {
+			t.Errorf("%s: expected %s, got %v, %v", code, want.Repr(), result, err)
+		}
+	}
+}
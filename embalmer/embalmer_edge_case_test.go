@@ -2,6 +2,7 @@ package embalmer
 
 import (
 	"bytes"
+	"go/types"
 	"strings"
 	"testing"
 	"text/template"
@@ -236,3 +237,42 @@ func TestGetPackagePrefixSingleSegment(t *testing.T) {
 		t.Errorf("expected mypkg, got %s", result)
 	}
 }
+
+func TestGenerateArgumentConversionContext(t *testing.T) {
+	tm, _ := NewTypeMapper()
+	var buf bytes.Buffer
+	err := tm.GenerateArgumentConversion(ArgConversionInfo{
+		N:         0,
+		Name:      "ctx",
+		Type:      "context.Context",
+		IsContext: true,
+	}, &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := buf.String()
+	if !strings.Contains(code, "param_ctx := ev.Context()") {
+		t.Errorf("expected ctx from the evaluator, got:\n%s", code)
+	}
+	if strings.Contains(code, "argIdx") {
+		t.Errorf("an injected ctx must not consume a Ghoul argument, got:\n%s", code)
+	}
+}
+
+func TestTypeMapperIsContext(t *testing.T) {
+	tm, _ := NewTypeMapper()
+	ctxPkg := types.NewPackage("context", "context")
+	ctxType := types.NewNamed(types.NewTypeName(0, ctxPkg, "Context", nil), types.NewInterfaceType(nil, nil), nil)
+	otherPkg := types.NewPackage("example.com/other", "other")
+	otherType := types.NewNamed(types.NewTypeName(0, otherPkg, "Context", nil), types.NewInterfaceType(nil, nil), nil)
+
+	if !tm.IsContext(ctxType) {
+		t.Error("expected context.Context to be recognised")
+	}
+	if tm.IsContext(otherType) {
+		t.Error("expected other.Context not to be recognised")
+	}
+	if tm.IsContext(types.Typ[types.Int]) {
+		t.Error("expected int not to be recognised")
+	}
+}
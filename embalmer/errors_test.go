@@ -588,3 +588,27 @@ func TestResultHandlingErrorFunction(t *testing.T) {
 		t.Error("expected error handling for functions returning error")
 	}
 }
+
+func TestContextFirstParameterIsInjected(t *testing.T) {
+	testpkgPath, _ := filepath.Abs("../testpkg")
+	if _, err := os.Stat(testpkgPath); os.IsNotExist(err) {
+		t.Skip("testpkg not found")
+	}
+
+	outputDir := t.TempDir()
+	Mummify(&MummificationConfig{
+		PackagePath:     testpkgPath,
+		OutputDir:       outputDir,
+		SkipUnwrappable: true,
+	})
+
+	content, _ := os.ReadFile(filepath.Join(outputDir, "testpkg.go"))
+	code := string(content)
+
+	if !strings.Contains(code, "param_ctx := ev.Context()") {
+		t.Errorf("expected ctx to come from the evaluator, got:\n%s", code)
+	}
+	if !strings.Contains(code, "testpkg.CountUntilDone(param_ctx, param_n)") {
+		t.Errorf("expected CountUntilDone to receive the injected ctx, got:\n%s", code)
+	}
+}
@@ -332,7 +332,9 @@ func (g *Generator) processFunctionInfo(funcInfo FunctionInfo) (FunctionWrapperD
 			IsVariadic: isLastAndVariadic,
 		}
 
-		if isLastAndVariadic {
+		if i == 0 && g.typeMapper.IsContext(param.Type) {
+			argInfo.IsContext = true
+		} else if isLastAndVariadic {
 			// For variadic params, extract the element type from the slice
 			if sliceType, ok := param.Type.(*types.Slice); ok {
 				elemType := sliceType.Elem()
@@ -388,7 +390,9 @@ func (g *Generator) generateFunctionBody(wrapper *FunctionWrapperData, importPat
 	// Generate argument conversion code
 	if wrapper.HasReceiver || len(wrapper.Arguments) > 0 {
 		fmt.Fprintf(&body, "\t// Unwrap the mummy bindings and convert from Ghoul to Go types\n")
-		fmt.Fprintf(&body, "\targIdx := 0\n")
+		if wrapper.readsArgs() {
+			fmt.Fprintf(&body, "\targIdx := 0\n")
+		}
 
 		// Handle receiver first if it exists
 		if wrapper.HasReceiver && wrapper.ReceiverInfo != nil {
@@ -453,6 +457,20 @@ func (g *Generator) generateFunctionBody(wrapper *FunctionWrapperData, importPat
 	return nil
 }
 
+// readsArgs reports whether the wrapper takes anything from the Ghoul
+// arguments; a leading context.Context comes from the evaluator instead.
+func (wrapper *FunctionWrapperData) readsArgs() bool {
+	if wrapper.HasReceiver {
+		return true
+	}
+	for _, arg := range wrapper.Arguments {
+		if !arg.IsContext {
+			return true
+		}
+	}
+	return false
+}
+
 // generateArgumentConversion generates code to convert a single argument
 func (g *Generator) generateArgumentConversion(arg *ArgConversionInfo, body *bytes.Buffer) error {
 	return g.typeMapper.GenerateArgumentConversion(*arg, body)
@@ -551,7 +569,9 @@ func (g *Generator) generateConstructor(structInfo StructInfo, importPath string
 	var body bytes.Buffer
 	fmt.Fprintf(&body, "// %s raises a new %s from the grave.\n", goFuncName, structInfo.Name)
 	fmt.Fprintf(&body, "func %s(args []*_e.Node, ev *_eval.Evaluator) (*_e.Node, error) {\n", goFuncName)
-	fmt.Fprintf(&body, "\targIdx := 0\n")
+	if len(structInfo.Fields) > 0 {
+		fmt.Fprintf(&body, "\targIdx := 0\n")
+	}
 
 	// Generate field extraction
 	for _, field := range structInfo.Fields {
@@ -651,7 +671,9 @@ func (g *Generator) generateInterfaceMethodWrapper(ifaceName string, method Func
 			Name: paramName,
 			Type: qualifiedTypeToAlias(param.Type.String()),
 		}
-		if g.typeMapper.IsFunction(param.Type) {
+		if i == 0 && g.typeMapper.IsContext(param.Type) {
+			info.IsContext = true
+		} else if g.typeMapper.IsFunction(param.Type) {
 			info.FuncSignature = g.typeMapper.BuildFuncSignature(param.Type)
 			info.Type = buildGoFuncLiteralType(param.Type)
 		} else if !isForeign && ghoulType != "" {
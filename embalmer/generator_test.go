@@ -2,6 +2,8 @@ package embalmer
 
 import (
 	"bytes"
+	"go/parser"
+	"go/token"
 	"go/types"
 	"os"
 	"path/filepath"
@@ -463,3 +465,29 @@ type fakeType string
 
 func (f fakeType) Underlying() types.Type { return f }
 func (f fakeType) String() string         { return string(f) }
+
+func TestGenerateContextOnlyFunctionReadsNoArgs(t *testing.T) {
+	config := &Config{PackagePath: ".", OutputFile: "/dev/null", PackageName: "test"}
+	g, _ := NewGenerator(config)
+	wrapper := &FunctionWrapperData{
+		OriginalName:   "Ping",
+		GoFuncName:     "mummy_ping",
+		Arguments:      []ArgConversionInfo{{N: 0, Name: "ctx", Type: "context.Context", IsContext: true}},
+		ParameterNames: []string{"param_ctx"},
+		Results:        []ResultConversionInfo{{Index: 0, Type: "error", Name: "err"}},
+	}
+	if err := g.generateFunctionBody(wrapper, "example/probe"); err != nil {
+		t.Fatal(err)
+	}
+	code := wrapper.GeneratedCode
+	// An unused argIdx would not compile.
+	if strings.Contains(code, "argIdx") {
+		t.Errorf("expected no argIdx when every argument comes from the evaluator, got:\n%s", code)
+	}
+	if !strings.Contains(code, "param_ctx := ev.Context()") || !strings.Contains(code, "probe.Ping(param_ctx)") {
+		t.Errorf("expected Ping to be called with the evaluator's context, got:\n%s", code)
+	}
+	if _, err := parser.ParseFile(token.NewFileSet(), "probe.go", "package probe\n"+code, 0); err != nil {
+		t.Errorf("expected the wrapper to parse: %v\n%s", err, code)
+	}
+}
@@ -63,6 +63,7 @@ type ArgConversionInfo struct {
 	BuiltInType   string
 	FuncSignature *FuncSignatureInfo
 	IsVariadic    bool
+	IsContext     bool // filled from the evaluation context, not from args
 }
 
 type ResultConversionInfo struct {
@@ -163,6 +164,10 @@ func (tm *TypeMapper) initializeTemplates() error {
 }
 
 func (tm *TypeMapper) GenerateArgumentConversion(info ArgConversionInfo, w io.Writer) error {
+	if info.IsContext {
+		fmt.Fprintf(w, "\tparam_%s := ev.Context()\n", info.Name)
+		return nil
+	}
 	if info.IsVariadic {
 		return tm.generateVariadicConversion(info, w)
 	}
@@ -228,7 +233,6 @@ func (tm *TypeMapper) generateFunctionAdapter(info ArgConversionInfo, w io.Write
 	fmt.Fprintf(w, "\tif ghoulArg_%s.FuncVal == nil {\n", name)
 	fmt.Fprintf(w, "\t\treturn nil, _fmt.Errorf(\"expected function for parameter '%s', got %%s\", _e.NodeTypeName(ghoulArg_%s))\n", name, name)
 	fmt.Fprintf(w, "\t}\n")
-	fmt.Fprintf(w, "\tghoulFunc_%s := ghoulArg_%s.FuncVal\n", name, name)
 
 	// Build the Go function adapter
 	fmt.Fprintf(w, "\tparam_%s := %s{\n", name, info.Type)
@@ -244,13 +248,13 @@ func (tm *TypeMapper) generateFunctionAdapter(info ArgConversionInfo, w io.Write
 	}
 
 	if len(sig.Results) == 0 {
-		fmt.Fprintf(w, "\t\t(*ghoulFunc_%s)(ghoulArgs, ev)\n", name)
+		fmt.Fprintf(w, "\t\tev.Apply(ev.Context(), ghoulArg_%s, ghoulArgs)\n", name)
 	} else if len(sig.Results) == 1 {
-		fmt.Fprintf(w, "\t\tresult, _ := (*ghoulFunc_%s)(ghoulArgs, ev)\n", name)
+		fmt.Fprintf(w, "\t\tresult, _ := ev.Apply(ev.Context(), ghoulArg_%s, ghoulArgs)\n", name)
 		r := sig.Results[0]
 		fmt.Fprintf(w, "\t\treturn %s\n", tm.ghoulToGoConversion("result", r))
 	} else {
-		fmt.Fprintf(w, "\t\tresult, _ := (*ghoulFunc_%s)(ghoulArgs, ev)\n", name)
+		fmt.Fprintf(w, "\t\tresult, _ := ev.Apply(ev.Context(), ghoulArg_%s, ghoulArgs)\n", name)
 		for i, r := range sig.Results {
 			varName := fmt.Sprintf("goResult%d", i)
 			fmt.Fprintf(w, "\t\t%s := %s\n", varName, tm.ghoulToGoConversion(fmt.Sprintf("result.Children[%d]", i), r))
@@ -426,6 +430,17 @@ func (tm *TypeMapper) UnsupportedTypeReason(t types.Type) string {
 	return ""
 }
 
+// IsContext reports whether t is context.Context. A leading context
+// parameter is supplied by the wrapper instead of the Ghoul caller.
+func (tm *TypeMapper) IsContext(t types.Type) bool {
+	named, ok := t.(*types.Named)
+	if !ok {
+		return false
+	}
+	obj := named.Obj()
+	return obj.Pkg() != nil && obj.Pkg().Path() == "context" && obj.Name() == "Context"
+}
+
 func (tm *TypeMapper) isPrimitiveType(t types.Type) bool {
 	_, exists := tm.primitiveMap[t.String()]
 	return exists
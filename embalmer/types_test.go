@@ -164,6 +164,28 @@ func TestFunctionTypeTemplateGeneratesAdapter(t *testing.T) {
 	}
 }
 
+func TestFunctionTypeAdapterCallsThroughApply(t *testing.T) {
+	tm, _ := NewTypeMapper()
+	var buf bytes.Buffer
+	err := tm.GenerateArgumentConversion(ArgConversionInfo{
+		N:    0,
+		Name: "callback",
+		Type: "func(int) int",
+		FuncSignature: &FuncSignatureInfo{
+			Params:  []FuncParamInfo{{Type: "int", GhoulType: "Integer"}},
+			Results: []FuncParamInfo{{Type: "int", GhoulType: "Integer"}},
+		},
+	}, &buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	code := buf.String()
+	if !strings.Contains(code, "ev.Apply(") || !strings.Contains(code, "ghoulArg_callback, ghoulArgs)") {
+		t.Errorf("expected adapter to call the Ghoul function through ev.Apply, got:\n%s", code)
+	}
+}
+
 func TestFunctionTypeTemplateVoidReturn(t *testing.T) {
 	tm, _ := NewTypeMapper()
 	var buf bytes.Buffer
@@ -0,0 +1,60 @@
+package ghoul
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	e "github.com/archevel/ghoul/bones"
+)
+
+func TestStartRunsAScriptAcrossTicks(t *testing.T) {
+	g := New()
+	var moves []int64
+	if err := g.Define("move", func(dx int64) { moves = append(moves, dx) }); err != nil {
+		t.Fatal(err)
+	}
+	x, err := g.Start(context.Background(), strings.NewReader(`
+(define patrol
+  (lambda (n)
+    (when (> n 0)
+      (move (yield 'walking))
+      (patrol (- n 1)))))
+(patrol 3)
+'arrived`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for tick := int64(1); !x.Done(); tick++ {
+		res, err := x.Step(1000)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if x.Yielded() {
+			if res.Repr() != "walking" {
+				t.Fatalf("expected the script to yield walking, got %s", res.Repr())
+			}
+			x.Resume(e.IntNode(tick))
+		} else if x.Done() && res.Repr() != "arrived" {
+			t.Errorf("expected the script to arrive, got %s", res.Repr())
+		}
+	}
+	if len(moves) != 3 || moves[0] != 1 || moves[2] != 3 {
+		t.Errorf("expected a move for each tick, got %v", moves)
+	}
+}
+
+func TestYieldFailsUnderMap(t *testing.T) {
+	g := New()
+	x, err := g.Start(context.Background(), strings.NewReader(`(map yield '(1 2))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := x.Step(0); err == nil || !strings.Contains(err.Error(), "cannot suspend") {
+		t.Errorf("expected yield to fail inside map, got %v", err)
+	}
+	if _, err := g.Process(strings.NewReader(`(yield 1)`)); err == nil {
+		t.Error("expected yield to fail under Process")
+	}
+}
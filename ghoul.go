@@ -1,18 +1,23 @@
 package ghoul
 
 import (
+	"bytes"
 	"context"
 	_ "embed"
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 
 	ev "github.com/archevel/ghoul/consume"
 	"github.com/archevel/ghoul/reanimator"
 	e "github.com/archevel/ghoul/bones"
 	"github.com/archevel/ghoul/engraving"
 	"github.com/archevel/ghoul/exhumer"
+	"github.com/archevel/ghoul/ossuary"
 )
 
 //go:embed prelude/prelude.ghl
@@ -23,10 +28,119 @@ func PreludeSource() string {
 	return preludeSource
 }
 
+// Ghoul is an interpreter instance. Once configured it may be used from
+// several goroutines: Process calls expand one at a time but evaluate in
+// parallel, and procedures handed to Go, such as HTTP handlers, may be
+// called concurrently. Bindings are shared, so concurrent define and set!
+// of one name race only as Ghoul code, each taking effect whole.
 type Ghoul interface {
 	Process(exprReader io.Reader) (*e.Node, error)
 	ProcessFile(filename string) (*e.Node, error)
 	ProcessWithContext(ctx context.Context, exprReader io.Reader, filename *string) (*e.Node, error)
+	// UseExpansionCache makes required modules load their expansion from
+	// cache when unchanged, and store it after expanding otherwise. Use
+	// the cache's Invalidate or Clear methods to drop entries.
+	UseExpansionCache(cache *ossuary.Cache)
+	// CompileFile expands and compiles the module at src and writes the
+	// bytecode to dst. ProcessFile and require load the result in place
+	// of the source.
+	CompileFile(src, dst string) error
+	// SetDisassemblyOutput makes every later Process or ProcessFile call
+	// write the bytecode listing of its code to w before running it. A
+	// nil w turns the listing off.
+	SetDisassemblyOutput(w io.Writer)
+	// SetOptimize turns the bytecode optimizer on or off for later
+	// Process, ProcessFile and CompileFile calls and the modules they
+	// require. It is on by default.
+	SetOptimize(enabled bool)
+	// SetLimits bounds the instructions, call depth, stack and allocation
+	// of each later Process, ProcessFile or CompileFile call, macro
+	// expansion included. Running past a limit fails the call with an
+	// error wrapping a LimitError. The zero Limits removes all bounds.
+	SetLimits(limits Limits)
+	// Define makes the Go function fn callable from Ghoul as name.
+	// Arguments and results convert as they do for embalmed packages:
+	// booleans, strings, integers and floats map to Ghoul values, Ghoul
+	// lists fill slices, other Go values travel as mummies, and func
+	// parameters accept Ghoul procedures. A leading context.Context
+	// receives the evaluation's context, a trailing error result fails
+	// the call, and several results are returned as a list.
+	Define(name string, fn any) error
+	// DefineValue binds name to v, converted as a result of Define is.
+	DefineValue(name string, v any) error
+	// Lookup returns the value bound to name at top level.
+	Lookup(name string) (*e.Node, error)
+	// Call applies the procedure bound to name to args, converted as the
+	// results of a function passed to Define are; Go funcs become
+	// procedures. It runs under ctx and the limits set with SetLimits.
+	Call(ctx context.Context, name string, args ...any) (*e.Node, error)
+	// Snapshot captures the instance's top-level bindings, the closures
+	// and compiled code they hold, its macros and the mummies it required,
+	// for Restore to start another instance from without running the code
+	// that built them. Go functions and values are recorded by the names
+	// they are bound to, not stored; one bound to no name fails the
+	// snapshot with an error wrapping ErrUnserializable.
+	Snapshot() ([]byte, error)
+	// Restore replaces the instance's bindings and macros with those of a
+	// snapshot. Go functions and values in it are bound again to what the
+	// instance, or a mummy the snapshot required, binds their names to;
+	// Restore fails, changing nothing, if one is missing.
+	Restore(data []byte) error
+	// Start expands and compiles the code read from exprReader and
+	// returns an Execution that runs it a step at a time under ctx, for
+	// hosts that share a goroutine between scripts. The code may call
+	// yield to end a step with a value. SetLimits bounds all its steps
+	// together.
+	Start(ctx context.Context, exprReader io.Reader) (*Execution, error)
+}
+
+// Execution is code started with Start. Step runs it for a number of
+// instructions, until it yields or until it finishes; Resume sets the
+// value the pending yield returns.
+type Execution = ev.Execution
+
+// ErrUnserializable is matched by the error of a Snapshot holding a Go
+// value it cannot name.
+var ErrUnserializable = ev.ErrUnserializable
+
+// ErrPanic is matched by the error of an evaluation that called a Go
+// function which panicked. The panic is recovered so a script cannot take
+// the host down with it.
+var ErrPanic = ev.ErrPanic
+
+// Limits bounds the resources a single evaluation may use; see SetLimits.
+type Limits = ev.Limits
+
+// LimitError names the limit an evaluation ran past. Use errors.As to get
+// it from an error, or errors.Is with ErrLimitExceeded to test for any.
+type LimitError = ev.LimitError
+
+// ErrLimitExceeded is matched by every LimitError.
+var ErrLimitExceeded = ev.ErrLimitExceeded
+
+// Limit names one of the fields of Limits.
+type Limit = ev.Limit
+
+// The limits a LimitError can name.
+const (
+	LimitFuel  = ev.LimitFuel
+	LimitDepth = ev.LimitDepth
+	LimitStack = ev.LimitStack
+	LimitAlloc = ev.LimitAlloc
+	// LimitOutput is reported when a Sandbox's MaxOutput is reached.
+	LimitOutput = ev.LimitOutput
+)
+
+// Stats counts what evaluations did: instructions, calls, stack and
+// frame depth, allocated nodes, time in Go functions by name and time in
+// macro expansion. See CollectStats.
+type Stats = ev.Stats
+
+// CollectStats returns a copy of ctx that makes ProcessWithContext, Call
+// and Start add the counts of the evaluations they run under it to stats.
+// Evaluations run without one keep no counts.
+func CollectStats(ctx context.Context, stats *Stats) context.Context {
+	return ev.CollectStats(ctx, stats)
 }
 
 // New creates a Ghoul instance with the standard prelude loaded.
@@ -36,34 +150,93 @@ func New() Ghoul {
 
 // NewBare creates a Ghoul instance without the prelude.
 func NewBare() Ghoul {
-	return newGhoul(engraving.StandardLogger, false)
+	return newGhoul(options{logger: engraving.StandardLogger, noPrelude: true})
 }
 
 // NewBareWithLogger creates a Ghoul instance without the prelude, using the given logger.
 func NewBareWithLogger(logger engraving.Logger) Ghoul {
-	return newGhoul(logger, false)
+	return newGhoul(options{logger: logger, noPrelude: true})
 }
 
 func NewLoggingGhoul(logger engraving.Logger) Ghoul {
-	return newGhoul(logger, true)
+	return newGhoul(options{logger: logger})
 }
 
-func newGhoul(logger engraving.Logger, loadPrelude bool) Ghoul {
+func newGhoul(o options) Ghoul {
 	var markCounter uint64
-	exp := reanimator.New(logger, &markCounter)
+	exp := reanimator.New(o.logger, &markCounter)
 	// The evaluator shares the reanimator's environment so that bindings
 	// from require (loaded during expansion) are visible at runtime.
-	evaluator := ev.NewWithMarkCounter(logger, exp.EvalEnv(), &markCounter)
-	g := ghoul{reanimator: exp, evaluator: evaluator}
-	if loadPrelude {
-		g.Process(strings.NewReader(preludeSource))
+	evaluator := ev.NewWithMarkCounter(o.logger, exp.EvalEnv(), &markCounter)
+	g := ghoul{reanimator: exp, evaluator: evaluator, modulePaths: o.modulePaths, files: o.files, expanding: &sync.Mutex{}}
+	g.configure(o)
+	if len(g.modulePaths) > 0 || g.files != nil {
+		// Let code without a file of its own require from the paths.
+		g.useModuleState("")
+	}
+	if !o.noPrelude {
+		g.processPrelude()
 	}
 	return g
 }
 
+// preludeFilename labels prelude source positions so macro expansion
+// backtraces can tell prelude templates apart from user code.
+var preludeFilename = "prelude.ghl"
+
+// processPrelude evaluates the embedded prelude. Unlike ProcessFile it
+// leaves the module state alone, since the prelude is not on disk and
+// must not become the base for resolving requires.
+func (g ghoul) processPrelude() {
+	_, parsed := exhumer.ParseWithFilename(strings.NewReader(preludeSource), &preludeFilename)
+	boneNodes, err := g.reanimator.ReanimateNodes(parsed.Expressions)
+	if err != nil {
+		return
+	}
+	g.evaluator.ConsumeNodes(boneNodes)
+}
+
 type ghoul struct {
 	reanimator *reanimator.Reanimator
 	evaluator  *ev.Evaluator
+	// modulePaths are searched by require after the requiring file's
+	// directory; see WithModulePaths.
+	modulePaths []string
+	// files, when set, is where require reads Ghoul modules from instead
+	// of the OS; see Sandbox.
+	files fs.FS
+	// expanding serializes macro expansion, which keeps its state on the
+	// reanimator. Evaluation runs outside it, so code processed from
+	// several goroutines runs in parallel.
+	expanding *sync.Mutex
+}
+
+// useModuleState makes requires resolve relative to filename, then the
+// instance's module paths.
+func (g ghoul) useModuleState(filename string) {
+	ms := ev.NewModuleState(filename)
+	ms.SetSearchPaths(g.modulePaths)
+	ms.SetFS(g.files)
+	g.reanimator.SetModuleState(ms)
+	g.reanimator.SetModuleLoader(makeModuleLoader(g.reanimator))
+}
+
+func (g ghoul) UseExpansionCache(cache *ossuary.Cache) {
+	g.reanimator.SetExpansionCache(cache)
+}
+
+func (g ghoul) SetDisassemblyOutput(w io.Writer) {
+	g.evaluator.SetDisassemblyOutput(w)
+}
+
+func (g ghoul) SetOptimize(enabled bool) {
+	g.evaluator.SetOptimize(enabled)
+	g.reanimator.Evaluator().SetOptimize(enabled)
+}
+
+func (g ghoul) SetLimits(limits Limits) {
+	g.evaluator.SetLimits(limits)
+	g.reanimator.Evaluator().SetLimits(limits)
 }
 
 func (g ghoul) Process(exprReader io.Reader) (*e.Node, error) {
@@ -71,6 +244,9 @@ func (g ghoul) Process(exprReader io.Reader) (*e.Node, error) {
 }
 
 func (g ghoul) ProcessFile(filename string) (*e.Node, error) {
+	if isCompiled(filename) {
+		return g.processCompiled(context.Background(), filename)
+	}
 	f, err := os.Open(filename)
 	if err != nil {
 		return nil, err
@@ -85,12 +261,12 @@ func (g ghoul) ProcessWithContext(ctx context.Context, exprReader io.Reader, fil
 		return nil, fmt.Errorf("failed to parse Lisp code: parse result %d", parseRes)
 	}
 
+	g.expanding.Lock()
 	if filename != nil {
-		g.reanimator.SetModuleState(ev.NewModuleState(*filename))
-		g.reanimator.SetModuleLoader(makeModuleLoader(g.reanimator))
+		g.useModuleState(*filename)
 	}
-
-	boneNodes, err := g.reanimator.ReanimateNodes(parsed.Expressions)
+	boneNodes, err := g.reanimator.ReanimateNodesWithContext(ctx, parsed.Expressions)
+	g.expanding.Unlock()
 	if err != nil {
 		return nil, fmt.Errorf("failed to expand macros: %w", err)
 	}
@@ -102,40 +278,129 @@ func (g ghoul) ProcessWithContext(ctx context.Context, exprReader io.Reader, fil
 	return result, nil
 }
 
+func (g ghoul) Start(ctx context.Context, exprReader io.Reader) (*Execution, error) {
+	parseRes, parsed := exhumer.Parse(exprReader)
+	if parseRes != 0 {
+		return nil, fmt.Errorf("failed to parse Lisp code: parse result %d", parseRes)
+	}
+
+	g.expanding.Lock()
+	boneNodes, err := g.reanimator.ReanimateNodesWithContext(ctx, parsed.Expressions)
+	g.expanding.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand macros: %w", err)
+	}
+
+	code, err := g.evaluator.Compile(boneNodes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compile Lisp code: %w", err)
+	}
+	return g.evaluator.StartWithContext(ctx, code), nil
+}
+
+// isCompiled reports whether path names a .ghc bytecode file.
+func isCompiled(path string) bool {
+	return filepath.Ext(path) == ".ghc"
+}
+
+// processCompiled runs a .ghc file: its recorded macro definitions and
+// requires are replayed, then its code runs in the top-level environment.
+func (g ghoul) processCompiled(ctx context.Context, filename string) (*e.Node, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	m, err := ev.ReadCompiled(data, g.reanimator.FreshMark)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", filename, err)
+	}
+
+	g.expanding.Lock()
+	g.useModuleState(filename)
+	err = g.reanimator.ReplayEffects(m.Effects)
+	g.expanding.Unlock()
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand macros: %w", err)
+	}
+
+	result, err := g.evaluator.RunCode(ctx, m.Code)
+	if err != nil {
+		return nil, fmt.Errorf("failed to process Lisp code: %w", err)
+	}
+	return result, nil
+}
+
+func (g ghoul) CompileFile(src, dst string) error {
+	data, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	parsed, err := parseModule(src, data)
+	if err != nil {
+		return err
+	}
+
+	g.expanding.Lock()
+	g.useModuleState(src)
+	forms, effects, err := g.reanimator.ExpandRecording(parsed)
+	g.expanding.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to expand macros in %s: %w", src, err)
+	}
+	boneNodes, err := reanimator.TranslateNodes(forms)
+	if err != nil {
+		return fmt.Errorf("failed to expand macros in %s: %w", src, err)
+	}
+	code, err := g.evaluator.Compile(boneNodes)
+	if err != nil {
+		return fmt.Errorf("failed to compile %s: %w", src, err)
+	}
+
+	var buf bytes.Buffer
+	if err := ev.WriteCompiled(&buf, &ev.CompiledModule{Effects: effects, Code: code}); err != nil {
+		return fmt.Errorf("failed to compile %s: %w", src, err)
+	}
+	return os.WriteFile(dst, buf.Bytes(), 0644)
+}
+
 // makeModuleLoader creates a loader that processes Ghoul module files
 // through the full pipeline: parse → reanimate → evaluate → extract exports.
 func makeModuleLoader(r *reanimator.Reanimator) reanimator.ModuleLoader {
 	return func(filePath string, parentReanimator *reanimator.Reanimator) (*ev.ModuleExports, error) {
-		f, err := os.Open(filePath)
+		src, err := parentReanimator.ModuleState().ReadFile(filePath)
 		if err != nil {
 			return nil, fmt.Errorf("failed to open %s: %w", filePath, err)
 		}
-		defer f.Close()
-
-		parseRes, parsed := exhumer.ParseWithFilename(f, &filePath)
-		if parseRes != 0 {
-			return nil, fmt.Errorf("failed to parse %s", filePath)
-		}
 
 		// Push a fresh macro scope for module isolation, then pop after
 		savedScopes := parentReanimator.PushModuleScope()
-		boneNodes, err := parentReanimator.ReanimateNodes(parsed.Expressions)
+		var run func(*ev.Evaluator) error
+		if isCompiled(filePath) {
+			var code *ev.CodeObject
+			code, err = replayCompiled(parentReanimator, filePath, src)
+			run = func(moduleEval *ev.Evaluator) error {
+				_, err := moduleEval.RunCode(parentReanimator.Context(), code)
+				return err
+			}
+		} else {
+			var boneNodes []*e.Node
+			boneNodes, err = expandModule(parentReanimator, filePath, src)
+			run = func(moduleEval *ev.Evaluator) error {
+				_, err := moduleEval.ConsumeNodesWithContext(parentReanimator.Context(), boneNodes)
+				return err
+			}
+		}
 		macros := parentReanimator.ExportMacros()
 		parentReanimator.PopModuleScope(savedScopes)
 		if err != nil {
-			return nil, fmt.Errorf("failed to expand macros in %s: %w", filePath, err)
+			return nil, err
 		}
 
 		// Evaluate in a module environment to get runtime exports
 		moduleEnv := ev.NewModuleEnvironment(parentReanimator.EvalEnv())
-		moduleEval := ev.NewWithMarkCounter(
-			parentReanimator.Evaluator().Log(),
-			moduleEnv,
-			parentReanimator.Evaluator().MarkCounter(),
-		)
+		moduleEval := parentReanimator.Evaluator().ForEnvironment(moduleEnv)
 
-		_, err = moduleEval.ConsumeNodes(boneNodes)
-		if err != nil {
+		if err := run(moduleEval); err != nil {
 			return nil, err
 		}
 
@@ -145,3 +410,64 @@ func makeModuleLoader(r *reanimator.Reanimator) reanimator.ModuleLoader {
 	}
 }
 
+// expandModule parses and expands a module in the current (fresh) macro
+// scope, going through the expansion cache when one is configured.
+func expandModule(r *reanimator.Reanimator, filePath string, src []byte) ([]*e.Node, error) {
+	cache := r.ExpansionCache()
+	if cache == nil {
+		parsed, err := parseModule(filePath, src)
+		if err != nil {
+			return nil, err
+		}
+		boneNodes, err := r.ReanimateNodes(parsed)
+		if err != nil {
+			return nil, fmt.Errorf("failed to expand macros in %s: %w", filePath, err)
+		}
+		return boneNodes, nil
+	}
+
+	key := ossuary.Key{Path: filePath, Content: src, Version: version(), MacroSet: r.MacroFingerprint()}
+	if entry, ok := cache.Load(key, r.FreshMark); ok {
+		if err := r.ReplayEffects(entry.Effects); err == nil && r.MacroFingerprint() == entry.MacroSet {
+			return reanimator.TranslateNodes(entry.Forms)
+		}
+		// A required module changed the macros this one was expanded
+		// with; start over from a clean scope.
+		r.ResetModuleScope()
+	}
+
+	parsed, err := parseModule(filePath, src)
+	if err != nil {
+		return nil, err
+	}
+	forms, effects, err := r.ExpandRecording(parsed)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expand macros in %s: %w", filePath, err)
+	}
+	entry := &ossuary.Entry{Effects: effects, Forms: forms, MacroSet: r.MacroFingerprint()}
+	if err := cache.Store(key, entry); err != nil {
+		r.Evaluator().Log().Debug("expansion not cached", filePath, err)
+	}
+	return reanimator.TranslateNodes(forms)
+}
+
+// replayCompiled decodes a .ghc module and replays its macro definitions
+// and requires in the current (fresh) macro scope.
+func replayCompiled(r *reanimator.Reanimator, filePath string, data []byte) (*ev.CodeObject, error) {
+	m, err := ev.ReadCompiled(data, r.FreshMark)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load %s: %w", filePath, err)
+	}
+	if err := r.ReplayEffects(m.Effects); err != nil {
+		return nil, fmt.Errorf("failed to expand macros in %s: %w", filePath, err)
+	}
+	return m.Code, nil
+}
+
+func parseModule(filePath string, src []byte) (*e.Node, error) {
+	parseRes, parsed := exhumer.ParseWithFilename(bytes.NewReader(src), &filePath)
+	if parseRes != 0 {
+		return nil, fmt.Errorf("failed to parse %s", filePath)
+	}
+	return parsed.Expressions, nil
+}
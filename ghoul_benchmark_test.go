@@ -144,3 +144,79 @@ func BenchmarkParseOnly(b *testing.B) {
 		_ = result
 	}
 }
+
+// BenchmarkGlobalLookups calls builtins and user top-level procedures from
+// a hot loop, so most of its time goes to resolving global names. The
+// mutating case also assigns a global on every step.
+func BenchmarkGlobalLookups(b *testing.B) {
+	code := `
+(define square (lambda (x) (* x x)))
+(define step (lambda (acc x) (+ acc (square x))))
+(define walk (lambda (lst acc)
+  (cond
+    ((null? lst) acc)
+    (else (walk (cdr lst) (step acc (car lst)))))))
+(define numbers (list 1 2 3 4 5 6 7 8 9 10))
+(define repeat (lambda (n acc)
+  (cond
+    ((eq? n 0) acc)
+    (else (repeat (- n 1) (walk numbers acc))))))
+(repeat 200 0)
+`
+	mutating := `
+(define steps 0)
+(define square (lambda (x) (* x x)))
+(define step (lambda (acc x) (set! steps (+ steps 1)) (+ acc (square x))))
+(define walk (lambda (lst acc)
+  (cond
+    ((null? lst) acc)
+    (else (walk (cdr lst) (step acc (car lst)))))))
+(define numbers (list 1 2 3 4 5 6 7 8 9 10))
+(define repeat (lambda (n acc)
+  (cond
+    ((eq? n 0) acc)
+    (else (repeat (- n 1) (walk numbers acc))))))
+(repeat 200 0)
+`
+	for _, bc := range []struct{ name, code string }{{"reading", code}, {"mutating", mutating}} {
+		b.Run(bc.name, func(b *testing.B) {
+			g := New()
+			g.Process(strings.NewReader("1"))
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				result, err := g.Process(strings.NewReader(bc.code))
+				if err != nil {
+					b.Fatal(err)
+				}
+				_ = result
+			}
+		})
+	}
+}
+
+// BenchmarkDeepRecursion measures non-tail calls that nest deeply, with a
+// let in each frame and a closure over the frame's locals.
+func BenchmarkDeepRecursion(b *testing.B) {
+	code := `
+(define depth (lambda (n)
+  (cond
+    ((eq? n 0) 0)
+    (else
+      (let ((m (- n 1)))
+        (define next (lambda () (depth m)))
+        (+ 1 (next)))))))
+(depth 5000)
+`
+	g := New()
+	g.Process(strings.NewReader("1"))
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		result, err := g.Process(strings.NewReader(code))
+		if err != nil {
+			b.Fatal(err)
+		}
+		_ = result
+	}
+}
@@ -135,4 +135,40 @@ func TestProcessWithContextParseError(t *testing.T) {
 	if !strings.Contains(err.Error(), "parse") {
 		t.Errorf("Expected parse error message, but got: %v", err)
 	}
-}
\ No newline at end of file
+}
+func TestProcessWithContextTimeoutStopsMacroTransformer(t *testing.T) {
+	ghoul := New()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	program := `
+		(define-macro (stuck)
+		  (define spin (lambda (n) (spin (+ n 1))))
+		  (spin 0))
+		(stuck)
+	`
+	_, err := ghoul.ProcessWithContext(ctx, strings.NewReader(program), nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded from the macro transformer, but got: %v", err)
+	}
+}
+
+func TestProcessWithContextTimeoutStopsHigherOrderBuiltins(t *testing.T) {
+	ghoul := New()
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	program := `
+		(define build (lambda (n acc) (cond ((eq? n 0) acc) (else (build (- n 1) (cons n acc))))))
+		(define items (build 3000 '()))
+		(map (lambda (x) (map (lambda (y) (+ x y)) items)) items)
+	`
+	start := time.Now()
+	_, err := ghoul.ProcessWithContext(ctx, strings.NewReader(program), nil)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded from map, but got: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected map to stop soon after the deadline, took %s", elapsed)
+	}
+}
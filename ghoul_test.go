@@ -1,12 +1,18 @@
 package ghoul
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strconv"
 	"strings"
 	"testing"
 
 	e "github.com/archevel/ghoul/bones"
+	"github.com/archevel/ghoul/ossuary"
 )
 
 // --- Prelude auto-loading tests ---
@@ -767,6 +773,24 @@ func TestMacroExpansionErrorIncludesMacroName(t *testing.T) {
 	}
 }
 
+func TestErrorInNestedExpansionShowsBacktrace(t *testing.T) {
+	tmpFile := t.TempDir() + "/nested.ghl"
+	os.WriteFile(tmpFile, []byte(
+		"(define-syntax inner (syntax-rules () ((inner) (car 1))))\n"+
+			"(define-syntax outer (syntax-rules () ((outer) (let* ((y 2)) (inner)))))\n"+
+			"(outer)\n"), 0644)
+
+	g := New()
+	_, err := g.ProcessFile(tmpFile)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	want := "in expansion of outer at " + tmpFile + ":3:2, from inner at " + tmpFile + ":2:63, template at " + tmpFile + ":1:49"
+	if !strings.Contains(err.Error(), want) {
+		t.Errorf("expected backtrace %q, got:\n%s", want, err.Error())
+	}
+}
+
 func TestScopedIdentifierMacroCallExpansion(t *testing.T) {
 	g := New()
 	res, err := g.Process(strings.NewReader(
@@ -1073,3 +1097,597 @@ func TestSetViaExportedSetterAffectsExporter(t *testing.T) {
 		t.Errorf("expected 99 (A's foo changed via setter), got %s", result.Repr())
 	}
 }
+
+func TestHygienicMacroTempDoesNotShadowLambdaParam(t *testing.T) {
+	g := New()
+	in := `
+(define-syntax my-or (syntax-rules () ((_ a b) (let ((t a)) (cond (t t) (else b))))))
+((lambda (t) (my-or #f t)) 7)
+`
+	res, err := g.Process(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !res.Equiv(e.IntNode(7)) {
+		t.Errorf("expected 7 (macro's t must not capture the parameter), got %s", res.Repr())
+	}
+}
+
+// --- define-macro and gensym ---
+
+func TestDefineMacroSwapWithGensym(t *testing.T) {
+	g := New()
+	in := `
+(define-macro (swap! a b)
+  (let ((tmp (gensym "tmp")))
+    (list 'let (list (list tmp a))
+      (list 'set! a b)
+      (list 'set! b tmp))))
+(define tmp 1)
+(define tmp2 2)
+((lambda (x) (swap! tmp x) (swap! tmp tmp2) (list tmp tmp2 x)) 3)
+`
+	res, err := g.Process(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if res.Repr() != "(2 3 1)" {
+		t.Errorf("expected (2 3 1), got %s", res.Repr())
+	}
+}
+
+func TestDefineMacroIsUnhygienic(t *testing.T) {
+	g := New()
+	in := `
+(define-macro (aif test then else)
+  (list 'let (list (list 'it test))
+    (list 'cond (list 'it then) (list 'else else))))
+(aif (+ 40 2) it 0)
+`
+	res, err := g.Process(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !res.Equiv(e.IntNode(42)) {
+		t.Errorf("expected the body to see the macro's 'it', got %s", res.Repr())
+	}
+}
+
+func TestDefineMacroWithRestParamsAndProcedureForm(t *testing.T) {
+	g := New()
+	in := `
+(define-macro (my-begin . forms) (cons 'begin forms))
+(define-macro sum-all (lambda args (cons '+ args)))
+(my-begin 1 2 (sum-all 1 2 3))
+`
+	res, err := g.Process(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !res.Equiv(e.IntNode(6)) {
+		t.Errorf("expected 6, got %s", res.Repr())
+	}
+}
+
+func TestDefineMacroReusesQuotedTemplateAcrossCalls(t *testing.T) {
+	g := New()
+	in := `
+(define-macro (seven) '(+ 3 4))
+(+ (seven) (seven) (seven))
+`
+	res, err := g.Process(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !res.Equiv(e.IntNode(21)) {
+		t.Errorf("expected 21, got %s", res.Repr())
+	}
+}
+
+func TestDefineMacroRejectsNonProcedure(t *testing.T) {
+	g := New()
+	_, err := g.Process(strings.NewReader("(define-macro broken 42)"))
+	if err == nil || !strings.Contains(err.Error(), "must be a procedure") {
+		t.Errorf("expected procedure error, got %v", err)
+	}
+}
+
+func TestRequireDefineMacroFromModule(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "macros.ghl"), []byte(`
+(define-macro (unless-zero n body)
+  (let ((v (gensym)))
+    (list 'let (list (list v n))
+      (list 'cond (list (list 'eq? v 0) 0) (list 'else body)))))
+`), 0644)
+	os.WriteFile(filepath.Join(dir, "main.ghl"), []byte(`
+(require macros as m)
+(m:unless-zero 5 42)
+`), 0644)
+
+	g := New()
+	result, err := g.ProcessFile(filepath.Join(dir, "main.ghl"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !result.Equiv(e.IntNode(42)) {
+		t.Errorf("expected 42, got %s", result.Repr())
+	}
+}
+
+// --- Syntax parameters ---
+
+const whileLoopSource = `
+(define-syntax-parameter break (syntax-rules () ((_) (break-outside-loop))))
+(define-syntax-parameter continue (syntax-rules () ((_) (continue-outside-loop))))
+(define-syntax while (syntax-rules ()
+  ((_ test body ...)
+   (call/ec (lambda (k)
+     (syntax-parameterize ((break (syntax-rules () ((_) (k #f)))))
+       (define loop (lambda ()
+         (cond (test
+                (call/ec (lambda (c)
+                  (syntax-parameterize ((continue (syntax-rules () ((_) (c #f)))))
+                    body ...)))
+                (loop)))))
+       (loop)))))))
+`
+
+func TestSyntaxParameterWhileWithBreakAndContinue(t *testing.T) {
+	g := New()
+	in := whileLoopSource + `
+(define i 0)
+(define sum 0)
+(define k 100)
+(define c 200)
+(while #t
+  (set! i (+ i 1))
+  (cond ((> i 10) (break)))
+  (cond ((eq? (- i (* 2 (/ i 2))) 1) (continue)))
+  (set! sum (+ sum i)))
+(list i sum k c)
+`
+	res, err := g.Process(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if res.Repr() != "(11 30 100 200)" {
+		t.Errorf("expected (11 30 100 200), got %s", res.Repr())
+	}
+}
+
+func TestSyntaxParameterNestedLoopsBreakInnermost(t *testing.T) {
+	g := New()
+	in := whileLoopSource + `
+(define i 0)
+(define hits 0)
+(while (< i 3)
+  (set! i (+ i 1))
+  (define j 0)
+  (while #t
+    (set! j (+ j 1))
+    (cond ((> j 2) (break)))
+    (set! hits (+ hits 1))))
+hits
+`
+	res, err := g.Process(strings.NewReader(in))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !res.Equiv(e.IntNode(6)) {
+		t.Errorf("expected 6, got %s", res.Repr())
+	}
+}
+
+func TestSyntaxParameterDefaultOutsideParameterize(t *testing.T) {
+	g := New()
+	_, err := g.Process(strings.NewReader(whileLoopSource + "(break)"))
+	if err == nil || !strings.Contains(err.Error(), "break-outside-loop") {
+		t.Errorf("expected default transformer to apply outside a loop, got %v", err)
+	}
+}
+
+func TestSyntaxParameterizeRejectsOrdinaryMacro(t *testing.T) {
+	g := New()
+	in := `
+(define-syntax plain (syntax-rules () ((_) 1)))
+(syntax-parameterize ((plain (syntax-rules () ((_) 2)))) (plain))
+`
+	_, err := g.Process(strings.NewReader(in))
+	if err == nil || !strings.Contains(err.Error(), "plain is not a syntax parameter") {
+		t.Errorf("expected not-a-syntax-parameter error, got %v", err)
+	}
+}
+
+// --- Expansion cache ---
+
+func newCachedGhoul(t *testing.T, cache *ossuary.Cache) Ghoul {
+	t.Helper()
+	g := New()
+	g.UseExpansionCache(cache)
+	return g
+}
+
+func TestRequireReusesCachedExpansionAcrossInstances(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := ossuary.Open(filepath.Join(dir, "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The gensym's printed name records the mark counter at expansion
+	// time, so it only repeats when the expansion itself is reused.
+	os.WriteFile(filepath.Join(dir, "gen.ghl"), []byte(`
+(define-macro (fresh-name) (list 'quote (gensym)))
+(define sym (fresh-name))
+`), 0644)
+	os.WriteFile(filepath.Join(dir, "main.ghl"), []byte("(require gen) gen:sym"), 0644)
+	main := filepath.Join(dir, "main.ghl")
+
+	first, err := newCachedGhoul(t, cache).ProcessFile(main)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	bumpMarks := func(g Ghoul) {
+		if _, err := g.Process(strings.NewReader("(let ((a 1)) (let* ((b a)) b))")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	g := newCachedGhoul(t, cache)
+	bumpMarks(g)
+	second, err := g.ProcessFile(main)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if second.Repr() != first.Repr() {
+		t.Errorf("expected cached expansion %s, got a fresh one %s", first.Repr(), second.Repr())
+	}
+
+	if err := cache.Invalidate(filepath.Join(dir, "gen.ghl")); err != nil {
+		t.Fatal(err)
+	}
+	g = newCachedGhoul(t, cache)
+	bumpMarks(g)
+	third, err := g.ProcessFile(main)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if third.Repr() == first.Repr() {
+		t.Errorf("expected a fresh expansion after Invalidate, got %s again", third.Repr())
+	}
+}
+
+func TestCachedModuleStillExportsMacros(t *testing.T) {
+	dir := t.TempDir()
+	cache, _ := ossuary.Open(filepath.Join(dir, "cache"))
+	os.WriteFile(filepath.Join(dir, "macros.ghl"), []byte(`
+(define-syntax add1 (syntax-rules () ((add1 x) (+ x 1))))
+(define-macro (twice e) (list 'begin e e))
+(define base 40)
+`), 0644)
+	os.WriteFile(filepath.Join(dir, "main.ghl"), []byte(`
+(require macros as m)
+(define n m:base)
+(m:twice (set! n (m:add1 n)))
+n
+`), 0644)
+
+	for run := 1; run <= 2; run++ {
+		result, err := newCachedGhoul(t, cache).ProcessFile(filepath.Join(dir, "main.ghl"))
+		if err != nil {
+			t.Fatalf("run %d: unexpected error: %v", run, err)
+		}
+		if !result.Equiv(e.IntNode(42)) {
+			t.Errorf("run %d: expected 42, got %s", run, result.Repr())
+		}
+	}
+}
+
+func TestCachedModuleReexpandsWhenImportedMacroChanges(t *testing.T) {
+	dir := t.TempDir()
+	cache, _ := ossuary.Open(filepath.Join(dir, "cache"))
+	writeB := func(v int) {
+		src := "(define-syntax val (syntax-rules () ((_) " + strconv.Itoa(v) + ")))"
+		os.WriteFile(filepath.Join(dir, "b.ghl"), []byte(src), 0644)
+	}
+	writeB(1)
+	os.WriteFile(filepath.Join(dir, "a.ghl"), []byte("(require b) (define x (b:val))"), 0644)
+	os.WriteFile(filepath.Join(dir, "main.ghl"), []byte("(require a) a:x"), 0644)
+
+	for _, want := range []int{1, 2} {
+		writeB(want)
+		result, err := newCachedGhoul(t, cache).ProcessFile(filepath.Join(dir, "main.ghl"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !result.Equiv(e.IntNode(int64(want))) {
+			t.Errorf("expected %d, got %s", want, result.Repr())
+		}
+	}
+}
+
+func TestCachedExpansionKeepsErrorLocations(t *testing.T) {
+	dir := t.TempDir()
+	cache, _ := ossuary.Open(filepath.Join(dir, "cache"))
+	modFile := filepath.Join(dir, "bad.ghl")
+	os.WriteFile(modFile, []byte("(define-syntax oops (syntax-rules () ((_ x) (+ x missing))))\n(define y (oops 1))\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "main.ghl"), []byte("(require bad)"), 0644)
+
+	var msgs []string
+	for run := 0; run < 2; run++ {
+		_, err := newCachedGhoul(t, cache).ProcessFile(filepath.Join(dir, "main.ghl"))
+		if err == nil {
+			t.Fatal("expected an error")
+		}
+		msgs = append(msgs, err.Error())
+	}
+	if !strings.Contains(msgs[0], modFile+":2:12 in expansion of 'oops'") {
+		t.Errorf("expected expansion location in error, got %s", msgs[0])
+	}
+	if msgs[0] != msgs[1] {
+		t.Errorf("cached run reported a different error:\n%s\nvs\n%s", msgs[0], msgs[1])
+	}
+}
+
+// --- Compiled modules ---
+
+func TestCompiledFileRunsWithoutSource(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.ghl")
+	os.WriteFile(src, []byte(`
+(define-syntax add1 (syntax-rules () ((add1 x) (+ x 1))))
+(define make-adder (lambda (n) (lambda (x) (+ x n))))
+(add1 ((make-adder 40) 1))
+`), 0644)
+	dst := filepath.Join(dir, "main.ghc")
+	if err := New().CompileFile(src, dst); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	os.Remove(src)
+
+	result, err := New().ProcessFile(dst)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !result.Equiv(e.IntNode(42)) {
+		t.Errorf("expected 42, got %s", result.Repr())
+	}
+}
+
+func TestCompiledFileReplaysItsRequires(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "utils.ghl"), []byte("(define x 41)"), 0644)
+	src := filepath.Join(dir, "main.ghl")
+	os.WriteFile(src, []byte("(require utils) (+ utils:x 1)"), 0644)
+	dst := filepath.Join(dir, "main.ghc")
+	if err := New().CompileFile(src, dst); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	result, err := New().ProcessFile(dst)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !result.Equiv(e.IntNode(42)) {
+		t.Errorf("expected 42, got %s", result.Repr())
+	}
+}
+
+func TestRequireLoadsCompiledModuleWithMacros(t *testing.T) {
+	dir := t.TempDir()
+	lib := filepath.Join(dir, "macros.ghl")
+	os.WriteFile(lib, []byte(`
+(define-syntax add1 (syntax-rules () ((add1 x) (+ x 1))))
+(define-macro (twice e) (list 'begin e e))
+(define base 40)
+`), 0644)
+	if err := New().CompileFile(lib, filepath.Join(dir, "macros.ghc")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	os.Remove(lib)
+	os.WriteFile(filepath.Join(dir, "main.ghl"), []byte(`
+(require macros as m)
+(define n m:base)
+(m:twice (set! n (m:add1 n)))
+n
+`), 0644)
+
+	result, err := New().ProcessFile(filepath.Join(dir, "main.ghl"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !result.Equiv(e.IntNode(42)) {
+		t.Errorf("expected 42, got %s", result.Repr())
+	}
+}
+
+func TestRequireRejectsCorruptCompiledModule(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "broken.ghc"), []byte("GHC\x00\x01\x05"), 0644)
+	os.WriteFile(filepath.Join(dir, "main.ghl"), []byte("(require broken)"), 0644)
+
+	_, err := New().ProcessFile(filepath.Join(dir, "main.ghl"))
+	if err == nil || !strings.Contains(err.Error(), "broken.ghc: malformed bytecode") {
+		t.Errorf("expected a malformed bytecode error, got %v", err)
+	}
+}
+
+// --- Disassembly ---
+
+func TestSetDisassemblyOutputListsProcessedCode(t *testing.T) {
+	var buf bytes.Buffer
+	g := New()
+	g.SetDisassemblyOutput(&buf)
+	result, err := g.Process(strings.NewReader("(define n 41) (+ n 1)"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !result.Equiv(e.IntNode(42)) {
+		t.Errorf("expected 42, got %s", result.Repr())
+	}
+	for _, want := range []string{"== top-level ==", "OP_DEFINE         1     ; n", "OP_INT_ADD"} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("expected %q in listing:\n%s", want, buf.String())
+		}
+	}
+}
+
+// --- Optimizer ---
+
+func TestOptimizerMatchesUnoptimizedResults(t *testing.T) {
+	programs := []string{
+		`(let ((x (+ 1 2)) (y (* 3 4))) (list x y (- y x)))`,
+		`(define f (lambda (n) (let* ((a (+ n 1)) (b (* a 2))) (list a b)))) (f 4)`,
+		`(define f (lambda (t) (or #f t))) (list (f 1) (f #f))`,
+		`(define f (lambda (x) (let ((x (+ x 1))) (let ((x (* x 2))) x)))) (f 3)`,
+		`(define f (lambda (a b) (let ((a b) (b a)) (list a b)))) (f 1 2)`,
+		`(define f (lambda (n) (let ((k n)) (lambda () k)))) ((f 9))`,
+		`(define sum (lambda (i acc) (cond ((= i 0) acc) (else (let ((j (* i 2))) (sum (- i 1) (+ acc j))))))) (sum 1000 0)`,
+		`(define f (lambda (x) (when (and #t (> x 1)) (let ((y x)) (unless (< y 0) (string-append "big " (number->string y))))))) (f 5)`,
+		`(cond ((not (eq? 1 1)) 'a) ((< 2 1) 'b) (else (string-length "abc")))`,
+		`(define / *) (/ 6 3)`,
+	}
+	for _, src := range programs {
+		var results [2]string
+		for i, optimize := range []bool{true, false} {
+			g := New()
+			g.SetOptimize(optimize)
+			result, err := g.Process(strings.NewReader(src))
+			if err != nil {
+				results[i] = "error: " + err.Error()
+			} else {
+				results[i] = result.Repr()
+			}
+		}
+		if results[0] != results[1] {
+			t.Errorf("%s\noptimized: %s\nplain:     %s", src, results[0], results[1])
+		}
+	}
+}
+
+func TestOptimizerHonoursAssignmentsFromEarlierUnits(t *testing.T) {
+	for _, optimize := range []bool{true, false} {
+		g := New()
+		g.SetOptimize(optimize)
+		if _, err := g.Process(strings.NewReader(`(define evil (lambda () (set! string-length (lambda (s) 99))))`)); err != nil {
+			t.Fatal(err)
+		}
+		result, err := g.Process(strings.NewReader(`(evil) (string-length "ab")`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if result.Repr() != "99" {
+			t.Errorf("optimize %t: expected the assigned string-length to run, got %s", optimize, result.Repr())
+		}
+	}
+}
+
+func TestOptimizerFoldsAtCompileTime(t *testing.T) {
+	var buf bytes.Buffer
+	g := New()
+	g.SetDisassemblyOutput(&buf)
+	if _, err := g.Process(strings.NewReader(`(string-append "a" (number->string (+ 1 2)))`)); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), `OP_CONST          0     ; "a3"`) || strings.Contains(buf.String(), "CALL") {
+		t.Errorf("expected a folded constant:\n%s", buf.String())
+	}
+}
+
+func TestLimitsStopRunawayEvaluation(t *testing.T) {
+	cases := []struct {
+		name   string
+		limits Limits
+		src    string
+		want   Limit
+	}{
+		{"fuel", Limits{Fuel: 10000}, `(define spin (lambda () (spin))) (spin)`, LimitFuel},
+		{"depth", Limits{MaxDepth: 100}, `(define down (lambda (n) (+ 1 (down n)))) (down 0)`, LimitDepth},
+		{"stack", Limits{MaxStack: 500}, `(define down (lambda (n) (+ 1 (down n)))) (down 0)`, LimitStack},
+		{"alloc", Limits{MaxAlloc: 1 << 16}, `(define grow (lambda (acc) (grow (cons 1 acc)))) (grow '())`, LimitAlloc},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			g := New()
+			g.SetLimits(c.limits)
+			_, err := g.Process(strings.NewReader(c.src))
+			var limitErr LimitError
+			if !errors.As(err, &limitErr) || limitErr.Limit != c.want || !errors.Is(err, ErrLimitExceeded) {
+				t.Fatalf("expected the %s limit to stop evaluation, got %v", c.want, err)
+			}
+			if !strings.Contains(err.Error(), c.want.String()+" limit exceeded") {
+				t.Errorf("expected the error to name the limit, got %q", err)
+			}
+
+			// The next evaluation starts with a fresh budget.
+			res, err := g.Process(strings.NewReader(`(+ 1 2)`))
+			if err != nil || res.IntVal != 3 {
+				t.Errorf("expected 3 after the limit error, got %v, %v", res, err)
+			}
+		})
+	}
+}
+
+func TestLimitsChargeInternedSymbols(t *testing.T) {
+	g := New()
+	g.DefineValue("long-name", strings.Repeat("x", 1<<14))
+	g.SetLimits(Limits{MaxAlloc: 1 << 12})
+	_, err := g.Process(strings.NewReader(`(string->symbol long-name)`))
+	var limitErr LimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != LimitAlloc {
+		t.Errorf("expected interning a long name to exceed the alloc limit, got %v", err)
+	}
+}
+
+func TestRepeatedExpansionsDoNotGrowTheHeap(t *testing.T) {
+	g := New()
+	if _, err := g.Process(strings.NewReader(`
+(define-syntax swap!
+  (syntax-rules ()
+    ((_ a b) (let ((tmp a)) (set! a b) (set! b tmp)))))
+(define x 1)
+(define y 2)`)); err != nil {
+		t.Fatal(err)
+	}
+	heap := func() uint64 {
+		var m runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&m)
+		return m.HeapAlloc
+	}
+	before := heap()
+	for i := 0; i < 20000; i++ {
+		src := fmt.Sprintf(`(swap! x y) (string->symbol "made-up-%d")`, i)
+		if _, err := g.Process(strings.NewReader(src)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Every expansion makes fresh marks and every call a fresh symbol;
+	// kept forever, they would take megabytes.
+	if after := heap(); after > before+1<<20 {
+		t.Errorf("expected the heap to stay near %d bytes, got %d", before, after)
+	}
+}
+
+func TestLimitsApplyToMacroExpansion(t *testing.T) {
+	g := New()
+	g.SetLimits(Limits{Fuel: 10000})
+	_, err := g.Process(strings.NewReader(`
+(define-syntax forever
+  (lambda (stx)
+    (define spin (lambda () (spin)))
+    (spin)))
+(forever)`))
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Fatalf("expected the fuel limit to stop the macro, got %v", err)
+	}
+}
+
+func TestTailCallsDoNotCountAgainstMaxDepth(t *testing.T) {
+	g := New()
+	g.SetLimits(Limits{MaxDepth: 10})
+	res, err := g.Process(strings.NewReader(`(define count (lambda (n) (cond ((eq? n 0) 'done) (else (count (- n 1)))))) (count 10000)`))
+	if err != nil || res.Repr() != "done" {
+		t.Errorf("expected done, got %v, %v", res, err)
+	}
+}
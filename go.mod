@@ -2,10 +2,12 @@ module github.com/archevel/ghoul
 
 go 1.25.0
 
-require golang.org/x/tools v0.39.0
+require (
+	github.com/BurntSushi/toml v1.6.0
+	golang.org/x/tools v0.39.0
+)
 
 require (
-	github.com/BurntSushi/toml v1.6.0 // indirect
 	golang.org/x/mod v0.30.0 // indirect
 	golang.org/x/sync v0.18.0 // indirect
 )
@@ -0,0 +1,113 @@
+package macromancy
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/archevel/ghoul/bones"
+)
+
+// mismatch records why a syntax-rules pattern failed to match a macro call.
+// All methods are no-ops on a nil receiver so the matcher can thread it
+// unconditionally and pay nothing on the successful path.
+type mismatch struct {
+	reason   string
+	form     *bones.Node   // offending sub-form, nil when a list ran out of forms
+	within   *bones.Node   // innermost list being matched when the failure occurred
+	matched  int           // top-level sub-patterns matched before the failure
+	total    int           // top-level sub-patterns in the clause
+	lists    []*bones.Node // stack of code lists entered below the call form
+	recorded bool
+}
+
+// note records the first (innermost) failure; later notes from enclosing
+// matchers unwinding are ignored.
+func (mm *mismatch) note(form *bones.Node, format string, args ...any) {
+	if mm == nil || mm.recorded {
+		return
+	}
+	mm.recorded = true
+	mm.form = form
+	mm.reason = fmt.Sprintf(format, args...)
+	if len(mm.lists) > 0 {
+		mm.within = mm.lists[len(mm.lists)-1]
+	}
+}
+
+// progress records how many top-level sub-patterns matched. Only the
+// outermost matchChildren call (the macro call's arguments) counts.
+func (mm *mismatch) progress(done int, pattern []*bones.Node) {
+	if mm == nil || len(mm.lists) > 0 {
+		return
+	}
+	mm.matched = done
+	mm.total = 0
+	for _, p := range pattern {
+		if !isEllipsis(p) {
+			mm.total++
+		}
+	}
+}
+
+func (mm *mismatch) descend(list *bones.Node) {
+	if mm != nil {
+		mm.lists = append(mm.lists, list)
+	}
+}
+
+func (mm *mismatch) ascend() {
+	if mm != nil {
+		mm.lists = mm.lists[:len(mm.lists)-1]
+	}
+}
+
+// location returns the best known source location for the failure,
+// falling back to the macro call site.
+func (mm *mismatch) location(callSite *bones.Node) bones.CodeLocation {
+	if mm.form != nil && mm.form.Loc != nil {
+		return mm.form.Loc
+	}
+	if mm.within != nil && mm.within.Loc != nil {
+		return mm.within.Loc
+	}
+	return callSite.Loc
+}
+
+// describeForm renders a code form as "<type> <repr>" for diagnostics,
+// e.g. "list (a b)" or "integer 5".
+func describeForm(n *bones.Node) string {
+	if n == nil {
+		return "nothing"
+	}
+	if n.IsNil() {
+		return "empty list"
+	}
+	return bones.NodeTypeName(n) + " " + n.Repr()
+}
+
+// explainNoMatch re-runs every clause against the call with a mismatch
+// recorder and builds an error describing, per clause, the pattern, how far
+// matching got and the sub-form that did not fit.
+func explainNoMatch(macros []Macro, code *bones.Node) error {
+	var b strings.Builder
+	b.WriteString("no matching pattern for ")
+	b.WriteString(code.Repr())
+	if code.Loc != nil {
+		b.WriteString(" at ")
+		b.WriteString(code.Loc.String())
+	}
+	for i, m := range macros {
+		why := &mismatch{}
+		m.matchesNoting(code, why)
+		fmt.Fprintf(&b, "\n  clause %d: %s\n    ", i+1, m.Pattern.Repr())
+		if why.total > 0 {
+			fmt.Fprintf(&b, "matched %d of %d sub-patterns; ", why.matched, why.total)
+		}
+		b.WriteString(why.reason)
+		if loc := why.location(code); loc != nil {
+			b.WriteString(" at ")
+			b.WriteString(loc.String())
+		}
+	}
+	return fmt.Errorf("%s", b.String())
+}
@@ -6,10 +6,13 @@ import (
 	"github.com/archevel/ghoul/bones"
 )
 
-// bindings holds variable bindings during pattern matching.
+// bindings holds variable bindings during pattern matching. When why is
+// non-nil, the first mismatch encountered is recorded there so a failed
+// macro call can explain itself.
 type bindings struct {
 	vars     map[string]*bones.Node
 	repeated map[string][]*bones.Node
+	why      *mismatch
 }
 
 func newBindings() bindings {
@@ -45,7 +48,7 @@ func BuildSyntaxRulesTransformer(name string, syntaxRules *bones.Node, definitio
 					return expandHygienic(m.Body, bound, mark, m.PatternVars, definitionBindings), nil
 				}
 			}
-			return nil, fmt.Errorf("no matching pattern for %s", code.Repr())
+			return nil, explainNoMatch(macros, code)
 		},
 	}, nil
 }
@@ -104,20 +107,31 @@ func extractLiterals(litNode *bones.Node) (map[string]bool, error) {
 // --- Pattern matching ---
 
 func (m Macro) matches(code *bones.Node) (bool, bindings) {
+	return m.matchesNoting(code, nil)
+}
+
+// matchesNoting is matches with an optional mismatch recorder.
+func (m Macro) matchesNoting(code *bones.Node, why *mismatch) (bool, bindings) {
 	if m.Pattern.Kind != bones.ListNode || code.Kind != bones.ListNode {
+		why.note(code, "expected a macro call form, got %s", describeForm(code))
 		return false, bindings{}
 	}
 	if len(m.Pattern.Children) == 0 || len(code.Children) == 0 {
+		why.note(code, "expected a non-empty macro call form")
 		return false, bindings{}
 	}
 	// First child is the macro name — skip it, match the rest
 	patChildren := m.Pattern.Children[1:]
 	codeChildren := code.Children[1:]
-	return matchChildren(patChildren, codeChildren, newBindings(), m.Literals)
+	bound := newBindings()
+	bound.why = why
+	return matchChildren(patChildren, codeChildren, bound, m.Literals)
 }
 
 func matchChildren(pattern []*bones.Node, code []*bones.Node, bound bindings, literals map[string]bool) (bool, bindings) {
 	pi, ci := 0, 0
+	why := bound.why
+	done := 0 // sub-patterns fully matched, for diagnostics
 
 	for pi < len(pattern) {
 		pat := pattern[pi]
@@ -150,8 +164,10 @@ func matchChildren(pattern []*bones.Node, code []*bones.Node, bound bindings, li
 					break
 				}
 				localBound := newBindings()
+				localBound.why = why
 				ok, localBound := matchExpr(pat, code[ci], localBound, literals)
 				if !ok {
+					why.progress(done, pattern)
 					return false, bindings{}
 				}
 				for v := range subVars {
@@ -164,24 +180,31 @@ func matchChildren(pattern []*bones.Node, code []*bones.Node, bound bindings, li
 
 			// Skip the ellipsis in pattern
 			pi += 2
+			done++
 			continue
 		}
 
 		// Regular (non-ellipsis) match
 		if ci >= len(code) {
+			why.note(nil, "expected a form matching %s, reached end of form", pat.Repr())
+			why.progress(done, pattern)
 			return false, bindings{}
 		}
 		var ok bool
 		ok, bound = matchExpr(pat, code[ci], bound, literals)
 		if !ok {
+			why.progress(done, pattern)
 			return false, bindings{}
 		}
 		pi++
 		ci++
+		done++
 	}
 
 	// All pattern elements consumed — code should also be consumed
 	if ci < len(code) {
+		why.note(code[ci], "unexpected extra form %s", code[ci].Repr())
+		why.progress(done, pattern)
 		return false, bindings{}
 	}
 	return true, bound
@@ -192,6 +215,8 @@ func isEmptyList(n *bones.Node) bool {
 }
 
 func matchExpr(pattern *bones.Node, code *bones.Node, bound bindings, literals map[string]bool) (bool, bindings) {
+	why := bound.why
+
 	// Both empty lists (Nil or ListNode with no children)
 	if isEmptyList(pattern) && isEmptyList(code) {
 		return true, bound
@@ -211,11 +236,13 @@ func matchExpr(pattern *bones.Node, code *bones.Node, bound bindings, literals m
 			if codeName == name {
 				return true, bound
 			}
+			why.note(code, "literal `%s` expected, got %s", name, describeForm(code))
 			return false, bindings{}
 		}
 		// Variable binding
 		if existing, present := bound.vars[name]; present {
 			if !existing.Equiv(code) {
+				why.note(code, "pattern variable `%s` already bound to %s, got %s", name, existing.Repr(), describeForm(code))
 				return false, bound
 			}
 			return true, bound
@@ -235,9 +262,13 @@ func matchExpr(pattern *bones.Node, code *bones.Node, bound bindings, literals m
 			// Single-element pattern vs non-list code
 			return matchExpr(pattern.Children[0], code, bound, literals)
 		} else {
+			why.note(code, "expected list, got %s", describeForm(code))
 			return false, bindings{}
 		}
-		return matchChildren(pattern.Children, codeChildren, bound, literals)
+		why.descend(code)
+		ok, result := matchChildren(pattern.Children, codeChildren, bound, literals)
+		why.ascend()
+		return ok, result
 	}
 
 	// Literal value match
@@ -245,6 +276,11 @@ func matchExpr(pattern *bones.Node, code *bones.Node, bound bindings, literals m
 		return true, bound
 	}
 
+	if isEmptyList(pattern) {
+		why.note(code, "expected empty list, got %s", describeForm(code))
+	} else {
+		why.note(code, "expected %s, got %s", pattern.Repr(), describeForm(code))
+	}
 	return false, bindings{}
 }
 
@@ -329,7 +365,10 @@ func expandHygienicImpl(node *bones.Node, bound bindings, mark Mark, patternVars
 			return replacement
 		}
 		if defBindings != nil && defBindings[name] {
-			return node
+			// Copy so locations assigned to the expansion don't leak
+			// back into the shared template.
+			cp := *node
+			return &cp
 		}
 		if len(node.Marks) > 0 {
 			newMarks := copyMarks(node.Marks)
@@ -1,6 +1,7 @@
 package macromancy
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/archevel/ghoul/bones"
@@ -724,3 +725,75 @@ func TestMatchAndBindNoMatch(t *testing.T) {
 		t.Error("expected nil result on no match")
 	}
 }
+
+// --- No-match diagnostics ---
+
+func TestNoMatchErrorExplainsEachClause(t *testing.T) {
+	// (syntax-rules (then else)
+	//   ((my-if c then t else e) ...)
+	//   ((my-if c) ...))
+	syntaxRules := list(
+		n("syntax-rules"),
+		list(n("then"), n("else")),
+		list(list(n("my-if"), n("c"), n("then"), n("t"), n("else"), n("e")), n("t")),
+		list(list(n("my-if"), n("c")), n("c")),
+	)
+	st, err := BuildSyntaxRulesTransformer("my-if", syntaxRules, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code := list(n("my-if"), i(1), n("then"), i(2), n("otherwise"), i(3))
+	code.Loc = &bones.SourcePosition{Ln: 12, Col: 5}
+	_, err = st.Transform(code, 1)
+	if err == nil {
+		t.Fatal("expected no-match error")
+	}
+	msg := err.Error()
+	for _, want := range []string{
+		"no matching pattern for (my-if 1 then 2 otherwise 3) at 12:5",
+		"clause 1: (my-if c then t else e)",
+		"matched 3 of 5 sub-patterns; literal `else` expected, got identifier otherwise at 12:5",
+		"clause 2: (my-if c)",
+		"matched 1 of 1 sub-patterns; unexpected extra form then",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("expected %q in error, got:\n%s", want, msg)
+		}
+	}
+}
+
+func TestNoMatchErrorPointsAtNestedSubForm(t *testing.T) {
+	// (syntax-rules () ((my-let ((v e) ...) body) body))
+	syntaxRules := list(
+		n("syntax-rules"),
+		list(),
+		list(list(n("my-let"), list(list(n("v"), n("e")), n("...")), n("body")), n("body")),
+	)
+	st, err := BuildSyntaxRulesTransformer("my-let", syntaxRules, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bindingsForm := list(list(n("x"), i(1)), n("y"))
+	bindingsForm.Loc = &bones.SourcePosition{Ln: 3, Col: 9}
+	code := list(n("my-let"), bindingsForm, n("x"))
+	code.Loc = &bones.SourcePosition{Ln: 3, Col: 2}
+	_, err = st.Transform(code, 1)
+	if err == nil {
+		t.Fatal("expected no-match error")
+	}
+	want := "matched 0 of 2 sub-patterns; expected list, got identifier y at 3:9"
+	if !strings.Contains(err.Error(), want) {
+		t.Errorf("expected %q in error, got:\n%s", want, err.Error())
+	}
+}
+
+func TestNoMatchErrorReportsMissingForm(t *testing.T) {
+	m := Macro{Pattern: list(n("mac"), n("x"), n("y"))}
+	err := explainNoMatch([]Macro{m}, list(n("mac"), i(1)))
+	want := "matched 1 of 2 sub-patterns; expected a form matching y, reached end of form"
+	if !strings.Contains(err.Error(), want) {
+		t.Errorf("expected %q in error, got:\n%s", want, err.Error())
+	}
+}
@@ -0,0 +1,599 @@
+package ghoul
+
+import (
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	e "github.com/archevel/ghoul/bones"
+)
+
+// Unmarshal and Marshal let Ghoul serve as a configuration language: a
+// script evaluates to nested lists and association lists, which Unmarshal
+// decodes into the host's structs.
+//
+// A struct or map is read from an association list, whose entries are
+// pairs like (port . 80) or lists like (hosts "a" "b"), keyed by symbols
+// or strings. A struct field's key is given by a `ghoul:"name"` tag, or
+// is the field's name in lower kebab-case (MaxConns becomes max-conns).
+// The tag `ghoul:"-"` skips a field and `ghoul:"name,omitempty"` leaves
+// zero values out of Marshal's output. Go maps held in a mummy, Ghoul's
+// stand-in for hash tables, serve as well. Slices are read from lists,
+// pointers are allocated as needed, and time.Duration is read from
+// strings such as "1m30s". Decoded into an interface, a list becomes
+// []any, with the tail of an improper list as its last element, and an
+// association list of dotted pairs becomes map[string]any.
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// UnmarshalError reports a value that could not be decoded, at the path
+// through the data where it was found, such as servers[2].port.
+type UnmarshalError struct {
+	Path string
+	Msg  string
+}
+
+func (err *UnmarshalError) Error() string {
+	if err.Path == "" {
+		return err.Msg
+	}
+	return err.Path + ": " + err.Msg
+}
+
+// Unmarshal decodes node into the value v points to.
+func Unmarshal(node *e.Node, v any) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Pointer || rv.IsNil() {
+		return fmt.Errorf("unmarshal: expected a non-nil pointer, got %T", v)
+	}
+	if node == nil {
+		return &UnmarshalError{Msg: "expected a value, got nil"}
+	}
+	return unmarshal(node, rv.Elem(), "")
+}
+
+func unmarshal(n *e.Node, v reflect.Value, path string) error {
+	fail := func(format string, args ...any) error {
+		return &UnmarshalError{Path: path, Msg: fmt.Sprintf(format, args...)}
+	}
+	expected := func(what string) error {
+		return fail("expected %s, got %s", what, e.NodeTypeName(n))
+	}
+	t := v.Type()
+
+	switch {
+	case t == nodeType:
+		v.Set(reflect.ValueOf(n))
+		return nil
+	case t == durationType:
+		if n.Kind != e.StringNode {
+			return expected("duration string")
+		}
+		d, err := time.ParseDuration(n.StrVal)
+		if err != nil {
+			return fail("bad duration %q", n.StrVal)
+		}
+		v.SetInt(int64(d))
+		return nil
+	case n.Kind == e.MummyNode && n.ForeignVal != nil:
+		held := reflect.ValueOf(n.ForeignVal)
+		if held.Type().AssignableTo(t) {
+			v.Set(held)
+			return nil
+		}
+		if held.Kind() != reflect.Map || (t.Kind() != reflect.Map && t.Kind() != reflect.Struct) {
+			return fail("mummy contains %T, expected %s", n.ForeignVal, t)
+		}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		if n.IsNil() {
+			v.SetZero()
+			return nil
+		}
+		if v.IsNil() {
+			v.Set(reflect.New(t.Elem()))
+		}
+		return unmarshal(n, v.Elem(), path)
+	case reflect.Bool:
+		if n.Kind != e.BooleanNode {
+			return expected("boolean")
+		}
+		v.SetBool(n.BoolVal)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if n.Kind != e.IntegerNode {
+			return expected("integer")
+		}
+		if v.OverflowInt(n.IntVal) {
+			return fail("%d does not fit in %s", n.IntVal, t)
+		}
+		v.SetInt(n.IntVal)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if n.Kind != e.IntegerNode {
+			return expected("integer")
+		}
+		if n.IntVal < 0 || v.OverflowUint(uint64(n.IntVal)) {
+			return fail("%d does not fit in %s", n.IntVal, t)
+		}
+		v.SetUint(uint64(n.IntVal))
+	case reflect.Float32, reflect.Float64:
+		switch n.Kind {
+		case e.FloatNodeKind:
+			v.SetFloat(n.FloatVal)
+		case e.IntegerNode:
+			v.SetFloat(float64(n.IntVal))
+		default:
+			return expected("number")
+		}
+	case reflect.String:
+		switch n.Kind {
+		case e.StringNode:
+			v.SetString(n.StrVal)
+		case e.IdentifierNode:
+			v.SetString(n.Name)
+		default:
+			return expected("string")
+		}
+	case reflect.Slice:
+		items, err := listItems(n, path)
+		if err != nil {
+			return err
+		}
+		s := reflect.MakeSlice(t, len(items), len(items))
+		for i, item := range items {
+			if err := unmarshal(item, s.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+	case reflect.Array:
+		items, err := listItems(n, path)
+		if err != nil {
+			return err
+		}
+		if len(items) != t.Len() {
+			return fail("expected a list of %d, got %d", t.Len(), len(items))
+		}
+		for i, item := range items {
+			if err := unmarshal(item, v.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		return unmarshalMap(n, v, path)
+	case reflect.Struct:
+		return unmarshalStruct(n, v, path)
+	case reflect.Interface:
+		if n.IsNil() {
+			v.SetZero()
+			return nil
+		}
+		var natural reflect.Value
+		switch {
+		case isPairList(n):
+			m := map[string]any{}
+			if err := unmarshal(n, reflect.ValueOf(&m).Elem(), path); err != nil {
+				return err
+			}
+			natural = reflect.ValueOf(m)
+		case n.Kind == e.ListNode:
+			proper := n
+			if n.DottedTail != nil {
+				proper = e.NewListNode(append(slices.Clone(n.Children), n.DottedTail))
+			}
+			var items []any
+			if err := unmarshal(proper, reflect.ValueOf(&items).Elem(), path); err != nil {
+				return err
+			}
+			natural = reflect.ValueOf(items)
+		default:
+			natural = toGoValue(n)
+		}
+		if !natural.IsValid() || !natural.Type().AssignableTo(t) {
+			return expected(t.String())
+		}
+		v.Set(natural)
+	default:
+		return fail("cannot decode into %s", t)
+	}
+	return nil
+}
+
+func listItems(n *e.Node, path string) ([]*e.Node, error) {
+	switch {
+	case n.IsNil():
+		return nil, nil
+	case n.Kind == e.ListNode && n.DottedTail == nil:
+		return n.Children, nil
+	}
+	got := e.NodeTypeName(n)
+	if n.Kind == e.ListNode {
+		got = "improper list"
+	}
+	return nil, &UnmarshalError{Path: path, Msg: "expected list, got " + got}
+}
+
+// isPairList reports whether n is an association list with at least one
+// dotted pair, such as ((port . 80) (hosts "a" "b")).
+func isPairList(n *e.Node) bool {
+	if n.Kind != e.ListNode || n.DottedTail != nil || len(n.Children) == 0 {
+		return false
+	}
+	dotted := false
+	for _, item := range n.Children {
+		if item.Kind != e.ListNode || len(item.Children) == 0 {
+			return false
+		}
+		if key := item.Children[0]; key.Kind != e.IdentifierNode && key.Kind != e.StringNode {
+			return false
+		}
+		dotted = dotted || (len(item.Children) == 1 && item.DottedTail != nil)
+	}
+	return dotted
+}
+
+// entry is one key and value of an association list or mummy map.
+type entry struct {
+	key   string
+	value *e.Node
+}
+
+// entries reads n as an association list, or a mummy holding a map.
+func entries(n *e.Node, path string) ([]entry, error) {
+	if n.Kind == e.MummyNode {
+		m := reflect.ValueOf(n.ForeignVal)
+		if m.Kind() != reflect.Map {
+			return nil, &UnmarshalError{Path: path, Msg: fmt.Sprintf("expected association list, got mummy containing %T", n.ForeignVal)}
+		}
+		var out []entry
+		iter := m.MapRange()
+		for iter.Next() {
+			value, err := marshal(iter.Value(), "", map[visit]bool{})
+			if err != nil {
+				if value, err = toNode(iter.Value()); err != nil {
+					return nil, &UnmarshalError{Path: joinPath(path, fmt.Sprint(iter.Key().Interface())), Msg: err.Error()}
+				}
+			}
+			out = append(out, entry{key: fmt.Sprint(iter.Key().Interface()), value: value})
+		}
+		return out, nil
+	}
+
+	items, err := listItems(n, path)
+	if err != nil {
+		return nil, &UnmarshalError{Path: path, Msg: "expected association list, got " + e.NodeTypeName(n)}
+	}
+	out := make([]entry, len(items))
+	for i, item := range items {
+		if item.Kind != e.ListNode || len(item.Children) == 0 {
+			return nil, &UnmarshalError{Path: fmt.Sprintf("%s[%d]", path, i), Msg: "expected (key . value), got " + e.NodeTypeName(item)}
+		}
+		key := item.Children[0]
+		switch key.Kind {
+		case e.IdentifierNode:
+			out[i].key = key.Name
+		case e.StringNode:
+			out[i].key = key.StrVal
+		default:
+			return nil, &UnmarshalError{Path: fmt.Sprintf("%s[%d]", path, i), Msg: "expected symbol or string key, got " + e.NodeTypeName(key)}
+		}
+		out[i].value = item.Rest()
+	}
+	return out, nil
+}
+
+// entryValue is what an entry gives a field of type t: (port . 80) and
+// (port 80) both give 80 to a scalar, while (hosts "a" "b") gives a list
+// and (server (port . 80)) an association list.
+func entryValue(value *e.Node, t reflect.Type) *e.Node {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.Struct, reflect.Interface:
+		return value
+	}
+	if value.Kind == e.ListNode && value.DottedTail == nil && len(value.Children) == 1 {
+		return value.Children[0]
+	}
+	return value
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func unmarshalMap(n *e.Node, v reflect.Value, path string) error {
+	t := v.Type()
+	if n.IsNil() {
+		v.SetZero()
+		return nil
+	}
+	es, err := entries(n, path)
+	if err != nil {
+		return err
+	}
+	if v.IsNil() {
+		v.Set(reflect.MakeMapWithSize(t, len(es)))
+	}
+	for _, en := range es {
+		keyPath := joinPath(path, en.key)
+		key := reflect.New(t.Key()).Elem()
+		if t.Key().Kind() == reflect.String {
+			key.SetString(en.key)
+		} else if _, err := fmt.Sscan(en.key, key.Addr().Interface()); err != nil {
+			return &UnmarshalError{Path: keyPath, Msg: fmt.Sprintf("key %q is not a %s", en.key, t.Key())}
+		}
+		elem := reflect.New(t.Elem()).Elem()
+		if n.Kind == e.MummyNode {
+			err = unmarshal(en.value, elem, keyPath)
+		} else {
+			err = unmarshal(entryValue(en.value, t.Elem()), elem, keyPath)
+		}
+		if err != nil {
+			return err
+		}
+		v.SetMapIndex(key, elem)
+	}
+	return nil
+}
+
+func unmarshalStruct(n *e.Node, v reflect.Value, path string) error {
+	es, err := entries(n, path)
+	if err != nil {
+		return err
+	}
+	fields := structFields(v.Type())
+	for _, en := range es {
+		i := slices.IndexFunc(fields, func(f field) bool { return f.name == en.key })
+		if i < 0 {
+			continue // keys the struct has no field for are ignored
+		}
+		f := fields[i]
+		target := fieldByIndex(v, f.index)
+		value := en.value
+		if n.Kind != e.MummyNode {
+			value = entryValue(value, f.typ)
+		}
+		if err := unmarshal(value, target, joinPath(path, f.name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fieldByIndex is v.FieldByIndex, allocating embedded struct pointers
+// along the way.
+func fieldByIndex(v reflect.Value, index []int) reflect.Value {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				v.Set(reflect.New(v.Type().Elem()))
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v
+}
+
+// field is an exported struct field as Marshal and Unmarshal see it.
+type field struct {
+	name      string
+	index     []int
+	typ       reflect.Type
+	omitEmpty bool
+}
+
+// structFields lists the fields of t, with those of untagged embedded
+// structs promoted into it.
+func structFields(t reflect.Type) []field {
+	var fields []field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		tag, tagged := sf.Tag.Lookup("ghoul")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		if sf.Anonymous && !tagged {
+			et := sf.Type
+			if et.Kind() == reflect.Pointer {
+				if !sf.IsExported() {
+					continue // cannot be allocated through reflection
+				}
+				et = et.Elem()
+			}
+			if et.Kind() == reflect.Struct {
+				for _, inner := range structFields(et) {
+					inner.index = append([]int{i}, inner.index...)
+					fields = append(fields, inner)
+				}
+				continue
+			}
+		}
+		if !sf.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = kebabCase(sf.Name)
+		}
+		fields = append(fields, field{name: name, index: []int{i}, typ: sf.Type, omitEmpty: opts == "omitempty"})
+	}
+	return fields
+}
+
+// kebabCase turns a Go name like MaxConns into max-conns, as the embalmer
+// names wrapped functions.
+func kebabCase(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if i > 0 && r >= 'A' && r <= 'Z' {
+			b.WriteByte('-')
+		}
+		b.WriteRune(r)
+	}
+	return strings.ToLower(b.String())
+}
+
+// Marshal encodes v as the Ghoul value Unmarshal decodes back into it:
+// structs and maps become association lists, slices and arrays lists,
+// time.Duration a string, and pointers the value they point to. Struct
+// fields are keyed by symbols and map entries by strings, sorted.
+// A value that contains itself, through a pointer, map or slice, is an
+// error.
+func Marshal(v any) (*e.Node, error) {
+	return marshal(reflect.ValueOf(v), "", map[visit]bool{})
+}
+
+// visit identifies a pointer, map or slice marshal is inside of, to catch
+// values that contain themselves.
+type visit struct {
+	ptr uintptr
+	typ reflect.Type
+	len int
+}
+
+func marshal(v reflect.Value, path string, visiting map[visit]bool) (*e.Node, error) {
+	if !v.IsValid() {
+		return e.Nil, nil
+	}
+	t := v.Type()
+	if t == nodeType || t == durationType {
+		if t == durationType {
+			return e.StrNode(time.Duration(v.Int()).String()), nil
+		}
+		return marshalPrimitive(v, path)
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Slice:
+		if v.IsNil() {
+			break
+		}
+		at := visit{ptr: v.Pointer(), typ: t}
+		if t.Kind() == reflect.Slice {
+			at.len = v.Len()
+		}
+		if visiting[at] {
+			return nil, marshalError(path, fmt.Errorf("encountered a cycle via %s", t))
+		}
+		visiting[at] = true
+		defer delete(visiting, at)
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer, reflect.Interface:
+		if v.IsNil() {
+			return e.Nil, nil
+		}
+		return marshal(v.Elem(), path, visiting)
+	case reflect.Bool, reflect.String,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return marshalPrimitive(v, path)
+	case reflect.Slice, reflect.Array:
+		if v.Len() == 0 {
+			return e.Nil, nil
+		}
+		items := make([]*e.Node, v.Len())
+		for i := range items {
+			item, err := marshal(v.Index(i), fmt.Sprintf("%s[%d]", path, i), visiting)
+			if err != nil {
+				return nil, err
+			}
+			items[i] = item
+		}
+		return e.NewListNode(items), nil
+	case reflect.Map:
+		keys := v.MapKeys()
+		names := make([]string, len(keys))
+		for i, k := range keys {
+			names[i] = fmt.Sprint(k.Interface())
+		}
+		order := make([]int, len(keys))
+		for i := range order {
+			order[i] = i
+		}
+		slices.SortFunc(order, func(a, b int) int { return strings.Compare(names[a], names[b]) })
+		var pairs []*e.Node
+		for _, i := range order {
+			value, err := marshal(v.MapIndex(keys[i]), joinPath(path, names[i]), visiting)
+			if err != nil {
+				return nil, err
+			}
+			pairs = append(pairs, pair(e.StrNode(names[i]), value))
+		}
+		return list(pairs), nil
+	case reflect.Struct:
+		var pairs []*e.Node
+		for _, f := range structFields(t) {
+			fv, ok := existingField(v, f.index)
+			if !ok || (f.omitEmpty && fv.IsZero()) {
+				continue
+			}
+			value, err := marshal(fv, joinPath(path, f.name), visiting)
+			if err != nil {
+				return nil, err
+			}
+			pairs = append(pairs, pair(e.IdentNode(f.name), value))
+		}
+		return list(pairs), nil
+	}
+	return nil, marshalError(path, fmt.Errorf("cannot marshal %s", t))
+}
+
+func marshalPrimitive(v reflect.Value, path string) (*e.Node, error) {
+	n, err := toNode(v)
+	if err != nil {
+		return nil, marshalError(path, err)
+	}
+	return n, nil
+}
+
+func marshalError(path string, err error) error {
+	if path == "" {
+		return fmt.Errorf("marshal: %w", err)
+	}
+	return fmt.Errorf("marshal: %s: %w", path, err)
+}
+
+// existingField is v.FieldByIndex, reporting false where it would pass
+// through a nil embedded pointer.
+func existingField(v reflect.Value, index []int) (reflect.Value, bool) {
+	for i, x := range index {
+		if i > 0 && v.Kind() == reflect.Pointer {
+			if v.IsNil() {
+				return reflect.Value{}, false
+			}
+			v = v.Elem()
+		}
+		v = v.Field(x)
+	}
+	return v, true
+}
+
+// pair conses key onto value, as (cons key value) would.
+func pair(key, value *e.Node) *e.Node {
+	switch {
+	case value.IsNil():
+		return e.NewListNode([]*e.Node{key})
+	case value.Kind == e.ListNode:
+		return &e.Node{Kind: e.ListNode, Children: append([]*e.Node{key}, value.Children...), DottedTail: value.DottedTail}
+	}
+	return &e.Node{Kind: e.ListNode, Children: []*e.Node{key}, DottedTail: value}
+}
+
+func list(items []*e.Node) *e.Node {
+	if len(items) == 0 {
+		return e.Nil
+	}
+	return e.NewListNode(items)
+}
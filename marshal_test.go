@@ -0,0 +1,225 @@
+package ghoul
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	e "github.com/archevel/ghoul/bones"
+)
+
+type serverConfig struct {
+	Host    string        `ghoul:"host"`
+	Port    int           `ghoul:"port"`
+	Timeout time.Duration `ghoul:"timeout,omitempty"`
+	Tags    []string      `ghoul:"tags,omitempty"`
+}
+
+type appConfig struct {
+	Name     string
+	MaxConns int
+	Debug    *bool
+	Servers  []serverConfig `ghoul:"servers"`
+	Limits   map[string]int `ghoul:"limits"`
+	Secret   string         `ghoul:"-"`
+}
+
+func evalConfig(t *testing.T, src string) *e.Node {
+	t.Helper()
+	node, err := New().Process(strings.NewReader(src))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return node
+}
+
+func TestUnmarshalConfigFromAssociationLists(t *testing.T) {
+	node := evalConfig(t, `
+(define base-port 8000)
+(list
+  (cons 'name "api")
+  (cons 'max-conns 64)
+  (cons 'debug #t)
+  (list 'servers
+        (list (cons 'host "a") (cons 'port (+ base-port 1)) (cons 'timeout "1m30s"))
+        (list (list 'host "b") (list 'port (+ base-port 2)) (list 'tags "x" "y")))
+  (list 'limits (cons "cpu" 2) (cons "mem" 512))
+  (cons 'secret "ignored")
+  (cons 'unknown 1))`)
+
+	var cfg appConfig
+	if err := Unmarshal(node, &cfg); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	debug := true
+	want := appConfig{
+		Name:     "api",
+		MaxConns: 64,
+		Debug:    &debug,
+		Servers: []serverConfig{
+			{Host: "a", Port: 8001, Timeout: 90 * time.Second},
+			{Host: "b", Port: 8002, Tags: []string{"x", "y"}},
+		},
+		Limits: map[string]int{"cpu": 2, "mem": 512},
+	}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("expected %+v, got %+v", want, cfg)
+	}
+}
+
+func TestUnmarshalErrorsNameThePath(t *testing.T) {
+	node := evalConfig(t, `'((servers ((host . "a") (port . 1)) ((host . "b") (port . 2)) ((host . "c") (port . "eighty"))))`)
+	var cfg appConfig
+	err := Unmarshal(node, &cfg)
+	var uerr *UnmarshalError
+	if !errors.As(err, &uerr) || err.Error() != "servers[2].port: expected integer, got string" {
+		t.Fatalf("expected a path-qualified error, got %v", err)
+	}
+	if uerr.Path != "servers[2].port" {
+		t.Errorf("expected the path servers[2].port, got %s", uerr.Path)
+	}
+
+	for src, msg := range map[string]string{
+		`'((servers . 5))`:                  "servers: expected list, got integer",
+		`'((servers (3)))`:                  "servers[0][0]: expected (key . value), got integer",
+		`'((servers ((3 . 1))))`:            "servers[0][0]: expected symbol or string key, got integer",
+		`'((servers ((timeout . "soon"))))`: `servers[0].timeout: bad duration "soon"`,
+		`'((limits ("cpu" . 1.5)))`:         "limits.cpu: expected integer, got float",
+	} {
+		if err := Unmarshal(evalConfig(t, src), &appConfig{}); err == nil || err.Error() != msg {
+			t.Errorf("%s: expected %q, got %v", src, msg, err)
+		}
+	}
+}
+
+func TestUnmarshalRejectsOverflowAndNonPointers(t *testing.T) {
+	var small struct{ N int8 }
+	err := Unmarshal(evalConfig(t, `'((n . 300))`), &small)
+	if err == nil || err.Error() != "n: 300 does not fit in int8" {
+		t.Errorf("expected an overflow error, got %v", err)
+	}
+	if err := Unmarshal(e.IntNode(1), small); err == nil {
+		t.Error("expected an error for a non-pointer")
+	}
+}
+
+func TestUnmarshalFromMummyMaps(t *testing.T) {
+	g := New()
+	g.DefineValue("settings", map[string]any{"host": "h", "port": 7, "tags": []string{"t"}})
+	node, err := g.Process(strings.NewReader("settings"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var s serverConfig
+	if err := Unmarshal(node, &s); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if s.Host != "h" || s.Port != 7 || !reflect.DeepEqual(s.Tags, []string{"t"}) {
+		t.Errorf("unexpected result %+v", s)
+	}
+}
+
+func TestUnmarshalIntoInterfaces(t *testing.T) {
+	var v any
+	if err := Unmarshal(evalConfig(t, `'(1 "two" (3.5 #t))`), &v); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := []any{1, "two", []any{3.5, true}}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("expected %v, got %v", want, v)
+	}
+}
+
+func TestUnmarshalPairsIntoInterfaces(t *testing.T) {
+	var v any
+	if err := Unmarshal(evalConfig(t, `'((port . 80) (hosts "a" "b") ("name" . "api"))`), &v); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := map[string]any{"port": 80, "hosts": []any{"a", "b"}, "name": "api"}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("expected %v, got %v", want, v)
+	}
+	if err := Unmarshal(evalConfig(t, `'(1 2 . 3)`), &v); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if want := []any{1, 2, 3}; !reflect.DeepEqual(v, want) {
+		t.Errorf("expected %v, got %v", want, v)
+	}
+
+	var ints []int
+	err := Unmarshal(evalConfig(t, `'(1 . 2)`), &ints)
+	if err == nil || err.Error() != "expected list, got improper list" {
+		t.Errorf("expected an improper list error, got %v", err)
+	}
+}
+
+func TestUnmarshalRejectsNilNodes(t *testing.T) {
+	var v any
+	var unmarshalErr *UnmarshalError
+	if err := Unmarshal(nil, &v); !errors.As(err, &unmarshalErr) {
+		t.Errorf("expected an UnmarshalError, got %v", err)
+	}
+}
+
+func TestMarshalRoundTrips(t *testing.T) {
+	debug := false
+	cfg := appConfig{
+		Name:     "api",
+		MaxConns: 8,
+		Debug:    &debug,
+		Servers:  []serverConfig{{Host: "a", Port: 1, Timeout: time.Second, Tags: []string{"x"}}, {Host: "b", Port: 2}},
+		Limits:   map[string]int{"mem": 1, "cpu": 2},
+		Secret:   "hidden",
+	}
+	node, err := Marshal(cfg)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	wantRepr := `((name . "api") (max-conns . 8) (debug . #f) (servers ((host . "a") (port . 1) (timeout . "1s") (tags "x")) ((host . "b") (port . 2))) (limits ("cpu" . 2) ("mem" . 1)))`
+	if node.Repr() != wantRepr {
+		t.Errorf("expected\n%s\ngot\n%s", wantRepr, node.Repr())
+	}
+
+	var back appConfig
+	if err := Unmarshal(node, &back); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cfg.Secret = ""
+	if !reflect.DeepEqual(back, cfg) {
+		t.Errorf("expected %+v, got %+v", cfg, back)
+	}
+}
+
+func TestMarshalRejectsUnsupportedTypes(t *testing.T) {
+	_, err := Marshal(struct{ Events chan int }{})
+	if err == nil || err.Error() != "marshal: events: cannot marshal chan int" {
+		t.Errorf("expected a marshal error, got %v", err)
+	}
+}
+
+type cyclic struct {
+	Name string
+	Next *cyclic
+}
+
+func TestMarshalRejectsCycles(t *testing.T) {
+	loop := &cyclic{Name: "a"}
+	loop.Next = &cyclic{Name: "b", Next: loop}
+	_, err := Marshal(loop)
+	if err == nil || !strings.Contains(err.Error(), "next.next: encountered a cycle") {
+		t.Errorf("expected a cycle error, got %v", err)
+	}
+
+	m := map[string]any{}
+	m["self"] = m
+	if _, err := Marshal(m); err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("expected a cycle error for a map, got %v", err)
+	}
+
+	shared := &serverConfig{Host: "h"}
+	if _, err := Marshal([]*serverConfig{shared, shared}); err != nil {
+		t.Errorf("expected a shared pointer to marshal twice, got %v", err)
+	}
+}
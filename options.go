@@ -0,0 +1,126 @@
+package ghoul
+
+import (
+	"bufio"
+	"io"
+	"io/fs"
+	"log/slog"
+
+	ev "github.com/archevel/ghoul/consume"
+	"github.com/archevel/ghoul/engraving"
+	"github.com/archevel/ghoul/sarcophagus"
+)
+
+// Option configures a Ghoul instance made by NewWithOptions.
+type Option func(*options)
+
+type options struct {
+	stdout, stderr io.Writer
+	stdin          io.Reader
+	logger         engraving.Logger
+	modulePaths    []string
+	registry       *sarcophagus.Registry
+	files          fs.FS
+	sandbox        *Sandbox
+	// allowedMummies is only used when restrictMummies is set, so an
+	// empty allowlist can forbid every mummy.
+	allowedMummies  []string
+	restrictMummies bool
+	noPrelude       bool
+}
+
+// WithStdout makes print, println and the other output builtins write to
+// w instead of os.Stdout.
+func WithStdout(w io.Writer) Option {
+	return func(o *options) { o.stdout = w }
+}
+
+// WithStderr makes eprint and eprintln write to w instead of os.Stderr.
+// Unless WithLogger is also given, warnings are logged to w as well.
+func WithStderr(w io.Writer) Option {
+	return func(o *options) { o.stderr = w }
+}
+
+// WithStdin makes read-line read from r instead of os.Stdin.
+func WithStdin(r io.Reader) Option {
+	return func(o *options) { o.stdin = r }
+}
+
+// WithLogger sets the logger for expansion and evaluation diagnostics.
+func WithLogger(logger engraving.Logger) Option {
+	return func(o *options) { o.logger = logger }
+}
+
+// WithModulePaths adds directories that require searches, in order, for
+// Ghoul modules not found next to the requiring file. Code given to
+// Process, which has no file, can require from them too.
+func WithModulePaths(dirs ...string) Option {
+	return func(o *options) { o.modulePaths = append(o.modulePaths, dirs...) }
+}
+
+// WithRegistry makes require load sarcophagi from r instead of
+// sarcophagus.Default, where imported mummy packages entomb themselves.
+// Registry.Subset of the default one gives an instance a few of them.
+func WithRegistry(r *sarcophagus.Registry) Option {
+	return func(o *options) { o.registry = r }
+}
+
+// WithAllowedMummies limits require to the named sarcophagi, by short name
+// or import path. Without it every entombed mummy may be required; with
+// no names, none may.
+func WithAllowedMummies(names ...string) Option {
+	return func(o *options) {
+		o.allowedMummies = append(o.allowedMummies, names...)
+		o.restrictMummies = true
+	}
+}
+
+// WithoutPrelude leaves the standard prelude unloaded, as NewBare does.
+func WithoutPrelude() Option {
+	return func(o *options) { o.noPrelude = true }
+}
+
+// NewWithOptions creates a Ghoul instance configured by opts. With no
+// options it is the same as New.
+func NewWithOptions(opts ...Option) Ghoul {
+	var o options
+	for _, opt := range opts {
+		opt(&o)
+	}
+	if o.logger == nil {
+		o.logger = engraving.StandardLogger
+		if o.stderr != nil {
+			o.logger = engraving.NewWithWriter(o.stderr, slog.LevelWarn)
+		}
+	}
+	if o.sandbox != nil {
+		o.applySandbox()
+	}
+	return newGhoul(o)
+}
+
+// configure applies the streams and restrictions in o to g's evaluators.
+func (g ghoul) configure(o options) {
+	// Both evaluators read through one buffer, so input buffered while a
+	// macro read a line is still there for the code it expanded to.
+	var stdin *bufio.Reader
+	if o.stdin != nil {
+		stdin = bufio.NewReader(o.stdin)
+	}
+	for _, evaluator := range []*ev.Evaluator{g.evaluator, g.reanimator.Evaluator()} {
+		evaluator.SetStdout(o.stdout)
+		evaluator.SetStderr(o.stderr)
+		if stdin != nil {
+			evaluator.SetStdin(stdin)
+		}
+	}
+	if o.registry != nil {
+		g.reanimator.SetRegistry(o.registry)
+	}
+	if o.restrictMummies {
+		g.reanimator.SetAllowedMummies(append([]string{}, o.allowedMummies...))
+	}
+	if o.sandbox != nil {
+		g.restrict(o.sandbox)
+	}
+}
@@ -0,0 +1,158 @@
+package ghoul
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	e "github.com/archevel/ghoul/bones"
+	ev "github.com/archevel/ghoul/consume"
+	"github.com/archevel/ghoul/sarcophagus"
+)
+
+func TestWithStdoutCapturesOutputPerInstance(t *testing.T) {
+	var first, second bytes.Buffer
+	a := NewWithOptions(WithStdout(&first))
+	b := NewWithOptions(WithStdout(&second))
+	if _, err := a.Process(strings.NewReader(`(println "from a")`)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := b.Process(strings.NewReader(`(print "from b")`)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if first.String() != "from a\n" || second.String() != "from b" {
+		t.Errorf("expected each instance to write to its own stdout, got %q and %q", first.String(), second.String())
+	}
+}
+
+func TestWithStderrReceivesErrorOutput(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	g := NewWithOptions(WithStdout(&stdout), WithStderr(&stderr))
+	if _, err := g.Process(strings.NewReader(`(eprintln "oops")`)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stderr.String() != "oops\n" || stdout.Len() != 0 {
+		t.Errorf("expected oops on stderr only, got stdout %q, stderr %q", stdout.String(), stderr.String())
+	}
+}
+
+func TestWithStdinFeedsReadLine(t *testing.T) {
+	g := NewWithOptions(WithStdin(strings.NewReader("first\nsecond\n")))
+	result, err := g.Process(strings.NewReader(`(read-line) (read-line)`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !result.Equiv(e.StrNode("second")) {
+		t.Errorf("expected the second line, got %s", result.Repr())
+	}
+}
+
+func TestRequiredModulesWriteToTheInstancesStdout(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "noisy.ghl"), []byte(`(println "loading")`), 0644)
+	os.WriteFile(filepath.Join(dir, "main.ghl"), []byte("(require noisy)"), 0644)
+
+	var out bytes.Buffer
+	g := NewWithOptions(WithStdout(&out))
+	if _, err := g.ProcessFile(filepath.Join(dir, "main.ghl")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if out.String() != "loading\n" {
+		t.Errorf("expected the module's output to be captured, got %q", out.String())
+	}
+}
+
+func TestWithModulePathsLetsProcessRequireModules(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "utils.ghl"), []byte("(define x 42)"), 0644)
+
+	g := NewWithOptions(WithModulePaths(dir))
+	result, err := g.Process(strings.NewReader("(require utils) utils:x"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !result.Equiv(e.IntNode(42)) {
+		t.Errorf("expected 42, got %s", result.Repr())
+	}
+
+	_, err = New().Process(strings.NewReader("(require utils) utils:x"))
+	if err == nil {
+		t.Error("expected require without a file or module paths to fail")
+	}
+}
+
+func TestWithAllowedMummiesRefusesOthers(t *testing.T) {
+	defer sarcophagus.ClearRegistry()
+	answer := func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
+		return e.IntNode(42), nil
+	}
+	for _, name := range []string{"safe", "unsafe"} {
+		sarcophagus.Entomb(name, "github.com/example/"+name, &sarcophagus.Mummy{
+			Names: []string{"answer"},
+			Register: func(prefix string, only map[string]bool, register func(string, interface{})) {
+				sarcophagus.RegisterIfAllowed(prefix, only, "answer", answer, register)
+			},
+		})
+	}
+
+	g := NewWithOptions(WithAllowedMummies("safe"))
+	if result, err := g.Process(strings.NewReader("(require safe) (safe:answer)")); err != nil || !result.Equiv(e.IntNode(42)) {
+		t.Errorf("expected the allowed mummy to load, got %v, %v", result, err)
+	}
+	_, err := g.Process(strings.NewReader("(require unsafe)"))
+	if err == nil || !strings.Contains(err.Error(), "mummy 'unsafe' is not allowed") {
+		t.Errorf("expected unsafe to be refused, got %v", err)
+	}
+
+	_, err = NewWithOptions(WithAllowedMummies()).Process(strings.NewReader("(require safe)"))
+	if err == nil {
+		t.Error("expected an empty allowlist to refuse every mummy")
+	}
+}
+
+func TestWithRegistryGivesInstancesTheirOwnMummies(t *testing.T) {
+	answer := func(n int64) func([]*e.Node, *ev.Evaluator) (*e.Node, error) {
+		return func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
+			return e.IntNode(n), nil
+		}
+	}
+	trusted := sarcophagus.NewRegistry()
+	for i, name := range []string{"strs", "files"} {
+		fn := answer(int64(i))
+		trusted.Entomb(name, "github.com/example/"+name, &sarcophagus.Mummy{
+			Names: []string{"answer"},
+			Register: func(prefix string, only map[string]bool, register func(string, interface{})) {
+				sarcophagus.RegisterIfAllowed(prefix, only, "answer", fn, register)
+			},
+		})
+	}
+	sandboxed := NewWithOptions(WithRegistry(trusted.Subset("strs")))
+	full := NewWithOptions(WithRegistry(trusted))
+
+	if result, err := full.Process(strings.NewReader("(require files) (files:answer)")); err != nil || !result.Equiv(e.IntNode(1)) {
+		t.Errorf("expected the trusted instance to load files, got %v, %v", result, err)
+	}
+	if result, err := sandboxed.Process(strings.NewReader("(require strs) (strs:answer)")); err != nil || !result.Equiv(e.IntNode(0)) {
+		t.Errorf("expected the sandboxed instance to load strs, got %v, %v", result, err)
+	}
+	_, err := sandboxed.Process(strings.NewReader("(require files)"))
+	if err == nil || !strings.Contains(err.Error(), "not found") {
+		t.Errorf("expected files to be unknown to the sandboxed instance, got %v", err)
+	}
+	if _, err := New().Process(strings.NewReader("(require strs)")); err == nil {
+		t.Error("expected the default registry not to see mummies of another one")
+	}
+}
+
+func TestWithoutPreludeLeavesPreludeMacrosUndefined(t *testing.T) {
+	_, err := NewWithOptions(WithoutPrelude()).Process(strings.NewReader("(let ((x 1)) x)"))
+	if err == nil {
+		t.Error("expected let to be undefined without the prelude")
+	}
+	result, err := NewWithOptions().Process(strings.NewReader("(let ((x 1)) x)"))
+	if err != nil || !result.Equiv(e.IntNode(1)) {
+		t.Errorf("expected let from the prelude, got %v, %v", result, err)
+	}
+}
@@ -0,0 +1,201 @@
+// Package ossuary keeps the bones of expanded modules between runs. A
+// Cache stores the fully expanded core forms of a module on disk, together
+// with the expansion-time effects (macro definitions and requires) needed
+// to rebuild its macro scope, so an unchanged module can skip parsing and
+// macro expansion the next time it is required.
+package ossuary
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"github.com/archevel/ghoul/bones"
+	"github.com/archevel/ghoul/consume"
+)
+
+// FormatVersion is bumped whenever the layout of an entry changes, so
+// entries written by an incompatible build are never read back. The nodes
+// inside carry consume.GhcFormatVersion, which is checked on reading.
+const FormatVersion = 2
+
+// ErrUncacheable is returned by Store when an expansion contains values
+// that only exist at runtime, such as procedures spliced in by a general
+// transformer.
+var ErrUncacheable = errors.New("expansion contains runtime values")
+
+// Key identifies one expansion of a module. Every field takes part in the
+// lookup: a different source, interpreter version or set of macros visible
+// when expansion started yields a different entry.
+type Key struct {
+	Path     string // source file the module was loaded from
+	Content  []byte // source text of the module
+	Version  string // interpreter version that produced the expansion
+	MacroSet string // fingerprint of the macros in scope before expansion
+}
+
+func (k Key) digest() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "ossuary/%d\x00%s\x00%s\x00%s\x00", FormatVersion, k.Version, k.Path, k.MacroSet)
+	h.Write(k.Content)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Entry is a cached module expansion.
+type Entry struct {
+	// Effects are the top-level forms that changed the macro scope or the
+	// environment during expansion, in order. Replaying them restores the
+	// module's macros and required bindings.
+	Effects []*bones.Node
+	// Forms are the expanded core forms, ready for translation.
+	Forms []*bones.Node
+	// MacroSet fingerprints the macros in scope after expansion. A
+	// mismatch after replaying Effects means an imported module changed.
+	MacroSet string
+}
+
+// Cache is a directory of expansion entries. It is safe to share between
+// interpreter instances; writes are atomic renames.
+type Cache struct {
+	dir string
+}
+
+// Open returns a cache rooted at dir, creating the directory if needed.
+func Open(dir string) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("ossuary: %w", err)
+	}
+	return &Cache{dir: dir}, nil
+}
+
+// Dir returns the directory the cache is stored in.
+func (c *Cache) Dir() string {
+	return c.dir
+}
+
+func (c *Cache) pathDir(path string) string {
+	sum := sha256.Sum256([]byte(path))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:8]))
+}
+
+func (c *Cache) entryFile(key Key) string {
+	return filepath.Join(c.pathDir(key.Path), key.digest()+".bones")
+}
+
+// Load returns the entry stored for key. Hygiene marks in the entry are
+// replaced with marks drawn from fresh, in their original order, so they
+// cannot collide with marks handed out since the entry was written.
+// A missing or unreadable entry is reported as a miss.
+func (c *Cache) Load(key Key, fresh func() uint64) (*Entry, bool) {
+	data, err := os.ReadFile(c.entryFile(key))
+	if err != nil {
+		return nil, false
+	}
+	entry, err := decode(data, fresh)
+	if err != nil {
+		return nil, false
+	}
+	return entry, true
+}
+
+// Store writes entry under key, replacing any older expansion of the same
+// source path. It returns ErrUncacheable if the entry cannot be encoded.
+func (c *Cache) Store(key Key, entry *Entry) error {
+	data, err := encode(entry)
+	if err != nil {
+		return err
+	}
+
+	dir := c.pathDir(key.Path)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return fmt.Errorf("ossuary: %w", err)
+	}
+	tmp, err := os.CreateTemp(dir, "tmp-*")
+	if err != nil {
+		return fmt.Errorf("ossuary: %w", err)
+	}
+	_, werr := tmp.Write(data)
+	cerr := tmp.Close()
+	if werr != nil || cerr != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("ossuary: failed to write entry: %w", errors.Join(werr, cerr))
+	}
+	target := c.entryFile(key)
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("ossuary: %w", err)
+	}
+
+	// Older expansions of the same file are dead weight once replaced.
+	stale, _ := filepath.Glob(filepath.Join(dir, "*.bones"))
+	for _, f := range stale {
+		if f != target {
+			os.Remove(f)
+		}
+	}
+	return nil
+}
+
+// Invalidate drops every cached expansion of the module at path.
+func (c *Cache) Invalidate(path string) error {
+	if err := os.RemoveAll(c.pathDir(path)); err != nil {
+		return fmt.Errorf("ossuary: %w", err)
+	}
+	return nil
+}
+
+// Clear drops every entry in the cache.
+func (c *Cache) Clear() error {
+	entries, err := os.ReadDir(c.dir)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("ossuary: %w", err)
+	}
+	for _, de := range entries {
+		if err := os.RemoveAll(filepath.Join(c.dir, de.Name())); err != nil {
+			return fmt.Errorf("ossuary: %w", err)
+		}
+	}
+	return nil
+}
+
+// --- encoding ---
+
+// encode writes an entry as its MacroSet, length-prefixed, followed by its
+// effects and forms in consume's node encoding, the one .ghc files use.
+func encode(entry *Entry) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.Write(binary.AppendUvarint(nil, uint64(len(entry.MacroSet))))
+	buf.WriteString(entry.MacroSet)
+	if err := consume.WriteNodes(&buf, entry.Effects, entry.Forms); err != nil {
+		if errors.Is(err, consume.ErrUnserializable) {
+			return nil, fmt.Errorf("%w: %w", ErrUncacheable, err)
+		}
+		return nil, fmt.Errorf("ossuary: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func decode(data []byte, fresh func() uint64) (*Entry, error) {
+	n, size := binary.Uvarint(data)
+	if size <= 0 || n > uint64(len(data)-size) {
+		return nil, errors.New("ossuary: truncated entry")
+	}
+	macroSet := string(data[size : size+int(n)])
+	groups, err := consume.ReadNodes(data[size+int(n):], fresh)
+	if err != nil {
+		return nil, fmt.Errorf("ossuary: %w", err)
+	}
+	if len(groups) != 2 {
+		return nil, fmt.Errorf("ossuary: expected effects and forms, got %d groups", len(groups))
+	}
+	return &Entry{Effects: groups[0], Forms: groups[1], MacroSet: macroSet}, nil
+}
@@ -0,0 +1,178 @@
+package ossuary
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/archevel/ghoul/bones"
+)
+
+func counterFrom(start uint64) func() uint64 {
+	next := start
+	return func() uint64 {
+		next++
+		return next
+	}
+}
+
+func testKey(path string) Key {
+	return Key{Path: path, Content: []byte("(define x 1)"), Version: "test", MacroSet: "none"}
+}
+
+func TestStoreAndLoadRoundTrip(t *testing.T) {
+	c, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := "mod.ghl"
+	pos := &bones.SourcePosition{Ln: 3, Col: 5, Filename: &file}
+	form := &bones.Node{Kind: bones.ListNode, Loc: pos, Children: []*bones.Node{
+		bones.IdentNode("define"),
+		bones.ScopedIdentNode("x", map[uint64]bool{40: true, 7: true}),
+		bones.QuoteNodeVal(&bones.Node{Kind: bones.ListNode, Children: []*bones.Node{bones.IntNode(1)}, DottedTail: bones.StrNode("tail")}),
+	}}
+	key := testKey("mod.ghl")
+	if err := c.Store(key, &Entry{Forms: []*bones.Node{form}, MacroSet: "after"}); err != nil {
+		t.Fatal(err)
+	}
+
+	entry, ok := c.Load(key, counterFrom(100))
+	if !ok {
+		t.Fatal("expected a hit")
+	}
+	if entry.MacroSet != "after" || len(entry.Forms) != 1 {
+		t.Fatalf("unexpected entry %+v", entry)
+	}
+	got := entry.Forms[0]
+	if got.Repr() != form.Repr() {
+		t.Errorf("expected %s, got %s", form.Repr(), got.Repr())
+	}
+	if got.Loc.String() != "mod.ghl:3:5" {
+		t.Errorf("expected location mod.ghl:3:5, got %s", got.Loc.String())
+	}
+	marks := got.Children[1].Marks
+	if len(marks) != 2 || !marks[101] || !marks[102] {
+		t.Errorf("expected marks remapped in order to 101,102, got %v", marks)
+	}
+	if got.Children[2].Quoted.DottedTail.StrVal != "tail" {
+		t.Errorf("dotted tail lost: %s", got.Children[2].Repr())
+	}
+}
+
+func TestLoadKeepsSharedExpansionLocationsShared(t *testing.T) {
+	c, _ := Open(t.TempDir())
+	call := &bones.SourcePosition{Ln: 9, Col: 1}
+	def := &bones.SourcePosition{Ln: 2, Col: 4}
+	mel := &bones.MacroExpansionLocation{MacroName: "outer", CallSite: call, DefSite: def}
+	a := &bones.Node{Kind: bones.ListNode, Loc: mel, Children: []*bones.Node{bones.IdentNode("f")}}
+	b := &bones.Node{Kind: bones.ListNode, Loc: mel, Children: []*bones.Node{bones.IdentNode("g")}}
+	key := testKey("m.ghl")
+	if err := c.Store(key, &Entry{Forms: []*bones.Node{a, b}}); err != nil {
+		t.Fatal(err)
+	}
+
+	entry, ok := c.Load(key, counterFrom(0))
+	if !ok {
+		t.Fatal("expected a hit")
+	}
+	got, ok := entry.Forms[0].Loc.(*bones.MacroExpansionLocation)
+	if !ok {
+		t.Fatalf("expected expansion location, got %T", entry.Forms[0].Loc)
+	}
+	if got != entry.Forms[1].Loc {
+		t.Error("expected both forms to share one expansion location")
+	}
+	if got.Backtrace() != mel.Backtrace() {
+		t.Errorf("expected %q, got %q", mel.Backtrace(), got.Backtrace())
+	}
+}
+
+func TestLoadMissesOnAnyKeyChange(t *testing.T) {
+	c, _ := Open(t.TempDir())
+	key := testKey("m.ghl")
+	c.Store(key, &Entry{Forms: []*bones.Node{bones.IntNode(1)}})
+
+	for name, k := range map[string]Key{
+		"content":   {Path: key.Path, Content: []byte("(define x 2)"), Version: key.Version, MacroSet: key.MacroSet},
+		"version":   {Path: key.Path, Content: key.Content, Version: "other", MacroSet: key.MacroSet},
+		"macro set": {Path: key.Path, Content: key.Content, Version: key.Version, MacroSet: "other"},
+		"path":      {Path: "n.ghl", Content: key.Content, Version: key.Version, MacroSet: key.MacroSet},
+	} {
+		if _, ok := c.Load(k, counterFrom(0)); ok {
+			t.Errorf("expected a miss after changing %s", name)
+		}
+	}
+	if _, ok := c.Load(key, counterFrom(0)); !ok {
+		t.Error("expected a hit for the original key")
+	}
+}
+
+func TestStoreReplacesOlderEntriesForSamePath(t *testing.T) {
+	c, _ := Open(t.TempDir())
+	old := testKey("m.ghl")
+	c.Store(old, &Entry{})
+	newer := old
+	newer.Content = []byte("(define x 2)")
+	c.Store(newer, &Entry{})
+
+	if _, ok := c.Load(old, counterFrom(0)); ok {
+		t.Error("expected the older entry to be removed")
+	}
+	if _, ok := c.Load(newer, counterFrom(0)); !ok {
+		t.Error("expected the newer entry to be kept")
+	}
+}
+
+func TestInvalidateAndClear(t *testing.T) {
+	dir := t.TempDir()
+	c, _ := Open(dir)
+	a, b := testKey("a.ghl"), testKey("b.ghl")
+	c.Store(a, &Entry{})
+	c.Store(b, &Entry{})
+
+	if err := c.Invalidate("a.ghl"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Load(a, counterFrom(0)); ok {
+		t.Error("expected a.ghl to be invalidated")
+	}
+	if _, ok := c.Load(b, counterFrom(0)); !ok {
+		t.Error("expected b.ghl to survive invalidating a.ghl")
+	}
+
+	if err := c.Clear(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.Load(b, counterFrom(0)); ok {
+		t.Error("expected Clear to drop b.ghl")
+	}
+	if _, err := os.Stat(dir); err != nil {
+		t.Errorf("Clear should keep the cache directory: %v", err)
+	}
+}
+
+func TestStoreRejectsRuntimeValues(t *testing.T) {
+	c, _ := Open(t.TempDir())
+	fn := bones.FuncNode(func(args []*bones.Node, ev bones.Evaluator) (*bones.Node, error) { return bones.Nil, nil })
+	err := c.Store(testKey("m.ghl"), &Entry{Forms: []*bones.Node{bones.NewListNode([]*bones.Node{fn})}})
+	if !errors.Is(err, ErrUncacheable) {
+		t.Errorf("expected ErrUncacheable, got %v", err)
+	}
+}
+
+func TestLoadTreatsCorruptEntryAsMiss(t *testing.T) {
+	c, _ := Open(t.TempDir())
+	key := testKey("m.ghl")
+	c.Store(key, &Entry{})
+	files, _ := filepath.Glob(filepath.Join(c.Dir(), "*", "*.bones"))
+	if len(files) != 1 {
+		t.Fatalf("expected one entry file, got %v", files)
+	}
+	os.WriteFile(files[0], []byte("not gob"), 0o644)
+
+	if _, ok := c.Load(key, counterFrom(0)); ok {
+		t.Error("expected a corrupt entry to miss")
+	}
+}
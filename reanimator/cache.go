@@ -0,0 +1,86 @@
+package reanimator
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+
+	"github.com/archevel/ghoul/bones"
+	"github.com/archevel/ghoul/ossuary"
+)
+
+// SetExpansionCache configures the cache the module loader consults before
+// parsing and expanding a required module. A nil cache disables caching.
+func (exp *Reanimator) SetExpansionCache(c *ossuary.Cache) {
+	exp.expansionCache = c
+}
+
+// ExpansionCache returns the configured expansion cache, or nil.
+func (exp *Reanimator) ExpansionCache() *ossuary.Cache {
+	return exp.expansionCache
+}
+
+// FreshMark hands out a new hygiene mark from the shared counter.
+func (exp *Reanimator) FreshMark() uint64 {
+	return exp.freshMark()
+}
+
+// ExpandRecording expands top-level forms like ReanimateNodes but returns
+// the untranslated core forms together with the expansion-time effects
+// (macro definitions and requires) seen at the top-level scope, in order.
+// Replaying the effects with ReplayEffects rebuilds the macro scope
+// without expanding the forms again.
+func (exp *Reanimator) ExpandRecording(topLevel *bones.Node) (forms []*bones.Node, effects []*bones.Node, err error) {
+	savedRecording, savedEffects := exp.recording, exp.effects
+	exp.recording, exp.effects = true, nil
+	defer func() { exp.recording, exp.effects = savedRecording, savedEffects }()
+
+	forms, err = exp.expandTopLevel(topLevel)
+	return forms, exp.effects, err
+}
+
+// ReplayEffects processes effects recorded by ExpandRecording in the
+// current top-level scope.
+func (exp *Reanimator) ReplayEffects(effects []*bones.Node) error {
+	// A module replayed while its requirer is being recorded must not
+	// leak its effects into the requirer's entry.
+	savedRecording := exp.recording
+	exp.recording = false
+	defer func() { exp.recording = savedRecording }()
+
+	_, err := exp.expandTopLevel(&bones.Node{Kind: bones.ListNode, Children: effects})
+	return err
+}
+
+func (exp *Reanimator) recordEffect(node *bones.Node, scope *macroScope) {
+	if exp.recording && scope == exp.nodeScopes {
+		exp.effects = append(exp.effects, node)
+	}
+}
+
+// MacroFingerprint summarises the macros visible from the top-level scope.
+// Two expansions of the same source agree whenever their fingerprints do.
+func (exp *Reanimator) MacroFingerprint() string {
+	visible := map[string]string{}
+	for scope := exp.nodeScopes; scope != nil; scope = scope.parent {
+		for name, b := range scope.bindings {
+			if _, shadowed := visible[name]; !shadowed {
+				visible[name] = b.source
+			}
+		}
+	}
+	names := make([]string, 0, len(visible))
+	for name := range visible {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	for _, name := range names {
+		h.Write([]byte(name))
+		h.Write([]byte{0})
+		h.Write([]byte(visible[name]))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -1,7 +1,7 @@
 // Package reanimator brings macromancy macros to life by expanding them
 // into living code. It runs as a separate phase between parsing
 // and evaluation — after reanimation, the expression tree contains no
-// define-syntax forms and no macro calls, only core forms (lambda, define,
+// define-syntax, define-macro or syntax parameter forms and no macro calls, only core forms (lambda, define,
 // set!, cond, begin, quote, require) and function calls.
 //
 // The reanimator maintains its own macro environment and uses a sub-evaluator
@@ -10,13 +10,17 @@
 package reanimator
 
 import (
+	"context"
 	"fmt"
 	"sync/atomic"
+	"time"
 
 	"github.com/archevel/ghoul/bones"
 	ev "github.com/archevel/ghoul/consume"
 	"github.com/archevel/ghoul/engraving"
 	"github.com/archevel/ghoul/macromancy"
+	"github.com/archevel/ghoul/ossuary"
+	"github.com/archevel/ghoul/sarcophagus"
 	"github.com/archevel/ghoul/tome"
 )
 
@@ -33,6 +37,24 @@ type Reanimator struct {
 	moduleState     *ev.ModuleState
 	moduleLoader    ModuleLoader
 	requiredModules map[string]bool
+	expansionCache  *ossuary.Cache
+
+	// registry holds the sarcophagi require can load; see SetRegistry.
+	registry *sarcophagus.Registry
+	// allowedMummies, when non-nil, names the only sarcophagi require
+	// may load, and deniedMummies those it may never load; allowedModules
+	// does the same for Ghoul modules. See SetAllowedMummies.
+	allowedMummies map[string]bool
+	deniedMummies  map[string]bool
+	allowedModules map[string]bool
+
+	// ctx is the context expansion runs under; see ReanimateNodesWithContext.
+	ctx context.Context
+
+	// recording collects top-level expansion-time effects while a module
+	// is expanded for the expansion cache; see ExpandRecording.
+	recording bool
+	effects   []*bones.Node
 }
 
 // New creates a Reanimator with its own evaluation environment for running
@@ -48,6 +70,7 @@ func New(logger engraving.Logger, markCounter *uint64) *Reanimator {
 		markCounter:     markCounter,
 		log:             logger,
 		requiredModules: map[string]bool{},
+		registry:        sarcophagus.Default,
 	}
 }
 
@@ -55,6 +78,46 @@ func (exp *Reanimator) SetModuleState(ms *ev.ModuleState) {
 	exp.moduleState = ms
 }
 
+// ModuleState returns the state set with SetModuleState, or nil.
+func (exp *Reanimator) ModuleState() *ev.ModuleState {
+	return exp.moduleState
+}
+
+// SetRegistry makes require load sarcophagi from r instead of
+// sarcophagus.Default.
+func (exp *Reanimator) SetRegistry(r *sarcophagus.Registry) {
+	exp.registry = r
+}
+
+// SetAllowedMummies restricts require to the named sarcophagi, by short
+// name or import path. A nil names allows every entombed mummy.
+func (exp *Reanimator) SetAllowedMummies(names []string) {
+	exp.allowedMummies = nameSet(names)
+}
+
+// SetDeniedMummies makes require refuse the named sarcophagi, by short
+// name or import path, even when SetAllowedMummies allows them.
+func (exp *Reanimator) SetDeniedMummies(names []string) {
+	exp.deniedMummies = nameSet(names)
+}
+
+// SetAllowedModules restricts require to the named Ghoul modules, as
+// written in the require. A nil names allows every module.
+func (exp *Reanimator) SetAllowedModules(names []string) {
+	exp.allowedModules = nameSet(names)
+}
+
+func nameSet(names []string) map[string]bool {
+	if names == nil {
+		return nil
+	}
+	set := make(map[string]bool, len(names))
+	for _, name := range names {
+		set[name] = true
+	}
+	return set
+}
+
 func (exp *Reanimator) SetModuleLoader(loader ModuleLoader) {
 	exp.moduleLoader = loader
 }
@@ -92,6 +155,12 @@ func (exp *Reanimator) PushModuleScope() *macroScope {
 	return saved
 }
 
+// ResetModuleScope discards the macros defined in the scope pushed by
+// PushModuleScope, leaving an empty scope in its place.
+func (exp *Reanimator) ResetModuleScope() {
+	exp.nodeScopes = newMacroScope(exp.nodeScopes.parent)
+}
+
 // PopModuleScope restores the macro scope saved by PushModuleScope.
 func (exp *Reanimator) PopModuleScope(saved *macroScope) {
 	exp.nodeScopes = saved
@@ -112,9 +181,27 @@ type generalTransformer struct {
 	funcNode *bones.Node
 }
 
+// plainTransformer holds a FuncNode defined with define-macro. It is
+// called with the unevaluated argument forms as plain data and its result
+// is spliced in without any hygiene; use gensym to introduce fresh names.
+type plainTransformer struct {
+	funcNode *bones.Node
+}
+
 type macroBinding struct {
-	syntaxTransformer  *macromancy.SyntaxTransformer
+	syntaxTransformer *macromancy.SyntaxTransformer
+	// rules and defBindings are what syntaxTransformer was built from,
+	// kept so Snapshot can store it.
+	rules              *bones.Node
+	defBindings        map[string]bool
 	generalTransformer *generalTransformer
+	plainTransformer   *plainTransformer
+	// source is the printed definition, used to fingerprint the macros in
+	// scope for the expansion cache.
+	source string
+	// syntaxParameter marks bindings made by define-syntax-parameter,
+	// the only ones syntax-parameterize may rebind.
+	syntaxParameter bool
 }
 
 func newMacroScope(parent *macroScope) *macroScope {
@@ -137,6 +224,36 @@ func (s *macroScope) define(name string, b macroBinding) {
 // ReanimateNodes expands all macros in a Node tree and translates the
 // result into semantic nodes. This is the pipeline entry point.
 func (exp *Reanimator) ReanimateNodes(topLevel *bones.Node) ([]*bones.Node, error) {
+	results, err := exp.expandTopLevel(topLevel)
+	if err != nil {
+		return nil, err
+	}
+	return TranslateNodes(results)
+}
+
+// ReanimateNodesWithContext is ReanimateNodes with transformers and
+// required modules running under ctx, so cancelling it stops expansion.
+// The time it takes is added to the Expansion of ctx's stats, if any.
+func (exp *Reanimator) ReanimateNodesWithContext(ctx context.Context, topLevel *bones.Node) ([]*bones.Node, error) {
+	if stats := ev.StatsFrom(ctx); stats != nil {
+		defer func(start time.Time) { stats.Expansion += time.Since(start) }(time.Now())
+	}
+	savedCtx := exp.ctx
+	exp.ctx = ctx
+	defer func() { exp.ctx = savedCtx }()
+	return exp.ReanimateNodes(topLevel)
+}
+
+// Context returns the context expansion currently runs under, or
+// context.Background outside ReanimateNodesWithContext.
+func (exp *Reanimator) Context() context.Context {
+	if exp.ctx != nil {
+		return exp.ctx
+	}
+	return context.Background()
+}
+
+func (exp *Reanimator) expandTopLevel(topLevel *bones.Node) ([]*bones.Node, error) {
 	if topLevel == nil || topLevel.IsNil() {
 		return nil, nil
 	}
@@ -156,10 +273,14 @@ func (exp *Reanimator) ReanimateNodes(topLevel *bones.Node) ([]*bones.Node, erro
 			results = append(results, expanded)
 		}
 	}
+	return results, nil
+}
 
-	// Translate ListNodes to semantic nodes (CallNode, LambdaNode, etc.)
+// TranslateNodes turns expanded core forms into semantic nodes
+// (CallNode, LambdaNode, etc.).
+func TranslateNodes(expanded []*bones.Node) ([]*bones.Node, error) {
 	var translated []*bones.Node
-	for _, node := range results {
+	for _, node := range expanded {
 		t, err := translateNode(node)
 		if err != nil {
 			return nil, err
@@ -186,11 +307,30 @@ func (exp *Reanimator) expandNode(node *bones.Node, scope *macroScope) (*bones.N
 
 	// define-syntax: register macro, strip from output
 	if headName == "define-syntax" {
+		exp.recordEffect(node, scope)
 		return exp.processDefineSyntax(node, scope)
 	}
 
+	// define-syntax-parameter: register rebindable macro, strip from output
+	if headName == "define-syntax-parameter" {
+		exp.recordEffect(node, scope)
+		return exp.processDefineSyntaxParameter(node, scope)
+	}
+
+	// syntax-parameterize: expand body with parameters rebound
+	if headName == "syntax-parameterize" {
+		return exp.processSyntaxParameterize(node, scope)
+	}
+
+	// define-macro: register unhygienic macro, strip from output
+	if headName == "define-macro" {
+		exp.recordEffect(node, scope)
+		return exp.processDefineMacro(node, scope)
+	}
+
 	// require: load module eagerly, strip from output
 	if headName == "require" {
+		exp.recordEffect(node, scope)
 		return exp.processRequire(node, scope)
 	}
 
@@ -228,6 +368,15 @@ func (exp *Reanimator) expandNode(node *bones.Node, scope *macroScope) (*bones.N
 	}
 }
 
+// expansionForms are handled by the reanimator itself and must always be
+// visited, even in subtrees without macro calls.
+var expansionForms = map[string]bool{
+	"define-syntax":           true,
+	"define-syntax-parameter": true,
+	"syntax-parameterize":     true,
+	"define-macro":            true,
+}
+
 func (exp *Reanimator) containsMacroCall(node *bones.Node, scope *macroScope) bool {
 	if node.Kind != bones.ListNode {
 		return false
@@ -235,7 +384,7 @@ func (exp *Reanimator) containsMacroCall(node *bones.Node, scope *macroScope) bo
 	for _, child := range node.Children {
 		if child.Kind == bones.ListNode && len(child.Children) > 0 {
 			name := child.Children[0].IdentName()
-			if name == "define-syntax" {
+			if expansionForms[name] {
 				return true
 			}
 			if name != "" {
@@ -252,19 +401,88 @@ func (exp *Reanimator) containsMacroCall(node *bones.Node, scope *macroScope) bo
 }
 
 func (exp *Reanimator) processDefineSyntax(node *bones.Node, scope *macroScope) (*bones.Node, error) {
+	name, binding, err := exp.defineSyntaxBinding("define-syntax", node, scope)
+	if err != nil {
+		return nil, err
+	}
+	scope.define(name, binding)
+	return nil, nil
+}
+
+// processDefineSyntaxParameter handles (define-syntax-parameter name
+// transformer). The binding behaves like define-syntax until a
+// syntax-parameterize form rebinds it for the extent of its body.
+func (exp *Reanimator) processDefineSyntaxParameter(node *bones.Node, scope *macroScope) (*bones.Node, error) {
+	name, binding, err := exp.defineSyntaxBinding("define-syntax-parameter", node, scope)
+	if err != nil {
+		return nil, err
+	}
+	binding.syntaxParameter = true
+	scope.define(name, binding)
+	return nil, nil
+}
+
+// processSyntaxParameterize handles (syntax-parameterize ((name
+// transformer) ...) body ...). Each name must already be a syntax
+// parameter; the body is expanded in a scope where it is rebound, so uses
+// written by the caller and uses introduced by macros both see the new
+// transformer while the parameter's own binding stays hygienic.
+func (exp *Reanimator) processSyntaxParameterize(node *bones.Node, scope *macroScope) (*bones.Node, error) {
+	if len(node.Children) < 3 {
+		return nil, fmt.Errorf("bad syntax: syntax-parameterize requires bindings and a body")
+	}
+	bindingsNode := node.Children[1]
+	if bindingsNode.Kind != bones.ListNode && !bindingsNode.IsNil() {
+		return nil, fmt.Errorf("bad syntax: syntax-parameterize bindings must be a list")
+	}
+
+	inner := newMacroScope(scope)
+	for _, b := range bindingsNode.Children {
+		if b.Kind != bones.ListNode || len(b.Children) != 2 {
+			return nil, fmt.Errorf("bad syntax: syntax-parameterize binding must be (name transformer)")
+		}
+		name := b.Children[0].IdentName()
+		if name == "" {
+			return nil, fmt.Errorf("bad syntax: syntax-parameterize name must be an identifier")
+		}
+		if existing, found := scope.lookup(name); !found || !existing.syntaxParameter {
+			return nil, fmt.Errorf("syntax-parameterize: %s is not a syntax parameter", name)
+		}
+		binding, err := exp.buildTransformer("syntax-parameterize", name, b.Children[1], scope)
+		if err != nil {
+			return nil, err
+		}
+		binding.syntaxParameter = true
+		inner.define(name, binding)
+	}
+
+	body := node.Children[2]
+	if len(node.Children) > 3 {
+		children := append([]*bones.Node{bones.IdentNode("begin")}, node.Children[2:]...)
+		body = &bones.Node{Kind: bones.ListNode, Children: children, Loc: node.Loc}
+	}
+	return exp.expandNode(body, inner)
+}
+
+// defineSyntaxBinding parses (form name transformer) and builds the binding.
+func (exp *Reanimator) defineSyntaxBinding(form string, node *bones.Node, scope *macroScope) (string, macroBinding, error) {
 	if len(node.Children) < 3 {
-		return nil, fmt.Errorf("bad syntax: define-syntax requires name and transformer")
+		return "", macroBinding{}, fmt.Errorf("bad syntax: %s requires name and transformer", form)
 	}
 
 	nameNode := node.Children[1]
 	name := nameNode.IdentName()
 	if name == "" {
-		return nil, fmt.Errorf("bad syntax: define-syntax name must be an identifier")
+		return "", macroBinding{}, fmt.Errorf("bad syntax: %s name must be an identifier", form)
 	}
 
-	transformerNode := node.Children[2]
+	binding, err := exp.buildTransformer(form, name, node.Children[2], scope)
+	return name, binding, err
+}
+
+func (exp *Reanimator) buildTransformer(form string, name string, transformerNode *bones.Node, scope *macroScope) (macroBinding, error) {
 	if transformerNode.Kind != bones.ListNode || len(transformerNode.Children) == 0 {
-		return nil, fmt.Errorf("bad syntax: transformer must be a form")
+		return macroBinding{}, fmt.Errorf("bad syntax: transformer must be a form")
 	}
 
 	// syntax-rules: build Node-based transformer directly
@@ -272,30 +490,77 @@ func (exp *Reanimator) processDefineSyntax(node *bones.Node, scope *macroScope)
 		defBindings := exp.boundIdentifierNames()
 		st, err := macromancy.BuildSyntaxRulesTransformer(name, transformerNode, defBindings)
 		if err != nil {
-			return nil, fmt.Errorf("bad syntax: %s", err)
+			return macroBinding{}, fmt.Errorf("bad syntax: %s", err)
 		}
-		scope.define(name, macroBinding{syntaxTransformer: &st})
-		return nil, nil
+		return macroBinding{syntaxTransformer: &st, rules: transformerNode, defBindings: defBindings, source: transformerNode.Repr()}, nil
 	}
 
 	// General transformer: expand, translate, evaluate to get a Function,
 	// then store it for later invocation during macro calls.
 	expandedNode, err := exp.expandNode(transformerNode, scope)
 	if err != nil {
-		return nil, fmt.Errorf("define-syntax: failed to expand transformer: %w", err)
+		return macroBinding{}, fmt.Errorf("%s: failed to expand transformer: %w", form, err)
+	}
+	translated, err := translateNode(expandedNode)
+	if err != nil {
+		return macroBinding{}, fmt.Errorf("%s: failed to translate transformer: %w", form, err)
+	}
+	resultNode, err := exp.evaluator.ConsumeNodes([]*bones.Node{translated})
+	if err != nil {
+		return macroBinding{}, fmt.Errorf("%s: failed to evaluate transformer: %w", form, err)
+	}
+	if resultNode.Kind != bones.FunctionNode || resultNode.FuncVal == nil {
+		return macroBinding{}, fmt.Errorf("bad syntax: transformer must be a procedure")
+	}
+	return macroBinding{generalTransformer: &generalTransformer{funcNode: resultNode}, source: transformerNode.Repr()}, nil
+}
+
+// processDefineMacro handles (define-macro (name . params) body ...) and
+// (define-macro name procedure). The procedure is evaluated once, like a
+// general transformer, and invoked at each use with the argument forms.
+func (exp *Reanimator) processDefineMacro(node *bones.Node, scope *macroScope) (*bones.Node, error) {
+	if len(node.Children) < 3 {
+		return nil, fmt.Errorf("bad syntax: define-macro requires name and body")
+	}
+
+	var name string
+	var procNode *bones.Node
+	signature := node.Children[1]
+	if signature.Kind == bones.ListNode && len(signature.Children) > 0 {
+		name = signature.Children[0].IdentName()
+		params := signature.DottedTail
+		if len(signature.Children) > 1 || params == nil {
+			params = &bones.Node{Kind: bones.ListNode, Children: signature.Children[1:], DottedTail: signature.DottedTail}
+		}
+		children := append([]*bones.Node{bones.IdentNode("lambda"), params}, node.Children[2:]...)
+		procNode = &bones.Node{Kind: bones.ListNode, Children: children, Loc: node.Loc}
+	} else {
+		if len(node.Children) != 3 {
+			return nil, fmt.Errorf("bad syntax: define-macro expects a single procedure after the name")
+		}
+		name = signature.IdentName()
+		procNode = node.Children[2]
+	}
+	if name == "" {
+		return nil, fmt.Errorf("bad syntax: define-macro name must be an identifier")
+	}
+
+	expandedNode, err := exp.expandNode(procNode, scope)
+	if err != nil {
+		return nil, fmt.Errorf("define-macro: failed to expand procedure: %w", err)
 	}
 	translated, err := translateNode(expandedNode)
 	if err != nil {
-		return nil, fmt.Errorf("define-syntax: failed to translate transformer: %w", err)
+		return nil, fmt.Errorf("define-macro: failed to translate procedure: %w", err)
 	}
 	resultNode, err := exp.evaluator.ConsumeNodes([]*bones.Node{translated})
 	if err != nil {
-		return nil, fmt.Errorf("define-syntax: failed to evaluate transformer: %w", err)
+		return nil, fmt.Errorf("define-macro: failed to evaluate procedure: %w", err)
 	}
 	if resultNode.Kind != bones.FunctionNode || resultNode.FuncVal == nil {
-		return nil, fmt.Errorf("bad syntax: transformer must be a procedure")
+		return nil, fmt.Errorf("bad syntax: define-macro %s must be a procedure", name)
 	}
-	scope.define(name, macroBinding{generalTransformer: &generalTransformer{funcNode: resultNode}})
+	scope.define(name, macroBinding{plainTransformer: &plainTransformer{funcNode: resultNode}, source: node.Repr()})
 	return nil, nil
 }
 
@@ -317,13 +582,7 @@ func (exp *Reanimator) expandMacroCall(binding macroBinding, node *bones.Node, s
 		wrapped := macromancy.WrapSyntax(node, macromancy.NewMarkSet())
 		markedInput := macromancy.ApplyMark(wrapped, mark)
 
-		// Build a call node: (transformer-fn (quote markedInput))
-		quotedInput := bones.QuoteNodeVal(markedInput)
-		callNode := &bones.Node{
-			Kind:     bones.CallNode,
-			Children: []*bones.Node{binding.generalTransformer.funcNode, quotedInput},
-		}
-		resultNode, err := exp.evaluator.EvalSubExpression(callNode)
+		resultNode, err := exp.evaluator.Apply(exp.Context(), binding.generalTransformer.funcNode, []*bones.Node{markedInput})
 		if err != nil {
 			return nil, err
 		}
@@ -335,6 +594,21 @@ func (exp *Reanimator) expandMacroCall(binding macroBinding, node *bones.Node, s
 		return resolved, nil
 	}
 
+	if binding.plainTransformer != nil {
+		// The argument forms are passed as they are, with no marks applied
+		resultNode, err := exp.evaluator.Apply(exp.Context(), binding.plainTransformer.funcNode, node.Children[1:])
+		if err != nil {
+			return nil, err
+		}
+		// The result may reuse quoted constants of the procedure, so copy it
+		// before locations are stamped onto it.
+		input := callSiteForms{nodes: map[*bones.Node]bool{}, locs: map[bones.CodeLocation]bool{}}
+		input.collect(node)
+		expanded := copyOutsideCallSite(resultNode, input)
+		setMacroLocation(expanded, node)
+		return expanded, nil
+	}
+
 	return nil, fmt.Errorf("internal error: macro binding has no transformer")
 }
 
@@ -619,6 +893,10 @@ func inheritLoc(child *bones.Node, parent *bones.Node) {
 	}
 }
 
+// setMacroLocation gives every form the expansion introduced an expansion
+// location pointing at the call site. Forms that came from the call itself
+// (substituted pattern variables) keep their own locations, and a call
+// site that is itself an expansion location chains the frames together.
 func setMacroLocation(expanded *bones.Node, callSite *bones.Node) {
 	if expanded == nil || callSite == nil || callSite.Loc == nil {
 		return
@@ -627,17 +905,77 @@ func setMacroLocation(expanded *bones.Node, callSite *bones.Node) {
 	if len(callSite.Children) > 0 {
 		macroName = callSite.Children[0].IdentName()
 	}
-	loc := &bones.MacroExpansionLocation{MacroName: macroName, CallSite: callSite.Loc}
-	setLocationRecursive(expanded, loc)
+	input := callSiteForms{nodes: map[*bones.Node]bool{}, locs: map[bones.CodeLocation]bool{}}
+	input.collect(callSite)
+	plain := &bones.MacroExpansionLocation{MacroName: macroName, CallSite: callSite.Loc}
+	setLocationRecursive(expanded, macroName, callSite.Loc, plain, input)
 }
 
-func setLocationRecursive(node *bones.Node, loc bones.CodeLocation) {
-	if node.Loc == nil {
-		node.Loc = loc
+// callSiteForms identifies forms that were part of a macro call. Syntax
+// rules substitute the call's own nodes; general transformers rebuild
+// lists but carry their locations over, so both identity and location
+// are checked.
+type callSiteForms struct {
+	nodes map[*bones.Node]bool
+	locs  map[bones.CodeLocation]bool
+}
+
+func (c callSiteForms) collect(node *bones.Node) {
+	c.nodes[node] = true
+	if node.Loc != nil {
+		c.locs[node.Loc] = true
 	}
 	if node.Kind == bones.ListNode {
 		for _, child := range node.Children {
-			setLocationRecursive(child, loc)
+			c.collect(child)
 		}
 	}
 }
+
+func (c callSiteForms) contains(node *bones.Node) bool {
+	return c.nodes[node] || (node.Loc != nil && c.locs[node.Loc])
+}
+
+// copyOutsideCallSite copies the lists and identifiers of an expansion
+// that did not come from the macro call itself.
+func copyOutsideCallSite(node *bones.Node, input callSiteForms) *bones.Node {
+	if input.nodes[node] {
+		return node
+	}
+	switch node.Kind {
+	case bones.IdentifierNode:
+		cp := *node
+		return &cp
+	case bones.ListNode:
+		cp := *node
+		cp.Children = make([]*bones.Node, len(node.Children))
+		for i, child := range node.Children {
+			cp.Children[i] = copyOutsideCallSite(child, input)
+		}
+		if node.DottedTail != nil {
+			cp.DottedTail = copyOutsideCallSite(node.DottedTail, input)
+		}
+		return &cp
+	}
+	return node
+}
+
+// setLocationRecursive only touches lists and identifiers: atoms may be
+// shared singletons (cached integers, booleans) and never carry locations
+// the compiler uses.
+func setLocationRecursive(node *bones.Node, macroName string, callSite bones.CodeLocation, plain *bones.MacroExpansionLocation, input callSiteForms) {
+	if node.Kind != bones.ListNode && node.Kind != bones.IdentifierNode {
+		return
+	}
+	if input.contains(node) {
+		return
+	}
+	if node.Loc == nil {
+		node.Loc = plain
+	} else {
+		node.Loc = &bones.MacroExpansionLocation{MacroName: macroName, CallSite: callSite, DefSite: node.Loc}
+	}
+	for _, child := range node.Children {
+		setLocationRecursive(child, macroName, callSite, plain, input)
+	}
+}
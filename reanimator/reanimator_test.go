@@ -453,6 +453,27 @@ func TestExpandMacroCallError(t *testing.T) {
 	}
 }
 
+func TestExpandMacroCallErrorExplainsMismatch(t *testing.T) {
+	r := newTestReanimator()
+	nodes := parseNodes(t, `
+(define-syntax swap! (syntax-rules () ((swap! a b) (begin a b))))
+(swap! x)
+`)
+	_, err := r.ReanimateNodes(nodes)
+	if err == nil {
+		t.Fatal("expected error for non-matching macro call")
+	}
+	for _, want := range []string{
+		"no matching pattern for (swap! x) at 3:2",
+		"clause 1: (swap! a b)",
+		"expected a form matching b, reached end of form at 3:2",
+	} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("expected %q in error, got:\n%s", want, err.Error())
+		}
+	}
+}
+
 func TestExpandMacroLocationSetOnExpansion(t *testing.T) {
 	// Expanded code should have macro call site location set
 	r := newTestReanimator()
@@ -478,6 +499,40 @@ func TestExpandMacroLocationSetOnExpansion(t *testing.T) {
 	}
 }
 
+func TestExpandMacroLocationKeepsCallSiteFormsAndChains(t *testing.T) {
+	r := newTestReanimator()
+	nodes := parseNodes(t, `
+(define-syntax inner (syntax-rules () ((inner x) (list x))))
+(define-syntax outer (syntax-rules () ((outer y) (inner (+ y 1)))))
+(outer 5)
+`)
+	results, err := r.ReanimateNodes(nodes)
+	if err != nil {
+		t.Fatalf("expansion failed: %v", err)
+	}
+	// (outer 5) → (inner (+ 5 1)) → (list (+ 5 1))
+	call := results[0]
+	mel, ok := call.Loc.(*bones.MacroExpansionLocation)
+	if !ok {
+		t.Fatalf("expected expansion location, got %T", call.Loc)
+	}
+	if mel.MacroName != "inner" || mel.DefSite == nil || mel.DefSite.String() != "2:51" {
+		t.Errorf("expected inner template location, got %s (def %v)", mel.MacroName, mel.DefSite)
+	}
+	parent, ok := mel.CallSite.(*bones.MacroExpansionLocation)
+	if !ok || parent.MacroName != "outer" || parent.CallSite.String() != "4:2" {
+		t.Fatalf("expected chain back to outer call at 4:2, got %v", mel.CallSite)
+	}
+
+	// (+ 5 1) was written in outer's template and passed through inner
+	// unchanged, so it belongs to the outer expansion only.
+	arg := call.Children[1]
+	argLoc, ok := arg.Loc.(*bones.MacroExpansionLocation)
+	if !ok || argLoc.MacroName != "outer" || argLoc.DefSite.String() != "3:58" {
+		t.Errorf("expected argument to keep outer template location, got %v", arg.Loc)
+	}
+}
+
 // --- Integration test: expand then evaluate ---
 
 func TestExpandIntegrationWithEvaluator(t *testing.T) {
@@ -1,6 +1,7 @@
 package reanimator
 
 import (
+	"errors"
 	"fmt"
 
 	"github.com/archevel/ghoul/bones"
@@ -8,6 +9,11 @@ import (
 	"github.com/archevel/ghoul/sarcophagus"
 )
 
+// ErrCapabilityDenied is matched by the error of a require refused by the
+// restrictions set with SetAllowedMummies, SetDeniedMummies or
+// SetAllowedModules.
+var ErrCapabilityDenied = errors.New("capability denied")
+
 // processRequire handles (require module ...) during macro expansion.
 // It loads sarcophagi and Ghoul modules eagerly, binding runtime exports
 // into the reanimator's eval environment and macro exports into the
@@ -34,8 +40,11 @@ func (exp *Reanimator) processRequire(node *bones.Node, scope *macroScope) (*bon
 	}
 
 	// Try entombed mummy first
-	mummy := sarcophagus.Unearth(moduleName)
+	mummy := exp.registry.Unearth(moduleName)
 	if mummy != nil {
+		if err := exp.checkMummy(moduleName, mummy); err != nil {
+			return nil, fmt.Errorf("require: %w", err)
+		}
 		requireKey := moduleName + ":" + prefix
 		if exp.requiredModules[requireKey] {
 			return nil, nil
@@ -48,6 +57,9 @@ func (exp *Reanimator) processRequire(node *bones.Node, scope *macroScope) (*bon
 	}
 
 	// Try Ghoul file module
+	if exp.allowedModules != nil && !exp.allowedModules[moduleName] {
+		return nil, fmt.Errorf("require: %w: module '%s' is not allowed", ErrCapabilityDenied, moduleName)
+	}
 	if exp.moduleState != nil {
 		return nil, exp.requireGhoulModule(moduleName, prefix, only, scope)
 	}
@@ -55,6 +67,32 @@ func (exp *Reanimator) processRequire(node *bones.Node, scope *macroScope) (*bon
 	return nil, fmt.Errorf("require: module '%s' not found", moduleName)
 }
 
+// checkMummy returns an error wrapping ErrCapabilityDenied if the mummy
+// required as name may not be loaded.
+func (exp *Reanimator) checkMummy(name string, mummy *sarcophagus.Mummy) error {
+	if exp.allowedMummies != nil && !exp.namesMummy(exp.allowedMummies, name, mummy) {
+		return fmt.Errorf("%w: mummy '%s' is not allowed", ErrCapabilityDenied, name)
+	}
+	if exp.namesMummy(exp.deniedMummies, name, mummy) {
+		return fmt.Errorf("%w: mummy '%s' is banned", ErrCapabilityDenied, name)
+	}
+	return nil
+}
+
+// namesMummy reports whether names holds name or another name of mummy,
+// so a mummy listed by short name matches a require by import path.
+func (exp *Reanimator) namesMummy(names map[string]bool, name string, mummy *sarcophagus.Mummy) bool {
+	if names[name] {
+		return true
+	}
+	for other := range names {
+		if exp.registry.Unearth(other) == mummy {
+			return true
+		}
+	}
+	return false
+}
+
 func parseRequireOptions(args []*bones.Node) (alias string, only map[string]bool, err error) {
 	i := 1 // skip module name
 	for i < len(args) {
@@ -118,18 +156,27 @@ func (exp *Reanimator) requireMummy(mummy *sarcophagus.Mummy, prefix string, onl
 		}
 	}
 
-	register := func(name string, fn interface{}) {
-		if newFn, ok := fn.(func([]*bones.Node, *ev.Evaluator) (*bones.Node, error)); ok {
-			wrapped := func(args []*bones.Node, evaluator bones.Evaluator) (*bones.Node, error) {
-				return newFn(args, evaluator.(*ev.Evaluator))
-			}
-			exp.evalEnv.BindByName(name, bones.FuncNode(wrapped))
+	mummy.Register(prefix, only, func(name string, fn interface{}) {
+		if node := mummyFunc(fn); node != nil {
+			exp.evalEnv.BindByName(name, node)
 		}
-	}
-	mummy.Register(prefix, only, register)
+	})
 	return nil
 }
 
+// mummyFunc wraps a function a mummy registers as a procedure, or returns
+// nil for one of another type.
+func mummyFunc(fn interface{}) *bones.Node {
+	newFn, ok := fn.(func([]*bones.Node, *ev.Evaluator) (*bones.Node, error))
+	if !ok {
+		return nil
+	}
+	wrapped := func(args []*bones.Node, evaluator bones.Evaluator) (*bones.Node, error) {
+		return newFn(args, evaluator.(*ev.Evaluator))
+	}
+	return bones.FuncNode(wrapped)
+}
+
 func (exp *Reanimator) requireGhoulModule(moduleName string, prefix string, only map[string]bool, scope *macroScope) error {
 	filePath, err := exp.moduleState.ResolveFile(moduleName)
 	if err != nil {
@@ -1,6 +1,7 @@
 package reanimator
 
 import (
+	"errors"
 	"strings"
 	"testing"
 
@@ -288,3 +289,73 @@ func TestRequireStripsFromOutput(t *testing.T) {
 		t.Errorf("expected CallNode, got %d", result[0].Kind)
 	}
 }
+
+func TestRequireRefusesMummiesOutsideTheAllowlist(t *testing.T) {
+	defer sarcophagus.ClearRegistry()
+	registerTestModule()
+	r := newTestReanimator()
+	r.SetAllowedMummies([]string{"othermod"})
+	_, err := r.ReanimateNodes(parseNodes(t, `(require testmod)`))
+	if err == nil || !strings.Contains(err.Error(), "mummy 'testmod' is not allowed") {
+		t.Fatalf("expected testmod to be refused, got %v", err)
+	}
+
+	r.SetAllowedMummies([]string{"github.com/example/testmod"})
+	if _, err := r.ReanimateNodes(parseNodes(t, `(require github.com/example/testmod as tm)`)); err != nil {
+		t.Fatalf("expected the allowed import path to load, got %v", err)
+	}
+}
+
+func TestRequireUsesTheReanimatorsRegistry(t *testing.T) {
+	own := sarcophagus.NewRegistry()
+	own.Entomb("ownmod", "github.com/example/ownmod", &sarcophagus.Mummy{
+		Names: []string{"foo"},
+		Register: func(prefix string, only map[string]bool, register func(string, interface{})) {
+			sarcophagus.RegisterIfAllowed(prefix, only, "foo", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
+				return e.IntNode(7), nil
+			}, register)
+		},
+	})
+
+	r := newTestReanimator()
+	r.SetRegistry(own)
+	if _, err := r.ReanimateNodes(parseNodes(t, `(require ownmod)`)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := r.evalEnv.LookupByName("ownmod:foo"); err != nil {
+		t.Errorf("ownmod:foo not found: %v", err)
+	}
+
+	_, err := newTestReanimator().ReanimateNodes(parseNodes(t, `(require ownmod)`))
+	if err == nil || !strings.Contains(err.Error(), "module 'ownmod' not found") {
+		t.Errorf("expected the default registry not to have ownmod, got %v", err)
+	}
+}
+
+func TestRequireRefusesDeniedMummiesByEitherName(t *testing.T) {
+	defer sarcophagus.ClearRegistry()
+	registerTestModule()
+	r := newTestReanimator()
+	r.SetDeniedMummies([]string{"testmod"})
+	for _, src := range []string{`(require testmod)`, `(require github.com/example/testmod as tm)`} {
+		_, err := r.ReanimateNodes(parseNodes(t, src))
+		if !errors.Is(err, ErrCapabilityDenied) || !strings.Contains(err.Error(), "is banned") {
+			t.Errorf("%s: expected a capability denied error, got %v", src, err)
+		}
+	}
+
+	r = newTestReanimator()
+	r.SetAllowedMummies([]string{"testmod"})
+	if _, err := r.ReanimateNodes(parseNodes(t, `(require github.com/example/testmod as tm)`)); err != nil {
+		t.Errorf("expected a mummy allowed by short name to load by path, got %v", err)
+	}
+}
+
+func TestRequireRefusesModulesOutsideTheAllowlist(t *testing.T) {
+	r := newTestReanimator()
+	r.SetAllowedModules([]string{"utils"})
+	_, err := r.ReanimateNodes(parseNodes(t, `(require secrets)`))
+	if !errors.Is(err, ErrCapabilityDenied) || !strings.Contains(err.Error(), "capability denied: module 'secrets' is not allowed") {
+		t.Errorf("expected a capability denied error, got %v", err)
+	}
+}
@@ -0,0 +1,228 @@
+package reanimator
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync/atomic"
+
+	"github.com/archevel/ghoul/bones"
+	ev "github.com/archevel/ghoul/consume"
+	"github.com/archevel/ghoul/macromancy"
+)
+
+// Kinds of macro binding in a snapshot.
+const (
+	snapshotSyntaxRules = iota + 1
+	snapshotGeneral
+	snapshotPlain
+)
+
+// Snapshot writes the reanimator's state as a consume image: the
+// evaluation environment, the macro scopes from the top level out, the
+// requires already done and the mark counter. Restore reads it back.
+func (exp *Reanimator) Snapshot(w io.Writer) error {
+	var scopes []*bones.Node
+	for scope := exp.nodeScopes; scope != nil; scope = scope.parent {
+		node, err := macroScopeNode(scope)
+		if err != nil {
+			return err
+		}
+		scopes = append(scopes, node)
+	}
+	requires := make([]string, 0, len(exp.requiredModules))
+	for key := range exp.requiredModules {
+		requires = append(requires, key)
+	}
+	sort.Strings(requires)
+	return ev.WriteImage(w, &ev.Image{
+		Env:      exp.evalEnv,
+		Requires: requires,
+		Roots:    scopes,
+		Marks:    atomic.LoadUint64(exp.markCounter),
+	})
+}
+
+// macroScopeNode lists the bindings of scope as
+// (name kind source syntax-parameter? transformer definition-bindings).
+// A syntax-rules transformer is stored as its rules, to be built again,
+// with the names bound where it was defined that its templates use.
+func macroScopeNode(scope *macroScope) (*bones.Node, error) {
+	names := make([]string, 0, len(scope.bindings))
+	for name := range scope.bindings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	entries := make([]*bones.Node, 0, len(names))
+	for _, name := range names {
+		b := scope.bindings[name]
+		kind, transformer, defs := 0, bones.Nil, bones.Nil
+		switch {
+		case b.syntaxTransformer != nil && b.rules != nil:
+			kind, transformer = snapshotSyntaxRules, b.rules
+			var used []*bones.Node
+			for _, id := range identifierNames(b.rules) {
+				if b.defBindings[id] {
+					used = append(used, bones.StrNode(id))
+				}
+			}
+			defs = bones.NewListNode(used)
+		case b.generalTransformer != nil:
+			kind, transformer = snapshotGeneral, b.generalTransformer.funcNode
+		case b.plainTransformer != nil:
+			kind, transformer = snapshotPlain, b.plainTransformer.funcNode
+		default:
+			return nil, fmt.Errorf("snapshot: macro %s cannot be stored", name)
+		}
+		entries = append(entries, bones.NewListNode([]*bones.Node{
+			bones.StrNode(name), bones.IntNode(int64(kind)), bones.StrNode(b.source),
+			bones.BoolNode(b.syntaxParameter), transformer, defs,
+		}))
+	}
+	return bones.NewListNode(entries), nil
+}
+
+// identifierNames returns the names of the identifiers in n, sorted.
+func identifierNames(n *bones.Node) []string {
+	seen := map[string]bool{}
+	var walk func(n *bones.Node)
+	walk = func(n *bones.Node) {
+		if n == nil {
+			return
+		}
+		if n.Kind == bones.IdentifierNode {
+			seen[n.Name] = true
+		}
+		for _, c := range n.Children {
+			walk(c)
+		}
+		walk(n.DottedTail)
+		walk(n.Quoted)
+	}
+	walk(n)
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Restore replaces the reanimator's state with a snapshot written by
+// Snapshot. Procedures and other Go values in it are bound again by name:
+// to what the evaluation environment binds the name to, or to the
+// procedure of that name from a mummy the snapshot required. Those
+// mummies must be allowed to load.
+func (exp *Reanimator) Restore(data []byte) error {
+	img, err := ev.OpenImage(data)
+	if err != nil {
+		return fmt.Errorf("restore: %w", err)
+	}
+	mummyProcs := map[string]*bones.Node{}
+	for _, key := range img.Requires {
+		if err := exp.unearthRequire(key, mummyProcs); err != nil {
+			return fmt.Errorf("restore: %w", err)
+		}
+	}
+
+	roots, commit, err := img.Load(exp.evalEnv, func(name string) (*bones.Node, bool) {
+		if v, err := exp.evalEnv.LookupByName(name); err == nil {
+			return v, true
+		}
+		v, ok := mummyProcs[name]
+		return v, ok
+	})
+	if err != nil {
+		return fmt.Errorf("restore: %w", err)
+	}
+
+	// The roots are the macro scopes, innermost first.
+	var scope *macroScope
+	for i := len(roots) - 1; i >= 0; i-- {
+		scope = newMacroScope(scope)
+		if err := restoreMacroScope(scope, roots[i]); err != nil {
+			return fmt.Errorf("restore: %w: %s", ev.ErrMalformedImage, err)
+		}
+	}
+	commit()
+	exp.nodeScopes = scope
+	exp.requiredModules = map[string]bool{}
+	for _, key := range img.Requires {
+		exp.requiredModules[key] = true
+	}
+	for {
+		marks := atomic.LoadUint64(exp.markCounter)
+		if marks >= img.Marks || atomic.CompareAndSwapUint64(exp.markCounter, marks, img.Marks) {
+			return nil
+		}
+	}
+}
+
+// unearthRequire adds the procedures of the mummy required under key,
+// as in requiredModules, to procs. Keys of Ghoul modules are skipped:
+// their bindings are in the snapshot.
+func (exp *Reanimator) unearthRequire(key string, procs map[string]*bones.Node) error {
+	moduleName, prefix, _ := strings.Cut(key, ":")
+	mummy := exp.registry.Unearth(moduleName)
+	if mummy == nil {
+		if prefix == "ghoul" {
+			return nil
+		}
+		return fmt.Errorf("mummy '%s' is not entombed", moduleName)
+	}
+	if err := exp.checkMummy(moduleName, mummy); err != nil {
+		return err
+	}
+	mummy.Register(prefix, nil, func(name string, fn interface{}) {
+		if node := mummyFunc(fn); node != nil {
+			procs[name] = node
+		}
+	})
+	return nil
+}
+
+func restoreMacroScope(scope *macroScope, node *bones.Node) error {
+	if node.Kind != bones.ListNode {
+		return fmt.Errorf("macro scope is a %s", bones.NodeTypeName(node))
+	}
+	for _, entry := range node.Children {
+		if entry.Kind != bones.ListNode || len(entry.Children) != 6 {
+			return fmt.Errorf("bad macro binding %s", entry.Repr())
+		}
+		name, kind, source, param, transformer, defs := entry.Children[0], entry.Children[1],
+			entry.Children[2], entry.Children[3], entry.Children[4], entry.Children[5]
+		if name.Kind != bones.StringNode || kind.Kind != bones.IntegerNode ||
+			source.Kind != bones.StringNode || param.Kind != bones.BooleanNode {
+			return fmt.Errorf("bad macro binding %s", entry.Repr())
+		}
+
+		b := macroBinding{source: source.StrVal, syntaxParameter: param.BoolVal}
+		switch kind.IntVal {
+		case snapshotSyntaxRules:
+			b.rules, b.defBindings = transformer, map[string]bool{}
+			for _, d := range defs.Children {
+				b.defBindings[d.StrVal] = true
+			}
+			st, err := macromancy.BuildSyntaxRulesTransformer(name.StrVal, transformer, b.defBindings)
+			if err != nil {
+				return fmt.Errorf("macro %s: %s", name.StrVal, err)
+			}
+			b.syntaxTransformer = &st
+		case snapshotGeneral, snapshotPlain:
+			if transformer.Kind != bones.FunctionNode || transformer.FuncVal == nil {
+				return fmt.Errorf("macro %s: transformer is not a procedure", name.StrVal)
+			}
+			if kind.IntVal == snapshotGeneral {
+				b.generalTransformer = &generalTransformer{funcNode: transformer}
+			} else {
+				b.plainTransformer = &plainTransformer{funcNode: transformer}
+			}
+		default:
+			return fmt.Errorf("macro %s: unknown kind %d", name.StrVal, kind.IntVal)
+		}
+		scope.define(name.StrVal, b)
+	}
+	return nil
+}
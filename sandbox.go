@@ -0,0 +1,127 @@
+package ghoul
+
+import (
+	"io"
+	"io/fs"
+	"os"
+	"sync"
+
+	ev "github.com/archevel/ghoul/consume"
+	"github.com/archevel/ghoul/reanimator"
+)
+
+// Sandbox restricts what the scripts an instance runs may reach, for
+// running code that is not trusted; see WithSandbox. Mummies that write
+// to os.Stdout themselves, such as fmt's Println, bypass MaxOutput.
+type Sandbox struct {
+	// Mummies lists the sarcophagi require may load, by short name or
+	// import path. Nil allows every one not Banned.
+	Mummies []string
+	// Banned lists sarcophagi require refuses even when Mummies allows
+	// them.
+	Banned []string
+	// Modules lists the Ghoul modules require may load, by the name
+	// given to it. Nil allows every module in Files.
+	Modules []string
+	// Files holds the Ghoul modules scripts may require, searched from
+	// its root. Without it they may require none.
+	Files fs.FS
+	// MaxOutput caps the bytes builtins may write to stdout and stderr
+	// together. Zero leaves output unbounded.
+	MaxOutput int64
+	// Limits bounds each evaluation, as SetLimits does.
+	Limits Limits
+}
+
+// BannedMummies names the sarcophagi of packages that reach outside the
+// process: its files, environment, subprocesses and the network.
+var BannedMummies = []string{
+	"os", "os/exec", "os/signal", "os/user", "io/ioutil", "syscall", "plugin",
+	"net", "net/http", "net/rpc", "net/smtp", "net/mail",
+}
+
+// UntrustedSandbox returns a profile for scripts from outside: it bans
+// the BannedMummies, allows no Ghoul modules, caps output at 1 MiB and
+// bounds the work, call depth and memory of each evaluation.
+func UntrustedSandbox() Sandbox {
+	return Sandbox{
+		Banned:    BannedMummies,
+		MaxOutput: 1 << 20,
+		Limits:    Limits{Fuel: 100_000_000, MaxDepth: 10_000, MaxStack: 1 << 20, MaxAlloc: 256 << 20},
+	}
+}
+
+// ErrCapabilityDenied is matched by the error of a require a Sandbox or
+// WithAllowedMummies refuses.
+var ErrCapabilityDenied = reanimator.ErrCapabilityDenied
+
+// WithSandbox runs every script of the instance under sb. It takes
+// precedence over WithAllowedMummies, and output through WithStdout and
+// WithStderr is capped as well.
+func WithSandbox(sb Sandbox) Option {
+	return func(o *options) { o.sandbox = &sb }
+}
+
+// applySandbox caps the output streams and sets where modules come from.
+func (o *options) applySandbox() {
+	sb := o.sandbox
+	o.files = sb.Files
+	if sb.MaxOutput > 0 {
+		limit := &outputLimit{max: sb.MaxOutput}
+		o.stdout = limit.wrap(o.stdout, os.Stdout)
+		o.stderr = limit.wrap(o.stderr, os.Stderr)
+	}
+}
+
+// restrict applies the require restrictions and limits of sb to g.
+func (g ghoul) restrict(sb *Sandbox) {
+	if sb.Mummies != nil {
+		g.reanimator.SetAllowedMummies(append([]string{}, sb.Mummies...))
+	}
+	g.reanimator.SetDeniedMummies(sb.Banned)
+	modules := sb.Modules
+	if sb.Files == nil {
+		modules = []string{}
+	}
+	g.reanimator.SetAllowedModules(modules)
+	g.SetLimits(sb.Limits)
+}
+
+// outputLimit caps the bytes written through the writers it wraps, taken
+// together. The write that crosses the cap is cut short and fails with a
+// LimitError.
+type outputLimit struct {
+	mu      sync.Mutex
+	max     int64
+	written int64
+}
+
+func (l *outputLimit) wrap(w, fallback io.Writer) io.Writer {
+	if w == nil {
+		w = fallback
+	}
+	return limitedWriter{w: w, limit: l}
+}
+
+type limitedWriter struct {
+	w     io.Writer
+	limit *outputLimit
+}
+
+func (w limitedWriter) Write(p []byte) (int, error) {
+	l := w.limit
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	room := max(l.max-l.written, 0)
+	if int64(len(p)) <= room {
+		n, err := w.w.Write(p)
+		l.written += int64(n)
+		return n, err
+	}
+	n, err := w.w.Write(p[:room])
+	l.written += int64(n)
+	if err == nil {
+		err = ev.LimitError{Limit: ev.LimitOutput, Max: l.max}
+	}
+	return n, err
+}
@@ -0,0 +1,119 @@
+package ghoul
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	e "github.com/archevel/ghoul/bones"
+	ev "github.com/archevel/ghoul/consume"
+	"github.com/archevel/ghoul/sarcophagus"
+)
+
+func hostRegistry() *sarcophagus.Registry {
+	r := sarcophagus.NewRegistry()
+	for _, path := range []string{"os", "os/exec", "strings"} {
+		short := path[strings.LastIndex(path, "/")+1:]
+		r.Entomb(short, path, &sarcophagus.Mummy{
+			Names: []string{"name"},
+			Register: func(prefix string, only map[string]bool, register func(string, interface{})) {
+				sarcophagus.RegisterIfAllowed(prefix, only, "name", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
+					return e.StrNode(path), nil
+				}, register)
+			},
+		})
+	}
+	return r
+}
+
+func TestSandboxRefusesBannedMummies(t *testing.T) {
+	g := NewWithOptions(WithRegistry(hostRegistry()), WithSandbox(UntrustedSandbox()))
+	for _, src := range []string{"(require os)", "(require exec)", "(require os/exec as run)"} {
+		_, err := g.Process(strings.NewReader(src))
+		if !errors.Is(err, ErrCapabilityDenied) || !strings.Contains(err.Error(), "capability denied") {
+			t.Errorf("%s: expected a capability denied error, got %v", src, err)
+		}
+	}
+	if result, err := g.Process(strings.NewReader("(require strings) (strings:name)")); err != nil || result.StrVal != "strings" {
+		t.Errorf("expected strings to be available, got %v, %v", result, err)
+	}
+
+	sb := UntrustedSandbox()
+	sb.Mummies = []string{"os"}
+	_, err := NewWithOptions(WithRegistry(hostRegistry()), WithSandbox(sb)).Process(strings.NewReader("(require os)"))
+	if !errors.Is(err, ErrCapabilityDenied) {
+		t.Errorf("expected the ban to hold over the allowlist, got %v", err)
+	}
+	_, err = NewWithOptions(WithRegistry(hostRegistry()), WithSandbox(sb)).Process(strings.NewReader("(require strings)"))
+	if !errors.Is(err, ErrCapabilityDenied) {
+		t.Errorf("expected mummies outside the allowlist to be refused, got %v", err)
+	}
+}
+
+func TestSandboxLoadsModulesOnlyFromItsFiles(t *testing.T) {
+	sb := UntrustedSandbox()
+	sb.Files = fstest.MapFS{
+		"utils.ghl":      {Data: []byte("(define double (lambda (x) (* x 2)))")},
+		"lib/secret.ghl": {Data: []byte("(define key 42)")},
+	}
+	g := NewWithOptions(WithSandbox(sb))
+	if result, err := g.Process(strings.NewReader("(require utils) (utils:double 21)")); err != nil || !result.Equiv(e.IntNode(42)) {
+		t.Errorf("expected the module from the FS, got %v, %v", result, err)
+	}
+	if _, err := g.Process(strings.NewReader("(require ../utils)")); err == nil || !strings.Contains(err.Error(), "module not found") {
+		t.Errorf("expected paths leaving the FS not to resolve, got %v", err)
+	}
+
+	sb.Modules = []string{"utils"}
+	_, err := NewWithOptions(WithSandbox(sb)).Process(strings.NewReader("(require lib/secret)"))
+	if !errors.Is(err, ErrCapabilityDenied) {
+		t.Errorf("expected modules outside the allowlist to be refused, got %v", err)
+	}
+	_, err = NewWithOptions(WithSandbox(UntrustedSandbox())).Process(strings.NewReader("(require utils)"))
+	if !errors.Is(err, ErrCapabilityDenied) {
+		t.Errorf("expected no modules without Files, got %v", err)
+	}
+}
+
+func TestSandboxCapsOutput(t *testing.T) {
+	var out, errOut bytes.Buffer
+	sb := UntrustedSandbox()
+	sb.MaxOutput = 10
+	g := NewWithOptions(WithStdout(&out), WithStderr(&errOut), WithSandbox(sb))
+	_, err := g.Process(strings.NewReader(`(eprint "1234") (define loop (lambda () (print "ab") (loop))) (loop)`))
+	var limitErr LimitError
+	if !errors.As(err, &limitErr) || limitErr.Limit != LimitOutput || limitErr.Max != 10 {
+		t.Fatalf("expected the output limit to stop the script, got %v", err)
+	}
+	if out.String() != "ababab" || errOut.String() != "1234" {
+		t.Errorf("expected 10 bytes in all, got %q and %q", out.String(), errOut.String())
+	}
+}
+
+func TestSandboxAppliesLimits(t *testing.T) {
+	sb := UntrustedSandbox()
+	sb.Limits = Limits{Fuel: 10_000}
+	g := NewWithOptions(WithSandbox(sb))
+	_, err := g.Process(strings.NewReader("(define spin (lambda () (spin))) (spin)"))
+	if !errors.Is(err, ErrLimitExceeded) {
+		t.Errorf("expected the fuel limit to stop the script, got %v", err)
+	}
+}
+
+func TestSandboxSurvivesPanickingBuiltins(t *testing.T) {
+	g := NewWithOptions(WithSandbox(UntrustedSandbox()))
+	if err := g.Define("explode", func() { panic("boom") }); err != nil {
+		t.Fatal(err)
+	}
+	for _, src := range []string{"(car)", "(map (lambda (x) (car)) '(1))", "(explode)"} {
+		_, err := g.Process(strings.NewReader(src))
+		if !errors.Is(err, ErrPanic) {
+			t.Errorf("%s: expected the panic as an error, got %v", src, err)
+		}
+	}
+	if result, err := g.Process(strings.NewReader("(car '(1 2))")); err != nil || result.IntVal != 1 {
+		t.Errorf("expected the instance to keep working, got %v, %v", result, err)
+	}
+}
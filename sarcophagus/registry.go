@@ -1,27 +1,99 @@
 package sarcophagus
 
+import (
+	"sort"
+	"sync"
+)
+
 type Mummy struct {
 	Names    []string
 	Register func(prefix string, only map[string]bool, register func(string, interface{}))
 }
 
-var registry = map[string]*Mummy{}
+// Registry maps the names a package can be required by, its short name
+// and its import path, to the mummy wrapping it. It is safe for
+// concurrent use.
+type Registry struct {
+	mu      sync.RWMutex
+	mummies map[string]*Mummy
+}
 
-func Entomb(shortName string, fullPath string, mummy *Mummy) {
-	registry[shortName] = mummy
+func NewRegistry() *Registry {
+	return &Registry{mummies: map[string]*Mummy{}}
+}
+
+// Default is the registry generated mummies entomb themselves in when
+// their package is imported, and the one Ghoul instances use unless
+// given their own.
+var Default = NewRegistry()
+
+func (r *Registry) Entomb(shortName string, fullPath string, mummy *Mummy) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mummies[shortName] = mummy
 	if fullPath != shortName {
-		registry[fullPath] = mummy
+		r.mummies[fullPath] = mummy
+	}
+}
+
+func (r *Registry) Unearth(name string) *Mummy {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.mummies[name]
+}
+
+// Names returns every name a mummy can be unearthed by, sorted.
+func (r *Registry) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.mummies))
+	for name := range r.mummies {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+	return names
 }
 
+// Subset returns a new registry holding the named mummies of r, each under
+// its short name and import path alike. Names r does not know are left
+// out.
+func (r *Registry) Subset(names ...string) *Registry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	wanted := map[*Mummy]bool{}
+	for _, name := range names {
+		if m := r.mummies[name]; m != nil {
+			wanted[m] = true
+		}
+	}
+	subset := NewRegistry()
+	for name, m := range r.mummies {
+		if wanted[m] {
+			subset.mummies[name] = m
+		}
+	}
+	return subset
+}
+
+func (r *Registry) Clear() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	clear(r.mummies)
+}
+
+// Entomb registers mummy in the Default registry.
+func Entomb(shortName string, fullPath string, mummy *Mummy) {
+	Default.Entomb(shortName, fullPath, mummy)
+}
+
+// Unearth looks name up in the Default registry.
 func Unearth(name string) *Mummy {
-	return registry[name]
+	return Default.Unearth(name)
 }
 
+// ClearRegistry empties the Default registry.
 func ClearRegistry() {
-	for k := range registry {
-		delete(registry, k)
-	}
+	Default.Clear()
 }
 
 func RegisterIfAllowed(prefix string, only map[string]bool, name string, fn interface{}, register func(string, interface{})) {
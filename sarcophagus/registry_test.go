@@ -1,6 +1,7 @@
 package sarcophagus
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -134,3 +135,36 @@ func TestClearRegistry(t *testing.T) {
 		t.Error("expected nil after clear")
 	}
 }
+
+func TestRegistriesAreIndependent(t *testing.T) {
+	m := &Mummy{Names: []string{"x"}, Register: func(string, map[string]bool, func(string, interface{})) {}}
+	r := NewRegistry()
+	r.Entomb("own", "example/own", m)
+
+	if Unearth("own") != nil {
+		t.Error("expected the default registry to be untouched")
+	}
+	if r.Unearth("example/own") != m {
+		t.Error("expected the mummy in its own registry")
+	}
+	NewRegistry().Clear()
+	if r.Unearth("own") != m {
+		t.Error("expected clearing another registry to leave this one alone")
+	}
+}
+
+func TestSubsetKeepsShortNamesAndPaths(t *testing.T) {
+	strs := &Mummy{Names: []string{"repeat"}, Register: func(string, map[string]bool, func(string, interface{})) {}}
+	osm := &Mummy{Names: []string{"exit"}, Register: func(string, map[string]bool, func(string, interface{})) {}}
+	r := NewRegistry()
+	r.Entomb("strings", "strings", strs)
+	r.Entomb("osx", "example/osx", osm)
+
+	sub := r.Subset("strings", "example/osx", "missing")
+	if got := strings.Join(sub.Names(), ","); got != "example/osx,osx,strings" {
+		t.Errorf("expected both names of each selected mummy, got %s", got)
+	}
+	if r.Subset("strings").Unearth("osx") != nil {
+		t.Error("expected mummies not named to be left out")
+	}
+}
@@ -0,0 +1,40 @@
+package ghoul
+
+import (
+	"bytes"
+
+	ev "github.com/archevel/ghoul/consume"
+)
+
+// ErrMalformedSnapshot is matched by the error of restoring data that is
+// not a snapshot this build can read.
+var ErrMalformedSnapshot = ev.ErrMalformedImage
+
+func (g ghoul) Snapshot() ([]byte, error) {
+	g.expanding.Lock()
+	defer g.expanding.Unlock()
+	var buf bytes.Buffer
+	if err := g.reanimator.Snapshot(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (g ghoul) Restore(data []byte) error {
+	g.expanding.Lock()
+	defer g.expanding.Unlock()
+	return g.reanimator.Restore(data)
+}
+
+// Restore makes an instance configured by opts, as NewWithOptions does,
+// that starts from the snapshot in data instead of the prelude. An
+// instance whose snapshot holds functions or values added with Define or
+// DefineValue must be given them again before restoring: make it with
+// NewWithOptions and WithoutPrelude, define them, then call its Restore.
+func Restore(data []byte, opts ...Option) (Ghoul, error) {
+	g := NewWithOptions(append(opts, WithoutPrelude())...)
+	if err := g.Restore(data); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
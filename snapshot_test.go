@@ -0,0 +1,148 @@
+package ghoul
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	e "github.com/archevel/ghoul/bones"
+	ev "github.com/archevel/ghoul/consume"
+)
+
+const snapshotSource = `
+(define make-counter
+  (lambda ()
+    (let ((n 0))
+      (lambda () (set! n (+ n 1)) n))))
+(define tick (make-counter))
+(tick)
+(define-syntax swap!
+  (syntax-rules ()
+    ((_ a b) (let ((tmp a)) (set! a b) (set! b tmp)))))
+(define-macro (twice form) (list 'begin form form))
+(define x 1)
+(define y 2)`
+
+func TestRestoreContinuesFromTheSnapshot(t *testing.T) {
+	g := New()
+	if _, err := g.Process(strings.NewReader(snapshotSource)); err != nil {
+		t.Fatal(err)
+	}
+	data, err := g.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	restored, err := Restore(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct{ src, want string }{
+		{"(tick)", "2"},
+		{"(define tmp 5) (swap! tmp y) (list tmp y)", "(2 5)"},
+		{"(twice (tick)) (tick)", "5"},
+		{"(when (> x 0) (and #t 'prelude))", "prelude"},
+	} {
+		result, err := restored.Process(strings.NewReader(tc.src))
+		if err != nil || result.Repr() != tc.want {
+			t.Errorf("%s: expected %s, got %v, %v", tc.src, tc.want, result, err)
+		}
+	}
+	if result, err := g.Process(strings.NewReader("(tick)")); err != nil || !result.Equiv(e.IntNode(2)) {
+		t.Errorf("expected the original instance to be unaffected, got %v, %v", result, err)
+	}
+
+	marks := g.(ghoul).reanimator.FreshMark()
+	if next := restored.(ghoul).reanimator.FreshMark(); next < marks {
+		t.Errorf("expected the mark counter to carry over, got %d after %d", next, marks)
+	}
+}
+
+func TestRestoreRebindsMummiesByName(t *testing.T) {
+	g := NewWithOptions(WithRegistry(hostRegistry()))
+	if _, err := g.Process(strings.NewReader("(require strings as s) (define name s:name)")); err != nil {
+		t.Fatal(err)
+	}
+	data, err := g.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	restored, err := Restore(data, WithRegistry(hostRegistry()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := restored.Process(strings.NewReader("(list (name) (s:name))")); err != nil || result.Repr() != `("strings" "strings")` {
+		t.Errorf("expected the mummy to be bound again, got %v, %v", result, err)
+	}
+
+	sb := UntrustedSandbox()
+	sb.Banned = append(sb.Banned, "strings")
+	if _, err := Restore(data, WithRegistry(hostRegistry()), WithSandbox(sb)); !errors.Is(err, ErrCapabilityDenied) {
+		t.Errorf("expected restoring a banned mummy to be refused, got %v", err)
+	}
+}
+
+func TestRestoreNeedsTheHostsDefinitions(t *testing.T) {
+	g := New()
+	g.Define("greet", func(who string) string { return "hello " + who })
+	if _, err := g.Process(strings.NewReader(`(define welcome (lambda () (greet "crypt")))`)); err != nil {
+		t.Fatal(err)
+	}
+	data, err := g.Snapshot()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Restore(data); err == nil || !strings.Contains(err.Error(), "no procedure named greet") {
+		t.Errorf("expected the missing definition to be reported, got %v", err)
+	}
+	restored := NewWithOptions(WithoutPrelude())
+	restored.Define("greet", func(who string) string { return "hi " + who })
+	if err := restored.Restore(data); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := CallAs[string](t.Context(), restored, "welcome"); err != nil || got != "hi crypt" {
+		t.Errorf("expected the new definition to be used, got %q, %v", got, err)
+	}
+
+	g.Process(strings.NewReader("(define unnamed (list greet))"))
+	g.Process(strings.NewReader("(set! greet 1)"))
+	if _, err := g.Snapshot(); !errors.Is(err, ErrUnserializable) {
+		t.Errorf("expected a procedure with no name to fail the snapshot, got %v", err)
+	}
+}
+
+func TestRestoreRejectsOtherData(t *testing.T) {
+	if _, err := Restore([]byte("(define x 1)")); !errors.Is(err, ErrMalformedSnapshot) {
+		t.Errorf("expected ErrMalformedSnapshot, got %v", err)
+	}
+}
+
+func TestRestoreLeavesTheInstanceAloneOnABadMacroScope(t *testing.T) {
+	other := New()
+	if _, err := other.Process(strings.NewReader("(define x 2)")); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	err := ev.WriteImage(&buf, &ev.Image{
+		Env:   other.(ghoul).reanimator.EvalEnv(),
+		Roots: []*e.Node{e.NewListNode([]*e.Node{e.IntNode(1)})},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	g := New()
+	if _, err := g.Process(strings.NewReader("(define x 1)")); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Restore(buf.Bytes()); !errors.Is(err, ErrMalformedSnapshot) {
+		t.Fatalf("expected ErrMalformedSnapshot, got %v", err)
+	}
+	result, err := g.Process(strings.NewReader("(when #t x)"))
+	if err != nil || !result.Equiv(e.IntNode(1)) {
+		t.Errorf("expected the bindings and macros to be unchanged, got %v, %v", result, err)
+	}
+}
@@ -0,0 +1,47 @@
+package ghoul
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCollectStatsOfProcessAndCall(t *testing.T) {
+	g := New()
+	if err := g.Define("nap", func() { time.Sleep(2 * time.Millisecond) }); err != nil {
+		t.Fatal(err)
+	}
+	var stats Stats
+	ctx := CollectStats(context.Background(), &stats)
+	_, err := g.ProcessWithContext(ctx, strings.NewReader(`
+(define-syntax twice (syntax-rules () ((_ e) (begin e e))))
+(define rest (lambda xs xs))
+(twice (nap))
+(rest 1 2)`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Expansion <= 0 || stats.Instructions == 0 || stats.ClosureCalls == 0 {
+		t.Errorf("expected expansion time, instructions and closure calls, got %+v", stats)
+	}
+	if stats.NativeTime["nap"] < 4*time.Millisecond {
+		t.Errorf("expected two naps to be timed under their name, got %v", stats.NativeTime)
+	}
+
+	calls := stats.ClosureCalls
+	if _, err := g.Call(ctx, "rest", 3); err != nil {
+		t.Fatal(err)
+	}
+	if stats.ClosureCalls != calls+1 {
+		t.Errorf("expected Call to add to the same stats, got %d closure calls after %d", stats.ClosureCalls, calls)
+	}
+
+	before := stats
+	if _, err := g.Process(strings.NewReader(`(rest 4)`)); err != nil {
+		t.Fatal(err)
+	}
+	if stats.Instructions != before.Instructions {
+		t.Error("expected evaluations without CollectStats to keep no counts")
+	}
+}
@@ -1,6 +1,7 @@
 package testpkg
 
 import (
+	"context"
 	"io"
 	"os"
 )
@@ -68,6 +69,18 @@ func CloseWithMessage(err error) error {
 	return nil
 }
 
+// --- Context: leading context.Context is supplied by the evaluator ---
+
+// CountUntilDone counts to n unless ctx is cancelled first
+func CountUntilDone(ctx context.Context, n int) (int, error) {
+	for i := 0; i < n; i++ {
+		if err := ctx.Err(); err != nil {
+			return i, err
+		}
+	}
+	return n, nil
+}
+
 // --- Package name shadowing: parameter named same as package ---
 
 // Exported interface using io types
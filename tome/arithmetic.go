@@ -29,7 +29,7 @@ func numResult(val float64, allInt bool) *e.Node {
 
 func registerArithmetic(env *ev.Environment) {
 	// (+) → 0, (+ a) → a, (+ a b ...) → sum
-	env.Register("+", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
+	env.RegisterPure("+", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
 		acc := 0.0
 		allInt := true
 		for _, arg := range args {
@@ -46,7 +46,7 @@ func registerArithmetic(env *ev.Environment) {
 	})
 
 	// (*) → 1, (* a) → a, (* a b ...) → product
-	env.Register("*", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
+	env.RegisterPure("*", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
 		acc := 1.0
 		allInt := true
 		for _, arg := range args {
@@ -63,7 +63,7 @@ func registerArithmetic(env *ev.Environment) {
 	})
 
 	// (- a) → negation, (- a b ...) → a - b - ...
-	env.Register("-", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
+	env.RegisterPure("-", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
 		if len(args) == 0 {
 			return nil, fmt.Errorf("-: expected at least one argument")
 		}
@@ -90,7 +90,7 @@ func registerArithmetic(env *ev.Environment) {
 	})
 
 	// (/ a) → 1/a, (/ a b ...) → a / b / ...
-	env.Register("/", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
+	env.RegisterPure("/", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
 		if len(args) == 0 {
 			return nil, fmt.Errorf("/: expected at least one argument")
 		}
@@ -123,7 +123,7 @@ func registerArithmetic(env *ev.Environment) {
 	})
 
 	// mod stays binary
-	env.Register("mod", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
+	env.RegisterPure("mod", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
 		if len(args) != 2 {
 			return nil, fmt.Errorf("mod: expected 2 arguments, got %d", len(args))
 		}
@@ -50,33 +50,33 @@ func numCompare(name string, intCmp func(a, b int64) bool, floatCmp func(a, b fl
 }
 
 func registerComparison(env *ev.Environment) {
-	env.Register("eq?", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
+	env.RegisterPure("eq?", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
 		fst := args[0]
 		snd := args[1]
 		return e.BoolNode(fst.Equiv(snd)), nil
 	})
 
-	env.Register("=", numCompare("=",
+	env.RegisterPure("=", numCompare("=",
 		func(a, b int64) bool { return a == b },
 		func(a, b float64) bool { return a == b },
 	))
 
-	env.Register("<", numCompare("<",
+	env.RegisterPure("<", numCompare("<",
 		func(a, b int64) bool { return a < b },
 		func(a, b float64) bool { return a < b },
 	))
 
-	env.Register(">", numCompare(">",
+	env.RegisterPure(">", numCompare(">",
 		func(a, b int64) bool { return a > b },
 		func(a, b float64) bool { return a > b },
 	))
 
-	env.Register("<=", numCompare("<=",
+	env.RegisterPure("<=", numCompare("<=",
 		func(a, b int64) bool { return a <= b },
 		func(a, b float64) bool { return a <= b },
 	))
 
-	env.Register(">=", numCompare(">=",
+	env.RegisterPure(">=", numCompare(">=",
 		func(a, b int64) bool { return a >= b },
 		func(a, b float64) bool { return a >= b },
 	))
@@ -0,0 +1,66 @@
+package tome
+
+import (
+	"errors"
+	"fmt"
+
+	e "github.com/archevel/ghoul/bones"
+	ev "github.com/archevel/ghoul/consume"
+)
+
+// escapeInvoked unwinds the evaluation stack back to the
+// call-with-escape-continuation that created its tag. If nothing catches
+// it the continuation was invoked after its extent ended.
+type escapeInvoked struct {
+	tag   *int
+	value *e.Node
+}
+
+func (esc *escapeInvoked) Error() string {
+	return "escape continuation invoked outside its dynamic extent"
+}
+
+func registerControl(env *ev.Environment) {
+	callEC := func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
+		// (call/ec (lambda (k) ... (k value) ...))
+		// Calling k returns value from the call/ec form. k is one-shot and
+		// upward only: it cannot re-enter a computation that has finished.
+		if len(args) != 1 {
+			return nil, fmt.Errorf("call/ec: expected 1 argument, got %d", len(args))
+		}
+		tag := new(int)
+		k := e.FuncNode(func(kargs []*e.Node, _ e.Evaluator) (*e.Node, error) {
+			value := e.Nil
+			if len(kargs) > 0 {
+				value = kargs[0]
+			}
+			return nil, &escapeInvoked{tag: tag, value: value}
+		})
+		result, err := evaluator.Apply(evaluator.Context(), args[0], []*e.Node{k})
+		var esc *escapeInvoked
+		if errors.As(err, &esc) && esc.tag == tag {
+			return esc.value, nil
+		}
+		return result, err
+	}
+	env.Register("call-with-escape-continuation", callEC)
+	env.Register("call/ec", callEC)
+
+	env.BindByName("apply", ev.ApplyProcedure())
+	env.BindByName("yield", ev.YieldProcedure())
+
+	env.Register("disassemble", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
+		// (disassemble f) returns the bytecode listing of the closure f.
+		if len(args) != 1 {
+			return nil, fmt.Errorf("disassemble: expected 1 argument, got %d", len(args))
+		}
+		code, ok := ev.ProcedureCode(args[0])
+		if !ok && args[0].Kind == e.FunctionNode {
+			return nil, fmt.Errorf("disassemble: %s is a Go function, not compiled code", args[0].Repr())
+		}
+		if !ok {
+			return nil, fmt.Errorf("disassemble: expected procedure, got %s", e.NodeTypeName(args[0]))
+		}
+		return e.StrNode(ev.DisassembleString(code)), nil
+	})
+}
@@ -0,0 +1,136 @@
+package tome
+
+import (
+	"strings"
+	"testing"
+
+	e "github.com/archevel/ghoul/bones"
+)
+
+func TestCallEcReturnsNormally(t *testing.T) {
+	result, err := evalWithStdlib(`(call/ec (lambda (k) (+ 1 2)))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Equiv(e.IntNode(3)) {
+		t.Errorf("expected 3, got %s", result.Repr())
+	}
+}
+
+func TestCallEcEscapesWithValue(t *testing.T) {
+	result, err := evalWithStdlib(`(+ 1 (call/ec (lambda (k) (+ 100 (k 41)))))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Equiv(e.IntNode(42)) {
+		t.Errorf("expected 42, got %s", result.Repr())
+	}
+}
+
+func TestCallEcEscapesThroughNestedCalls(t *testing.T) {
+	result, err := evalWithStdlib(`
+(define find-first (lambda (pred lst)
+  (call-with-escape-continuation (lambda (return)
+    (map (lambda (x) (cond ((pred x) (return x)) (else #f))) lst)
+    #f))))
+(find-first (lambda (x) (> x 2)) '(1 2 3 4))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Equiv(e.IntNode(3)) {
+		t.Errorf("expected 3, got %s", result.Repr())
+	}
+}
+
+func TestCallEcInnerEscapeDoesNotStopOuter(t *testing.T) {
+	result, err := evalWithStdlib(`
+(call/ec (lambda (outer)
+  (+ 1 (call/ec (lambda (inner) (inner 1) (outer 100))))))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Equiv(e.IntNode(2)) {
+		t.Errorf("expected 2, got %s", result.Repr())
+	}
+}
+
+func TestCallEcInvokedAfterExtentFails(t *testing.T) {
+	_, err := evalWithStdlib(`
+(define saved (call/ec (lambda (k) k)))
+(saved 1)`)
+	if err == nil || !strings.Contains(err.Error(), "outside its dynamic extent") {
+		t.Errorf("expected extent error, got %v", err)
+	}
+}
+
+func TestCallEcChecksItsArity(t *testing.T) {
+	for src, n := range map[string]string{"(call/ec)": "0", "(call/ec car cdr)": "2"} {
+		_, err := evalWithStdlib(src)
+		if err == nil || !strings.Contains(err.Error(), "call/ec: expected 1 argument, got "+n) {
+			t.Errorf("%s: expected an arity error, got %v", src, err)
+		}
+	}
+}
+
+func TestApplySpreadsArguments(t *testing.T) {
+	result, err := evalWithStdlib(`(apply + 1 2 '(3 4))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Equiv(e.IntNode(10)) {
+		t.Errorf("expected 10, got %s", result.Repr())
+	}
+}
+
+func TestApplyWithEmptyList(t *testing.T) {
+	result, err := evalWithStdlib(`(apply list '())`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.IsNil() {
+		t.Errorf("expected (), got %s", result.Repr())
+	}
+}
+
+func TestApplyAsHigherOrderArgument(t *testing.T) {
+	result, err := evalWithStdlib(`(map (lambda (args) (apply + args)) '((1 2) (3 4 5)))`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Repr() != "(3 12)" {
+		t.Errorf("expected (3 12), got %s", result.Repr())
+	}
+}
+
+func TestApplyRejectsImproperLastArgument(t *testing.T) {
+	_, err := evalWithStdlib(`(apply + 1 2)`)
+	if err == nil {
+		t.Error("expected error when last argument is not a list")
+	}
+}
+
+func TestDisassembleReturnsListing(t *testing.T) {
+	result, err := evalWithStdlib(`(define sq (lambda (x) (* x x))) (disassemble sq)`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Kind != e.StringNode {
+		t.Fatalf("expected a string, got %s", e.NodeTypeName(result))
+	}
+	for _, want := range []string{"== lambda #1 (x), 1 locals ==", "OP_LOAD_LOCAL     slot 0     ; x", "OP_INT_MUL"} {
+		if !strings.Contains(result.StrVal, want) {
+			t.Errorf("expected %q in listing:\n%s", want, result.StrVal)
+		}
+	}
+}
+
+func TestDisassembleRejectsGoFunctions(t *testing.T) {
+	_, err := evalWithStdlib(`(disassemble car)`)
+	if err == nil || !strings.Contains(err.Error(), "is a Go function") {
+		t.Errorf("expected a Go function error, got %v", err)
+	}
+	_, err = evalWithStdlib(`(disassemble 1)`)
+	if err == nil || !strings.Contains(err.Error(), "expected procedure") {
+		t.Errorf("expected a type error, got %v", err)
+	}
+}
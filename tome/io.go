@@ -1,7 +1,10 @@
 package tome
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"strings"
 
 	e "github.com/archevel/ghoul/bones"
 	ev "github.com/archevel/ghoul/consume"
@@ -9,22 +12,53 @@ import (
 
 func registerIO(env *ev.Environment) {
 	env.Register("println", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
-		fst := args[0]
-		if fst.Kind == e.StringNode {
-			fmt.Println(fst.StrVal)
-		} else {
-			fmt.Println(fst.Repr())
+		if _, err := fmt.Fprintln(evaluator.Stdout(), displayString(args[0])); err != nil {
+			return nil, fmt.Errorf("println: %w", err)
 		}
 		return e.Nil, nil
 	})
 
 	env.Register("print", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
-		fst := args[0]
-		if fst.Kind == e.StringNode {
-			fmt.Print(fst.StrVal)
-		} else {
-			fmt.Print(fst.Repr())
+		if _, err := fmt.Fprint(evaluator.Stdout(), displayString(args[0])); err != nil {
+			return nil, fmt.Errorf("print: %w", err)
 		}
 		return e.Nil, nil
 	})
+
+	env.Register("eprintln", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
+		if _, err := fmt.Fprintln(evaluator.Stderr(), displayString(args[0])); err != nil {
+			return nil, fmt.Errorf("eprintln: %w", err)
+		}
+		return e.Nil, nil
+	})
+
+	env.Register("eprint", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
+		if _, err := fmt.Fprint(evaluator.Stderr(), displayString(args[0])); err != nil {
+			return nil, fmt.Errorf("eprint: %w", err)
+		}
+		return e.Nil, nil
+	})
+
+	// (read-line) returns the next line of input without its line ending,
+	// or '() once the input is exhausted.
+	env.Register("read-line", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
+		line, err := evaluator.Stdin().ReadString('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("read-line: %w", err)
+		}
+		if err != nil && line == "" {
+			return e.Nil, nil
+		}
+		line = strings.TrimSuffix(line, "\n")
+		return e.StrNode(strings.TrimSuffix(line, "\r")), nil
+	})
+}
+
+// displayString is how print and friends show a value: strings without
+// quotes, anything else as its Repr.
+func displayString(n *e.Node) string {
+	if n.Kind == e.StringNode {
+		return n.StrVal
+	}
+	return n.Repr()
 }
@@ -1,9 +1,16 @@
 package tome
 
 import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
 	"testing"
 
-	_ "github.com/archevel/ghoul/bones"
+	e "github.com/archevel/ghoul/bones"
+	ev "github.com/archevel/ghoul/consume"
+	"github.com/archevel/ghoul/engraving"
+	p "github.com/archevel/ghoul/exhumer"
 )
 
 func TestPrintln(t *testing.T) {
@@ -30,3 +37,45 @@ func TestPrintNonString(t *testing.T) {
 	if err != nil { t.Fatal(err) }
 	if !result.IsNil() { t.Errorf("expected NIL, got %s", result.Repr()) }
 }
+
+// evalWithStreams evaluates code with the evaluator's streams redirected
+// to buffers, returning what was written to each.
+func evalWithStreams(code, input string) (stdout, stderr string, result *e.Node, err error) {
+	env := ev.NewEnvironment()
+	RegisterAll(env)
+	evaluator := ev.New(engraving.StandardLogger, env)
+	var out, errOut bytes.Buffer
+	evaluator.SetStdout(&out)
+	evaluator.SetStderr(&errOut)
+	evaluator.SetStdin(strings.NewReader(input))
+	_, parsed := p.Parse(strings.NewReader(code))
+	result, err = evaluator.EvaluateNode(context.Background(), parsed.Expressions)
+	return out.String(), errOut.String(), result, err
+}
+
+func TestPrintWritesToTheEvaluatorsStdout(t *testing.T) {
+	out, errOut, _, err := evalWithStreams(`(print "a") (println 42) (eprintln "oops") (eprint 'x)`, "")
+	if err != nil { t.Fatal(err) }
+	if out != "a42\n" { t.Errorf("expected stdout %q, got %q", "a42\n", out) }
+	if errOut != "oops\nx" { t.Errorf("expected stderr %q, got %q", "oops\nx", errOut) }
+}
+
+func TestReadLine(t *testing.T) {
+	_, _, result, err := evalWithStreams(`(list (read-line) (read-line) (read-line) (read-line))`, "one\r\ntwo\nthree")
+	if err != nil { t.Fatal(err) }
+	if result.Repr() != `("one" "two" "three" ())` { t.Errorf("expected three lines then '(), got %s", result.Repr()) }
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("disk full") }
+
+func TestPrintFailsWhenTheStreamDoes(t *testing.T) {
+	env := ev.NewEnvironment()
+	RegisterAll(env)
+	evaluator := ev.New(engraving.StandardLogger, env)
+	evaluator.SetStdout(failingWriter{})
+	_, parsed := p.Parse(strings.NewReader(`(println "hello")`))
+	_, err := evaluator.EvaluateNode(context.Background(), parsed.Expressions)
+	if err == nil || !strings.Contains(err.Error(), "println: disk full") { t.Errorf("expected the write error, got %v", err) }
+}
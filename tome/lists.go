@@ -126,8 +126,7 @@ func registerLists(env *ev.Environment) {
 
 		results := make([]*e.Node, 0, len(lstNode.Children))
 		for _, child := range lstNode.Children {
-			callNode := &e.Node{Kind: e.CallNode, Children: []*e.Node{fnNode, child}}
-			result, err := evaluator.EvalSubExpression(callNode)
+			result, err := evaluator.Apply(evaluator.Context(), fnNode, []*e.Node{child})
 			if err != nil {
 				return nil, fmt.Errorf("map: %w", err)
 			}
@@ -150,8 +149,7 @@ func registerLists(env *ev.Environment) {
 
 		var results []*e.Node
 		for _, child := range lstNode.Children {
-			callNode := &e.Node{Kind: e.CallNode, Children: []*e.Node{fnNode, child}}
-			result, err := evaluator.EvalSubExpression(callNode)
+			result, err := evaluator.Apply(evaluator.Context(), fnNode, []*e.Node{child})
 			if err != nil {
 				return nil, fmt.Errorf("filter: %w", err)
 			}
@@ -179,8 +177,7 @@ func registerLists(env *ev.Environment) {
 		}
 
 		for _, child := range lstNode.Children {
-			callNode := &e.Node{Kind: e.CallNode, Children: []*e.Node{fnNode, acc, child}}
-			result, err := evaluator.EvalSubExpression(callNode)
+			result, err := evaluator.Apply(evaluator.Context(), fnNode, []*e.Node{acc, child})
 			if err != nil {
 				return nil, fmt.Errorf("foldl: %w", err)
 			}
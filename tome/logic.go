@@ -8,7 +8,7 @@ import (
 )
 
 func registerLogic(env *ev.Environment) {
-	env.Register("not", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
+	env.RegisterPure("not", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
 		val := args[0]
 		if val.Kind != e.BooleanNode {
 			return nil, fmt.Errorf("not: expected boolean, got %s", e.NodeTypeName(val))
@@ -13,5 +13,6 @@ func RegisterAll(env *ev.Environment) {
 	registerTypes(env)
 	registerIO(env)
 	registerSyntax(env)
+	registerControl(env)
 	registerConversions(env)
 }
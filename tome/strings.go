@@ -10,7 +10,7 @@ import (
 )
 
 func registerStrings(env *ev.Environment) {
-	env.Register("string-append", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
+	env.RegisterPure("string-append", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
 		var b strings.Builder
 		for _, arg := range args {
 			if arg.Kind != e.StringNode {
@@ -21,14 +21,14 @@ func registerStrings(env *ev.Environment) {
 		return e.StrNode(b.String()), nil
 	})
 
-	env.Register("string-length", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
+	env.RegisterPure("string-length", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
 		if args[0].Kind != e.StringNode {
 			return nil, fmt.Errorf("string-length: expected string, got %s", e.NodeTypeName(args[0]))
 		}
 		return e.IntNode(int64(len([]rune(args[0].StrVal)))), nil
 	})
 
-	env.Register("substring", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
+	env.RegisterPure("substring", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
 		if args[0].Kind != e.StringNode {
 			return nil, fmt.Errorf("substring: expected string as first argument, got %s", e.NodeTypeName(args[0]))
 		}
@@ -47,7 +47,7 @@ func registerStrings(env *ev.Environment) {
 		return e.StrNode(string(runes[start:end])), nil
 	})
 
-	env.Register("string-ref", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
+	env.RegisterPure("string-ref", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
 		if args[0].Kind != e.StringNode {
 			return nil, fmt.Errorf("string-ref: expected string, got %s", e.NodeTypeName(args[0]))
 		}
@@ -62,7 +62,7 @@ func registerStrings(env *ev.Environment) {
 		return e.StrNode(string(runes[idx])), nil
 	})
 
-	env.Register("string-contains?", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
+	env.RegisterPure("string-contains?", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
 		if args[0].Kind != e.StringNode {
 			return nil, fmt.Errorf("string-contains?: expected string as first argument, got %s", e.NodeTypeName(args[0]))
 		}
@@ -87,21 +87,21 @@ func registerStrings(env *ev.Environment) {
 		return e.NewListNode(children), nil
 	})
 
-	env.Register("string-upcase", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
+	env.RegisterPure("string-upcase", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
 		if args[0].Kind != e.StringNode {
 			return nil, fmt.Errorf("string-upcase: expected string, got %s", e.NodeTypeName(args[0]))
 		}
 		return e.StrNode(strings.ToUpper(args[0].StrVal)), nil
 	})
 
-	env.Register("string-downcase", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
+	env.RegisterPure("string-downcase", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
 		if args[0].Kind != e.StringNode {
 			return nil, fmt.Errorf("string-downcase: expected string, got %s", e.NodeTypeName(args[0]))
 		}
 		return e.StrNode(strings.ToLower(args[0].StrVal)), nil
 	})
 
-	env.Register("string->number", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
+	env.RegisterPure("string->number", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
 		if args[0].Kind != e.StringNode {
 			return nil, fmt.Errorf("string->number: expected string, got %s", e.NodeTypeName(args[0]))
 		}
@@ -115,7 +115,7 @@ func registerStrings(env *ev.Environment) {
 		return nil, fmt.Errorf("string->number: cannot parse '%s' as a number", str)
 	})
 
-	env.Register("number->string", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
+	env.RegisterPure("number->string", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
 		switch args[0].Kind {
 		case e.IntegerNode:
 			return e.StrNode(strconv.FormatInt(args[0].IntVal, 10)), nil
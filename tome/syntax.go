@@ -1,6 +1,10 @@
 package tome
 
 import (
+	"fmt"
+	"strconv"
+	"sync/atomic"
+
 	e "github.com/archevel/ghoul/bones"
 	ev "github.com/archevel/ghoul/consume"
 	"github.com/archevel/ghoul/macromancy"
@@ -98,6 +102,26 @@ func registerSyntax(env *ev.Environment) {
 		return alist, nil
 	})
 
+	env.Register("gensym", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
+		// (gensym) or (gensym prefix)
+		// The fresh mark comes from the shared counter, so the identifier is
+		// distinct from every user-written name and from every other gensym,
+		// even one that happens to print the same.
+		prefix := "g"
+		if len(args) > 0 {
+			switch {
+			case args[0].Kind == e.StringNode:
+				prefix = args[0].StrVal
+			case args[0].IdentName() != "":
+				prefix = args[0].IdentName()
+			default:
+				return nil, fmt.Errorf("gensym: expected string or symbol prefix, got %s", e.NodeTypeName(args[0]))
+			}
+		}
+		mark := atomic.AddUint64(evaluator.MarkCounter(), 1)
+		return e.ScopedIdentNode(prefix+strconv.FormatUint(mark, 10), map[uint64]bool{mark: true}), nil
+	})
+
 	// Mummy conversion functions
 	wrapMummyConv := func(fn sarcophagus.NodeConversionFunc) func([]*e.Node, *ev.Evaluator) (*e.Node, error) {
 		return func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
@@ -1,6 +1,7 @@
 package tome
 
 import (
+	"strings"
 	"testing"
 
 	e "github.com/archevel/ghoul/bones"
@@ -117,3 +118,17 @@ func TestSyntaxMatchEmptyPattern(t *testing.T) {
 		t.Error("expected match for matching patterns")
 	}
 }
+
+func TestGensymProducesDistinctIdentifiers(t *testing.T) {
+	result, err := evalWithStdlib(`(list (gensym) (gensym 'tmp) (gensym "tmp"))`)
+	if err != nil { t.Fatal(err) }
+	a, b, c := result.Children[0], result.Children[1], result.Children[2]
+	if a.Kind != e.IdentifierNode || len(a.Marks) != 1 { t.Fatalf("expected marked identifier, got %s", a.Repr()) }
+	if !strings.HasPrefix(b.Name, "tmp") || !strings.HasPrefix(c.Name, "tmp") { t.Errorf("expected tmp prefix, got %s and %s", b.Name, c.Name) }
+	if b.Name == c.Name || b.Equiv(c) { t.Errorf("expected distinct gensyms, got %s twice", b.Name) }
+}
+
+func TestGensymRejectsBadPrefix(t *testing.T) {
+	_, err := evalWithStdlib(`(gensym 42)`)
+	if err == nil { t.Error("expected error for integer prefix") }
+}
@@ -8,7 +8,7 @@ import (
 )
 
 func registerTypes(env *ev.Environment) {
-	env.Register("number?", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
+	env.RegisterPure("number?", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
 		switch args[0].Kind {
 		case e.IntegerNode, e.FloatNodeKind:
 			return e.BoolNode(true), nil
@@ -17,22 +17,26 @@ func registerTypes(env *ev.Environment) {
 		}
 	})
 
-	env.Register("integer?", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
+	env.RegisterPure("integer?", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
 		return e.BoolNode(args[0].Kind == e.IntegerNode), nil
 	})
 
-	env.Register("float?", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
+	env.RegisterPure("float?", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
 		return e.BoolNode(args[0].Kind == e.FloatNodeKind), nil
 	})
 
-	env.Register("string?", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
+	env.RegisterPure("string?", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
 		return e.BoolNode(args[0].Kind == e.StringNode), nil
 	})
 
-	env.Register("boolean?", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
+	env.RegisterPure("boolean?", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
 		return e.BoolNode(args[0].Kind == e.BooleanNode), nil
 	})
 
+	env.RegisterPure("symbol?", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
+		return e.BoolNode(args[0].Kind == e.IdentifierNode), nil
+	})
+
 	env.Register("list?", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
 		n := args[0]
 		if n.Kind != e.ListNode && !n.IsNil() {
@@ -50,17 +54,31 @@ func registerTypes(env *ev.Environment) {
 }
 
 func registerConversions(env *ev.Environment) {
-	env.Register("integer->float", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
+	env.RegisterPure("integer->float", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
 		if args[0].Kind != e.IntegerNode {
 			return nil, fmt.Errorf("integer->float: expected integer, got %s", e.NodeTypeName(args[0]))
 		}
 		return e.FloatNode(float64(args[0].IntVal)), nil
 	})
 
-	env.Register("float->integer", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
+	env.RegisterPure("float->integer", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
 		if args[0].Kind != e.FloatNodeKind {
 			return nil, fmt.Errorf("float->integer: expected float, got %s", e.NodeTypeName(args[0]))
 		}
 		return e.IntNode(int64(args[0].FloatVal)), nil
 	})
+
+	env.RegisterPure("string->symbol", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
+		if args[0].Kind != e.StringNode {
+			return nil, fmt.Errorf("string->symbol: expected string, got %s", e.NodeTypeName(args[0]))
+		}
+		return e.SymbolNode(e.Intern(args[0].StrVal)), nil
+	})
+
+	env.RegisterPure("symbol->string", func(args []*e.Node, evaluator *ev.Evaluator) (*e.Node, error) {
+		if args[0].Kind != e.IdentifierNode {
+			return nil, fmt.Errorf("symbol->string: expected symbol, got %s", e.NodeTypeName(args[0]))
+		}
+		return e.StrNode(args[0].Symbol().Name()), nil
+	})
 }
@@ -78,3 +78,28 @@ func TestFloatToIntegerTypeError(t *testing.T) {
 	_, err := evalWithStdlib("(float->integer 42)")
 	if err == nil { t.Fatal("expected type error") }
 }
+
+func TestSymbolPredicate(t *testing.T) {
+	r1, _ := evalWithStdlib("(symbol? 'ghost)")
+	if !r1.Equiv(e.BoolNode(true)) { t.Error("'ghost is a symbol") }
+	r2, _ := evalWithStdlib(`(symbol? "ghost")`)
+	if !r2.Equiv(e.BoolNode(false)) { t.Error("string is not a symbol") }
+}
+
+func TestStringToSymbolRoundTrips(t *testing.T) {
+	r1, err := evalWithStdlib(`(eq? (string->symbol "ghost") 'ghost)`)
+	if err != nil { t.Fatal(err) }
+	if !r1.Equiv(e.BoolNode(true)) { t.Error("string->symbol should give the quoted symbol") }
+	r2, err := evalWithStdlib(`(symbol->string (string->symbol "two words"))`)
+	if err != nil { t.Fatal(err) }
+	if !r2.Equiv(e.StrNode("two words")) { t.Errorf("expected \"two words\", got %s", r2.Repr()) }
+	r3, _ := evalWithStdlib(`(symbol->string 'crypt)`)
+	if !r3.Equiv(e.StrNode("crypt")) { t.Errorf("expected \"crypt\", got %s", r3.Repr()) }
+}
+
+func TestSymbolConversionTypeErrors(t *testing.T) {
+	_, err := evalWithStdlib(`(string->symbol 'ghost)`)
+	if err == nil { t.Fatal("expected type error from string->symbol") }
+	_, err = evalWithStdlib(`(symbol->string "ghost")`)
+	if err == nil { t.Fatal("expected type error from symbol->string") }
+}
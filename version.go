@@ -0,0 +1,80 @@
+package ghoul
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"runtime/debug"
+	"sync"
+)
+
+const modulePath = "github.com/archevel/ghoul"
+
+// version identifies the interpreter build for the expansion cache. It is
+// the module version when ghoul is a dependency; development builds fall
+// back to the VCS revision so that editing the interpreter invalidates
+// entries written by an older checkout. Where neither names the code that
+// was built, as under a replace directive pointing at a local directory
+// or in a checkout with uncommitted changes, the executable's hash does.
+var version = sync.OnceValue(func() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return executableHash()
+	}
+	return buildVersion(info, executableHash)
+})
+
+func buildVersion(info *debug.BuildInfo, exe func() string) string {
+	if info.Main.Path != modulePath {
+		for _, dep := range info.Deps {
+			if dep.Path != modulePath {
+				continue
+			}
+			if dep.Replace == nil {
+				return dep.Version
+			}
+			if dep.Replace.Version == "" {
+				return "local " + dep.Replace.Path + " " + exe()
+			}
+			return dep.Version + "=>" + dep.Replace.Path + "@" + dep.Replace.Version
+		}
+		return exe()
+	}
+	v := info.Main.Version
+	revision, modified := "", false
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			revision = s.Value
+		case "vcs.modified":
+			modified = s.Value == "true"
+		}
+	}
+	if revision != "" {
+		v += " " + revision
+	}
+	if revision == "" || modified {
+		v += " " + exe()
+	}
+	return v
+}
+
+// executableHash returns a hash of the running executable, or "unknown"
+// when it cannot be read.
+func executableHash() string {
+	path, err := os.Executable()
+	if err != nil {
+		return "unknown"
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "unknown"
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "unknown"
+	}
+	return "exe:" + hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,44 @@
+package ghoul
+
+import (
+	"runtime/debug"
+	"testing"
+)
+
+func TestBuildVersion(t *testing.T) {
+	exe := func() string { return "exe:abc" }
+	dependency := func(dep *debug.Module) *debug.BuildInfo {
+		return &debug.BuildInfo{Main: debug.Module{Path: "example.com/app"}, Deps: []*debug.Module{dep}}
+	}
+	development := func(settings ...debug.BuildSetting) *debug.BuildInfo {
+		return &debug.BuildInfo{Main: debug.Module{Path: modulePath, Version: "(devel)"}, Settings: settings}
+	}
+	cases := []struct {
+		name string
+		info *debug.BuildInfo
+		want string
+	}{
+		{"dependency", dependency(&debug.Module{Path: modulePath, Version: "v1.2.3"}), "v1.2.3"},
+		{"replaced by a module version",
+			dependency(&debug.Module{Path: modulePath, Version: "v1.2.3", Replace: &debug.Module{Path: "example.com/fork", Version: "v1.2.4"}}),
+			"v1.2.3=>example.com/fork@v1.2.4"},
+		{"replaced by a local directory",
+			dependency(&debug.Module{Path: modulePath, Replace: &debug.Module{Path: "../ghoul"}}),
+			"local ../ghoul exe:abc"},
+		{"not a dependency", dependency(&debug.Module{Path: "example.com/other", Version: "v1.0.0"}), "exe:abc"},
+		{"clean checkout",
+			development(debug.BuildSetting{Key: "vcs.revision", Value: "f00d"}, debug.BuildSetting{Key: "vcs.modified", Value: "false"}),
+			"(devel) f00d"},
+		{"modified checkout",
+			development(debug.BuildSetting{Key: "vcs.revision", Value: "f00d"}, debug.BuildSetting{Key: "vcs.modified", Value: "true"}),
+			"(devel) f00d exe:abc"},
+		{"no VCS information", development(), "(devel) exe:abc"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := buildVersion(c.info, exe); got != c.want {
+				t.Errorf("expected %q, got %q", c.want, got)
+			}
+		})
+	}
+}